@@ -15,6 +15,7 @@ import (
 	"github.com/jeremy/ai-autonomous-webshop/backend/internal/db"
 	"github.com/jeremy/ai-autonomous-webshop/backend/internal/models"
 	"github.com/jeremy/ai-autonomous-webshop/backend/internal/services"
+	"github.com/jeremy/ai-autonomous-webshop/backend/migrations"
 )
 
 func main() {
@@ -26,19 +27,80 @@ func main() {
 		db.Connect(cfg.DatabaseURL)
 
 		log.Println("Running database migrations...")
+		if err := migrations.ConvertMoneyColumns(); err != nil {
+			log.Printf("Warning: Failed to convert money columns: %v", err)
+		}
 		db.DB.AutoMigrate(
 			&models.User{},
+			&models.UserRole{},
+			&models.RefreshToken{},
+			&models.RevokedToken{},
+			&models.UserToken{},
+			&models.SecurityEvent{},
+			&models.MFARecoveryCode{},
+			&models.PaymentTransaction{},
+			&models.PaymentEvent{},
+			&models.Category{},
 			&models.Product{},
+			&models.ProductSlugRedirect{},
+			&models.OptionType{},
+			&models.OptionValue{},
+			&models.ProductVariant{},
+			&models.VariantImage{},
 			&models.Order{},
 			&models.OrderItem{},
+			&models.OrderStatusHistory{},
+			&models.Promotion{},
+			&models.OrderDiscount{},
+			&models.OrderTaxLine{},
+			&models.Invoice{},
+			&models.InvoiceSequence{},
+			&models.ExchangeRate{},
+			&models.TaxRule{},
+			&models.Address{},
+			&models.ShippingZone{},
+			&models.ShippingMethod{},
+			&models.ShippingRate{},
+			&models.Shipment{},
+			&models.ShipmentEvent{},
+			&models.Refund{},
+			&models.ReturnRequest{},
+			&models.ReturnItem{},
 			&models.CartItem{},
+			&models.CartCoupon{},
+			&models.StockReservation{},
+			&models.CatalogImportJob{},
+			&models.CatalogImportError{},
 		)
+
+		if err := migrations.BackfillOrderCurrencies(); err != nil {
+			log.Printf("Warning: Failed to backfill order currencies: %v", err)
+		}
+		if err := migrations.BackfillProductVariants(); err != nil {
+			log.Printf("Warning: Failed to backfill product variants: %v", err)
+		}
+		if err := migrations.BackfillCategories(); err != nil {
+			log.Printf("Warning: Failed to backfill categories: %v", err)
+		}
+		if err := migrations.BackfillProductSlugs(); err != nil {
+			log.Printf("Warning: Failed to backfill product slugs: %v", err)
+		}
+		if err := services.NewTaxService().SeedDefaultRules(); err != nil {
+			log.Printf("Warning: Failed to seed tax rules: %v", err)
+		}
+		if err := services.NewShippingService().SeedDefaultZones(); err != nil {
+			log.Printf("Warning: Failed to seed shipping zones: %v", err)
+		}
 	} else {
 		log.Println("Warning: DATABASE_URL not set, running without database connection")
 	}
 
 	db.ConnectRedis(cfg.RedisURL)
 
+	if err := services.InitPaymentGateway(); err != nil {
+		log.Fatalf("Payment gateway: %v", err)
+	}
+
 	services.InitNotifier()
 
 	middleware.SetRateLimitConfig(middleware.RateLimitConfig{
@@ -54,6 +116,21 @@ func main() {
 	inventoryService := &services.InventoryService{}
 	inventoryService.StartInventoryJob()
 
+	tokenService := services.NewTokenService()
+	tokenService.StartCleanupJob()
+
+	paymentWebhookService := services.NewPaymentWebhookService()
+	paymentWebhookService.StartRetryJob()
+
+	stockReservationService := services.NewStockReservationService()
+	stockReservationService.StartSweeper()
+
+	currencyService := services.NewCurrencyService()
+	currencyService.StartRefreshJob()
+
+	catalogService := services.NewCatalogService()
+	catalogService.StartImportWorker()
+
 	r := api.SetupRouter()
 
 	srv := &http.Server{
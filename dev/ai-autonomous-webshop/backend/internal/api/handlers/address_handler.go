@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/services"
+)
+
+type AddressHandler struct {
+	service *services.AddressService
+}
+
+func NewAddressHandler() *AddressHandler {
+	return &AddressHandler{
+		service: services.NewAddressService(),
+	}
+}
+
+func (h *AddressHandler) List(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+
+	addresses, err := h.service.List(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch addresses"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"addresses": addresses})
+}
+
+func (h *AddressHandler) Create(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+
+	var input services.AddressInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	address, err := h.service.Create(userID, input)
+	if err != nil {
+		respondAddressError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, address)
+}
+
+func (h *AddressHandler) Update(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid address ID"})
+		return
+	}
+
+	var input services.AddressInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	address, err := h.service.Update(userID, uint(id), input)
+	if err != nil {
+		respondAddressError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, address)
+}
+
+func (h *AddressHandler) SetDefault(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid address ID"})
+		return
+	}
+
+	address, err := h.service.SetDefault(userID, uint(id))
+	if err != nil {
+		respondAddressError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, address)
+}
+
+func (h *AddressHandler) Delete(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid address ID"})
+		return
+	}
+
+	if err := h.service.Delete(userID, uint(id)); err != nil {
+		respondAddressError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Address deleted"})
+}
+
+func respondAddressError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrAddressNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Address not found"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Address request failed"})
+	}
+}
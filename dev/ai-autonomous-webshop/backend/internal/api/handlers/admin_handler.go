@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
 
@@ -11,11 +12,15 @@ import (
 
 type AdminHandler struct {
 	marginService *services.MarginService
+	roleService   *services.RoleService
+	auditService  *services.SecurityAuditService
 }
 
 func NewAdminHandler() *AdminHandler {
 	return &AdminHandler{
 		marginService: services.NewMarginService(),
+		roleService:   &services.RoleService{},
+		auditService:  &services.SecurityAuditService{},
 	}
 }
 
@@ -81,3 +86,92 @@ func (h *AdminHandler) ApplyPriceChange(c *gin.Context) {
 
 	c.JSON(http.StatusOK, result)
 }
+
+func (h *AdminHandler) GetUserRoles(c *gin.Context) {
+	userID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	roles, err := h.roleService.GetUserRoles(uint(userID))
+	if err != nil {
+		h.respondRoleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"user_id":     userID,
+		"roles":       roles,
+		"permissions": models.PermissionsForRoles(roles),
+	})
+}
+
+func (h *AdminHandler) GrantRole(c *gin.Context) {
+	userID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var req struct {
+		Role string `json:"role" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	grantedBy := c.MustGet("userID").(uint)
+	if err := h.roleService.Grant(uint(userID), req.Role, grantedBy); err != nil {
+		h.respondRoleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Role granted", "role": req.Role})
+}
+
+func (h *AdminHandler) RevokeRole(c *gin.Context) {
+	userID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	role := c.Param("role")
+	if err := h.roleService.Revoke(uint(userID), role); err != nil {
+		h.respondRoleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Role revoked", "role": role})
+}
+
+func (h *AdminHandler) respondRoleError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrUserNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrInvalidRole), errors.Is(err, services.ErrImplicitRole):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrLastAdminRole):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update roles"})
+	}
+}
+
+func (h *AdminHandler) GetSecurityEvents(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "50"))
+	if page <= 0 {
+		page = 1
+	}
+
+	result, err := h.auditService.List(c.Query("type"), page, pageSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch security events"})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
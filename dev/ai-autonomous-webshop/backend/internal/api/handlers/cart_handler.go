@@ -1,37 +1,98 @@
 package handlers
 
 import (
+	"errors"
+	"log"
 	"net/http"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/api/middleware"
 	"github.com/jeremy/ai-autonomous-webshop/backend/internal/services"
 )
 
+// GuestCartCookie holds the signed ID of a visitor's guest cart.
+const GuestCartCookie = "nexus_cart"
+
 type CartHandler struct {
-	service *services.CartService
+	service   *services.CartService
+	addresses *services.AddressService
 }
 
 func NewCartHandler() *CartHandler {
 	return &CartHandler{
-		service: &services.CartService{},
+		service:   services.NewCartService(),
+		addresses: services.NewAddressService(),
+	}
+}
+
+// cartOwner picks the signed-in user's cart or the guest cart from the
+// cookie. With create set a guest without a valid cookie gets a new cart;
+// otherwise ok is false for them.
+func (h *CartHandler) cartOwner(c *gin.Context, create bool) (owner services.CartOwner, ok bool, err error) {
+	if userID, exists := c.Get("userID"); exists {
+		return services.UserCart(userID.(uint)), true, nil
+	}
+
+	if cookie, err := c.Cookie(GuestCartCookie); err == nil {
+		if id, valid := h.service.ParseGuestCookie(cookie); valid {
+			return services.GuestCart(id), true, nil
+		}
+	}
+	if !create {
+		return services.CartOwner{}, false, nil
+	}
+
+	id, cookie, err := h.service.NewGuestCart()
+	if err != nil {
+		return services.CartOwner{}, false, err
 	}
+	setGuestCartCookie(c, cookie, int(h.service.GuestTTL().Seconds()))
+	return services.GuestCart(id), true, nil
+}
+
+func setGuestCartCookie(c *gin.Context, value string, maxAge int) {
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(GuestCartCookie, value, maxAge, "/", "", c.Request.TLS != nil, true)
 }
 
+// GetCart returns the cart priced in the currency picked by
+// CurrencyMiddleware, with totals and warnings about changed lines. Tax and
+// shipping are estimated for the detected location, or for one of the
+// user's addresses given as address_id; a vat_id query parameter shows the
+// business (reverse charge) view.
 func (h *CartHandler) GetCart(c *gin.Context) {
-	userID := c.MustGet("userID").(uint)
-	items, err := h.service.GetCart(userID)
+	owner, _, _ := h.cartOwner(c, false)
+
+	location := middleware.GetTaxLocation(c)
+	if value := c.Query("address_id"); value != "" && !owner.IsGuest() {
+		addressID, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid address ID"})
+			return
+		}
+		address, err := h.addresses.Get(owner.UserID, uint(addressID))
+		if err != nil {
+			respondAddressError(c, err)
+			return
+		}
+		location.Country, location.Region = address.Country, address.Region
+	}
+	location.VATID = c.Query("vat_id")
+	summary, err := h.service.Summary(c.Request.Context(), owner, middleware.GetUserCurrency(c), location)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch cart"})
+		respondCartError(c, err, "Failed to fetch cart")
 		return
 	}
-	c.JSON(http.StatusOK, items)
+	c.JSON(http.StatusOK, summary)
 }
 
+// AddToCart adds a variant to the cart. product_id alone is enough for a
+// product that has a single variant.
 func (h *CartHandler) AddToCart(c *gin.Context) {
-	userID := c.MustGet("userID").(uint)
 	var req struct {
-		ProductID uint `json:"product_id" binding:"required"`
+		ProductID uint `json:"product_id" binding:"required_without=VariantID"`
+		VariantID uint `json:"variant_id"`
 		Quantity  int  `json:"quantity" binding:"required,min=1"`
 	}
 
@@ -40,27 +101,145 @@ func (h *CartHandler) AddToCart(c *gin.Context) {
 		return
 	}
 
-	if err := h.service.AddToCart(userID, req.ProductID, req.Quantity); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add to cart"})
+	owner, _, err := h.cartOwner(c, true)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create cart"})
+		return
+	}
+
+	if err := h.service.AddToCart(c.Request.Context(), owner, req.ProductID, req.VariantID, req.Quantity); err != nil {
+		respondCartError(c, err, "Failed to add to cart")
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Item added to cart"})
 }
 
-func (h *CartHandler) RemoveFromCart(c *gin.Context) {
-	userID := c.MustGet("userID").(uint)
-	productIDStr := c.Param("product_id")
-	productID, err := strconv.Atoi(productIDStr)
+// UpdateQuantity sets the quantity of a variant in the cart; zero removes
+// it.
+func (h *CartHandler) UpdateQuantity(c *gin.Context) {
+	variantID, err := strconv.Atoi(c.Param("variant_id"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid variant ID"})
 		return
 	}
 
-	if err := h.service.RemoveFromCart(userID, uint(productID)); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove from cart"})
+	var req struct {
+		Quantity *int `json:"quantity" binding:"required,min=0"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
+	owner, ok, _ := h.cartOwner(c, false)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Variant is not in the cart"})
+		return
+	}
+
+	if err := h.service.SetQuantity(c.Request.Context(), owner, uint(variantID), *req.Quantity); err != nil {
+		respondCartError(c, err, "Failed to update cart")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Cart updated"})
+}
+
+func (h *CartHandler) RemoveFromCart(c *gin.Context) {
+	variantID, err := strconv.Atoi(c.Param("variant_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid variant ID"})
+		return
+	}
+
+	owner, ok, _ := h.cartOwner(c, false)
+	if ok {
+		if err := h.service.RemoveFromCart(c.Request.Context(), owner, uint(variantID)); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove from cart"})
+			return
+		}
+	}
+
 	c.JSON(http.StatusOK, gin.H{"message": "Item removed from cart"})
 }
+
+func (h *CartHandler) ApplyCoupon(c *gin.Context) {
+	var req struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	owner, _, err := h.cartOwner(c, true)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create cart"})
+		return
+	}
+
+	if err := h.service.ApplyCoupon(c.Request.Context(), owner, req.Code); err != nil {
+		respondCartError(c, err, "Failed to apply coupon")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Coupon applied"})
+}
+
+func (h *CartHandler) RemoveCoupon(c *gin.Context) {
+	owner, ok, _ := h.cartOwner(c, false)
+	if ok {
+		if err := h.service.RemoveCoupon(c.Request.Context(), owner, c.Param("code")); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove coupon"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Coupon removed"})
+}
+
+func respondCartError(c *gin.Context, err error, fallback string) {
+	var shortage *services.InsufficientStockError
+	var limit *services.CartQuantityLimitError
+	var coupon *services.CouponError
+	switch {
+	case errors.As(err, &shortage):
+		c.JSON(http.StatusConflict, gin.H{"error": "insufficient_stock", "items": shortage.Items})
+	case errors.As(err, &limit):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "quantity_limit", "product_id": limit.ProductID, "variant_id": limit.VariantID, "max_quantity": limit.Max})
+	case errors.Is(err, services.ErrProductUnavailable):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+	case errors.Is(err, services.ErrVariantRequired):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+	case errors.As(err, &coupon):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "coupon_rejected", "coupon": coupon.Code, "reason": coupon.Reason})
+	case errors.Is(err, services.ErrInvalidVATID):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrTooManyCoupons):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrCartItemNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Variant is not in the cart"})
+	case errors.Is(err, services.ErrInvalidQuantity):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fallback})
+	}
+}
+
+// mergeGuestCart folds the visitor's guest cart, if any, into the account
+// they just signed into and drops the cookie. A failure is logged but never
+// fails the login.
+func mergeGuestCart(c *gin.Context, carts *services.CartService, userID uint) {
+	cookie, err := c.Cookie(GuestCartCookie)
+	if err != nil {
+		return
+	}
+	if guestID, ok := carts.ParseGuestCookie(cookie); ok {
+		if _, err := carts.MergeGuestCart(c.Request.Context(), guestID, userID); err != nil {
+			log.Printf("Failed to merge guest cart into user %d: %v", userID, err)
+			return
+		}
+	}
+	setGuestCartCookie(c, "", -1)
+}
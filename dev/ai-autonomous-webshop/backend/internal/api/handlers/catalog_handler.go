@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/config"
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/models"
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/services"
+)
+
+type CatalogHandler struct {
+	service     *services.CatalogService
+	categories  *services.CategoryService
+	maxUploadMB int
+}
+
+func NewCatalogHandler() *CatalogHandler {
+	return &CatalogHandler{
+		service:     services.NewCatalogService(),
+		categories:  services.NewCategoryService(),
+		maxUploadMB: config.LoadConfig().CatalogImportMaxMB,
+	}
+}
+
+// StartImport takes a catalog file in the "file" form field and queues it
+// for import. The format comes from the format query parameter or else the
+// file name; dry_run=true only checks the rows. Uploads larger than
+// CATALOG_IMPORT_MAX_MB are refused with 413.
+func (h *CatalogHandler) StartImport(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, int64(h.maxUploadMB)<<20)
+	header, err := c.FormFile("file")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("Catalog file is larger than %d MB", h.maxUploadMB)})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No catalog file provided"})
+		return
+	}
+
+	format := c.Query("format")
+	if format == "" {
+		format = services.CatalogFormat(header.Filename)
+	}
+	dryRun := false
+	if value := c.Query("dry_run"); value != "" {
+		if dryRun, err = strconv.ParseBool(value); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid dry_run flag"})
+			return
+		}
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Catalog file could not be read"})
+		return
+	}
+	defer file.Close()
+
+	staffID := c.MustGet("userID").(uint)
+	job, err := h.service.StartImport(staffID, format, header.Filename, file, dryRun)
+	if err != nil {
+		respondCatalogError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, job)
+}
+
+func (h *CatalogHandler) ListImports(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+
+	result, err := h.service.ListImports(page, pageSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch import jobs"})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// GetImport returns a job with its counts and the errors of rejected rows.
+func (h *CatalogHandler) GetImport(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid import job ID"})
+		return
+	}
+
+	job, err := h.service.GetImport(uint(id))
+	if err != nil {
+		respondCatalogError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
+
+// Export streams the catalog, one row per variant, as CSV or NDJSON
+// (format, default csv). category (a slug, taking in the categories below
+// it), updated_since (RFC 3339) and in_stock=true narrow it down.
+func (h *CatalogHandler) Export(c *gin.Context) {
+	format := c.DefaultQuery("format", models.CatalogFormatCSV)
+	contentType := map[string]string{
+		models.CatalogFormatCSV:    "text/csv; charset=utf-8",
+		models.CatalogFormatNDJSON: "application/x-ndjson",
+	}[format]
+	if contentType == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": services.ErrUnsupportedFormat.Error()})
+		return
+	}
+
+	filter := services.CatalogFilter{}
+	if slug := c.Query("category"); slug != "" {
+		category, err := h.categories.BySlug(slug)
+		if errors.Is(err, services.ErrCategoryNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Category not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to look up category"})
+			return
+		}
+		filter.CategoryID = category.ID
+	}
+	if value := c.Query("updated_since"); value != "" {
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid updated_since timestamp, expected RFC 3339"})
+			return
+		}
+		filter.UpdatedSince = &t
+	}
+	if value := c.Query("in_stock"); value != "" {
+		inStock, err := strconv.ParseBool(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid in_stock flag"})
+			return
+		}
+		filter.InStockOnly = inStock
+	}
+
+	// A large catalog takes longer to send than the server's write timeout
+	// allows for a single response.
+	if err := http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{}); err != nil {
+		log.Printf("Catalog export: could not lift write deadline: %v", err)
+	}
+
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="catalog-%s.%s"`, time.Now().UTC().Format("20060102-150405"), format))
+	c.Status(http.StatusOK)
+
+	err := h.service.Export(c.Request.Context(), c.Writer, format, filter, c.Writer.Flush)
+	if err != nil {
+		// The status line is out already; all that can be done is to cut
+		// the response short and leave a trace.
+		log.Printf("Catalog export failed: %v", err)
+	}
+}
+
+func respondCatalogError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrUnsupportedFormat):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrImportJobNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Import job not found"})
+	case errors.Is(err, services.ErrImportQueueFull):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start catalog import"})
+	}
+}
@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/api/middleware"
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/services"
+)
+
+type CheckoutHandler struct {
+	service      *services.CheckoutService
+	reservations *services.StockReservationService
+}
+
+func NewCheckoutHandler() *CheckoutHandler {
+	return &CheckoutHandler{
+		service:      services.NewCheckoutService(),
+		reservations: services.NewStockReservationService(),
+	}
+}
+
+// Reserve holds the stock of the user's cart while they enter payment
+// details. Checkout converts the hold into the order; if it doesn't happen
+// before expires_at the stock goes back on sale.
+func (h *CheckoutHandler) Reserve(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+
+	hold, err := h.reservations.HoldCart(c.Request.Context(), userID)
+	if err != nil {
+		respondCheckoutError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, hold)
+}
+
+func (h *CheckoutHandler) ReleaseReservation(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+
+	if err := h.reservations.ReleaseCart(c.Request.Context(), userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to release reservation"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Reservation released"})
+}
+
+func (h *CheckoutHandler) Checkout(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+
+	// The card is tokenized client-side by the payment provider; only the
+	// resulting payment method ID is sent here.
+	var req struct {
+		PaymentMethodID  string `json:"payment_method_id" binding:"required"`
+		AddressID        uint   `json:"address_id" binding:"required"`
+		ShippingMethodID uint   `json:"shipping_method_id" binding:"required"`
+		VATID            string `json:"vat_id"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result, err := h.service.Checkout(c.Request.Context(), userID, services.CheckoutOptions{
+		AddressID:        req.AddressID,
+		ShippingMethodID: req.ShippingMethodID,
+		VATID:            req.VATID,
+		PaymentMethodID:  req.PaymentMethodID,
+		Currency:         middleware.GetUserCurrency(c),
+	})
+	if err != nil {
+		respondCheckoutError(c, err)
+		return
+	}
+
+	respondCheckoutResult(c, result)
+}
+
+// CompletePayment is called by the client after it finished 3-D Secure for
+// an order that checkout left pending.
+func (h *CheckoutHandler) CompletePayment(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	orderID, err := strconv.ParseUint(c.Param("order_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
+
+	result, err := h.service.CompletePayment(c.Request.Context(), userID, uint(orderID))
+	if err != nil {
+		respondCheckoutError(c, err)
+		return
+	}
+
+	respondCheckoutResult(c, result)
+}
+
+func respondCheckoutResult(c *gin.Context, result *services.CheckoutResult) {
+	if result.ActionRequired() {
+		c.JSON(http.StatusAccepted, gin.H{
+			"order":           result.Order,
+			"requires_action": true,
+			"client_secret":   result.Payment.ClientSecret,
+			"next_action_url": result.Payment.NextActionURL,
+		})
+		return
+	}
+	c.JSON(http.StatusCreated, result.Order)
+}
+
+func respondCheckoutError(c *gin.Context, err error) {
+	var shortage *services.InsufficientStockError
+	var limit *services.CartQuantityLimitError
+	var coupon *services.CouponError
+	switch {
+	case errors.As(err, &shortage):
+		c.JSON(http.StatusConflict, gin.H{"error": "insufficient_stock", "items": shortage.Items})
+	case errors.As(err, &limit):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "quantity_limit", "product_id": limit.ProductID, "variant_id": limit.VariantID, "max_quantity": limit.Max})
+	case errors.As(err, &coupon):
+		c.JSON(http.StatusConflict, gin.H{"error": "coupon_rejected", "coupon": coupon.Code, "reason": coupon.Reason})
+	case errors.Is(err, services.ErrCartEmpty):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cart is empty"})
+	case errors.Is(err, services.ErrInvalidQuantity),
+		errors.Is(err, services.ErrVariantRequired),
+		errors.Is(err, services.ErrInvalidVATID),
+		errors.Is(err, services.ErrAddressNotFound):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrShippingUnavailable):
+		c.JSON(http.StatusConflict, gin.H{"error": "shipping_unavailable", "message": err.Error()})
+	case errors.Is(err, services.ErrProductUnavailable):
+		c.JSON(http.StatusConflict, gin.H{"error": "One or more products are no longer available"})
+	case errors.Is(err, services.ErrOrderNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+	case errors.Is(err, services.ErrOrderNotPending):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrPaymentDeclined):
+		c.JSON(http.StatusPaymentRequired, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrPaymentActionRequired):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "requires_action": true})
+	case errors.Is(err, services.ErrPaymentTimeout):
+		c.JSON(http.StatusGatewayTimeout, gin.H{"error": "Payment provider did not respond, you have not been charged"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Checkout failed"})
+	}
+}
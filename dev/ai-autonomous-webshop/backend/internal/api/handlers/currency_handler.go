@@ -5,6 +5,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/jeremy/ai-autonomous-webshop/backend/internal/api/middleware"
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/money"
 	"github.com/jeremy/ai-autonomous-webshop/backend/internal/services"
 )
 
@@ -22,6 +23,7 @@ func NewCurrencyHandler() *CurrencyHandler {
 
 type CurrencyResponse struct {
 	Code          string  `json:"code"`
+	Numeric       string  `json:"numeric"`
 	Symbol        string  `json:"symbol"`
 	Name          string  `json:"name"`
 	Rate          float64 `json:"rate"`
@@ -90,6 +92,7 @@ func (h *CurrencyHandler) GetCurrency(c *gin.Context) {
 
 	c.JSON(http.StatusOK, CurrencyResponse{
 		Code:          currency.Code,
+		Numeric:       currency.Numeric,
 		Symbol:        currency.Symbol,
 		Name:          currency.Name,
 		Rate:          currency.Rate,
@@ -104,6 +107,7 @@ func (h *CurrencyHandler) GetUserCurrency(c *gin.Context) {
 
 	c.JSON(http.StatusOK, CurrencyResponse{
 		Code:          currency.Code,
+		Numeric:       currency.Numeric,
 		Symbol:        currency.Symbol,
 		Name:          currency.Name,
 		Rate:          currency.Rate,
@@ -119,6 +123,7 @@ func (h *CurrencyHandler) GetSupportedCurrencies(c *gin.Context) {
 	for i, curr := range currencies {
 		response[i] = CurrencyResponse{
 			Code:          curr.Code,
+			Numeric:       curr.Numeric,
 			Symbol:        curr.Symbol,
 			Name:          curr.Name,
 			Rate:          curr.Rate,
@@ -127,12 +132,32 @@ func (h *CurrencyHandler) GetSupportedCurrencies(c *gin.Context) {
 		}
 	}
 
+	status := h.currencyService.RateStatus()
 	c.JSON(http.StatusOK, gin.H{
-		"currencies": response,
-		"default":    "USD",
+		"currencies":  response,
+		"default":     services.BaseCurrency,
+		"rates_as_of": status.AsOf,
+		"rates_stale": status.Stale,
 	})
 }
 
+// GetRates returns the exchange rates in use, where they come from and
+// whether they are stale.
+func (h *CurrencyHandler) GetRates(c *gin.Context) {
+	c.JSON(http.StatusOK, h.currencyService.RateStatus())
+}
+
+// RefreshRates fetches rates from the provider now instead of waiting for
+// the next scheduled refresh.
+func (h *CurrencyHandler) RefreshRates(c *gin.Context) {
+	if err := h.currencyService.RefreshRates(); err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to refresh exchange rates: " + err.Error(), "rates": h.currencyService.RateStatus()})
+		return
+	}
+
+	c.JSON(http.StatusOK, h.currencyService.RateStatus())
+}
+
 func (h *CurrencyHandler) SetCurrency(c *gin.Context) {
 	code := c.PostForm("currency")
 
@@ -176,13 +201,13 @@ func (h *CurrencyHandler) ConvertPrice(c *gin.Context) {
 		return
 	}
 
-	converted := h.currencyService.ConvertPricePrecise(req.Amount, req.From, req.To, 2)
+	converted := h.currencyService.ConvertPrice(money.FromFloat(req.Amount, req.From), req.To)
 	rate := h.currencyService.GetExchangeRate(req.From, req.To)
-	formatted := h.currencyService.FormatPrice(converted, req.To)
+	formatted := h.currencyService.FormatPrice(converted)
 
 	c.JSON(http.StatusOK, ConversionResponse{
 		OriginalAmount:  req.Amount,
-		ConvertedAmount: converted,
+		ConvertedAmount: converted.Float(),
 		FromCurrency:    req.From,
 		ToCurrency:      req.To,
 		ExchangeRate:    rate,
@@ -211,14 +236,14 @@ func (h *CurrencyHandler) ConvertMultiple(c *gin.Context) {
 	results := make([]ConversionResponse, len(req.Amounts))
 
 	for i, amount := range req.Amounts {
-		converted := h.currencyService.ConvertPricePrecise(amount, req.From, req.To, 2)
+		converted := h.currencyService.ConvertPrice(money.FromFloat(amount, req.From), req.To)
 		results[i] = ConversionResponse{
 			OriginalAmount:  amount,
-			ConvertedAmount: converted,
+			ConvertedAmount: converted.Float(),
 			FromCurrency:    req.From,
 			ToCurrency:      req.To,
 			ExchangeRate:    rate,
-			Formatted:       h.currencyService.FormatPrice(converted, req.To),
+			Formatted:       h.currencyService.FormatPrice(converted),
 		}
 	}
 
@@ -229,6 +254,5 @@ func (h *CurrencyHandler) ConvertMultiple(c *gin.Context) {
 }
 
 func (h *CurrencyHandler) isValidCurrency(code string) bool {
-	_, exists := services.CurrencyMap[code]
-	return exists
+	return services.IsSupportedCurrency(code)
 }
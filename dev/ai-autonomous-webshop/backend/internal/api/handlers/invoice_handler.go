@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/api/middleware"
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/models"
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/services"
+)
+
+type InvoiceHandler struct {
+	service *services.InvoiceService
+}
+
+func NewInvoiceHandler() *InvoiceHandler {
+	return &InvoiceHandler{
+		service: services.NewInvoiceService(),
+	}
+}
+
+// GetInvoice returns the invoice of an order as PDF.
+func (h *InvoiceHandler) GetInvoice(c *gin.Context) {
+	orderID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
+
+	invoice, err := h.service.Invoice(c.Request.Context(), uint(orderID), invoiceScope(c))
+	if err != nil {
+		respondInvoiceError(c, err)
+		return
+	}
+
+	respondInvoicePDF(c, invoice)
+}
+
+// ListDocuments lists the invoice and credit notes of an order.
+func (h *InvoiceHandler) ListDocuments(c *gin.Context) {
+	orderID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
+
+	documents, err := h.service.Documents(c.Request.Context(), uint(orderID), invoiceScope(c))
+	if err != nil {
+		respondInvoiceError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"documents": documents})
+}
+
+// GetDocument returns one invoice or credit note of an order as PDF.
+func (h *InvoiceHandler) GetDocument(c *gin.Context) {
+	orderID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
+	documentID, err := strconv.ParseUint(c.Param("document_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid document ID"})
+		return
+	}
+
+	document, err := h.service.Document(c.Request.Context(), uint(orderID), invoiceScope(c), uint(documentID))
+	if err != nil {
+		respondInvoiceError(c, err)
+		return
+	}
+
+	respondInvoicePDF(c, document)
+}
+
+// invoiceScope restricts customers to their own orders. Staff who manage
+// orders can fetch the documents of any order.
+func invoiceScope(c *gin.Context) uint {
+	if middleware.HasPermission(c, models.PermOrdersManage) {
+		return 0
+	}
+	return c.MustGet("userID").(uint)
+}
+
+func respondInvoicePDF(c *gin.Context, invoice *models.Invoice) {
+	c.Header("Content-Disposition", fmt.Sprintf(`inline; filename="%s.pdf"`, invoice.Number))
+	c.Header("Cache-Control", "private, max-age=86400")
+	c.Data(http.StatusOK, "application/pdf", invoice.PDF)
+}
+
+func respondInvoiceError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrOrderNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+	case errors.Is(err, services.ErrInvoiceNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+	case errors.Is(err, services.ErrOrderNotInvoiced):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch invoice"})
+	}
+}
@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/services"
+)
+
+type MFAHandler struct {
+	service      *services.MFAService
+	tokenService *services.TokenService
+	cartService  *services.CartService
+}
+
+func NewMFAHandler() *MFAHandler {
+	return &MFAHandler{
+		service:      services.NewMFAService(),
+		tokenService: services.NewTokenService(),
+		cartService:  services.NewCartService(),
+	}
+}
+
+// Verify completes a login for an account with MFA enabled by exchanging the
+// mfa_token from /auth/login and a TOTP or recovery code for a token pair.
+func (h *MFAHandler) Verify(c *gin.Context) {
+	var req struct {
+		MFAToken     string `json:"mfa_token" binding:"required"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Code == "" && req.RecoveryCode == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "code or recovery_code is required"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	claims, err := h.tokenService.ParseMFAPendingToken(ctx, req.MFAToken)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired MFA token"})
+		return
+	}
+
+	user, err := h.service.VerifyLogin(ctx, claims.UserID, req.Code, req.RecoveryCode)
+	if err != nil {
+		h.respondError(c, err)
+		return
+	}
+
+	if err := h.tokenService.ConsumeMFAPendingToken(ctx, claims); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	meta := sessionMeta(c)
+	meta.MFAVerified = true
+	tokens, err := h.tokenService.IssueTokenPair(ctx, user, meta)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+	mergeGuestCart(c, h.cartService, user.ID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"token":              tokens.AccessToken,
+		"access_token":       tokens.AccessToken,
+		"refresh_token":      tokens.RefreshToken,
+		"token_type":         tokens.TokenType,
+		"expires_in":         tokens.ExpiresIn,
+		"refresh_expires_at": tokens.RefreshExpiresAt,
+		"user":               user,
+	})
+}
+
+func (h *MFAHandler) Enroll(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+
+	enrollment, err := h.service.Enroll(userID)
+	if err != nil {
+		h.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, enrollment)
+}
+
+func (h *MFAHandler) ConfirmEnrollment(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+
+	var req struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	codes, err := h.service.ConfirmEnrollment(c.Request.Context(), userID, req.Code)
+	if err != nil {
+		h.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "Two-factor authentication enabled, log in again to use it",
+		"recovery_codes": codes,
+	})
+}
+
+func (h *MFAHandler) Disable(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+
+	var req struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.service.Disable(c.Request.Context(), userID, req.Code); err != nil {
+		h.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication disabled"})
+}
+
+func (h *MFAHandler) RegenerateRecoveryCodes(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+
+	var req struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	codes, err := h.service.RegenerateRecoveryCodes(c.Request.Context(), userID, req.Code)
+	if err != nil {
+		h.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+func (h *MFAHandler) respondError(c *gin.Context, err error) {
+	if respondAccountLocked(c, err, "Too many failed two-factor attempts") {
+		return
+	}
+	switch {
+	case errors.Is(err, services.ErrInvalidMFACode):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid two-factor code"})
+	case errors.Is(err, services.ErrMFAAlreadyEnabled),
+		errors.Is(err, services.ErrMFANotEnrolled),
+		errors.Is(err, services.ErrMFANotEnabled):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrUserNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Two-factor authentication request failed"})
+	}
+}
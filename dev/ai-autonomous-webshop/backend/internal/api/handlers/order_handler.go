@@ -1,21 +1,28 @@
 package handlers
 
 import (
+	"errors"
+	"io"
 	"net/http"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/api/middleware"
 	"github.com/jeremy/ai-autonomous-webshop/backend/internal/models"
 	"github.com/jeremy/ai-autonomous-webshop/backend/internal/services"
 )
 
 type OrderHandler struct {
-	service *services.OrderService
+	service         *services.OrderService
+	checkoutService *services.CheckoutService
+	refundService   *services.RefundService
 }
 
 func NewOrderHandler() *OrderHandler {
 	return &OrderHandler{
-		service: &services.OrderService{},
+		service:         &services.OrderService{},
+		checkoutService: services.NewCheckoutService(),
+		refundService:   services.NewRefundService(),
 	}
 }
 
@@ -23,8 +30,12 @@ func (h *OrderHandler) Create(c *gin.Context) {
 	userID := c.MustGet("userID").(uint)
 
 	var req struct {
-		Items      []models.OrderItem `json:"items" binding:"required"`
-		CardNumber string             `json:"card_number" binding:"required"`
+		Items            []models.OrderItem `json:"items" binding:"required"`
+		Coupons          []string           `json:"coupons"`
+		PaymentMethodID  string             `json:"payment_method_id" binding:"required"`
+		AddressID        uint               `json:"address_id" binding:"required"`
+		ShippingMethodID uint               `json:"shipping_method_id" binding:"required"`
+		VATID            string             `json:"vat_id"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -32,13 +43,20 @@ func (h *OrderHandler) Create(c *gin.Context) {
 		return
 	}
 
-	order, err := h.service.Create(userID, req.Items, req.CardNumber)
+	result, err := h.checkoutService.PlaceOrder(c.Request.Context(), userID, req.Items, services.CheckoutOptions{
+		AddressID:        req.AddressID,
+		ShippingMethodID: req.ShippingMethodID,
+		VATID:            req.VATID,
+		Coupons:          req.Coupons,
+		PaymentMethodID:  req.PaymentMethodID,
+		Currency:         middleware.GetUserCurrency(c),
+	})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondCheckoutError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusCreated, order)
+	respondCheckoutResult(c, result)
 }
 
 func (h *OrderHandler) GetMyOrders(c *gin.Context) {
@@ -52,3 +70,196 @@ func (h *OrderHandler) GetMyOrders(c *gin.Context) {
 
 	c.JSON(http.StatusOK, orders)
 }
+
+func (h *OrderHandler) GetHistory(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	orderID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
+
+	history, err := h.service.History(uint(orderID), userID)
+	if err != nil {
+		respondOrderError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, history)
+}
+
+func (h *OrderHandler) AdminGetHistory(c *gin.Context) {
+	orderID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
+
+	history, err := h.service.History(uint(orderID), 0)
+	if err != nil {
+		respondOrderError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, history)
+}
+
+// fulfilmentStatuses are the statuses staff may set by hand. Payment
+// related statuses only change through checkout, refunds and webhooks so
+// they can't drift from what the payment provider knows.
+var fulfilmentStatuses = map[string]bool{
+	models.OrderStatusPicking:   true,
+	models.OrderStatusShipped:   true,
+	models.OrderStatusDelivered: true,
+}
+
+func (h *OrderHandler) AdminUpdateStatus(c *gin.Context) {
+	orderID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
+
+	var req struct {
+		Status string `json:"status" binding:"required"`
+		Reason string `json:"reason"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !fulfilmentStatuses[req.Status] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Status cannot be set manually", "status": req.Status})
+		return
+	}
+
+	staffID := c.MustGet("userID").(uint)
+	order, err := h.service.Transition(c.Request.Context(), uint(orderID), req.Status, services.StaffActor(staffID), req.Reason)
+	if err != nil {
+		respondOrderError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, order)
+}
+
+// Cancel lets a customer cancel their order until it ships. Paid orders
+// are refunded in full.
+func (h *OrderHandler) Cancel(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	orderID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
+
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Reason == "" {
+		req.Reason = "cancelled by customer"
+	}
+
+	order, err := h.refundService.Cancel(c.Request.Context(), uint(orderID), userID, services.CustomerActor(userID), req.Reason)
+	if err != nil {
+		respondOrderError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, order)
+}
+
+func (h *OrderHandler) AdminCancel(c *gin.Context) {
+	orderID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
+
+	var req struct {
+		Reason string `json:"reason" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	staffID := c.MustGet("userID").(uint)
+	order, err := h.refundService.Cancel(c.Request.Context(), uint(orderID), 0, services.StaffActor(staffID), req.Reason)
+	if err != nil {
+		respondOrderError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, order)
+}
+
+// AdminRefund refunds part or all of a paid order without a return.
+func (h *OrderHandler) AdminRefund(c *gin.Context) {
+	orderID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
+
+	var req struct {
+		Amount float64 `json:"amount" binding:"required,gt=0"`
+		Reason string  `json:"reason" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	staffID := c.MustGet("userID").(uint)
+	refund, err := h.refundService.Refund(c.Request.Context(), uint(orderID), req.Amount, req.Reason, services.StaffActor(staffID))
+	if err != nil {
+		respondOrderError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, refund)
+}
+
+func (h *OrderHandler) AdminListRefunds(c *gin.Context) {
+	orderID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
+
+	refunds, err := h.refundService.ListForOrder(uint(orderID))
+	if err != nil {
+		respondOrderError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, refunds)
+}
+
+func respondOrderError(c *gin.Context, err error) {
+	var invalid *services.InvalidTransitionError
+	switch {
+	case errors.As(err, &invalid):
+		c.JSON(http.StatusConflict, gin.H{"error": invalid.Error(), "from": invalid.From, "to": invalid.To})
+	case errors.Is(err, services.ErrOrderNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+	case errors.Is(err, services.ErrInvalidOrderStatus):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrOrderStatusChanged),
+		errors.Is(err, services.ErrOrderNotCancellable),
+		errors.Is(err, services.ErrOrderNotRefundable),
+		errors.Is(err, services.ErrRefundExceedsPaid):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrInvalidRefundAmount):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrRefundFailed):
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update order"})
+	}
+}
@@ -1,36 +1,80 @@
 package handlers
 
 import (
+	"context"
+	"errors"
 	"log"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/jeremy/ai-autonomous-webshop/backend/internal/api/middleware"
 	"github.com/jeremy/ai-autonomous-webshop/backend/internal/models"
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/money"
 	"github.com/jeremy/ai-autonomous-webshop/backend/internal/services"
 )
 
+// productMetaTimeout is how long staff asking for meta fields wait for the
+// AI provider before the template is used.
+const productMetaTimeout = 15 * time.Second
+
 type ProductHandler struct {
 	service         *services.ProductService
+	variants        *services.VariantService
 	currencyService *services.CurrencyService
+	ai              *services.AIService
 }
 
 func NewProductHandler() *ProductHandler {
 	return &ProductHandler{
 		service:         &services.ProductService{},
+		variants:        services.NewVariantService(),
 		currencyService: services.NewCurrencyService(),
+		ai:              services.NewAIService(),
 	}
 }
 
+// ProductWithCurrency is a product priced in the shopper's currency with
+// its variant matrix, variant prices converted too. InStock is whether any
+// variant can be bought.
 type ProductWithCurrency struct {
 	models.Product
-	OriginalPrice    float64 `json:"original_price"`
-	ConvertedPrice   float64 `json:"converted_price"`
-	PriceFormatted   string  `json:"price_formatted"`
-	OriginalCurrency string  `json:"original_currency"`
-	UserCurrency     string  `json:"user_currency"`
-	ExchangeRate     float64 `json:"exchange_rate"`
+	OriginalPrice    money.Money                    `json:"original_price"`
+	ConvertedPrice   money.Money                    `json:"converted_price"`
+	PriceFormatted   string                         `json:"price_formatted"`
+	OriginalCurrency string                         `json:"original_currency"`
+	UserCurrency     string                         `json:"user_currency"`
+	ExchangeRate     float64                        `json:"exchange_rate"`
+	Options          []models.OptionType            `json:"options"`
+	Variants         []services.VariantAvailability `json:"variants"`
+	InStock          bool                           `json:"in_stock"`
+}
+
+func (h *ProductHandler) withCurrency(p models.Product, userCurrency string) ProductWithCurrency {
+	convertedPrice := h.currencyService.ConvertPrice(p.Price, userCurrency)
+	result := ProductWithCurrency{
+		Product:          p,
+		OriginalPrice:    p.Price,
+		ConvertedPrice:   convertedPrice,
+		PriceFormatted:   h.currencyService.FormatPrice(convertedPrice),
+		OriginalCurrency: "USD",
+		UserCurrency:     userCurrency,
+		ExchangeRate:     h.currencyService.GetExchangeRate("USD", userCurrency),
+		Options:          p.Options,
+		Variants:         h.variants.Matrix(&p),
+	}
+	if result.Options == nil {
+		result.Options = []models.OptionType{}
+	}
+	for i := range result.Variants {
+		v := &result.Variants[i]
+		v.Price = h.currencyService.ConvertPrice(v.Price, userCurrency)
+		result.InStock = result.InStock || v.Available
+	}
+	return result
 }
 
 func (h *ProductHandler) GetAll(c *gin.Context) {
@@ -57,18 +101,7 @@ func (h *ProductHandler) GetAll(c *gin.Context) {
 
 	var enrichedProducts []ProductWithCurrency
 	for _, p := range result.Products {
-		convertedPrice := h.currencyService.ConvertPricePrecise(p.Price, "USD", userCurrency, 2)
-		rate := h.currencyService.GetExchangeRate("USD", userCurrency)
-
-		enrichedProducts = append(enrichedProducts, ProductWithCurrency{
-			Product:          p,
-			OriginalPrice:    p.Price,
-			ConvertedPrice:   convertedPrice,
-			PriceFormatted:   h.currencyService.FormatPrice(convertedPrice, userCurrency),
-			OriginalCurrency: "USD",
-			UserCurrency:     userCurrency,
-			ExchangeRate:     rate,
-		})
+		enrichedProducts = append(enrichedProducts, h.withCurrency(p, userCurrency))
 		_ = aiService
 	}
 
@@ -88,46 +121,413 @@ func (h *ProductHandler) GetByID(c *gin.Context) {
 	}
 
 	product, err := h.service.GetByID(uint(id))
-	if err != nil {
+	if err != nil || product == nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
 		return
 	}
 
-	userCurrency := middleware.GetUserCurrency(c)
-	convertedPrice := h.currencyService.ConvertPricePrecise(product.Price, "USD", userCurrency, 2)
-	rate := h.currencyService.GetExchangeRate("USD", userCurrency)
+	c.Header("ETag", productETag(product))
+	c.JSON(http.StatusOK, h.withCurrency(*product, middleware.GetUserCurrency(c)))
+}
 
-	response := ProductWithCurrency{
-		Product:          *product,
-		OriginalPrice:    product.Price,
-		ConvertedPrice:   convertedPrice,
-		PriceFormatted:   h.currencyService.FormatPrice(convertedPrice, userCurrency),
-		OriginalCurrency: "USD",
-		UserCurrency:     userCurrency,
-		ExchangeRate:     rate,
+// GetBySlug is GetByID for storefront links. A slug from before the
+// product was renamed redirects permanently to the current one.
+func (h *ProductHandler) GetBySlug(c *gin.Context) {
+	product, current, err := h.service.GetBySlug(c.Param("slug"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch product"})
+		return
+	}
+	if product == nil && current != "" {
+		location := strings.TrimSuffix(c.FullPath(), ":slug") + url.PathEscape(current)
+		if c.Request.URL.RawQuery != "" {
+			location += "?" + c.Request.URL.RawQuery
+		}
+		c.Redirect(http.StatusMovedPermanently, location)
+		return
 	}
+	if product == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+
+	c.Header("ETag", productETag(product))
+	c.JSON(http.StatusOK, h.withCurrency(*product, middleware.GetUserCurrency(c)))
+}
+
+// productETag is the ETag of a product's current version. Edits send it
+// back in If-Match.
+func productETag(p *models.Product) string {
+	return `"` + strconv.Itoa(p.Version) + `"`
+}
 
-	c.JSON(http.StatusOK, response)
+// ifMatchVersion reads the product version an edit was made against from
+// If-Match. ok is false when the header is missing or isn't a product
+// ETag; "*" matches any version and gives 0.
+func ifMatchVersion(c *gin.Context) (version int, ok bool) {
+	tag := strings.TrimSpace(c.GetHeader("If-Match"))
+	if tag == "*" {
+		return 0, true
+	}
+	tag = strings.Trim(strings.TrimPrefix(tag, "W/"), `"`)
+	version, err := strconv.Atoi(tag)
+	if err != nil || version < 1 {
+		return 0, false
+	}
+	return version, true
 }
 
 func (h *ProductHandler) Create(c *gin.Context) {
-	var product models.Product
-	if err := c.ShouldBindJSON(&product); err != nil {
+	var input services.ProductCreateInput
+	if err := c.ShouldBindJSON(&input); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	// AI-Powered SEO Optimization
-	// Automatically generate meta tags and SEO-friendly slugs
-	if product.Description != "" {
-		log.Printf("AI Agent: Optimizing SEO for %s...", product.Name)
-		// Mock logic: generate keywords from description
+	product, err := h.service.Create(input)
+	if err != nil {
+		respondProductError(c, err)
+		return
 	}
 
-	if err := h.service.Create(&product); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create product"})
-		return
+	// Meta fields staff left blank are written by the AI service in the
+	// background, so a slow provider doesn't hold up the response.
+	if product.MetaTitle == "" || product.MetaDescription == "" {
+		go h.fillMeta(*product)
 	}
 
+	c.Header("ETag", productETag(product))
 	c.JSON(http.StatusCreated, product)
 }
+
+// Update replaces a product's details. If-Match must carry the ETag the
+// edit was made against.
+func (h *ProductHandler) Update(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+		return
+	}
+	version, ok := ifMatchVersion(c)
+	if !ok {
+		c.JSON(http.StatusPreconditionRequired, gin.H{"error": "If-Match header with the product's ETag is required"})
+		return
+	}
+
+	var input services.ProductInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	product, err := h.service.Update(uint(id), version, input)
+	if err != nil {
+		respondProductError(c, err)
+		return
+	}
+
+	c.Header("ETag", productETag(product))
+	c.JSON(http.StatusOK, product)
+}
+
+// Patch changes only the fields sent. If-Match is required as for Update.
+func (h *ProductHandler) Patch(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+		return
+	}
+	version, ok := ifMatchVersion(c)
+	if !ok {
+		c.JSON(http.StatusPreconditionRequired, gin.H{"error": "If-Match header with the product's ETag is required"})
+		return
+	}
+
+	var patch services.ProductPatch
+	if err := c.ShouldBindJSON(&patch); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	product, err := h.service.Patch(uint(id), version, patch)
+	if err != nil {
+		respondProductError(c, err)
+		return
+	}
+
+	c.Header("ETag", productETag(product))
+	c.JSON(http.StatusOK, product)
+}
+
+// GenerateMeta has the AI service rewrite a product's meta title and
+// description. If-Match is optional; without it, the product must not
+// change while they are written.
+func (h *ProductHandler) GenerateMeta(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+		return
+	}
+
+	product, err := h.service.GetByID(uint(id))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch product"})
+		return
+	}
+	if product == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+	if c.GetHeader("If-Match") != "" {
+		if version, ok := ifMatchVersion(c); !ok || (version != 0 && version != product.Version) {
+			c.JSON(http.StatusPreconditionFailed, gin.H{"error": services.ErrProductModified.Error()})
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), productMetaTimeout)
+	defer cancel()
+	product, err = h.service.Patch(product.ID, product.Version, h.metaPatch(ctx, product, true))
+	if err != nil {
+		respondProductError(c, err)
+		return
+	}
+
+	c.Header("ETag", productETag(product))
+	c.JSON(http.StatusOK, product)
+}
+
+// metaPatch has the AI service write a product's meta fields, all of them
+// or only the blank ones.
+func (h *ProductHandler) metaPatch(ctx context.Context, product *models.Product, overwrite bool) services.ProductPatch {
+	meta := h.ai.GenerateProductMeta(ctx, product.Name, product.Category, product.Description)
+	var patch services.ProductPatch
+	if overwrite || product.MetaTitle == "" {
+		patch.MetaTitle = &meta.Title
+	}
+	if overwrite || product.MetaDescription == "" {
+		patch.MetaDescription = &meta.Description
+	}
+	return patch
+}
+
+// fillMeta writes the blank meta fields of a product just created. If staff
+// edit the product in the meantime, whatever they left blank is filled in
+// on the version they saved; a failure only leaves the fields blank.
+func (h *ProductHandler) fillMeta(product models.Product) {
+	patch := h.metaPatch(context.Background(), &product, false)
+	for attempt := 0; attempt < 3; attempt++ {
+		_, err := h.service.Patch(product.ID, product.Version, patch)
+		if !errors.Is(err, services.ErrProductModified) {
+			if err != nil {
+				log.Printf("Failed to generate meta fields of product %d: %v", product.ID, err)
+			}
+			return
+		}
+
+		current, err := h.service.GetByID(product.ID)
+		if err != nil || current == nil {
+			return
+		}
+		product = *current
+		if product.MetaTitle != "" {
+			patch.MetaTitle = nil
+		}
+		if product.MetaDescription != "" {
+			patch.MetaDescription = nil
+		}
+		if patch.MetaTitle == nil && patch.MetaDescription == nil {
+			return
+		}
+	}
+}
+
+// Delete soft-deletes a product. If-Match is optional here; when sent, the
+// product is only deleted if it hasn't changed since.
+func (h *ProductHandler) Delete(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+		return
+	}
+	version := 0
+	if c.GetHeader("If-Match") != "" {
+		var ok bool
+		if version, ok = ifMatchVersion(c); !ok {
+			c.JSON(http.StatusPreconditionFailed, gin.H{"error": "If-Match does not match the product"})
+			return
+		}
+	}
+
+	if err := h.service.Delete(uint(id), version); err != nil {
+		respondProductError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Product deleted"})
+}
+
+func (h *ProductHandler) Restore(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+		return
+	}
+
+	product, err := h.service.Restore(uint(id))
+	if err != nil {
+		respondProductError(c, err)
+		return
+	}
+
+	c.Header("ETag", productETag(product))
+	c.JSON(http.StatusOK, product)
+}
+
+func (h *ProductHandler) ListDeleted(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+
+	result, err := h.service.ListDeleted(page, pageSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch products"})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+func (h *ProductHandler) BulkUpdatePrices(c *gin.Context) {
+	var req struct {
+		Items []services.BulkPriceUpdate `json:"items" binding:"required,min=1,max=500,dive"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	updated, err := h.service.BulkUpdatePrices(req.Items)
+	if err != nil {
+		respondProductError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"updated": updated})
+}
+
+func (h *ProductHandler) BulkUpdateStock(c *gin.Context) {
+	var req struct {
+		Items []services.BulkStockUpdate `json:"items" binding:"required,min=1,max=500,dive"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	updated, err := h.service.BulkUpdateStock(req.Items)
+	if err != nil {
+		respondProductError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"updated": updated})
+}
+
+// BulkSetCategories puts products in the given categories, replacing the
+// ones they were in. An empty category_ids takes them out of all.
+func (h *ProductHandler) BulkSetCategories(c *gin.Context) {
+	var req struct {
+		ProductIDs  []uint `json:"product_ids" binding:"required,min=1,max=500"`
+		CategoryIDs []uint `json:"category_ids" binding:"max=20"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	updated, err := h.service.BulkSetCategories(req.ProductIDs, req.CategoryIDs)
+	if err != nil {
+		respondProductError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"updated": updated})
+}
+
+// SetOptions replaces a product's option types and regenerates its variant
+// matrix.
+func (h *ProductHandler) SetOptions(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+		return
+	}
+
+	var req struct {
+		Options []services.ProductOptionInput `json:"options" binding:"dive"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	product, err := h.variants.SetOptions(uint(id), req.Options)
+	if err != nil {
+		respondProductError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"product":  product,
+		"variants": h.variants.Matrix(product),
+	})
+}
+
+func (h *ProductHandler) UpdateVariant(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+		return
+	}
+	variantID, err := strconv.ParseUint(c.Param("variant_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid variant ID"})
+		return
+	}
+
+	var input services.VariantInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	variant, err := h.variants.UpdateVariant(uint(id), uint(variantID), input)
+	if err != nil {
+		respondProductError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, variant)
+}
+
+func respondProductError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrProductNotFound), errors.Is(err, services.ErrVariantNotFound):
+		// Bulk edits name the product or variant that wasn't found.
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrProductModified):
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrProductNotDeleted):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrVariantRequired),
+		errors.Is(err, services.ErrCategoryNotFound),
+		errors.Is(err, services.ErrInvalidAttributes):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrInvalidOptions):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrSKUTaken):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update product"})
+	}
+}
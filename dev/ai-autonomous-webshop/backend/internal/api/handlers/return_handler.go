@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/models"
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/services"
+)
+
+type ReturnHandler struct {
+	service *services.ReturnService
+}
+
+func NewReturnHandler() *ReturnHandler {
+	return &ReturnHandler{
+		service: services.NewReturnService(),
+	}
+}
+
+func (h *ReturnHandler) Create(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	orderID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
+
+	var req struct {
+		Items []services.ReturnLine `json:"items" binding:"required,min=1,dive"`
+		Note  string                `json:"note"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	request, err := h.service.Request(c.Request.Context(), userID, uint(orderID), req.Items, req.Note)
+	if err != nil {
+		respondReturnError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, request)
+}
+
+func (h *ReturnHandler) GetMyReturns(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+
+	requests, err := h.service.GetByUserID(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch returns"})
+		return
+	}
+
+	c.JSON(http.StatusOK, requests)
+}
+
+func (h *ReturnHandler) GetReturn(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid return ID"})
+		return
+	}
+
+	request, err := h.service.Get(uint(id), userID)
+	if err != nil {
+		respondReturnError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, request)
+}
+
+// GetReasons lists the reason codes a return line can carry.
+func (h *ReturnHandler) GetReasons(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"reasons": models.ReturnReasons})
+}
+
+func (h *ReturnHandler) AdminList(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "50"))
+	if page <= 0 {
+		page = 1
+	}
+
+	result, err := h.service.List(c.Query("status"), page, pageSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch returns"})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+func (h *ReturnHandler) AdminGet(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid return ID"})
+		return
+	}
+
+	request, err := h.service.Get(uint(id), 0)
+	if err != nil {
+		respondReturnError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, request)
+}
+
+func (h *ReturnHandler) Approve(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid return ID"})
+		return
+	}
+
+	var decision services.ReturnDecision
+	if err := c.ShouldBindJSON(&decision); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	staffID := c.MustGet("userID").(uint)
+	request, err := h.service.Approve(c.Request.Context(), uint(id), staffID, decision)
+	if err != nil {
+		respondReturnError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, request)
+}
+
+func (h *ReturnHandler) Reject(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid return ID"})
+		return
+	}
+
+	var req struct {
+		Note string `json:"note" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	staffID := c.MustGet("userID").(uint)
+	request, err := h.service.Reject(c.Request.Context(), uint(id), staffID, req.Note)
+	if err != nil {
+		respondReturnError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, request)
+}
+
+func respondReturnError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrReturnNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Return not found"})
+	case errors.Is(err, services.ErrInvalidReturnReason):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "reasons": models.ReturnReasons})
+	case errors.Is(err, services.ErrReturnEmpty),
+		errors.Is(err, services.ErrInvalidQuantity):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrReturnNotAllowed),
+		errors.Is(err, services.ErrReturnQuantity),
+		errors.Is(err, services.ErrReturnNotPending):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		respondOrderError(c, err)
+	}
+}
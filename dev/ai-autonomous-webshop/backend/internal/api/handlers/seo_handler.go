@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/services"
+)
+
+type SEOHandler struct {
+	service  *services.SEOService
+	products *services.ProductService
+	variants *services.VariantService
+}
+
+func NewSEOHandler() *SEOHandler {
+	return &SEOHandler{
+		service:  services.NewSEOService(),
+		products: &services.ProductService{},
+		variants: services.NewVariantService(),
+	}
+}
+
+// Sitemap serves /sitemap.xml, an index of the files under /sitemaps/ when
+// the catalog is too large for one.
+func (h *SEOHandler) Sitemap(c *gin.Context) {
+	body, err := h.service.Sitemap()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build sitemap"})
+		return
+	}
+
+	c.Header("Cache-Control", "public, max-age=3600")
+	c.Data(http.StatusOK, "application/xml; charset=utf-8", body)
+}
+
+func (h *SEOHandler) SitemapFile(c *gin.Context) {
+	body, err := h.service.SitemapFile(c.Param("file"))
+	if errors.Is(err, services.ErrSitemapNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Sitemap not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build sitemap"})
+		return
+	}
+
+	c.Header("Cache-Control", "public, max-age=3600")
+	c.Data(http.StatusOK, "application/xml; charset=utf-8", body)
+}
+
+// ProductJSONLD returns the schema.org structured data of a product page,
+// for the storefront to embed in a script tag.
+func (h *SEOHandler) ProductJSONLD(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+		return
+	}
+
+	product, err := h.products.GetByID(uint(id))
+	if err != nil || product == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+
+	body, err := json.Marshal(h.service.ProductJSONLD(product, h.variants.Matrix(product)))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build structured data"})
+		return
+	}
+
+	c.Data(http.StatusOK, "application/ld+json; charset=utf-8", body)
+}
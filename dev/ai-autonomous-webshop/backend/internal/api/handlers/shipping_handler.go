@@ -0,0 +1,250 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/services"
+)
+
+type ShippingHandler struct {
+	service *services.ShippingService
+}
+
+func NewShippingHandler() *ShippingHandler {
+	return &ShippingHandler{
+		service: services.NewShippingService(),
+	}
+}
+
+func (h *ShippingHandler) ListZones(c *gin.Context) {
+	zones, err := h.service.ListZones()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch shipping zones"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"zones": zones})
+}
+
+func (h *ShippingHandler) CreateZone(c *gin.Context) {
+	var input services.ShippingZoneInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	zone, err := h.service.CreateZone(input)
+	if err != nil {
+		respondShippingError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, zone)
+}
+
+func (h *ShippingHandler) UpdateZone(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid shipping zone ID"})
+		return
+	}
+
+	var input services.ShippingZoneInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	zone, err := h.service.UpdateZone(uint(id), input)
+	if err != nil {
+		respondShippingError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, zone)
+}
+
+func (h *ShippingHandler) DeleteZone(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid shipping zone ID"})
+		return
+	}
+
+	if err := h.service.DeleteZone(uint(id)); err != nil {
+		respondShippingError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Shipping zone deleted"})
+}
+
+func (h *ShippingHandler) CreateMethod(c *gin.Context) {
+	zoneID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid shipping zone ID"})
+		return
+	}
+
+	var input services.ShippingMethodInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	method, err := h.service.CreateMethod(uint(zoneID), input)
+	if err != nil {
+		respondShippingError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, method)
+}
+
+func (h *ShippingHandler) UpdateMethod(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid shipping method ID"})
+		return
+	}
+
+	var input services.ShippingMethodInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	method, err := h.service.UpdateMethod(uint(id), input)
+	if err != nil {
+		respondShippingError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, method)
+}
+
+func (h *ShippingHandler) DeleteMethod(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid shipping method ID"})
+		return
+	}
+
+	if err := h.service.DeleteMethod(uint(id)); err != nil {
+		respondShippingError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Shipping method deleted"})
+}
+
+// GetShipments lists the shipments of one of the user's orders with their
+// tracking history.
+func (h *ShippingHandler) GetShipments(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	orderID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
+
+	shipments, err := h.service.Shipments(uint(orderID), userID)
+	if err != nil {
+		respondShippingError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"shipments": shipments})
+}
+
+func (h *ShippingHandler) AdminGetShipments(c *gin.Context) {
+	orderID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
+
+	shipments, err := h.service.Shipments(uint(orderID), 0)
+	if err != nil {
+		respondShippingError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"shipments": shipments})
+}
+
+func (h *ShippingHandler) CreateShipment(c *gin.Context) {
+	orderID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
+
+	var input services.ShipmentInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	staffID := c.MustGet("userID").(uint)
+	shipment, err := h.service.CreateShipment(c.Request.Context(), uint(orderID), staffID, input)
+	if err != nil {
+		respondShippingError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, shipment)
+}
+
+// AddEvent records a tracking update for a shipment, which is pushed to the
+// customer over the WebSocket.
+func (h *ShippingHandler) AddEvent(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid shipment ID"})
+		return
+	}
+
+	var input services.ShipmentEventInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	staffID := c.MustGet("userID").(uint)
+	shipment, err := h.service.AddShipmentEvent(c.Request.Context(), uint(id), services.StaffActor(staffID), input)
+	if err != nil {
+		respondShippingError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, shipment)
+}
+
+func respondShippingError(c *gin.Context, err error) {
+	var invalid *services.InvalidTransitionError
+	switch {
+	case errors.As(err, &invalid):
+		c.JSON(http.StatusConflict, gin.H{"error": invalid.Error(), "from": invalid.From, "to": invalid.To})
+	case errors.Is(err, services.ErrShippingZoneNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Shipping zone not found"})
+	case errors.Is(err, services.ErrShippingMethodNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Shipping method not found"})
+	case errors.Is(err, services.ErrShipmentNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Shipment not found"})
+	case errors.Is(err, services.ErrOrderNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+	case errors.Is(err, services.ErrInvalidShippingMethod),
+		errors.Is(err, services.ErrInvalidShipmentStatus):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrShipmentClosed),
+		errors.Is(err, services.ErrOrderNotShippable),
+		errors.Is(err, services.ErrOrderStatusChanged):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Shipping request failed"})
+	}
+}
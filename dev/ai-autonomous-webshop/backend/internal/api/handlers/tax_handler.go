@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/services"
+)
+
+type TaxHandler struct {
+	service *services.TaxService
+}
+
+func NewTaxHandler() *TaxHandler {
+	return &TaxHandler{
+		service: services.NewTaxService(),
+	}
+}
+
+func (h *TaxHandler) ListRules(c *gin.Context) {
+	rules, err := h.service.ListRules(c.Query("country"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch tax rules"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"rules": rules})
+}
+
+func (h *TaxHandler) CreateRule(c *gin.Context) {
+	var input services.TaxRuleInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	rule, err := h.service.CreateRule(input)
+	if err != nil {
+		respondTaxError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, rule)
+}
+
+func (h *TaxHandler) UpdateRule(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid tax rule ID"})
+		return
+	}
+
+	var input services.TaxRuleInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	rule, err := h.service.UpdateRule(uint(id), input)
+	if err != nil {
+		respondTaxError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, rule)
+}
+
+func (h *TaxHandler) DeleteRule(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid tax rule ID"})
+		return
+	}
+
+	if err := h.service.DeleteRule(uint(id)); err != nil {
+		respondTaxError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Tax rule deleted"})
+}
+
+func respondTaxError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrTaxRuleNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Tax rule not found"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Tax rule request failed"})
+	}
+}
@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/services"
+)
+
+const maxWebhookBodyBytes = 1 << 20
+
+type WebhookHandler struct {
+	service *services.PaymentWebhookService
+}
+
+func NewWebhookHandler() *WebhookHandler {
+	return &WebhookHandler{
+		service: services.NewPaymentWebhookService(),
+	}
+}
+
+// HandlePayment acknowledges an event as soon as it is stored. Processing
+// failures are retried on our side, so only storage failures ask the
+// provider to redeliver.
+func (h *WebhookHandler) HandlePayment(c *gin.Context) {
+	payload, err := io.ReadAll(io.LimitReader(c.Request.Body, maxWebhookBodyBytes))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read body"})
+		return
+	}
+
+	event, duplicate, err := h.service.Ingest(c.Request.Context(), c.Param("provider"), payload, c.Request.Header)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrUnknownPaymentProvider):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Unknown payment provider"})
+		case errors.Is(err, services.ErrInvalidWebhook):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid signature"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store event"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"received": true, "duplicate": duplicate, "event_id": event.EventID})
+}
+
+func (h *WebhookHandler) ListPaymentEvents(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "50"))
+	if page <= 0 {
+		page = 1
+	}
+
+	result, err := h.service.List(c.Query("status"), page, pageSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch payment events"})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+func (h *WebhookHandler) GetPaymentEvent(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid event ID"})
+		return
+	}
+
+	event, err := h.service.Get(uint(id))
+	if err != nil {
+		respondPaymentEventError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, event)
+}
+
+func (h *WebhookHandler) RetryPaymentEvent(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid event ID"})
+		return
+	}
+
+	event, err := h.service.Retry(c.Request.Context(), uint(id))
+	if err != nil {
+		respondPaymentEventError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, event)
+}
+
+func respondPaymentEventError(c *gin.Context, err error) {
+	if errors.Is(err, services.ErrPaymentEventNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payment event not found"})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load payment event"})
+}
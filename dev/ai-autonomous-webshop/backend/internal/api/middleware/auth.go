@@ -1,54 +1,84 @@
 package middleware
 
 import (
+	"errors"
 	"net/http"
 	"strings"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
-	"github.com/jeremy/ai-autonomous-webshop/backend/internal/config"
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/services"
 )
 
 func AuthMiddleware() gin.HandlerFunc {
+	tokenService := services.NewTokenService()
+
 	return func(c *gin.Context) {
-		authHeader := c.GetHeader("Authorization")
-		if authHeader == "" {
+		if c.GetHeader("Authorization") == "" {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header required"})
 			c.Abort()
 			return
 		}
-
-		parts := strings.Split(authHeader, " ")
-		if len(parts) != 2 || parts[0] != "Bearer" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid authorization format"})
-			c.Abort()
+		if !authenticate(c, tokenService) {
 			return
 		}
+		c.Next()
+	}
+}
 
-		tokenString := parts[1]
-		cfg := config.LoadConfig()
-
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			return []byte(cfg.JWTSecret), nil
-		})
+// OptionalAuthMiddleware authenticates the request when it carries a token
+// and lets it through anonymously otherwise, for endpoints that also serve
+// guests. A token that is present but invalid is still rejected.
+func OptionalAuthMiddleware() gin.HandlerFunc {
+	tokenService := services.NewTokenService()
 
-		if err != nil || !token.Valid {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
-			c.Abort()
+	return func(c *gin.Context) {
+		if c.GetHeader("Authorization") != "" && !authenticate(c, tokenService) {
 			return
 		}
+		c.Next()
+	}
+}
 
-		claims, ok := token.Claims.(jwt.MapClaims)
-		if !ok {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token claims"})
-			c.Abort()
-			return
+// authenticate verifies the bearer token and sets the user info in the
+// context. It aborts the request and returns false if the token is bad.
+func authenticate(c *gin.Context, tokenService *services.TokenService) bool {
+	parts := strings.Split(c.GetHeader("Authorization"), " ")
+	if len(parts) != 2 || parts[0] != "Bearer" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid authorization format"})
+		c.Abort()
+		return false
+	}
+
+	claims, err := tokenService.ParseAccessToken(c.Request.Context(), parts[1])
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrTokenRevoked):
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Token has been revoked"})
+		case errors.Is(err, services.ErrInvalidToken):
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+		default:
+			LogError(c.Request.Context(), "token verification failed", err)
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Unable to verify token"})
 		}
+		c.Abort()
+		return false
+	}
 
-		// Set user info in context
-		c.Set("userID", uint(claims["user_id"].(float64)))
-		c.Next()
+	// Set user info in context
+	c.Set("userID", claims.UserID)
+	c.Set("roles", claims.Roles)
+	c.Set("permissions", claims.Permissions)
+	c.Set("accessClaims", claims)
+	return true
+}
+
+func GetAccessClaims(c *gin.Context) *services.AccessClaims {
+	if claims, exists := c.Get("accessClaims"); exists {
+		if ac, ok := claims.(*services.AccessClaims); ok {
+			return ac
+		}
 	}
+	return nil
 }
 
 func CORSMiddleware() gin.HandlerFunc {
@@ -56,7 +86,7 @@ func CORSMiddleware() gin.HandlerFunc {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
 		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE")
+		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, PATCH, DELETE")
 
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequirePermission must run after AuthMiddleware. It aborts with 403 unless
+// the token carries every listed permission.
+func RequirePermission(permissions ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		granted := GetPermissions(c)
+		for _, required := range permissions {
+			if !containsString(granted, required) {
+				logger.Warn("permission_denied",
+					slog.String("requestID", GetRequestID(c)),
+					slog.Any("userID", c.Value("userID")),
+					slog.String("permission", required),
+					slog.String("method", c.Request.Method),
+					slog.String("path", c.Request.URL.Path),
+				)
+				c.JSON(http.StatusForbidden, gin.H{
+					"error":      "Insufficient permissions",
+					"permission": required,
+				})
+				c.Abort()
+				return
+			}
+		}
+		c.Next()
+	}
+}
+
+// RequireRole must run after AuthMiddleware. It aborts with 403 unless the
+// token carries at least one of the listed roles.
+func RequireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		held := GetRoles(c)
+		for _, role := range roles {
+			if containsString(held, role) {
+				c.Next()
+				return
+			}
+		}
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient role"})
+		c.Abort()
+	}
+}
+
+func GetRoles(c *gin.Context) []string {
+	if roles, exists := c.Get("roles"); exists {
+		if r, ok := roles.([]string); ok {
+			return r
+		}
+	}
+	return nil
+}
+
+func GetPermissions(c *gin.Context) []string {
+	if perms, exists := c.Get("permissions"); exists {
+		if p, ok := perms.([]string); ok {
+			return p
+		}
+	}
+	return nil
+}
+
+func HasPermission(c *gin.Context, permission string) bool {
+	return containsString(GetPermissions(c), permission)
+}
+
+func containsString(values []string, target string) bool {
+	for _, v := range values {
+		if v == target {
+			return true
+		}
+	}
+	return false
+}
@@ -119,6 +119,17 @@ func GetUserCurrency(c *gin.Context) string {
 	return "USD"
 }
 
+// GetTaxLocation returns where the visitor is taxed, going by the country
+// and region GeoDetectionMiddleware found.
+func GetTaxLocation(c *gin.Context) services.TaxLocation {
+	if geo, exists := c.Get("geo"); exists {
+		if g, ok := geo.(*services.GeoLocation); ok {
+			return services.TaxLocation{Country: g.Country, Region: g.RegionCode}
+		}
+	}
+	return services.TaxLocation{}
+}
+
 func ConvertPriceMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		targetCurrency := c.Query("convert_to")
@@ -136,8 +147,7 @@ func ConvertPriceMiddleware() gin.HandlerFunc {
 }
 
 func isValidCurrency(code string) bool {
-	_, exists := services.CurrencyMap[code]
-	return exists
+	return services.IsSupportedCurrency(code)
 }
 
 func init() {
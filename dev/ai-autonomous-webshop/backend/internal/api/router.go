@@ -6,6 +6,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/jeremy/ai-autonomous-webshop/backend/internal/api/handlers"
 	"github.com/jeremy/ai-autonomous-webshop/backend/internal/api/middleware"
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/models"
 	"github.com/jeremy/ai-autonomous-webshop/backend/internal/services"
 )
 
@@ -36,6 +37,13 @@ func SetupRouter() *gin.Engine {
 		c.JSON(http.StatusOK, health)
 	})
 
+	webhookHandler := handlers.NewWebhookHandler()
+	r.POST("/webhooks/payments/:provider", webhookHandler.HandlePayment)
+
+	seoHandler := handlers.NewSEOHandler()
+	r.GET("/sitemap.xml", seoHandler.Sitemap)
+	r.GET("/sitemaps/:file", seoHandler.SitemapFile)
+
 	// API v1 group
 	v1 := r.Group("/api/v1")
 	v1.Use(middleware.PublicRateLimiter())
@@ -56,6 +64,20 @@ func SetupRouter() *gin.Engine {
 		{
 			auth.POST("/register", authHandler.Register)
 			auth.POST("/login", authHandler.Login)
+			auth.POST("/refresh", authHandler.Refresh)
+			auth.POST("/logout", middleware.AuthMiddleware(), authHandler.Logout)
+			auth.POST("/logout-all", middleware.AuthMiddleware(), authHandler.LogoutAll)
+			auth.POST("/verify-email", authHandler.VerifyEmail)
+			auth.POST("/verify-email/resend", authHandler.ResendVerification)
+			auth.POST("/password/forgot", authHandler.ForgotPassword)
+			auth.POST("/password/reset", authHandler.ResetPassword)
+
+			mfaHandler := handlers.NewMFAHandler()
+			auth.POST("/mfa/verify", mfaHandler.Verify)
+			auth.POST("/mfa/enroll", middleware.AuthMiddleware(), mfaHandler.Enroll)
+			auth.POST("/mfa/enroll/confirm", middleware.AuthMiddleware(), mfaHandler.ConfirmEnrollment)
+			auth.POST("/mfa/disable", middleware.AuthMiddleware(), mfaHandler.Disable)
+			auth.POST("/mfa/recovery-codes", middleware.AuthMiddleware(), mfaHandler.RegenerateRecoveryCodes)
 		}
 
 		productHandler := handlers.NewProductHandler()
@@ -63,7 +85,76 @@ func SetupRouter() *gin.Engine {
 		{
 			products.GET("", productHandler.GetAll)
 			products.GET("/:id", productHandler.GetByID)
-			products.POST("", productHandler.Create)
+			products.GET("/slug/:slug", productHandler.GetBySlug)
+			products.GET("/:id/jsonld", seoHandler.ProductJSONLD)
+			products.POST("",
+				middleware.AuthMiddleware(),
+				middleware.RequirePermission(models.PermCatalogWrite),
+				productHandler.Create,
+			)
+			products.GET("/deleted",
+				middleware.AuthMiddleware(),
+				middleware.RequirePermission(models.PermCatalogWrite),
+				productHandler.ListDeleted,
+			)
+			products.PUT("/:id",
+				middleware.AuthMiddleware(),
+				middleware.RequirePermission(models.PermCatalogWrite),
+				productHandler.Update,
+			)
+			products.PATCH("/:id",
+				middleware.AuthMiddleware(),
+				middleware.RequirePermission(models.PermCatalogWrite),
+				productHandler.Patch,
+			)
+			products.DELETE("/:id",
+				middleware.AuthMiddleware(),
+				middleware.RequirePermission(models.PermCatalogWrite),
+				productHandler.Delete,
+			)
+			products.POST("/:id/restore",
+				middleware.AuthMiddleware(),
+				middleware.RequirePermission(models.PermCatalogWrite),
+				productHandler.Restore,
+			)
+			products.POST("/:id/meta/generate",
+				middleware.AuthMiddleware(),
+				middleware.RequirePermission(models.PermCatalogWrite),
+				middleware.RequirePermission(models.PermAIGenerate),
+				productHandler.GenerateMeta,
+			)
+			products.PATCH("/bulk/price",
+				middleware.AuthMiddleware(),
+				middleware.RequirePermission(models.PermCatalogWrite),
+				productHandler.BulkUpdatePrices,
+			)
+			products.PATCH("/bulk/stock",
+				middleware.AuthMiddleware(),
+				middleware.RequirePermission(models.PermCatalogWrite),
+				productHandler.BulkUpdateStock,
+			)
+			products.PATCH("/bulk/categories",
+				middleware.AuthMiddleware(),
+				middleware.RequirePermission(models.PermCatalogWrite),
+				productHandler.BulkSetCategories,
+			)
+			products.PUT("/:id/options",
+				middleware.AuthMiddleware(),
+				middleware.RequirePermission(models.PermCatalogWrite),
+				productHandler.SetOptions,
+			)
+			products.PUT("/:id/variants/:variant_id",
+				middleware.AuthMiddleware(),
+				middleware.RequirePermission(models.PermCatalogWrite),
+				productHandler.UpdateVariant,
+			)
+		}
+
+		categoryHandler := handlers.NewCategoryHandler()
+		categories := v1.Group("/categories")
+		{
+			categories.GET("", categoryHandler.GetTree)
+			categories.GET("/:slug", categoryHandler.GetBySlug)
 		}
 
 		searchHandler := handlers.NewSearchHandler()
@@ -82,6 +173,7 @@ func SetupRouter() *gin.Engine {
 		{
 			currency.GET("", currencyHandler.GetUserCurrency)
 			currency.GET("/list", currencyHandler.GetSupportedCurrencies)
+			currency.GET("/rates", currencyHandler.GetRates)
 			currency.GET("/:code", currencyHandler.GetCurrency)
 			currency.POST("/set", currencyHandler.SetCurrency)
 			currency.POST("/convert", currencyHandler.ConvertPrice)
@@ -89,22 +181,67 @@ func SetupRouter() *gin.Engine {
 		}
 
 		orderHandler := handlers.NewOrderHandler()
+		returnHandler := handlers.NewReturnHandler()
+		shippingHandler := handlers.NewShippingHandler()
+		invoiceHandler := handlers.NewInvoiceHandler()
 		orders := v1.Group("/orders")
 		orders.Use(middleware.AuthMiddleware())
 		orders.Use(middleware.AuthRateLimiter())
 		{
 			orders.POST("", orderHandler.Create)
 			orders.GET("", orderHandler.GetMyOrders)
+			orders.GET("/:id/history", orderHandler.GetHistory)
+			orders.GET("/:id/shipments", shippingHandler.GetShipments)
+			orders.GET("/:id/invoice", invoiceHandler.GetInvoice)
+			orders.GET("/:id/documents", invoiceHandler.ListDocuments)
+			orders.GET("/:id/documents/:document_id", invoiceHandler.GetDocument)
+			orders.POST("/:id/cancel", orderHandler.Cancel)
+			orders.POST("/:id/returns", returnHandler.Create)
+		}
+
+		returns := v1.Group("/returns")
+		returns.Use(middleware.AuthMiddleware())
+		returns.Use(middleware.AuthRateLimiter())
+		{
+			returns.GET("", returnHandler.GetMyReturns)
+			returns.GET("/reasons", returnHandler.GetReasons)
+			returns.GET("/:id", returnHandler.GetReturn)
+		}
+
+		addressHandler := handlers.NewAddressHandler()
+		addresses := v1.Group("/addresses")
+		addresses.Use(middleware.AuthMiddleware())
+		addresses.Use(middleware.AuthRateLimiter())
+		{
+			addresses.GET("", addressHandler.List)
+			addresses.POST("", addressHandler.Create)
+			addresses.PUT("/:id", addressHandler.Update)
+			addresses.DELETE("/:id", addressHandler.Delete)
+			addresses.POST("/:id/default", addressHandler.SetDefault)
 		}
 
 		cartHandler := handlers.NewCartHandler()
 		cart := v1.Group("/cart")
-		cart.Use(middleware.AuthMiddleware())
+		cart.Use(middleware.OptionalAuthMiddleware())
 		cart.Use(middleware.AuthRateLimiter())
 		{
 			cart.GET("", cartHandler.GetCart)
 			cart.POST("", cartHandler.AddToCart)
-			cart.DELETE("/:product_id", cartHandler.RemoveFromCart)
+			cart.PATCH("/:variant_id", cartHandler.UpdateQuantity)
+			cart.DELETE("/:variant_id", cartHandler.RemoveFromCart)
+			cart.POST("/coupons", cartHandler.ApplyCoupon)
+			cart.DELETE("/coupons/:code", cartHandler.RemoveCoupon)
+		}
+
+		checkoutHandler := handlers.NewCheckoutHandler()
+		checkout := v1.Group("/checkout")
+		checkout.Use(middleware.AuthMiddleware())
+		checkout.Use(middleware.AuthRateLimiter())
+		{
+			checkout.POST("", checkoutHandler.Checkout)
+			checkout.POST("/reserve", checkoutHandler.Reserve)
+			checkout.DELETE("/reserve", checkoutHandler.ReleaseReservation)
+			checkout.POST("/:order_id/complete", checkoutHandler.CompletePayment)
 		}
 
 		adminHandler := handlers.NewAdminHandler()
@@ -112,15 +249,108 @@ func SetupRouter() *gin.Engine {
 		admin.Use(middleware.AuthMiddleware())
 		admin.Use(middleware.AuthRateLimiter())
 		{
-			admin.GET("/stats", adminHandler.GetStats)
-			admin.GET("/margin", adminHandler.GetMarginAnalysis)
-			admin.POST("/margin/apply", adminHandler.ApplyPriceChange)
+			admin.GET("/stats", middleware.RequirePermission(models.PermAnalyticsRead), adminHandler.GetStats)
+			admin.GET("/margin", middleware.RequirePermission(models.PermPricingRead), adminHandler.GetMarginAnalysis)
+			admin.POST("/margin/apply", middleware.RequirePermission(models.PermPricingApply), adminHandler.ApplyPriceChange)
+			admin.POST("/currency/rates/refresh", middleware.RequirePermission(models.PermPricingApply), currencyHandler.RefreshRates)
 
 			trendHandler := handlers.NewTrendHandler()
-			admin.GET("/trends", trendHandler.GetTrends)
+			admin.GET("/trends", middleware.RequirePermission(models.PermAnalyticsRead), trendHandler.GetTrends)
 
 			aiHandler := handlers.NewAIHandler()
-			admin.POST("/generate-description", aiHandler.GenerateDescription)
+			admin.POST("/generate-description", middleware.RequirePermission(models.PermAIGenerate), aiHandler.GenerateDescription)
+
+			users := admin.Group("/users")
+			users.Use(middleware.RequirePermission(models.PermUsersManage))
+			{
+				users.GET("/:id/roles", adminHandler.GetUserRoles)
+				users.POST("/:id/roles", adminHandler.GrantRole)
+				users.DELETE("/:id/roles/:role", adminHandler.RevokeRole)
+			}
+
+			admin.GET("/security/events", middleware.RequirePermission(models.PermUsersManage), adminHandler.GetSecurityEvents)
+
+			adminOrders := admin.Group("/orders")
+			adminOrders.Use(middleware.RequirePermission(models.PermOrdersManage))
+			{
+				adminOrders.PATCH("/:id/status", orderHandler.AdminUpdateStatus)
+				adminOrders.GET("/:id/history", orderHandler.AdminGetHistory)
+				adminOrders.GET("/:id/refunds", orderHandler.AdminListRefunds)
+				adminOrders.GET("/:id/shipments", shippingHandler.AdminGetShipments)
+				adminOrders.POST("/:id/shipments", shippingHandler.CreateShipment)
+				adminOrders.POST("/:id/cancel", middleware.RequirePermission(models.PermOrdersRefund), orderHandler.AdminCancel)
+				adminOrders.POST("/:id/refunds", middleware.RequirePermission(models.PermOrdersRefund), orderHandler.AdminRefund)
+			}
+
+			adminReturns := admin.Group("/returns")
+			adminReturns.Use(middleware.RequirePermission(models.PermOrdersManage))
+			{
+				adminReturns.GET("", returnHandler.AdminList)
+				adminReturns.GET("/:id", returnHandler.AdminGet)
+				adminReturns.POST("/:id/approve", middleware.RequirePermission(models.PermOrdersRefund), returnHandler.Approve)
+				adminReturns.POST("/:id/reject", returnHandler.Reject)
+			}
+
+			taxHandler := handlers.NewTaxHandler()
+			taxRules := admin.Group("/tax/rules")
+			{
+				taxRules.GET("", middleware.RequirePermission(models.PermPricingRead), taxHandler.ListRules)
+				taxRules.POST("", middleware.RequirePermission(models.PermPricingApply), taxHandler.CreateRule)
+				taxRules.PUT("/:id", middleware.RequirePermission(models.PermPricingApply), taxHandler.UpdateRule)
+				taxRules.DELETE("/:id", middleware.RequirePermission(models.PermPricingApply), taxHandler.DeleteRule)
+			}
+
+			admin.POST("/shipments/:id/events", middleware.RequirePermission(models.PermOrdersManage), shippingHandler.AddEvent)
+
+			shipping := admin.Group("/shipping")
+			{
+				shipping.GET("/zones", middleware.RequirePermission(models.PermPricingRead), shippingHandler.ListZones)
+				shipping.POST("/zones", middleware.RequirePermission(models.PermPricingApply), shippingHandler.CreateZone)
+				shipping.PUT("/zones/:id", middleware.RequirePermission(models.PermPricingApply), shippingHandler.UpdateZone)
+				shipping.DELETE("/zones/:id", middleware.RequirePermission(models.PermPricingApply), shippingHandler.DeleteZone)
+				shipping.POST("/zones/:id/methods", middleware.RequirePermission(models.PermPricingApply), shippingHandler.CreateMethod)
+				shipping.PUT("/methods/:id", middleware.RequirePermission(models.PermPricingApply), shippingHandler.UpdateMethod)
+				shipping.DELETE("/methods/:id", middleware.RequirePermission(models.PermPricingApply), shippingHandler.DeleteMethod)
+			}
+
+			catalogHandler := handlers.NewCatalogHandler()
+			catalog := admin.Group("/catalog")
+			catalog.Use(middleware.RequirePermission(models.PermCatalogWrite))
+			{
+				catalog.GET("/export", catalogHandler.Export)
+				catalog.GET("/imports", catalogHandler.ListImports)
+				catalog.POST("/imports", catalogHandler.StartImport)
+				catalog.GET("/imports/:id", catalogHandler.GetImport)
+			}
+
+			adminCategories := admin.Group("/categories")
+			adminCategories.Use(middleware.RequirePermission(models.PermCatalogWrite))
+			{
+				adminCategories.POST("", categoryHandler.Create)
+				adminCategories.PUT("/:id", categoryHandler.Update)
+				adminCategories.DELETE("/:id", categoryHandler.Delete)
+			}
+
+			promotionHandler := handlers.NewPromotionHandler()
+			promotions := admin.Group("/promotions")
+			promotions.Use(middleware.RequirePermission(models.PermPromotionsManage))
+			{
+				promotions.GET("", promotionHandler.List)
+				promotions.POST("", promotionHandler.Create)
+				promotions.GET("/usage", promotionHandler.UsageReport)
+				promotions.GET("/:id", promotionHandler.Get)
+				promotions.PUT("/:id", promotionHandler.Update)
+				promotions.DELETE("/:id", promotionHandler.Delete)
+				promotions.GET("/:id/usage", promotionHandler.Usage)
+			}
+
+			paymentEvents := admin.Group("/payments/events")
+			paymentEvents.Use(middleware.RequirePermission(models.PermOrdersManage))
+			{
+				paymentEvents.GET("", webhookHandler.ListPaymentEvents)
+				paymentEvents.GET("/:id", webhookHandler.GetPaymentEvent)
+				paymentEvents.POST("/:id/retry", webhookHandler.RetryPaymentEvent)
+			}
 		}
 
 		wsHandler := handlers.NewWebSocketHandler()
@@ -4,11 +4,13 @@ import (
 	"log"
 	"os"
 	"strconv"
+	"strings"
 
 	"github.com/joho/godotenv"
 )
 
 type Config struct {
+	Environment              string
 	Port                     string
 	DatabaseURL              string
 	JWTSecret                string
@@ -17,6 +19,48 @@ type Config struct {
 	RateLimitAuthPerMinute   int
 	RateLimitWritePerMinute  int
 	RateLimitEnabled         bool
+	BootstrapAdminEmail      string
+	AccessTokenTTLMinutes    int
+	RefreshTokenTTLHours     int
+	AppBaseURL               string
+	RequireEmailVerification bool
+	EmailVerifyTTLHours      int
+	PasswordResetTTLMinutes  int
+	MailTransport            string
+	MailFrom                 string
+	MailOutputDir            string
+	SMTPHost                 string
+	SMTPPort                 int
+	SMTPUsername             string
+	SMTPPassword             string
+	LoginBackoffThreshold    int
+	LoginMaxAttempts         int
+	LoginLockoutMinutes      int
+	MFAIssuer                string
+	MFAEncryptionKey         string
+	MFARequiredForAdmin      bool
+	PaymentGateway           string
+	StripeSecretKey          string
+	StripeAPIBase            string
+	PaymentTimeoutSeconds    int
+	PaymentWebhookSecret     string
+	WebhookMaxAttempts       int
+	StockHoldMinutes         int
+	GuestCartTTLHours        int
+	GuestCartSecret          string
+	CartMergeStrategy        string
+	CartMaxQuantity          int
+	TaxOriginCountry         string
+	ShippingProvider         string
+	InvoiceSellerName        string
+	InvoiceSellerAddress     string
+	InvoiceSellerVATID       string
+	ExchangeRateProvider     string
+	ExchangeRateURL          string
+	ExchangeRateFile         string
+	ExchangeRateRefreshMins  int
+	ExchangeRateMaxAgeHours  int
+	CatalogImportMaxMB       int
 }
 
 func LoadConfig() *Config {
@@ -25,6 +69,7 @@ func LoadConfig() *Config {
 	}
 
 	return &Config{
+		Environment:              getEnv("APP_ENV", "development"),
 		Port:                     getEnv("PORT", "8080"),
 		DatabaseURL:              getEnv("DATABASE_URL", ""),
 		JWTSecret:                getEnv("JWT_SECRET", "change-me-in-prod"),
@@ -33,9 +78,57 @@ func LoadConfig() *Config {
 		RateLimitAuthPerMinute:   getEnvInt("RATE_LIMIT_AUTH", 500),
 		RateLimitWritePerMinute:  getEnvInt("RATE_LIMIT_WRITE", 50),
 		RateLimitEnabled:         getEnvBool("RATE_LIMIT_ENABLED", true),
+		BootstrapAdminEmail:      getEnv("BOOTSTRAP_ADMIN_EMAIL", ""),
+		AccessTokenTTLMinutes:    getEnvInt("ACCESS_TOKEN_TTL_MINUTES", 15),
+		RefreshTokenTTLHours:     getEnvInt("REFRESH_TOKEN_TTL_HOURS", 720),
+		AppBaseURL:               getEnv("APP_BASE_URL", "http://localhost:53001"),
+		RequireEmailVerification: getEnvBool("REQUIRE_EMAIL_VERIFICATION", false),
+		EmailVerifyTTLHours:      getEnvInt("EMAIL_VERIFY_TTL_HOURS", 48),
+		PasswordResetTTLMinutes:  getEnvInt("PASSWORD_RESET_TTL_MINUTES", 30),
+		MailTransport:            getEnv("MAIL_TRANSPORT", "log"),
+		MailFrom:                 getEnv("MAIL_FROM", "NEXUS Shop <no-reply@localhost>"),
+		MailOutputDir:            getEnv("MAIL_OUTPUT_DIR", ""),
+		SMTPHost:                 getEnv("SMTP_HOST", ""),
+		SMTPPort:                 getEnvInt("SMTP_PORT", 587),
+		SMTPUsername:             getEnv("SMTP_USERNAME", ""),
+		SMTPPassword:             getEnv("SMTP_PASSWORD", ""),
+		LoginBackoffThreshold:    getEnvInt("LOGIN_BACKOFF_THRESHOLD", 3),
+		LoginMaxAttempts:         getEnvInt("LOGIN_MAX_ATTEMPTS", 10),
+		LoginLockoutMinutes:      getEnvInt("LOGIN_LOCKOUT_MINUTES", 30),
+		MFAIssuer:                getEnv("MFA_ISSUER", "NEXUS Shop"),
+		MFAEncryptionKey:         getEnv("MFA_ENCRYPTION_KEY", ""),
+		MFARequiredForAdmin:      getEnvBool("MFA_REQUIRED_FOR_ADMIN", false),
+		PaymentGateway:           getEnv("PAYMENT_GATEWAY", ""),
+		StripeSecretKey:          getEnv("STRIPE_SECRET_KEY", ""),
+		StripeAPIBase:            getEnv("STRIPE_API_BASE", "https://api.stripe.com"),
+		PaymentTimeoutSeconds:    getEnvInt("PAYMENT_TIMEOUT_SECONDS", 15),
+		PaymentWebhookSecret:     getEnv("PAYMENT_WEBHOOK_SECRET", ""),
+		WebhookMaxAttempts:       getEnvInt("WEBHOOK_MAX_ATTEMPTS", 8),
+		StockHoldMinutes:         getEnvInt("STOCK_HOLD_MINUTES", 15),
+		GuestCartTTLHours:        getEnvInt("GUEST_CART_TTL_HOURS", 168),
+		GuestCartSecret:          getEnv("GUEST_CART_SECRET", ""),
+		CartMergeStrategy:        getEnv("CART_MERGE_STRATEGY", "sum"),
+		CartMaxQuantity:          getEnvInt("CART_MAX_QUANTITY", 20),
+		TaxOriginCountry:         getEnv("TAX_ORIGIN_COUNTRY", "US"),
+		ShippingProvider:         getEnv("SHIPPING_PROVIDER", "table"),
+		InvoiceSellerName:        getEnv("INVOICE_SELLER_NAME", "NEXUS Shop"),
+		InvoiceSellerAddress:     getEnv("INVOICE_SELLER_ADDRESS", ""),
+		InvoiceSellerVATID:       getEnv("INVOICE_SELLER_VAT_ID", ""),
+		ExchangeRateProvider:     getEnv("EXCHANGE_RATE_PROVIDER", "ecb"),
+		ExchangeRateURL:          getEnv("EXCHANGE_RATE_URL", "https://www.ecb.europa.eu/stats/eurofxref/eurofxref-daily.xml"),
+		ExchangeRateFile:         getEnv("EXCHANGE_RATE_FILE", ""),
+		ExchangeRateRefreshMins:  getEnvInt("EXCHANGE_RATE_REFRESH_MINUTES", 60),
+		ExchangeRateMaxAgeHours:  getEnvInt("EXCHANGE_RATE_MAX_AGE_HOURS", 96),
+		CatalogImportMaxMB:       getEnvInt("CATALOG_IMPORT_MAX_MB", 50),
 	}
 }
 
+// IsProduction reports whether APP_ENV is production, where development
+// stand-ins such as the fake payment gateway are refused.
+func (c *Config) IsProduction() bool {
+	return strings.EqualFold(c.Environment, "production")
+}
+
 func getEnv(key, fallback string) string {
 	if value, exists := os.LookupEnv(key); exists {
 		return value
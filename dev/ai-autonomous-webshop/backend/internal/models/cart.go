@@ -3,16 +3,22 @@ package models
 import (
 	"time"
 
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/money"
 	"gorm.io/gorm"
 )
 
+// CartItem is a quantity of one variant. ProductID repeats the variant's
+// product.
 type CartItem struct {
-	ID        uint           `gorm:"primaryKey" json:"id"`
-	UserID    uint           `gorm:"not null;index:idx_user_id" json:"user_id"`
-	ProductID uint           `gorm:"not null;index:idx_product_id" json:"product_id"`
-	Quantity  int            `gorm:"not null" json:"quantity"`
-	CreatedAt time.Time      `json:"created_at"`
-	UpdatedAt time.Time      `json:"updated_at"`
-	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
-	Product   Product        `gorm:"foreignKey:ProductID" json:"product"`
+	ID         uint           `gorm:"primaryKey" json:"id"`
+	UserID     uint           `gorm:"not null;index:idx_user_id" json:"user_id"`
+	ProductID  uint           `gorm:"not null;index:idx_product_id" json:"product_id"`
+	VariantID  uint           `gorm:"not null;default:0;index" json:"variant_id"`
+	Quantity   int            `gorm:"not null" json:"quantity"`
+	PriceAtAdd money.Money    `json:"price_at_add"`
+	CreatedAt  time.Time      `json:"created_at"`
+	UpdatedAt  time.Time      `json:"updated_at"`
+	DeletedAt  gorm.DeletedAt `gorm:"index" json:"-"`
+	Product    Product        `gorm:"foreignKey:ProductID" json:"product"`
+	Variant    ProductVariant `gorm:"foreignKey:VariantID" json:"variant"`
 }
@@ -0,0 +1,50 @@
+package models
+
+import "time"
+
+const (
+	CatalogFormatCSV    = "csv"
+	CatalogFormatNDJSON = "ndjson"
+)
+
+const (
+	ImportJobQueued    = "queued"
+	ImportJobRunning   = "running"
+	ImportJobCompleted = "completed"
+	ImportJobFailed    = "failed"
+)
+
+// CatalogImportJob is an uploaded catalog file being applied in the
+// background. A dry run checks every row and counts what would be created
+// and updated without writing anything. Failed counts rows that were
+// rejected; the job itself only fails when the file can't be read.
+type CatalogImportJob struct {
+	ID         uint                 `gorm:"primaryKey" json:"id"`
+	UserID     uint                 `gorm:"not null;index" json:"user_id"`
+	Format     string               `gorm:"size:10;not null" json:"format"`
+	Filename   string               `json:"filename"`
+	FilePath   string               `json:"-"`
+	DryRun     bool                 `gorm:"not null;default:false" json:"dry_run"`
+	Status     string               `gorm:"not null;index" json:"status"`
+	Processed  int                  `gorm:"not null;default:0" json:"processed"`
+	Created    int                  `gorm:"not null;default:0" json:"created"`
+	Updated    int                  `gorm:"not null;default:0" json:"updated"`
+	Failed     int                  `gorm:"not null;default:0" json:"failed"`
+	Error      string               `json:"error,omitempty"`
+	Errors     []CatalogImportError `gorm:"foreignKey:JobID" json:"errors,omitempty"`
+	StartedAt  *time.Time           `json:"started_at,omitempty"`
+	FinishedAt *time.Time           `json:"finished_at,omitempty"`
+	CreatedAt  time.Time            `json:"created_at"`
+	UpdatedAt  time.Time            `json:"updated_at"`
+}
+
+// CatalogImportError is why one row of an import was rejected. Row counts
+// data rows from 1, not counting a CSV header.
+type CatalogImportError struct {
+	ID      uint   `gorm:"primaryKey" json:"id"`
+	JobID   uint   `gorm:"not null;index" json:"job_id"`
+	Row     int    `gorm:"column:row_number;not null" json:"row"`
+	SKU     string `json:"sku,omitempty"`
+	Field   string `json:"field,omitempty"`
+	Message string `gorm:"not null" json:"message"`
+}
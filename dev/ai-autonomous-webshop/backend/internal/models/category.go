@@ -0,0 +1,55 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	AttributeText    = "text"
+	AttributeNumber  = "number"
+	AttributeBoolean = "boolean"
+	AttributeSelect  = "select"
+)
+
+func IsValidAttributeType(t string) bool {
+	switch t {
+	case AttributeText, AttributeNumber, AttributeBoolean, AttributeSelect:
+		return true
+	}
+	return false
+}
+
+// Category is a node of the catalog tree. Products can be in several
+// categories and are listed under every ancestor of them too. Position
+// orders siblings.
+//
+// Attributes are the product attributes the category defines, like
+// "Screen size" for TVs; a category also has those of its ancestors.
+// SalesMultiplier and TrendScore feed the margin analysis; when unset the
+// parent's apply.
+type Category struct {
+	ID              uint                `gorm:"primaryKey" json:"id"`
+	ParentID        *uint               `gorm:"index" json:"parent_id,omitempty"`
+	Name            string              `gorm:"size:100;not null" json:"name"`
+	Slug            string              `gorm:"size:120;not null;uniqueIndex:idx_category_slug,where:deleted_at IS NULL" json:"slug"`
+	Description     string              `json:"description,omitempty"`
+	Position        int                 `gorm:"not null;default:0" json:"position"`
+	Attributes      []CategoryAttribute `gorm:"serializer:json" json:"attributes"`
+	SalesMultiplier *float64            `json:"sales_multiplier,omitempty"`
+	TrendScore      *float64            `json:"trend_score,omitempty"`
+	Children        []Category          `gorm:"foreignKey:ParentID" json:"children,omitempty"`
+	CreatedAt       time.Time           `json:"created_at"`
+	UpdatedAt       time.Time           `json:"updated_at"`
+	DeletedAt       gorm.DeletedAt      `gorm:"index" json:"-"`
+}
+
+// CategoryAttribute is an attribute products in a category can have.
+// Options lists the allowed values of a select attribute.
+type CategoryAttribute struct {
+	Name     string   `json:"name" binding:"required,max=50"`
+	Type     string   `json:"type" binding:"required"`
+	Required bool     `json:"required"`
+	Options  []string `json:"options,omitempty"`
+}
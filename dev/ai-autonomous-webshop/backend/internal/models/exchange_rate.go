@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// ExchangeRate is one rate of the last set a RateProvider delivered that
+// passed validation: units of Currency per unit of Base. The whole set is
+// replaced on every successful refresh, so the rows always share a Source
+// and AsOf.
+type ExchangeRate struct {
+	Currency  string    `gorm:"primaryKey;size:3" json:"currency"`
+	Base      string    `gorm:"size:3;not null" json:"base"`
+	Rate      float64   `gorm:"not null" json:"rate"`
+	Source    string    `gorm:"size:32;not null" json:"source"`
+	AsOf      time.Time `gorm:"not null" json:"as_of"`
+	FetchedAt time.Time `gorm:"not null" json:"fetched_at"`
+}
@@ -0,0 +1,53 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/money"
+	"gorm.io/gorm"
+)
+
+const (
+	InvoiceTypeInvoice    = "invoice"
+	InvoiceTypeCreditNote = "credit_note"
+)
+
+// InvoiceSequence hands out the numbers of one document type in one year.
+// Taking a number locks the row until the transaction storing the document
+// commits, and a rollback gives the number back, so numbers have no gaps.
+type InvoiceSequence struct {
+	Type       string `gorm:"primaryKey;size:20"`
+	Year       int    `gorm:"primaryKey;autoIncrement:false"`
+	LastNumber int    `gorm:"not null;default:0"`
+}
+
+// Invoice is an issued invoice or credit note. The PDF is rendered once at
+// issue and never changed; amounts are copied, in the order's presentment
+// currency, so documents can be listed without it. An order has at most
+// one invoice and a credit note for every refund, which points at the
+// invoice it corrects.
+type Invoice struct {
+	ID        uint        `gorm:"primaryKey" json:"id"`
+	Type      string      `gorm:"size:20;not null;uniqueIndex:idx_invoice_sequence" json:"type"`
+	Year      int         `gorm:"not null;uniqueIndex:idx_invoice_sequence" json:"year"`
+	Sequence  int         `gorm:"not null;uniqueIndex:idx_invoice_sequence" json:"sequence"`
+	Number    string      `gorm:"size:32;not null;uniqueIndex" json:"number"`
+	OrderID   uint        `gorm:"not null;index;uniqueIndex:idx_order_invoice,where:type = 'invoice'" json:"order_id"`
+	UserID    uint        `gorm:"not null;index" json:"user_id"`
+	RefundID  *uint       `gorm:"uniqueIndex" json:"refund_id,omitempty"`
+	InvoiceID *uint       `json:"invoice_id,omitempty"`
+	Currency  string      `gorm:"size:3;not null" json:"currency"`
+	Net       money.Money `gorm:"not null" json:"net"`
+	TaxTotal  money.Money `gorm:"not null" json:"tax_total"`
+	Total     money.Money `gorm:"not null" json:"total"`
+	IssuedAt  time.Time   `gorm:"not null" json:"issued_at"`
+	PDF       []byte      `gorm:"column:pdf" json:"-"`
+	CreatedAt time.Time   `json:"created_at"`
+}
+
+func (i *Invoice) AfterFind(tx *gorm.DB) error {
+	i.Net = i.Net.WithCurrency(i.Currency)
+	i.TaxTotal = i.TaxTotal.WithCurrency(i.Currency)
+	i.Total = i.Total.WithCurrency(i.Currency)
+	return nil
+}
@@ -3,24 +3,189 @@ package models
 import (
 	"time"
 
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/money"
 	"gorm.io/gorm"
 )
 
+const (
+	OrderStatusPending           = "pending"
+	OrderStatusAuthorized        = "authorized"
+	OrderStatusPaid              = "paid"
+	OrderStatusPicking           = "picking"
+	OrderStatusShipped           = "shipped"
+	OrderStatusDelivered         = "delivered"
+	OrderStatusPaymentFailed     = "payment_failed"
+	OrderStatusCancelled         = "cancelled"
+	OrderStatusRefunded          = "refunded"
+	OrderStatusPartiallyRefunded = "partially_refunded"
+	OrderStatusDisputed          = "disputed"
+)
+
+// OrderTransitions lists the statuses an order may move to from each
+// status. Statuses without an entry are terminal. A partially refunded
+// order keeps being fulfilled, and can be partially refunded again.
+var OrderTransitions = map[string][]string{
+	OrderStatusPending:           {OrderStatusAuthorized, OrderStatusPaid, OrderStatusPaymentFailed, OrderStatusCancelled},
+	OrderStatusAuthorized:        {OrderStatusPaid, OrderStatusPaymentFailed, OrderStatusCancelled},
+	OrderStatusPaid:              {OrderStatusPicking, OrderStatusCancelled, OrderStatusRefunded, OrderStatusPartiallyRefunded, OrderStatusDisputed},
+	OrderStatusPicking:           {OrderStatusShipped, OrderStatusCancelled, OrderStatusRefunded, OrderStatusPartiallyRefunded, OrderStatusDisputed},
+	OrderStatusShipped:           {OrderStatusDelivered, OrderStatusRefunded, OrderStatusPartiallyRefunded, OrderStatusDisputed},
+	OrderStatusDelivered:         {OrderStatusRefunded, OrderStatusPartiallyRefunded, OrderStatusDisputed},
+	OrderStatusPartiallyRefunded: {OrderStatusPicking, OrderStatusShipped, OrderStatusDelivered, OrderStatusRefunded, OrderStatusPartiallyRefunded, OrderStatusDisputed},
+	OrderStatusDisputed:          {OrderStatusPaid, OrderStatusDelivered, OrderStatusRefunded},
+}
+
+func CanTransitionOrder(from, to string) bool {
+	for _, next := range OrderTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+func IsValidOrderStatus(status string) bool {
+	if _, ok := OrderTransitions[status]; ok {
+		return true
+	}
+	switch status {
+	case OrderStatusPaymentFailed, OrderStatusCancelled, OrderStatusRefunded:
+		return true
+	}
+	return false
+}
+
+// Order amounts are kept twice. The plain fields are in BaseCurrency, which
+// is what reporting, promotions, tax rules and shipping rates work in; the
+// Presentment fields are in Currency, the currency the customer saw and was
+// charged in. ExchangeRate is the number of Currency units per BaseCurrency
+// unit, locked when the order was placed. Money columns hold minor units
+// only; AfterFind tags them with the currency they are in.
 type Order struct {
-	ID        uint           `gorm:"primaryKey" json:"id"`
-	UserID    uint           `gorm:"not null;index:idx_user_id" json:"user_id"`
-	Total     float64        `gorm:"not null" json:"total"`
-	Status    string         `gorm:"default:'pending';index:idx_status" json:"status"`
-	Items     []OrderItem    `gorm:"foreignKey:OrderID" json:"items"`
-	CreatedAt time.Time      `json:"created_at"`
-	UpdatedAt time.Time      `json:"updated_at"`
-	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+	ID                       uint                 `gorm:"primaryKey" json:"id"`
+	UserID                   uint                 `gorm:"not null;index:idx_user_id" json:"user_id"`
+	Subtotal                 money.Money          `gorm:"not null;default:0" json:"subtotal"`
+	DiscountTotal            money.Money          `gorm:"not null;default:0" json:"discount_total"`
+	FreeShipping             bool                 `gorm:"not null;default:false" json:"free_shipping"`
+	ShippingAddress          *PostalAddress       `gorm:"serializer:json" json:"shipping_address,omitempty"`
+	ShippingMethodID         *uint                `json:"shipping_method_id,omitempty"`
+	ShippingMethod           string               `json:"shipping_method,omitempty"`
+	ShippingCarrier          string               `gorm:"size:32" json:"shipping_carrier,omitempty"`
+	ShippingTotal            money.Money          `gorm:"not null;default:0" json:"shipping_total"`
+	ShippingTax              money.Money          `gorm:"not null;default:0" json:"shipping_tax"`
+	TaxTotal                 money.Money          `gorm:"not null;default:0" json:"tax_total"`
+	TaxCountry               string               `gorm:"size:2" json:"tax_country,omitempty"`
+	TaxRegion                string               `gorm:"size:10" json:"tax_region,omitempty"`
+	VATID                    string               `gorm:"column:vat_id;size:20" json:"vat_id,omitempty"`
+	PricesIncludeTax         bool                 `gorm:"not null;default:false" json:"prices_include_tax"`
+	ReverseCharge            bool                 `gorm:"not null;default:false" json:"reverse_charge"`
+	Total                    money.Money          `gorm:"not null" json:"total"`
+	Currency                 string               `gorm:"size:3;default:'USD'" json:"currency"`
+	BaseCurrency             string               `gorm:"size:3;not null;default:'USD'" json:"base_currency"`
+	ExchangeRate             float64              `gorm:"not null;default:1" json:"exchange_rate"`
+	Status                   string               `gorm:"default:'pending';index:idx_status" json:"status"`
+	PaymentProvider          string               `json:"payment_provider,omitempty"`
+	PaymentIntentID          string               `gorm:"index" json:"payment_intent_id,omitempty"`
+	PaidAt                   *time.Time           `json:"paid_at,omitempty"`
+	RefundedTotal            money.Money          `gorm:"not null;default:0" json:"refunded_total"`
+	PresentmentSubtotal      money.Money          `gorm:"not null;default:0" json:"presentment_subtotal"`
+	PresentmentDiscountTotal money.Money          `gorm:"not null;default:0" json:"presentment_discount_total"`
+	PresentmentShippingTotal money.Money          `gorm:"not null;default:0" json:"presentment_shipping_total"`
+	PresentmentTaxTotal      money.Money          `gorm:"not null;default:0" json:"presentment_tax_total"`
+	PresentmentTotal         money.Money          `gorm:"not null;default:0" json:"presentment_total"`
+	PresentmentRefundedTotal money.Money          `gorm:"not null;default:0" json:"presentment_refunded_total"`
+	Items                    []OrderItem          `gorm:"foreignKey:OrderID" json:"items"`
+	Transactions             []PaymentTransaction `gorm:"foreignKey:OrderID" json:"transactions,omitempty"`
+	Refunds                  []Refund             `gorm:"foreignKey:OrderID" json:"refunds,omitempty"`
+	Discounts                []OrderDiscount      `gorm:"foreignKey:OrderID" json:"discounts,omitempty"`
+	TaxLines                 []OrderTaxLine       `gorm:"foreignKey:OrderID" json:"tax_lines,omitempty"`
+	Shipments                []Shipment           `gorm:"foreignKey:OrderID" json:"shipments,omitempty"`
+	CreatedAt                time.Time            `json:"created_at"`
+	UpdatedAt                time.Time            `json:"updated_at"`
+	DeletedAt                gorm.DeletedAt       `gorm:"index" json:"-"`
 }
 
+// OrderItem is a quantity of one variant. SKU and VariantName are copied
+// from the variant when the order is placed. Amounts are in BaseCurrency,
+// the Presentment ones in the order's Currency.
 type OrderItem struct {
-	ID        uint    `gorm:"primaryKey" json:"id"`
-	OrderID   uint    `gorm:"not null;index:idx_order_id" json:"order_id"`
-	ProductID uint    `gorm:"not null;index:idx_product_id" json:"product_id"`
-	Quantity  int     `gorm:"not null" json:"quantity"`
-	Price     float64 `gorm:"not null" json:"price"`
+	ID                   uint        `gorm:"primaryKey" json:"id"`
+	OrderID              uint        `gorm:"not null;index:idx_order_id" json:"order_id"`
+	ProductID            uint        `gorm:"not null;index:idx_product_id" json:"product_id"`
+	ProductName          string      `json:"product_name"`
+	VariantID            uint        `gorm:"not null;default:0;index" json:"variant_id"`
+	SKU                  string      `gorm:"size:64" json:"sku"`
+	VariantName          string      `json:"variant_name,omitempty"`
+	Quantity             int         `gorm:"not null" json:"quantity"`
+	Price                money.Money `gorm:"not null" json:"price"`
+	Discount             money.Money `gorm:"not null;default:0" json:"discount"`
+	TaxRate              float64     `gorm:"not null;default:0" json:"tax_rate"`
+	TaxAmount            money.Money `gorm:"not null;default:0" json:"tax_amount"`
+	ReturnedQuantity     int         `gorm:"not null;default:0" json:"returned_quantity"`
+	PresentmentPrice     money.Money `gorm:"not null;default:0" json:"presentment_price"`
+	PresentmentDiscount  money.Money `gorm:"not null;default:0" json:"presentment_discount"`
+	PresentmentTaxAmount money.Money `gorm:"not null;default:0" json:"presentment_tax_amount"`
+}
+
+// AfterFind tags the amounts of the order and its preloaded lines with
+// their currency.
+func (o *Order) AfterFind(tx *gorm.DB) error {
+	o.TagCurrencies()
+	return nil
+}
+
+// TagCurrencies labels the base amounts with BaseCurrency and the
+// presentment ones with Currency, for orders and lines loaded from the
+// database, which only stores minor units.
+func (o *Order) TagCurrencies() {
+	base, presentment := o.BaseCurrency, o.Currency
+	if base == "" {
+		base = money.DefaultCurrency
+	}
+	if presentment == "" {
+		presentment = base
+	}
+	for _, m := range []*money.Money{&o.Subtotal, &o.DiscountTotal, &o.ShippingTotal, &o.ShippingTax, &o.TaxTotal, &o.Total, &o.RefundedTotal} {
+		*m = m.WithCurrency(base)
+	}
+	for _, m := range []*money.Money{&o.PresentmentSubtotal, &o.PresentmentDiscountTotal, &o.PresentmentShippingTotal, &o.PresentmentTaxTotal, &o.PresentmentTotal, &o.PresentmentRefundedTotal} {
+		*m = m.WithCurrency(presentment)
+	}
+	for i := range o.Items {
+		item := &o.Items[i]
+		item.Price = item.Price.WithCurrency(base)
+		item.Discount = item.Discount.WithCurrency(base)
+		item.TaxAmount = item.TaxAmount.WithCurrency(base)
+		item.PresentmentPrice = item.PresentmentPrice.WithCurrency(presentment)
+		item.PresentmentDiscount = item.PresentmentDiscount.WithCurrency(presentment)
+		item.PresentmentTaxAmount = item.PresentmentTaxAmount.WithCurrency(presentment)
+	}
+	for i := range o.TaxLines {
+		line := &o.TaxLines[i]
+		line.TaxableAmount = line.TaxableAmount.WithCurrency(base)
+		line.Amount = line.Amount.WithCurrency(base)
+		line.PresentmentTaxableAmount = line.PresentmentTaxableAmount.WithCurrency(presentment)
+		line.PresentmentAmount = line.PresentmentAmount.WithCurrency(presentment)
+	}
+	for i := range o.Discounts {
+		o.Discounts[i].Amount = o.Discounts[i].Amount.WithCurrency(base)
+	}
+}
+
+// OrderStatusHistory records every status change of an order. Actor is
+// "customer", "staff", "system" or "provider:<name>"; ActorID is set for
+// customer and staff.
+type OrderStatusHistory struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	OrderID    uint      `gorm:"not null;index" json:"order_id"`
+	FromStatus string    `json:"from_status"`
+	ToStatus   string    `gorm:"not null" json:"to_status"`
+	Actor      string    `gorm:"not null" json:"actor"`
+	ActorID    *uint     `json:"actor_id,omitempty"`
+	Reason     string    `json:"reason"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+func (OrderStatusHistory) TableName() string {
+	return "order_status_history"
 }
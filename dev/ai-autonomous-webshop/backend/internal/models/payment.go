@@ -0,0 +1,56 @@
+package models
+
+import "time"
+
+const (
+	PaymentTxnAuthorize = "authorize"
+	PaymentTxnCapture   = "capture"
+	PaymentTxnRefund    = "refund"
+	PaymentTxnVoid      = "void"
+)
+
+// PaymentTransaction is one call to the payment gateway made for an order.
+// Failed calls are recorded too, with the gateway's error message.
+type PaymentTransaction struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	OrderID     uint      `gorm:"not null;index" json:"order_id"`
+	Provider    string    `gorm:"not null" json:"provider"`
+	Type        string    `gorm:"not null" json:"type"`
+	IntentID    string    `gorm:"index" json:"intent_id"`
+	ProviderRef string    `json:"provider_ref,omitempty"`
+	Amount      int64     `json:"amount"`
+	Currency    string    `gorm:"size:3" json:"currency"`
+	Status      string    `json:"status"`
+	Error       string    `json:"error,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+const (
+	PaymentEventReceived  = "received"
+	PaymentEventProcessed = "processed"
+	PaymentEventIgnored   = "ignored"
+	PaymentEventFailed    = "failed"
+	PaymentEventDead      = "dead"
+)
+
+// PaymentEvent is a webhook delivery from a payment provider. The unique
+// (provider, event_id) pair makes redeliveries of the same event no-ops.
+type PaymentEvent struct {
+	ID            uint       `gorm:"primaryKey" json:"id"`
+	Provider      string     `gorm:"not null;uniqueIndex:idx_provider_event" json:"provider"`
+	EventID       string     `gorm:"not null;uniqueIndex:idx_provider_event" json:"event_id"`
+	Type          string     `json:"type"`
+	Kind          string     `gorm:"index" json:"kind"`
+	IntentID      string     `gorm:"index" json:"intent_id"`
+	Amount        int64      `json:"amount"`
+	OrderID       *uint      `gorm:"index" json:"order_id,omitempty"`
+	Payload       string     `gorm:"type:text" json:"payload"`
+	Status        string     `gorm:"not null;index" json:"status"`
+	Result        string     `json:"result,omitempty"`
+	Attempts      int        `json:"attempts"`
+	LastError     string     `json:"last_error,omitempty"`
+	NextAttemptAt *time.Time `gorm:"index" json:"next_attempt_at,omitempty"`
+	ProcessedAt   *time.Time `json:"processed_at,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
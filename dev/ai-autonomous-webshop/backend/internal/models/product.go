@@ -3,19 +3,52 @@ package models
 import (
 	"time"
 
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/money"
 	"gorm.io/gorm"
 )
 
+// Product is what the catalog lists. It is sold as its Variants, which
+// carry the stock; Stock is their total, kept in step whenever a variant's
+// stock changes. Price and WeightGrams apply to variants that don't
+// override them. Version goes up with every staff or pricing edit, so an
+// edit made against an older copy can be refused.
+//
+// CategoryID is the primary one of Categories, and Category its name, kept
+// in step for search and display. Attributes are values of the attributes
+// its categories define, by attribute name.
+//
+// Slug is made from the name and changes only when the name does; the
+// slugs it had before are kept as ProductSlugRedirects so old links still
+// lead to it. MetaTitle and MetaDescription are for search engines.
 type Product struct {
-	ID          uint           `gorm:"primaryKey" json:"id"`
-	Name        string         `gorm:"not null" json:"name"`
-	Description string         `json:"description"`
-	Price       float64        `gorm:"not null" json:"price"`
-	ImageURL    string         `json:"image_url"`
-	BlurHash    string         `json:"blur_hash"`
-	Category    string         `gorm:"index:idx_category" json:"category"`
-	Stock       int            `gorm:"default:0" json:"stock"`
-	CreatedAt   time.Time      `json:"created_at"`
-	UpdatedAt   time.Time      `json:"updated_at"`
-	DeletedAt   gorm.DeletedAt `gorm:"index" json:"-"`
+	ID              uint              `gorm:"primaryKey" json:"id"`
+	Name            string            `gorm:"not null" json:"name"`
+	Slug            string            `gorm:"size:160;not null;default:'';uniqueIndex:idx_product_slug,where:slug <> ''" json:"slug"`
+	MetaTitle       string            `gorm:"size:70" json:"meta_title"`
+	MetaDescription string            `gorm:"size:160" json:"meta_description"`
+	Description     string            `json:"description"`
+	Price           money.Money       `gorm:"not null" json:"price"`
+	ImageURL        string            `json:"image_url"`
+	BlurHash        string            `json:"blur_hash"`
+	Category        string            `gorm:"index:idx_category" json:"category"`
+	CategoryID      *uint             `gorm:"index" json:"category_id,omitempty"`
+	Categories      []Category        `gorm:"many2many:product_categories" json:"categories,omitempty"`
+	Attributes      map[string]string `gorm:"serializer:json" json:"attributes,omitempty"`
+	Stock           int               `gorm:"default:0" json:"stock"`
+	MaxQuantity     int               `gorm:"default:0" json:"max_quantity,omitempty"`
+	WeightGrams     int               `gorm:"not null;default:0" json:"weight_grams"`
+	Version         int               `gorm:"not null;default:1" json:"version"`
+	Options         []OptionType      `gorm:"foreignKey:ProductID" json:"options,omitempty"`
+	Variants        []ProductVariant  `gorm:"foreignKey:ProductID" json:"variants,omitempty"`
+	CreatedAt       time.Time         `json:"created_at"`
+	UpdatedAt       time.Time         `json:"updated_at"`
+	DeletedAt       gorm.DeletedAt    `gorm:"index" json:"-"`
+}
+
+// ProductSlugRedirect is a slug a product had before it was renamed.
+type ProductSlugRedirect struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	ProductID uint      `gorm:"not null;index" json:"product_id"`
+	Slug      string    `gorm:"size:160;not null;uniqueIndex" json:"slug"`
+	CreatedAt time.Time `json:"created_at"`
 }
@@ -0,0 +1,110 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/money"
+	"gorm.io/gorm"
+)
+
+const (
+	PromotionPercentage   = "percentage"
+	PromotionFixedAmount  = "fixed_amount"
+	PromotionBuyXGetY     = "buy_x_get_y"
+	PromotionFreeShipping = "free_shipping"
+)
+
+func IsValidPromotionType(t string) bool {
+	switch t {
+	case PromotionPercentage, PromotionFixedAmount, PromotionBuyXGetY, PromotionFreeShipping:
+		return true
+	}
+	return false
+}
+
+// Promotion is a discount rule. With a Code the customer has to apply it to
+// their cart; without one it applies by itself to every cart it matches.
+//
+// Value is the percentage off for percentage promotions and the amount off
+// for fixed-amount ones. A buy-X-get-Y promotion makes GetQuantity of every
+// BuyQuantity+GetQuantity matching units free, the cheapest ones first.
+// ProductIDs and Categories limit which lines a promotion applies to; with
+// neither it applies to the whole cart. UsageLimit and PerUserLimit are
+// unlimited at zero.
+//
+// Several Stackable promotions can apply to one order. A promotion that
+// isn't stackable is applied alone: the applicable promotion with the
+// highest Priority goes first and decides.
+type Promotion struct {
+	ID           uint           `gorm:"primaryKey" json:"id"`
+	Name         string         `gorm:"not null" json:"name"`
+	Description  string         `json:"description"`
+	Code         string         `gorm:"size:64;uniqueIndex:idx_promotion_code,where:code <> '' AND deleted_at IS NULL" json:"code,omitempty"`
+	Type         string         `gorm:"size:20;not null" json:"type"`
+	Value        float64        `gorm:"not null;default:0" json:"value"`
+	BuyQuantity  int            `gorm:"not null;default:0" json:"buy_quantity,omitempty"`
+	GetQuantity  int            `gorm:"not null;default:0" json:"get_quantity,omitempty"`
+	ProductIDs   []uint         `gorm:"serializer:json" json:"product_ids"`
+	Categories   []string       `gorm:"serializer:json" json:"categories"`
+	MinSubtotal  money.Money    `gorm:"not null;default:0" json:"min_subtotal"`
+	StartsAt     *time.Time     `json:"starts_at,omitempty"`
+	EndsAt       *time.Time     `json:"ends_at,omitempty"`
+	UsageLimit   int            `gorm:"not null;default:0" json:"usage_limit"`
+	PerUserLimit int            `gorm:"not null;default:0" json:"per_user_limit"`
+	UsageCount   int            `gorm:"not null;default:0" json:"usage_count"`
+	Stackable    bool           `gorm:"not null;default:false" json:"stackable"`
+	Priority     int            `gorm:"not null;default:0" json:"priority"`
+	Active       bool           `gorm:"not null;default:true;index" json:"active"`
+	CreatedBy    uint           `json:"created_by"`
+	CreatedAt    time.Time      `json:"created_at"`
+	UpdatedAt    time.Time      `json:"updated_at"`
+	DeletedAt    gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+// AppliesTo reports whether a line of the product is in the promotion's
+// scope. categories are the names and slugs of the product's categories and
+// their ancestors, so a promotion on a category covers the ones below it.
+func (p *Promotion) AppliesTo(productID uint, categories []string) bool {
+	if len(p.ProductIDs) == 0 && len(p.Categories) == 0 {
+		return true
+	}
+	for _, id := range p.ProductIDs {
+		if id == productID {
+			return true
+		}
+	}
+	for _, c := range p.Categories {
+		for _, category := range categories {
+			if strings.EqualFold(c, category) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// OrderDiscount is a promotion applied to an order, copied from the
+// promotion when the order was placed so later edits don't change it. It
+// doubles as the redemption record that usage limits count. Released is set
+// when the order fails or is cancelled, which gives the use back.
+type OrderDiscount struct {
+	ID          uint        `gorm:"primaryKey" json:"id"`
+	OrderID     uint        `gorm:"not null;index" json:"order_id"`
+	PromotionID uint        `gorm:"not null;index:idx_discount_promotion_user" json:"promotion_id"`
+	UserID      uint        `gorm:"not null;index:idx_discount_promotion_user" json:"user_id"`
+	Code        string      `gorm:"size:64" json:"code,omitempty"`
+	Name        string      `json:"name"`
+	Type        string      `gorm:"size:20" json:"type"`
+	Amount      money.Money `gorm:"not null" json:"amount"`
+	Released    bool        `gorm:"not null;default:false" json:"released"`
+	CreatedAt   time.Time   `json:"created_at"`
+}
+
+// CartCoupon is a promotion code applied to a user's cart.
+type CartCoupon struct {
+	ID        uint      `gorm:"primaryKey" json:"-"`
+	UserID    uint      `gorm:"not null;uniqueIndex:idx_cart_coupon" json:"-"`
+	Code      string    `gorm:"size:64;not null;uniqueIndex:idx_cart_coupon" json:"code"`
+	CreatedAt time.Time `json:"created_at"`
+}
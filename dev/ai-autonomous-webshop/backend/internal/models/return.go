@@ -0,0 +1,112 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/money"
+	"gorm.io/gorm"
+)
+
+const (
+	ReturnReasonDamaged        = "damaged"
+	ReturnReasonDefective      = "defective"
+	ReturnReasonWrongItem      = "wrong_item"
+	ReturnReasonNotAsDescribed = "not_as_described"
+	ReturnReasonNoLongerNeeded = "no_longer_needed"
+	ReturnReasonOther          = "other"
+)
+
+var ReturnReasons = []string{
+	ReturnReasonDamaged,
+	ReturnReasonDefective,
+	ReturnReasonWrongItem,
+	ReturnReasonNotAsDescribed,
+	ReturnReasonNoLongerNeeded,
+	ReturnReasonOther,
+}
+
+func IsValidReturnReason(reason string) bool {
+	for _, r := range ReturnReasons {
+		if r == reason {
+			return true
+		}
+	}
+	return false
+}
+
+const (
+	ReturnStatusRequested = "requested"
+	ReturnStatusApproved  = "approved"
+	ReturnStatusRejected  = "rejected"
+)
+
+// ReturnRequest is a customer's request to send back some lines of an
+// order (an RMA). Staff approve it, which refunds and optionally restocks
+// the items, or reject it. RefundAmount is in the order's currency, which
+// Currency repeats.
+type ReturnRequest struct {
+	ID             uint         `gorm:"primaryKey" json:"id"`
+	OrderID        uint         `gorm:"not null;index" json:"order_id"`
+	UserID         uint         `gorm:"not null;index" json:"user_id"`
+	Status         string       `gorm:"not null;index" json:"status"`
+	Note           string       `json:"note,omitempty"`
+	ResolutionNote string       `json:"resolution_note,omitempty"`
+	ReviewedBy     *uint        `json:"reviewed_by,omitempty"`
+	ReviewedAt     *time.Time   `json:"reviewed_at,omitempty"`
+	RefundAmount   money.Money  `json:"refund_amount"`
+	Currency       string       `gorm:"size:3" json:"currency"`
+	Restocked      bool         `json:"restocked"`
+	Items          []ReturnItem `gorm:"foreignKey:ReturnRequestID" json:"items"`
+	CreatedAt      time.Time    `json:"created_at"`
+	UpdatedAt      time.Time    `json:"updated_at"`
+}
+
+type ReturnItem struct {
+	ID              uint   `gorm:"primaryKey" json:"id"`
+	ReturnRequestID uint   `gorm:"not null;index" json:"return_request_id"`
+	OrderItemID     uint   `gorm:"not null;index" json:"order_item_id"`
+	ProductID       uint   `gorm:"not null" json:"product_id"`
+	Quantity        int    `gorm:"not null" json:"quantity"`
+	Reason          string `gorm:"not null" json:"reason"`
+	Comment         string `json:"comment,omitempty"`
+}
+
+const (
+	RefundStatusPending = "pending"
+	RefundStatusFailed  = "failed"
+)
+
+// Refund is money given back for an order, whether for a cancellation, an
+// approved return or a goodwill refund by staff. Amount is in the order's
+// presentment Currency, as charged back to the customer; BaseAmount is the
+// same at the order's locked exchange rate. A refund is pending until the
+// gateway answers; after that Status is the gateway's, or failed.
+type Refund struct {
+	ID              uint        `gorm:"primaryKey" json:"id"`
+	OrderID         uint        `gorm:"not null;index" json:"order_id"`
+	ReturnRequestID *uint       `gorm:"index" json:"return_request_id,omitempty"`
+	Amount          money.Money `gorm:"not null" json:"amount"`
+	Currency        string      `gorm:"size:3" json:"currency"`
+	BaseAmount      money.Money `gorm:"not null;default:0" json:"base_amount"`
+	Reason          string      `json:"reason"`
+	Provider        string      `json:"provider"`
+	ProviderRef     string      `json:"provider_ref,omitempty"`
+	Status          string      `json:"status"`
+	Actor           string      `gorm:"not null" json:"actor"`
+	ActorID         *uint       `json:"actor_id,omitempty"`
+	CreatedAt       time.Time   `json:"created_at"`
+}
+
+func (r *Refund) AfterFind(tx *gorm.DB) error {
+	if r.Currency != "" {
+		r.Amount = r.Amount.WithCurrency(r.Currency)
+	}
+	return nil
+}
+
+func (r *ReturnRequest) AfterFind(tx *gorm.DB) error {
+	if r.Currency != "" {
+		r.RefundAmount = r.RefundAmount.WithCurrency(r.Currency)
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package models
+
+import (
+	"sort"
+	"time"
+)
+
+const (
+	RoleCustomer = "customer"
+	RoleStaff    = "staff"
+	RoleAdmin    = "admin"
+)
+
+const (
+	PermCatalogWrite     = "catalog:write"
+	PermPricingRead      = "pricing:read"
+	PermPricingApply     = "pricing:apply"
+	PermAnalyticsRead    = "analytics:read"
+	PermAIGenerate       = "ai:generate"
+	PermUsersManage      = "users:manage"
+	PermOrdersManage     = "orders:manage"
+	PermOrdersRefund     = "orders:refund"
+	PermPromotionsManage = "promotions:manage"
+)
+
+// RolePermissions maps each role to the permissions it grants. Every user
+// implicitly holds RoleCustomer; staff and admin are granted explicitly.
+var RolePermissions = map[string][]string{
+	RoleCustomer: {},
+	RoleStaff: {
+		PermCatalogWrite,
+		PermPricingRead,
+		PermAnalyticsRead,
+		PermAIGenerate,
+		PermOrdersManage,
+	},
+	RoleAdmin: {
+		PermCatalogWrite,
+		PermPricingRead,
+		PermPricingApply,
+		PermAnalyticsRead,
+		PermAIGenerate,
+		PermUsersManage,
+		PermOrdersManage,
+		PermOrdersRefund,
+		PermPromotionsManage,
+	},
+}
+
+type UserRole struct {
+	ID        uint      `gorm:"primaryKey" json:"-"`
+	UserID    uint      `gorm:"not null;uniqueIndex:idx_user_role" json:"user_id"`
+	Role      string    `gorm:"not null;uniqueIndex:idx_user_role" json:"role"`
+	GrantedBy uint      `json:"granted_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func IsValidRole(role string) bool {
+	_, ok := RolePermissions[role]
+	return ok
+}
+
+// RoleNames returns the user's roles, always including RoleCustomer.
+func (u *User) RoleNames() []string {
+	roles := []string{RoleCustomer}
+	for _, r := range u.Roles {
+		if r.Role != RoleCustomer {
+			roles = append(roles, r.Role)
+		}
+	}
+	return roles
+}
+
+func (u *User) Permissions() []string {
+	return PermissionsForRoles(u.RoleNames())
+}
+
+func (u *User) HasRole(role string) bool {
+	for _, r := range u.RoleNames() {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// PermissionsForRoles returns the sorted, de-duplicated union of the
+// permissions granted by the given roles.
+func PermissionsForRoles(roles []string) []string {
+	seen := make(map[string]bool)
+	perms := []string{}
+	for _, role := range roles {
+		for _, p := range RolePermissions[role] {
+			if !seen[p] {
+				seen[p] = true
+				perms = append(perms, p)
+			}
+		}
+	}
+	sort.Strings(perms)
+	return perms
+}
@@ -0,0 +1,25 @@
+package models
+
+import (
+	"time"
+)
+
+const (
+	SecurityEventLoginFailed     = "login_failed"
+	SecurityEventAccountLocked   = "account_locked"
+	SecurityEventLoginBlocked    = "login_blocked"
+	SecurityEventMFAEnabled      = "mfa_enabled"
+	SecurityEventMFADisabled     = "mfa_disabled"
+	SecurityEventMFARecoveryUsed = "mfa_recovery_code_used"
+)
+
+type SecurityEvent struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	Type      string    `gorm:"not null;index:idx_security_event_type" json:"type"`
+	UserID    *uint     `gorm:"index:idx_security_event_user_id" json:"user_id,omitempty"`
+	Email     string    `gorm:"index" json:"email"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	Detail    string    `gorm:"type:text" json:"detail"`
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+}
@@ -0,0 +1,142 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/money"
+	"gorm.io/gorm"
+)
+
+// PostalAddress is where a parcel goes. Country is an ISO 3166-1 alpha-2
+// code; Region is the state or province code in countries that have them.
+type PostalAddress struct {
+	Name       string `gorm:"not null" json:"name"`
+	Company    string `json:"company,omitempty"`
+	Line1      string `gorm:"not null" json:"line1"`
+	Line2      string `json:"line2,omitempty"`
+	City       string `gorm:"not null" json:"city"`
+	Region     string `gorm:"size:10" json:"region,omitempty"`
+	PostalCode string `gorm:"size:20" json:"postal_code"`
+	Country    string `gorm:"size:2;not null" json:"country"`
+	Phone      string `gorm:"size:32" json:"phone,omitempty"`
+}
+
+// Address is an entry in a customer's address book. Orders copy the address
+// they ship to, so editing or deleting an entry doesn't change them.
+type Address struct {
+	ID            uint   `gorm:"primaryKey" json:"id"`
+	UserID        uint   `gorm:"not null;index" json:"user_id"`
+	Label         string `gorm:"size:64" json:"label,omitempty"`
+	PostalAddress `gorm:"embedded"`
+	IsDefault     bool           `gorm:"not null;default:false" json:"is_default"`
+	CreatedAt     time.Time      `json:"created_at"`
+	UpdatedAt     time.Time      `json:"updated_at"`
+	DeletedAt     gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+// ShippingZone groups the countries that share shipping methods. A zone
+// without countries is the rest of the world: it covers every country no
+// other zone lists.
+type ShippingZone struct {
+	ID        uint             `gorm:"primaryKey" json:"id"`
+	Name      string           `gorm:"not null" json:"name"`
+	Countries []string         `gorm:"serializer:json" json:"countries"`
+	Active    bool             `gorm:"not null;default:true" json:"active"`
+	Methods   []ShippingMethod `gorm:"foreignKey:ZoneID" json:"methods"`
+	CreatedAt time.Time        `json:"created_at"`
+	UpdatedAt time.Time        `json:"updated_at"`
+}
+
+// Rate bases of a shipping method.
+const (
+	ShippingRateByWeight = "weight"
+	ShippingRateByPrice  = "price"
+)
+
+// ShippingMethod is a way of shipping to a zone, priced by a rate table on
+// either the parcel's weight or the order's value. MinDays and MaxDays are
+// the delivery estimate shown to customers.
+type ShippingMethod struct {
+	ID        uint           `gorm:"primaryKey" json:"id"`
+	ZoneID    uint           `gorm:"not null;index" json:"zone_id"`
+	Name      string         `gorm:"not null" json:"name"`
+	Carrier   string         `gorm:"size:32" json:"carrier"`
+	RateBasis string         `gorm:"size:10;not null" json:"rate_basis"`
+	MinDays   int            `gorm:"not null;default:0" json:"min_days"`
+	MaxDays   int            `gorm:"not null;default:0" json:"max_days"`
+	Active    bool           `gorm:"not null;default:true" json:"active"`
+	Rates     []ShippingRate `gorm:"foreignKey:MethodID" json:"rates"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+}
+
+// ShippingRate is one bracket of a method's rate table. The bounds are grams
+// for weight-based methods and the order subtotal after discounts, in the
+// base currency, for price-based ones. A bracket covers MinValue up to but
+// not including MaxValue; a MaxValue of zero has no upper bound.
+type ShippingRate struct {
+	ID       uint        `gorm:"primaryKey" json:"id"`
+	MethodID uint        `gorm:"not null;index" json:"method_id"`
+	MinValue float64     `gorm:"not null;default:0" json:"min_value"`
+	MaxValue float64     `gorm:"not null;default:0" json:"max_value"`
+	Price    money.Money `gorm:"not null" json:"price"`
+}
+
+// Covers reports whether value falls into the bracket.
+func (r *ShippingRate) Covers(value float64) bool {
+	return value >= r.MinValue && (r.MaxValue == 0 || value < r.MaxValue)
+}
+
+const (
+	ShipmentLabelCreated   = "label_created"
+	ShipmentInTransit      = "in_transit"
+	ShipmentOutForDelivery = "out_for_delivery"
+	ShipmentDelivered      = "delivered"
+	ShipmentException      = "exception"
+	ShipmentReturned       = "returned"
+)
+
+func IsValidShipmentStatus(status string) bool {
+	switch status {
+	case ShipmentLabelCreated, ShipmentInTransit, ShipmentOutForDelivery,
+		ShipmentDelivered, ShipmentException, ShipmentReturned:
+		return true
+	}
+	return false
+}
+
+// IsFinalShipmentStatus reports whether a shipment with the status has
+// reached the end of its journey and takes no more tracking updates.
+func IsFinalShipmentStatus(status string) bool {
+	return status == ShipmentDelivered || status == ShipmentReturned
+}
+
+// Shipment is a parcel of an order handed to a carrier. An order can ship
+// in several parcels. Status is the latest tracking status; Events is the
+// full tracking history.
+type Shipment struct {
+	ID             uint            `gorm:"primaryKey" json:"id"`
+	OrderID        uint            `gorm:"not null;index" json:"order_id"`
+	Carrier        string          `gorm:"size:32;not null" json:"carrier"`
+	Service        string          `json:"service,omitempty"`
+	TrackingNumber string          `gorm:"size:64;not null;index" json:"tracking_number"`
+	TrackingURL    string          `json:"tracking_url,omitempty"`
+	Status         string          `gorm:"size:20;not null" json:"status"`
+	ShippedAt      time.Time       `json:"shipped_at"`
+	DeliveredAt    *time.Time      `json:"delivered_at,omitempty"`
+	CreatedBy      uint            `json:"created_by"`
+	Events         []ShipmentEvent `gorm:"foreignKey:ShipmentID" json:"events,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+	UpdatedAt      time.Time       `json:"updated_at"`
+}
+
+// ShipmentEvent is one tracking update of a shipment.
+type ShipmentEvent struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	ShipmentID  uint      `gorm:"not null;index" json:"shipment_id"`
+	Status      string    `gorm:"size:20;not null" json:"status"`
+	Location    string    `json:"location,omitempty"`
+	Description string    `json:"description,omitempty"`
+	OccurredAt  time.Time `gorm:"not null" json:"occurred_at"`
+	CreatedAt   time.Time `json:"created_at"`
+}
@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+const (
+	StockReservationActive   = "active"
+	StockReservationConsumed = "consumed"
+	StockReservationReleased = "released"
+)
+
+// StockReservation holds stock for a cart in checkout (OrderID nil) or for
+// an order that is waiting for the customer to finish paying. The quantity
+// is taken off the variant's stock when the hold is created, so its Stock
+// is always what new buyers can get; releasing an expired hold puts it
+// back.
+type StockReservation struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	UserID    uint       `gorm:"not null;index" json:"user_id"`
+	OrderID   *uint      `gorm:"index" json:"order_id,omitempty"`
+	ProductID uint       `gorm:"not null;index" json:"product_id"`
+	VariantID uint       `gorm:"not null;default:0;index" json:"variant_id"`
+	Quantity  int        `gorm:"not null" json:"quantity"`
+	Status    string     `gorm:"not null;index:idx_reservation_status_expiry" json:"status"`
+	ExpiresAt time.Time  `gorm:"not null;index:idx_reservation_status_expiry" json:"expires_at"`
+	SettledAt *time.Time `json:"settled_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
@@ -0,0 +1,48 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/money"
+)
+
+// TaxRule is a tax rate for a country, or for one region of it such as a US
+// state. A rule with a Category only applies to products in that category
+// or one below it, by name or slug, e.g. a reduced VAT rate for books. For every line the most specific
+// active rule wins: region and category, then region, then country and
+// category, then country.
+//
+// PricesIncludeTax makes prices show tax-inclusive to customers in that
+// country, as is usual for VAT and GST; the amount charged is the same
+// either way. ReverseCharge lets business customers with a VAT ID of that
+// country account for the tax themselves.
+type TaxRule struct {
+	ID               uint      `gorm:"primaryKey" json:"id"`
+	Country          string    `gorm:"size:2;not null;index:idx_tax_rule_location" json:"country"`
+	Region           string    `gorm:"size:10;not null;default:'';index:idx_tax_rule_location" json:"region,omitempty"`
+	Category         string    `gorm:"not null;default:''" json:"category,omitempty"`
+	Name             string    `gorm:"not null" json:"name"`
+	Rate             float64   `gorm:"not null" json:"rate"`
+	PricesIncludeTax bool      `gorm:"not null;default:false" json:"prices_include_tax"`
+	ReverseCharge    bool      `gorm:"not null;default:false" json:"reverse_charge"`
+	Active           bool      `gorm:"not null;default:true" json:"active"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+// OrderTaxLine is the tax of an order at one rate, as the invoice shows it.
+// Rate is a percentage; it is zero for a reverse-charged order. The
+// Presentment amounts are in the order's currency.
+type OrderTaxLine struct {
+	ID                       uint        `gorm:"primaryKey" json:"id"`
+	OrderID                  uint        `gorm:"not null;index" json:"order_id"`
+	Name                     string      `gorm:"not null" json:"name"`
+	Country                  string      `gorm:"size:2" json:"country"`
+	Region                   string      `gorm:"size:10" json:"region,omitempty"`
+	Rate                     float64     `gorm:"not null" json:"rate"`
+	TaxableAmount            money.Money `gorm:"not null" json:"taxable_amount"`
+	Amount                   money.Money `gorm:"not null" json:"amount"`
+	PresentmentTaxableAmount money.Money `gorm:"not null;default:0" json:"presentment_taxable_amount"`
+	PresentmentAmount        money.Money `gorm:"not null;default:0" json:"presentment_amount"`
+	CreatedAt                time.Time   `json:"created_at"`
+}
@@ -0,0 +1,52 @@
+package models
+
+import (
+	"time"
+)
+
+// RefreshToken is the Postgres fallback record for an issued refresh token.
+// Only the SHA-256 hash of the token is stored. Tokens rotated from the same
+// login share a FamilyID so that reuse of an old token can revoke the chain,
+// and carry the MFA status of that login forward.
+type RefreshToken struct {
+	ID          uint       `gorm:"primaryKey" json:"id"`
+	UserID      uint       `gorm:"not null;index:idx_refresh_user_id" json:"user_id"`
+	FamilyID    string     `gorm:"not null;index:idx_refresh_family_id" json:"family_id"`
+	TokenHash   string     `gorm:"not null;uniqueIndex" json:"-"`
+	UserAgent   string     `json:"user_agent"`
+	IP          string     `json:"ip"`
+	MFAVerified bool       `gorm:"default:false" json:"mfa_verified"`
+	ExpiresAt   time.Time  `gorm:"not null" json:"expires_at"`
+	RotatedAt   *time.Time `json:"rotated_at,omitempty"`
+	RevokedAt   *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// RevokedToken is the Postgres fallback deny-list. A row either blocks a
+// single access token (JTI set) or every access token a user was issued
+// before RevokedBefore (JTI empty, "log out all devices").
+type RevokedToken struct {
+	ID            uint       `gorm:"primaryKey" json:"id"`
+	JTI           string     `gorm:"index:idx_revoked_jti" json:"jti,omitempty"`
+	UserID        uint       `gorm:"index:idx_revoked_user_id" json:"user_id"`
+	RevokedBefore *time.Time `json:"revoked_before,omitempty"`
+	ExpiresAt     time.Time  `gorm:"not null;index" json:"expires_at"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+const (
+	TokenPurposeEmailVerification = "email_verification"
+	TokenPurposePasswordReset     = "password_reset"
+)
+
+// UserToken is a single-use, expiring token mailed to the user. Only the
+// SHA-256 hash is stored.
+type UserToken struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	UserID    uint       `gorm:"not null;index:idx_user_token_user_id" json:"user_id"`
+	Purpose   string     `gorm:"not null;index:idx_user_token_user_id" json:"purpose"`
+	TokenHash string     `gorm:"not null;uniqueIndex" json:"-"`
+	ExpiresAt time.Time  `gorm:"not null" json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
@@ -0,0 +1,77 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/money"
+	"gorm.io/gorm"
+)
+
+// OptionType is something a product comes in several of, like size or
+// color, with the values it comes in. Position orders both.
+type OptionType struct {
+	ID        uint          `gorm:"primaryKey" json:"id"`
+	ProductID uint          `gorm:"not null;uniqueIndex:idx_product_option" json:"product_id"`
+	Name      string        `gorm:"size:50;not null;uniqueIndex:idx_product_option" json:"name"`
+	Position  int           `gorm:"not null;default:0" json:"position"`
+	Values    []OptionValue `gorm:"foreignKey:OptionTypeID" json:"values"`
+}
+
+type OptionValue struct {
+	ID           uint   `gorm:"primaryKey" json:"id"`
+	OptionTypeID uint   `gorm:"not null;uniqueIndex:idx_option_value" json:"option_type_id"`
+	Value        string `gorm:"size:50;not null;uniqueIndex:idx_option_value" json:"value"`
+	Position     int    `gorm:"not null;default:0" json:"position"`
+}
+
+// ProductVariant is what is actually sold: one value of each of the
+// product's option types, with its own SKU and stock. Carts, orders and
+// stock holds are kept per variant. A product without options has a single
+// variant with none. Price and WeightGrams override the product's when set.
+type ProductVariant struct {
+	ID          uint           `gorm:"primaryKey" json:"id"`
+	ProductID   uint           `gorm:"not null;index" json:"product_id"`
+	SKU         string         `gorm:"size:64;not null;uniqueIndex:idx_variant_sku,where:deleted_at IS NULL" json:"sku"`
+	Price       *money.Money   `json:"price,omitempty"`
+	Stock       int            `gorm:"not null;default:0" json:"stock"`
+	WeightGrams *int           `json:"weight_grams,omitempty"`
+	Options     []OptionValue  `gorm:"many2many:variant_option_values" json:"options"`
+	Images      []VariantImage `gorm:"foreignKey:VariantID" json:"images"`
+	CreatedAt   time.Time      `json:"created_at"`
+	UpdatedAt   time.Time      `json:"updated_at"`
+	DeletedAt   gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+type VariantImage struct {
+	ID        uint   `gorm:"primaryKey" json:"id"`
+	VariantID uint   `gorm:"not null;index" json:"variant_id"`
+	URL       string `gorm:"not null" json:"url"`
+	Position  int    `gorm:"not null;default:0" json:"position"`
+}
+
+// UnitPrice is what one unit of the variant of product costs.
+func (v *ProductVariant) UnitPrice(product *Product) money.Money {
+	if v.Price != nil {
+		return *v.Price
+	}
+	return product.Price
+}
+
+// Weight is the weight of one unit of the variant of product in grams.
+func (v *ProductVariant) Weight(product *Product) int {
+	if v.WeightGrams != nil {
+		return *v.WeightGrams
+	}
+	return product.WeightGrams
+}
+
+// Title names the variant by its option values, like "M / Red". It is
+// empty for a product without options.
+func (v *ProductVariant) Title() string {
+	values := make([]string, len(v.Options))
+	for i, o := range v.Options {
+		values[i] = o.Value
+	}
+	return strings.Join(values, " / ")
+}
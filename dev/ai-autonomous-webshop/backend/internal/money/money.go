@@ -0,0 +1,437 @@
+// Package money keeps amounts as integer minor units of a currency, so
+// prices and totals add up to the cent instead of drifting like float64.
+package money
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// DefaultCurrency is the currency of amounts read from the database or
+// decoded from JSON. Columns in another currency are re-tagged by the model
+// that knows it.
+const DefaultCurrency = "USD"
+
+var (
+	ErrInvalidAmount = errors.New("invalid money amount")
+	ErrOverflow      = errors.New("money amount out of range")
+)
+
+// exponents lists the ISO 4217 currencies whose minor unit isn't a cent.
+var exponents = map[string]int{
+	"BHD": 3, "BIF": 0, "CLP": 0, "DJF": 0, "GNF": 0, "IQD": 3, "ISK": 0,
+	"JOD": 3, "JPY": 0, "KMF": 0, "KRW": 0, "KWD": 3, "LYD": 3, "OMR": 3,
+	"PYG": 0, "RWF": 0, "TND": 3, "UGX": 0, "UYI": 0, "VND": 0, "VUV": 0,
+	"XAF": 0, "XOF": 0, "XPF": 0,
+}
+
+// Exponent is the number of decimals of currency's minor unit: 2 for USD,
+// 0 for JPY.
+func Exponent(currency string) int {
+	if currency == "" {
+		currency = DefaultCurrency
+	}
+	if exp, ok := exponents[currency]; ok {
+		return exp
+	}
+	return 2
+}
+
+// Money is an amount in minor units of a currency. The zero Money has no
+// currency yet and takes on the currency of the first amount it is
+// combined with, so it can start a sum. Arithmetic on two different
+// currencies is a programming error and panics, as does overflowing int64.
+type Money struct {
+	amount   int64
+	currency string
+}
+
+// New returns minor units of currency: New(1999, "USD") is $19.99.
+func New(minor int64, currency string) Money {
+	return Money{amount: minor, currency: currency}
+}
+
+// Zero is nothing in currency.
+func Zero(currency string) Money {
+	return Money{currency: currency}
+}
+
+// FromFloat converts a float amount in major units, rounding half to even
+// at the currency's minor unit. The float is read as the shortest decimal
+// that represents it, so 2.675 is 2.675 and not 2.67499999….
+func FromFloat(amount float64, currency string) Money {
+	if math.IsNaN(amount) || math.IsInf(amount, 0) {
+		panic(ErrInvalidAmount)
+	}
+	m, err := Parse(strconv.FormatFloat(amount, 'f', -1, 64), currency)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+// Parse reads a decimal amount in major units such as "19.99" or "-5",
+// rounding half to even when it has more decimals than the currency.
+func Parse(s, currency string) (Money, error) {
+	s = strings.TrimSpace(s)
+	r, ok := new(big.Rat).SetString(s)
+	if !ok || s == "" || strings.ContainsAny(s, "/eE") {
+		return Money{}, fmt.Errorf("%w: %q", ErrInvalidAmount, s)
+	}
+	minor, err := roundHalfEven(r.Mul(r, pow10(Exponent(currency))))
+	if err != nil {
+		return Money{}, err
+	}
+	return Money{amount: minor, currency: currency}, nil
+}
+
+// Sum adds amounts; the sum of none is the zero Money.
+func Sum(amounts ...Money) Money {
+	var total Money
+	for _, m := range amounts {
+		total = total.Add(m)
+	}
+	return total
+}
+
+// Min returns the smaller of a and b.
+func Min(a, b Money) Money {
+	if a.Cmp(b) <= 0 {
+		return a
+	}
+	return b
+}
+
+// Max returns the larger of a and b.
+func Max(a, b Money) Money {
+	if a.Cmp(b) >= 0 {
+		return a
+	}
+	return b
+}
+
+// Minor is the amount in minor units, which is what payment gateways take.
+func (m Money) Minor() int64 { return m.amount }
+
+// Currency is the ISO 4217 code; empty only for the zero Money.
+func (m Money) Currency() string { return m.currency }
+
+// WithCurrency relabels the same minor units as currency. It is for
+// amounts read from a column whose currency is stored elsewhere, never a
+// conversion; use Convert for that.
+func (m Money) WithCurrency(currency string) Money {
+	m.currency = currency
+	return m
+}
+
+// Float is the amount in major units, for display and ratios only.
+func (m Money) Float() float64 {
+	f, _ := new(big.Rat).SetFrac(big.NewInt(m.amount), pow10(Exponent(m.currency)).Num()).Float64()
+	return f
+}
+
+// String formats the amount in major units with the currency's decimals,
+// without a symbol: "19.99", "-0.50", "1500".
+func (m Money) String() string {
+	exp := Exponent(m.currency)
+	sign := ""
+	abs := new(big.Int).SetInt64(m.amount)
+	if m.amount < 0 {
+		sign = "-"
+		abs.Neg(abs)
+	}
+	digits := abs.String()
+	if exp == 0 {
+		return sign + digits
+	}
+	if len(digits) <= exp {
+		digits = strings.Repeat("0", exp-len(digits)+1) + digits
+	}
+	return sign + digits[:len(digits)-exp] + "." + digits[len(digits)-exp:]
+}
+
+func (m Money) IsZero() bool     { return m.amount == 0 }
+func (m Money) IsPositive() bool { return m.amount > 0 }
+func (m Money) IsNegative() bool { return m.amount < 0 }
+
+// Cmp compares a and b: -1 if m < o, 0 if equal, +1 if m > o.
+func (m Money) Cmp(o Money) int {
+	m.sameCurrency(o)
+	switch {
+	case m.amount < o.amount:
+		return -1
+	case m.amount > o.amount:
+		return 1
+	}
+	return 0
+}
+
+func (m Money) Equal(o Money) bool       { return m.Cmp(o) == 0 }
+func (m Money) LessThan(o Money) bool    { return m.Cmp(o) < 0 }
+func (m Money) GreaterThan(o Money) bool { return m.Cmp(o) > 0 }
+
+func (m Money) Add(o Money) Money {
+	currency := m.sameCurrency(o)
+	sum := m.amount + o.amount
+	if (sum > m.amount) != (o.amount > 0) {
+		panic(ErrOverflow)
+	}
+	return Money{amount: sum, currency: currency}
+}
+
+func (m Money) Sub(o Money) Money {
+	return m.Add(o.Neg())
+}
+
+func (m Money) Neg() Money {
+	if m.amount == math.MinInt64 {
+		panic(ErrOverflow)
+	}
+	return Money{amount: -m.amount, currency: m.currency}
+}
+
+func (m Money) Abs() Money {
+	if m.amount < 0 {
+		return m.Neg()
+	}
+	return m
+}
+
+// Times multiplies by a whole number, such as a quantity.
+func (m Money) Times(n int64) Money {
+	product := new(big.Int).Mul(big.NewInt(m.amount), big.NewInt(n))
+	if !product.IsInt64() {
+		panic(ErrOverflow)
+	}
+	return Money{amount: product.Int64(), currency: m.currency}
+}
+
+// Mul multiplies by a factor such as a tax rate or a price multiplier,
+// rounding half to even at the minor unit. Like FromFloat it reads the
+// factor as its shortest decimal, so 0.19 is exactly 0.19.
+func (m Money) Mul(factor float64) Money {
+	return m.MulRat(decimalRat(factor))
+}
+
+// Percent is m * percent / 100, rounded half to even.
+func (m Money) Percent(percent float64) Money {
+	r := decimalRat(percent)
+	return m.MulRat(r.Quo(r, big.NewRat(100, 1)))
+}
+
+// MulRat multiplies by an exact fraction, rounding half to even.
+func (m Money) MulRat(factor *big.Rat) Money {
+	r := new(big.Rat).Mul(new(big.Rat).SetInt64(m.amount), factor)
+	minor, err := roundHalfEven(r)
+	if err != nil {
+		panic(err)
+	}
+	return Money{amount: minor, currency: m.currency}
+}
+
+// Ratio is m / o as a float, for shares and percentages. It is zero when o
+// is.
+func (m Money) Ratio(o Money) float64 {
+	m.sameCurrency(o)
+	if o.amount == 0 {
+		return 0
+	}
+	f, _ := new(big.Rat).SetFrac(big.NewInt(m.amount), big.NewInt(o.amount)).Float64()
+	return f
+}
+
+// Convert converts into currency at rate units of currency per unit of m's
+// currency, rounding half to even at the target's minor unit.
+func (m Money) Convert(currency string, rate float64) Money {
+	if currency == m.currency && rate == 1 {
+		return m
+	}
+	r := decimalRat(rate)
+	r.Mul(r, pow10(Exponent(currency)))
+	r.Quo(r, pow10(Exponent(m.currency)))
+	converted := m.MulRat(r)
+	converted.currency = currency
+	return converted
+}
+
+// Allocate splits m in proportion to ratios without losing a minor unit:
+// each part gets its floor share and the units left over go one each to
+// the parts with the largest remainders, earliest first on ties. Ratios
+// must not be negative; if they are all zero m is split evenly.
+func (m Money) Allocate(ratios ...int64) []Money {
+	if len(ratios) == 0 {
+		return nil
+	}
+	var total int64
+	for _, r := range ratios {
+		if r < 0 {
+			panic(fmt.Errorf("money: negative allocation ratio %d", r))
+		}
+		total += r
+	}
+	if total == 0 {
+		ratios = make([]int64, len(ratios))
+		for i := range ratios {
+			ratios[i] = 1
+		}
+		total = int64(len(ratios))
+	}
+
+	amount := big.NewInt(m.amount)
+	sign := int64(1)
+	if m.amount < 0 {
+		sign = -1
+		amount.Neg(amount)
+	}
+	parts := make([]Money, len(ratios))
+	remainders := make([]*big.Int, len(ratios))
+	left := new(big.Int).Set(amount)
+	for i, r := range ratios {
+		share, rem := new(big.Int).QuoRem(new(big.Int).Mul(amount, big.NewInt(r)), big.NewInt(total), new(big.Int))
+		parts[i] = Money{amount: sign * share.Int64(), currency: m.currency}
+		remainders[i] = rem
+		left.Sub(left, share)
+	}
+	for n := left.Int64(); n > 0; n-- {
+		best := -1
+		for i, rem := range remainders {
+			if rem.Sign() >= 0 && (best < 0 || rem.Cmp(remainders[best]) > 0) {
+				best = i
+			}
+		}
+		parts[best].amount += sign
+		remainders[best] = big.NewInt(-1)
+	}
+	return parts
+}
+
+// Split divides m into n parts that differ by at most one minor unit, the
+// larger ones first.
+func (m Money) Split(n int) []Money {
+	if n <= 0 {
+		return nil
+	}
+	ratios := make([]int64, n)
+	for i := range ratios {
+		ratios[i] = 1
+	}
+	return m.Allocate(ratios...)
+}
+
+// MarshalJSON writes the amount as a JSON number in major units with the
+// currency's decimals, so API responses keep the shape they had as floats.
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// UnmarshalJSON reads a number or a numeric string in major units of
+// DefaultCurrency.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	s := strings.Trim(string(data), `"`)
+	if s == "null" || s == "" {
+		*m = Money{}
+		return nil
+	}
+	if strings.ContainsAny(s, "eE") {
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return fmt.Errorf("%w: %q", ErrInvalidAmount, s)
+		}
+		s = strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	parsed, err := Parse(s, DefaultCurrency)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+// Value stores the minor units; the currency lives in its own column or
+// is DefaultCurrency.
+func (m Money) Value() (driver.Value, error) {
+	return m.amount, nil
+}
+
+// Scan reads minor units as DefaultCurrency.
+func (m *Money) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*m = Zero(DefaultCurrency)
+	case int64:
+		*m = New(v, DefaultCurrency)
+	case float64:
+		*m = New(int64(math.Round(v)), DefaultCurrency)
+	case []byte:
+		return m.scanString(string(v))
+	case string:
+		return m.scanString(v)
+	default:
+		return fmt.Errorf("%w: cannot scan %T", ErrInvalidAmount, src)
+	}
+	return nil
+}
+
+func (m *Money) scanString(s string) error {
+	minor, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: %q", ErrInvalidAmount, s)
+	}
+	*m = New(minor, DefaultCurrency)
+	return nil
+}
+
+// GormDataType makes money columns bigint.
+func (Money) GormDataType() string {
+	return "bigint"
+}
+
+// sameCurrency returns the currency of m combined with o, adopting the
+// other one for the zero Money.
+func (m Money) sameCurrency(o Money) string {
+	switch {
+	case m.currency == o.currency:
+		return m.currency
+	case m.currency == "" && m.amount == 0:
+		return o.currency
+	case o.currency == "" && o.amount == 0:
+		return m.currency
+	}
+	panic(fmt.Errorf("money: currency mismatch %s and %s", m.currency, o.currency))
+}
+
+func decimalRat(f float64) *big.Rat {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		panic(ErrInvalidAmount)
+	}
+	r, _ := new(big.Rat).SetString(strconv.FormatFloat(f, 'f', -1, 64))
+	return r
+}
+
+func pow10(n int) *big.Rat {
+	return new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil))
+}
+
+// roundHalfEven rounds r to an integer, halves to the even neighbour.
+func roundHalfEven(r *big.Rat) (int64, error) {
+	q, rem := new(big.Int).QuoRem(r.Num(), r.Denom(), new(big.Int))
+	// QuoRem truncates towards zero, so rem has the sign of r.
+	twice := new(big.Int).Abs(rem)
+	twice.Lsh(twice, 1)
+	if c := twice.Cmp(r.Denom()); c > 0 || (c == 0 && q.Bit(0) == 1) {
+		if r.Sign() < 0 {
+			q.Sub(q, big.NewInt(1))
+		} else {
+			q.Add(q, big.NewInt(1))
+		}
+	}
+	if !q.IsInt64() {
+		return 0, ErrOverflow
+	}
+	return q.Int64(), nil
+}
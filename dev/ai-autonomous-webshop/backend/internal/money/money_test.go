@@ -0,0 +1,240 @@
+package money
+
+import (
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name     string
+		in       string
+		currency string
+		want     int64
+	}{
+		{"whole cents", "19.99", "USD", 1999},
+		{"no decimals", "5", "USD", 500},
+		{"tie rounds down to even", "2.345", "USD", 234},
+		{"tie rounds up to even", "2.355", "USD", 236},
+		{"above tie rounds up", "2.3451", "USD", 235},
+		{"negative tie rounds to even", "-2.345", "USD", -234},
+		{"negative tie away from zero to even", "-2.355", "USD", -236},
+		{"negative below tie", "-0.004", "USD", 0},
+		{"zero decimals", "1500", "JPY", 1500},
+		{"zero decimals tie to even", "2.5", "JPY", 2},
+		{"zero decimals tie up to even", "3.5", "JPY", 4},
+		{"zero decimals negative", "-1500", "JPY", -1500},
+		{"three decimals", "1.234", "KWD", 1234},
+		{"three decimals tie", "1.2345", "KWD", 1234},
+		{"three decimals negative tie", "-1.2355", "KWD", -1236},
+		{"surrounding space", " 7.10 ", "EUR", 710},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.in, tt.currency)
+			if err != nil {
+				t.Fatalf("Parse(%q, %s): %v", tt.in, tt.currency, err)
+			}
+			if got.Minor() != tt.want || got.Currency() != tt.currency {
+				t.Errorf("Parse(%q, %s) = %d %s, want %d %s", tt.in, tt.currency, got.Minor(), got.Currency(), tt.want, tt.currency)
+			}
+		})
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, in := range []string{"", "abc", "1/2", "1e3", "12.3.4"} {
+		if _, err := Parse(in, "USD"); !errors.Is(err, ErrInvalidAmount) {
+			t.Errorf("Parse(%q) error = %v, want ErrInvalidAmount", in, err)
+		}
+	}
+	if _, err := Parse("99999999999999999999", "USD"); !errors.Is(err, ErrOverflow) {
+		t.Errorf("Parse of a huge amount error = %v, want ErrOverflow", err)
+	}
+}
+
+func TestFromFloat(t *testing.T) {
+	tests := []struct {
+		name     string
+		in       float64
+		currency string
+		want     int64
+	}{
+		{"shortest decimal tie", 2.675, "USD", 268},
+		{"tie to even", 0.125, "USD", 12},
+		{"negative", -19.99, "USD", -1999},
+		{"zero decimals", 1999.5, "JPY", 2000},
+		{"three decimals", 0.0005, "BHD", 0},
+		{"three decimals tie up", 0.0015, "BHD", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := FromFloat(tt.in, tt.currency).Minor(); got != tt.want {
+				t.Errorf("FromFloat(%v, %s) = %d, want %d", tt.in, tt.currency, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestString(t *testing.T) {
+	tests := []struct {
+		minor    int64
+		currency string
+		want     string
+	}{
+		{1999, "USD", "19.99"},
+		{-50, "USD", "-0.50"},
+		{5, "USD", "0.05"},
+		{0, "USD", "0.00"},
+		{1500, "JPY", "1500"},
+		{-1500, "JPY", "-1500"},
+		{1234, "KWD", "1.234"},
+		{-7, "KWD", "-0.007"},
+		{0, "", "0.00"},
+	}
+	for _, tt := range tests {
+		if got := New(tt.minor, tt.currency).String(); got != tt.want {
+			t.Errorf("New(%d, %q).String() = %q, want %q", tt.minor, tt.currency, got, tt.want)
+		}
+	}
+}
+
+func TestArithmetic(t *testing.T) {
+	tests := []struct {
+		name string
+		got  Money
+		want Money
+	}{
+		{"add", New(1999, "USD").Add(New(1, "USD")), New(2000, "USD")},
+		{"add negative", New(100, "EUR").Add(New(-250, "EUR")), New(-150, "EUR")},
+		{"sub below zero", New(100, "USD").Sub(New(250, "USD")), New(-150, "USD")},
+		{"zero adopts currency", Money{}.Add(New(5, "JPY")), New(5, "JPY")},
+		{"sum", Sum(New(1, "KWD"), New(2, "KWD"), New(-4, "KWD")), New(-1, "KWD")},
+		{"times", New(-333, "USD").Times(3), New(-999, "USD")},
+		{"mul tie to even", New(25, "USD").Mul(0.5), New(12, "USD")},
+		{"mul negative tie to even", New(-35, "USD").Mul(0.5), New(-18, "USD")},
+		{"percent", New(1999, "USD").Percent(19), New(380, "USD")},
+		{"percent tie", New(250, "JPY").Percent(1), New(2, "JPY")},
+		{"mulrat tie", New(1, "USD").MulRat(big.NewRat(1, 2)), New(0, "USD")},
+		{"mulrat three decimals", New(1001, "BHD").MulRat(big.NewRat(1, 2)), New(500, "BHD")},
+		{"min", Min(New(-5, "USD"), New(3, "USD")), New(-5, "USD")},
+		{"max", Max(New(-5, "USD"), New(3, "USD")), New(3, "USD")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.got.Minor() != tt.want.Minor() || tt.got.Currency() != tt.want.Currency() {
+				t.Errorf("got %d %s, want %d %s", tt.got.Minor(), tt.got.Currency(), tt.want.Minor(), tt.want.Currency())
+			}
+		})
+	}
+}
+
+func TestConvert(t *testing.T) {
+	tests := []struct {
+		name string
+		from Money
+		to   string
+		rate float64
+		want int64
+	}{
+		{"same currency", New(1999, "USD"), "USD", 1, 1999},
+		{"to zero decimals tie", New(1999, "USD"), "JPY", 150, 2998},
+		{"to zero decimals tie to zero", New(1, "USD"), "JPY", 50, 0},
+		{"from zero decimals", New(1500, "JPY"), "USD", 0.0067, 1005},
+		{"to three decimals", New(1999, "USD"), "KWD", 0.3075, 6147},
+		{"from three decimals", New(1234, "KWD"), "USD", 3.25, 401},
+		{"negative", New(-1999, "USD"), "EUR", 0.92, -1839},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.from.Convert(tt.to, tt.rate)
+			if got.Minor() != tt.want || got.Currency() != tt.to {
+				t.Errorf("Convert = %d %s, want %d %s", got.Minor(), got.Currency(), tt.want, tt.to)
+			}
+		})
+	}
+}
+
+func TestAllocate(t *testing.T) {
+	tests := []struct {
+		name   string
+		amount Money
+		ratios []int64
+		want   []int64
+	}{
+		{"even", New(100, "USD"), []int64{1, 1}, []int64{50, 50}},
+		{"remainder to largest", New(100, "USD"), []int64{1, 1, 1}, []int64{34, 33, 33}},
+		{"proportional", New(1000, "USD"), []int64{1, 2, 7}, []int64{100, 200, 700}},
+		{"negative", New(-100, "USD"), []int64{1, 1, 1}, []int64{-34, -33, -33}},
+		{"all zero ratios", New(5, "JPY"), []int64{0, 0}, []int64{3, 2}},
+		{"three decimals", New(1001, "KWD"), []int64{1, 1}, []int64{501, 500}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parts := tt.amount.Allocate(tt.ratios...)
+			if len(parts) != len(tt.want) {
+				t.Fatalf("got %d parts, want %d", len(parts), len(tt.want))
+			}
+			total := Zero(tt.amount.Currency())
+			for i, p := range parts {
+				if p.Minor() != tt.want[i] {
+					t.Errorf("part %d = %d, want %d", i, p.Minor(), tt.want[i])
+				}
+				total = total.Add(p)
+			}
+			if !total.Equal(tt.amount) {
+				t.Errorf("parts add up to %s, want %s", total, tt.amount)
+			}
+		})
+	}
+}
+
+func TestCurrencyMismatch(t *testing.T) {
+	usd, eur := New(100, "USD"), New(100, "EUR")
+	tests := []struct {
+		name string
+		op   func()
+	}{
+		{"add", func() { usd.Add(eur) }},
+		{"sub", func() { usd.Sub(eur) }},
+		{"cmp", func() { usd.Cmp(eur) }},
+		{"ratio", func() { usd.Ratio(eur) }},
+		{"sum", func() { Sum(usd, eur) }},
+		{"min", func() { Min(usd, eur) }},
+		{"non-zero without currency", func() { New(1, "").Add(eur) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				r := recover()
+				err, ok := r.(error)
+				if !ok || !strings.Contains(err.Error(), "currency mismatch") {
+					t.Errorf("recovered %v, want a currency mismatch panic", r)
+				}
+			}()
+			tt.op()
+		})
+	}
+}
+
+func TestOverflow(t *testing.T) {
+	tests := []struct {
+		name string
+		op   func()
+	}{
+		{"add", func() { New(1<<62, "USD").Add(New(1<<62, "USD")) }},
+		{"times", func() { New(1<<62, "USD").Times(4) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if r := recover(); r != ErrOverflow {
+					t.Errorf("recovered %v, want ErrOverflow", r)
+				}
+			}()
+			tt.op()
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package services
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/db"
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+var ErrAddressNotFound = errors.New("address not found")
+
+// AddressInput is what a customer sends to add or replace an address.
+type AddressInput struct {
+	Label      string `json:"label" binding:"max=64"`
+	Name       string `json:"name" binding:"required"`
+	Company    string `json:"company"`
+	Line1      string `json:"line1" binding:"required"`
+	Line2      string `json:"line2"`
+	City       string `json:"city" binding:"required"`
+	Region     string `json:"region" binding:"max=10"`
+	PostalCode string `json:"postal_code" binding:"max=20"`
+	Country    string `json:"country" binding:"required,len=2"`
+	Phone      string `json:"phone" binding:"max=32"`
+	IsDefault  bool   `json:"is_default"`
+}
+
+// AddressService manages customers' address books. Every address book with
+// entries has exactly one default address.
+type AddressService struct{}
+
+func NewAddressService() *AddressService {
+	return &AddressService{}
+}
+
+// List returns the user's addresses, the default one first.
+func (s *AddressService) List(userID uint) ([]models.Address, error) {
+	var addresses []models.Address
+	if err := db.DB.Where("user_id = ?", userID).Order("is_default DESC, id").Find(&addresses).Error; err != nil {
+		return nil, err
+	}
+	return addresses, nil
+}
+
+func (s *AddressService) Get(userID, id uint) (*models.Address, error) {
+	return findAddress(db.DB, userID, id)
+}
+
+// Create adds an address. The user's first address becomes the default.
+func (s *AddressService) Create(userID uint, input AddressInput) (*models.Address, error) {
+	address := &models.Address{UserID: userID}
+	applyAddressInput(address, input)
+
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&models.Address{}).Where("user_id = ?", userID).Count(&count).Error; err != nil {
+			return err
+		}
+		address.IsDefault = input.IsDefault || count == 0
+		if address.IsDefault {
+			if err := clearDefaultAddress(tx, userID); err != nil {
+				return err
+			}
+		}
+		return tx.Create(address).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return address, nil
+}
+
+// Update replaces an address. Clearing is_default on the default address
+// is ignored; another address has to be made the default instead.
+func (s *AddressService) Update(userID, id uint, input AddressInput) (*models.Address, error) {
+	var address *models.Address
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if address, err = findAddress(tx, userID, id); err != nil {
+			return err
+		}
+		applyAddressInput(address, input)
+		if input.IsDefault && !address.IsDefault {
+			if err := clearDefaultAddress(tx, userID); err != nil {
+				return err
+			}
+			address.IsDefault = true
+		}
+		return tx.Save(address).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return address, nil
+}
+
+// SetDefault makes an address the user's default.
+func (s *AddressService) SetDefault(userID, id uint) (*models.Address, error) {
+	var address *models.Address
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if address, err = findAddress(tx, userID, id); err != nil {
+			return err
+		}
+		if err := clearDefaultAddress(tx, userID); err != nil {
+			return err
+		}
+		address.IsDefault = true
+		return tx.Model(address).Update("is_default", true).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return address, nil
+}
+
+// Delete removes an address. If it was the default, the most recently added
+// remaining address takes over.
+func (s *AddressService) Delete(userID, id uint) error {
+	return db.DB.Transaction(func(tx *gorm.DB) error {
+		address, err := findAddress(tx, userID, id)
+		if err != nil {
+			return err
+		}
+		if err := tx.Delete(address).Error; err != nil {
+			return err
+		}
+		if !address.IsDefault {
+			return nil
+		}
+		var next models.Address
+		err = tx.Where("user_id = ?", userID).Order("id DESC").First(&next).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return tx.Model(&next).Update("is_default", true).Error
+	})
+}
+
+func findAddress(tx *gorm.DB, userID, id uint) (*models.Address, error) {
+	var address models.Address
+	if err := tx.Where("id = ? AND user_id = ?", id, userID).First(&address).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrAddressNotFound
+		}
+		return nil, err
+	}
+	return &address, nil
+}
+
+func clearDefaultAddress(tx *gorm.DB, userID uint) error {
+	return tx.Model(&models.Address{}).Where("user_id = ? AND is_default = ?", userID, true).Update("is_default", false).Error
+}
+
+func applyAddressInput(a *models.Address, in AddressInput) {
+	a.Label = strings.TrimSpace(in.Label)
+	a.PostalAddress = models.PostalAddress{
+		Name:       strings.TrimSpace(in.Name),
+		Company:    strings.TrimSpace(in.Company),
+		Line1:      strings.TrimSpace(in.Line1),
+		Line2:      strings.TrimSpace(in.Line2),
+		City:       strings.TrimSpace(in.City),
+		Region:     strings.ToUpper(strings.TrimSpace(in.Region)),
+		PostalCode: strings.ToUpper(strings.TrimSpace(in.PostalCode)),
+		Country:    strings.ToUpper(in.Country),
+		Phone:      strings.TrimSpace(in.Phone),
+	}
+}
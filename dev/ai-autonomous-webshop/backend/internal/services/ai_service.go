@@ -37,14 +37,29 @@ type AIService struct {
 	mu     sync.RWMutex
 }
 
+// GenerationRequest is what to write about. Prompt, when set, is sent as
+// is instead of the product description prompt.
 type GenerationRequest struct {
 	ProductName string
 	Category    string
 	Features    []string
 	Tone        string
 	MaxLength   int
+	Prompt      string
 }
 
+// ProductMeta is the title and description search engines show for a
+// product page.
+type ProductMeta struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+}
+
+const (
+	metaTitleMaxLen       = 70
+	metaDescriptionMaxLen = 160
+)
+
 type StreamCallback func(chunk string, done bool) error
 
 func NewAIService() *AIService {
@@ -110,6 +125,59 @@ func (s *AIService) GenerateProductDescriptionStream(productName, category strin
 	return result, nil
 }
 
+// GenerateProductMeta writes a product's meta title and description with
+// the configured provider. When the provider fails, hasn't answered by the
+// time ctx is done or gives an answer that can't be used, they are made
+// from the product's name, category and description instead, so there is
+// always something to show.
+func (s *AIService) GenerateProductMeta(ctx context.Context, productName, category, description string) ProductMeta {
+	req := GenerationRequest{
+		ProductName: productName,
+		Category:    category,
+		Prompt:      buildMetaPrompt(productName, category, description),
+	}
+
+	type answer struct {
+		text string
+		err  error
+	}
+	answers := make(chan answer, 1)
+	switch s.config.LLMProvider {
+	case ProviderGemini:
+		go func() {
+			text, err := s.generateWithGemini(req, nil)
+			answers <- answer{text, err}
+		}()
+	case ProviderOllama:
+		go func() {
+			text, err := s.generateWithOllama(req, nil)
+			answers <- answer{text, err}
+		}()
+	default:
+		return templateProductMeta(productName, category, description)
+	}
+
+	var text string
+	var err error
+	select {
+	case a := <-answers:
+		text, err = a.text, a.err
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if err != nil {
+		log.Printf("Meta generation with %s failed: %v, using template", s.config.LLMProvider, err)
+		return templateProductMeta(productName, category, description)
+	}
+
+	meta, ok := parseProductMeta(text)
+	if !ok {
+		log.Printf("Meta generation with %s gave no usable answer, using template", s.config.LLMProvider)
+		return templateProductMeta(productName, category, description)
+	}
+	return meta
+}
+
 func (s *AIService) tryFallback(req GenerationRequest, callback StreamCallback) (string, error) {
 	providers := []LLMProvider{ProviderGemini, ProviderOllama, ProviderTemplate}
 	currentIdx := 0
@@ -157,7 +225,10 @@ func (s *AIService) generateWithGemini(req GenerationRequest, callback StreamCal
 		return "", fmt.Errorf("GEMINI_API_KEY not configured")
 	}
 
-	prompt := buildEcommercePrompt(req)
+	prompt := req.Prompt
+	if prompt == "" {
+		prompt = buildEcommercePrompt(req)
+	}
 
 	geminiReq := map[string]interface{}{
 		"contents": []map[string]interface{}{
@@ -271,7 +342,10 @@ func extractGeminiText(resp map[string]interface{}) string {
 }
 
 func (s *AIService) generateWithOllama(req GenerationRequest, callback StreamCallback) (string, error) {
-	prompt := buildEcommercePrompt(req)
+	prompt := req.Prompt
+	if prompt == "" {
+		prompt = buildEcommercePrompt(req)
+	}
 
 	ollamaReq := map[string]interface{}{
 		"model":  s.config.OllamaModel,
@@ -502,3 +576,67 @@ func (s *AIService) HealthCheck(ctx context.Context) error {
 		return nil
 	}
 }
+
+func buildMetaPrompt(productName, category, description string) string {
+	return fmt.Sprintf(`You are an SEO specialist for a premium online store.
+Write the meta title and meta description of this product page:
+
+Product: %s
+Category: %s
+Description: %s
+
+Requirements:
+- Title: at most 60 characters, starting with the product name
+- Description: at most 155 characters, focused on benefits, ending with a call-to-action
+- No quotes, emojis or keyword stuffing
+
+Output ONLY a JSON object like {"title": "...", "description": "..."}, no explanations.`,
+		productName,
+		category,
+		truncateText(description, 1000))
+}
+
+// parseProductMeta reads the JSON object a provider answered with, which
+// may come wrapped in a code fence.
+func parseProductMeta(text string) (ProductMeta, bool) {
+	start, end := strings.Index(text, "{"), strings.LastIndex(text, "}")
+	if start < 0 || end < start {
+		return ProductMeta{}, false
+	}
+	var meta ProductMeta
+	if err := json.Unmarshal([]byte(text[start:end+1]), &meta); err != nil {
+		return ProductMeta{}, false
+	}
+	meta.Title = truncateText(meta.Title, metaTitleMaxLen)
+	meta.Description = truncateText(meta.Description, metaDescriptionMaxLen)
+	return meta, meta.Title != "" && meta.Description != ""
+}
+
+func templateProductMeta(productName, category, description string) ProductMeta {
+	title := productName
+	if category != "" && len([]rune(productName+" | "+category)) <= metaTitleMaxLen {
+		title = productName + " | " + category
+	}
+	if description == "" {
+		description = fmt.Sprintf("Discover the %s. Premium quality, fast shipping and easy returns. Order yours today.", productName)
+	}
+	return ProductMeta{
+		Title:       truncateText(title, metaTitleMaxLen),
+		Description: truncateText(description, metaDescriptionMaxLen),
+	}
+}
+
+// truncateText collapses whitespace and cuts text to at most max runes at
+// a word boundary, marking the cut with an ellipsis.
+func truncateText(text string, max int) string {
+	text = strings.Join(strings.Fields(text), " ")
+	runes := []rune(text)
+	if len(runes) <= max {
+		return text
+	}
+	cut := string(runes[:max-1])
+	if i := strings.LastIndex(cut, " "); i > 0 {
+		cut = cut[:i]
+	}
+	return strings.TrimRight(cut, " ,;:-") + "…"
+}
@@ -1,14 +1,41 @@
 package services
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
 
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/config"
 	"github.com/jeremy/ai-autonomous-webshop/backend/internal/db"
 	"github.com/jeremy/ai-autonomous-webshop/backend/internal/models"
 	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
-type AuthService struct{}
+var (
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	ErrEmailNotVerified   = errors.New("email address not verified")
+	ErrInvalidUserToken   = errors.New("invalid or expired token")
+)
+
+type AuthService struct {
+	mailer Mailer
+	guard  *LoginGuard
+	audit  *SecurityAuditService
+}
+
+func NewAuthService() *AuthService {
+	return &AuthService{
+		mailer: NewMailer(),
+		guard:  NewLoginGuard(),
+		audit:  &SecurityAuditService{},
+	}
+}
 
 func (s *AuthService) Register(email, password string) (*models.User, error) {
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
@@ -25,18 +52,260 @@ func (s *AuthService) Register(email, password string) (*models.User, error) {
 		return nil, err
 	}
 
+	if err := s.SendVerificationEmail(context.Background(), user); err != nil {
+		log.Printf("Failed to send verification email to user %d: %v", user.ID, err)
+	}
+
 	return user, nil
 }
 
-func (s *AuthService) Login(email, password string) (*models.User, error) {
+// Login returns an *AccountLockedError while the account is backing off
+// from earlier failures; the password is not even checked in that case.
+func (s *AuthService) Login(ctx context.Context, email, password string, meta SessionMeta) (*models.User, error) {
+	attempt, err := s.guard.Reserve(ctx, email)
+	if err != nil {
+		var locked *AccountLockedError
+		if errors.As(err, &locked) {
+			s.audit.Record(&models.SecurityEvent{
+				Type:      models.SecurityEventLoginBlocked,
+				Email:     email,
+				IP:        meta.IP,
+				UserAgent: meta.UserAgent,
+				Detail:    locked.Error(),
+			})
+		}
+		return nil, err
+	}
+
 	var user models.User
-	if err := db.DB.Where("email = ?", email).First(&user).Error; err != nil {
-		return nil, errors.New("invalid credentials")
+	if err := db.DB.Preload("Roles").Where("email = ?", email).First(&user).Error; err != nil {
+		s.recordFailedLogin(attempt, email, nil, meta)
+		return nil, ErrInvalidCredentials
 	}
 
 	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
-		return nil, errors.New("invalid credentials")
+		s.recordFailedLogin(attempt, email, &user.ID, meta)
+		return nil, ErrInvalidCredentials
+	}
+
+	if err := s.guard.Reset(ctx, email); err != nil {
+		log.Printf("Failed to reset login attempts for user %d: %v", user.ID, err)
+	}
+
+	if !user.IsEmailVerified() && config.LoadConfig().RequireEmailVerification {
+		return nil, ErrEmailNotVerified
+	}
+
+	return &user, nil
+}
+
+// recordFailedLogin audits a failed attempt. Reserve counted it already.
+func (s *AuthService) recordFailedLogin(attempt LoginAttempt, email string, userID *uint, meta SessionMeta) {
+	failures, lockedOut := attempt.Failures, attempt.LockedOut
+
+	s.audit.Record(&models.SecurityEvent{
+		Type:      models.SecurityEventLoginFailed,
+		UserID:    userID,
+		Email:     email,
+		IP:        meta.IP,
+		UserAgent: meta.UserAgent,
+		Detail:    fmt.Sprintf("failed attempt %d", failures),
+	})
+
+	if !lockedOut {
+		return
+	}
+
+	detail := fmt.Sprintf("locked after %d failed attempts", failures)
+	s.audit.Record(&models.SecurityEvent{
+		Type:      models.SecurityEventAccountLocked,
+		UserID:    userID,
+		Email:     email,
+		IP:        meta.IP,
+		UserAgent: meta.UserAgent,
+		Detail:    detail,
+	})
+
+	if Notifier != nil {
+		go Notifier.NotifyAdmins("ACCOUNT_LOCKED", "Account locked after repeated failed logins: "+email, map[string]any{
+			"email":    email,
+			"user_id":  userID,
+			"ip":       meta.IP,
+			"failures": failures,
+		})
+	}
+}
+
+func (s *AuthService) SendVerificationEmail(ctx context.Context, user *models.User) error {
+	cfg := config.LoadConfig()
+
+	token, err := s.issueUserToken(user.ID, models.TokenPurposeEmailVerification, time.Duration(cfg.EmailVerifyTTLHours)*time.Hour)
+	if err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/verify-email?token=%s", strings.TrimRight(cfg.AppBaseURL, "/"), url.QueryEscape(token))
+	return s.mailer.Send(ctx, Mail{
+		To:      user.Email,
+		Subject: "Confirm your email address",
+		Body: fmt.Sprintf("Welcome to NEXUS!\n\nPlease confirm your email address by opening the link below:\n\n%s\n\n"+
+			"The link expires in %d hours. If you did not create an account, you can ignore this email.\n",
+			link, cfg.EmailVerifyTTLHours),
+	})
+}
+
+// ResendVerificationEmail is silent about unknown or already verified
+// addresses so that it can't be used to probe for accounts.
+func (s *AuthService) ResendVerificationEmail(ctx context.Context, email string) error {
+	var user models.User
+	if err := db.DB.Where("email = ?", email).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
 	}
+	if user.IsEmailVerified() {
+		return nil
+	}
+	return s.SendVerificationEmail(ctx, &user)
+}
 
+func (s *AuthService) VerifyEmail(token string) (*models.User, error) {
+	var user models.User
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		userID, err := consumeUserToken(tx, token, models.TokenPurposeEmailVerification)
+		if err != nil {
+			return err
+		}
+		if err := tx.First(&user, userID).Error; err != nil {
+			return ErrInvalidUserToken
+		}
+		if user.EmailVerifiedAt != nil {
+			return nil
+		}
+		now := time.Now()
+		user.EmailVerifiedAt = &now
+		if err := tx.Model(&user).Update("email_verified_at", now).Error; err != nil {
+			return err
+		}
+
+		// The first admin has to come from somewhere: the configured
+		// bootstrap address is promoted once its owner has proven they
+		// read its mail, not when someone registers it.
+		bootstrap := config.LoadConfig().BootstrapAdminEmail
+		if bootstrap == "" || !strings.EqualFold(user.Email, bootstrap) {
+			return nil
+		}
+		return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.UserRole{
+			UserID: user.ID,
+			Role:   models.RoleAdmin,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
 	return &user, nil
 }
+
+// RequestPasswordReset mails a reset link if the address belongs to an
+// account and returns nil either way.
+func (s *AuthService) RequestPasswordReset(ctx context.Context, email string) error {
+	var user models.User
+	if err := db.DB.Where("email = ?", email).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	cfg := config.LoadConfig()
+	token, err := s.issueUserToken(user.ID, models.TokenPurposePasswordReset, time.Duration(cfg.PasswordResetTTLMinutes)*time.Minute)
+	if err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/reset-password?token=%s", strings.TrimRight(cfg.AppBaseURL, "/"), url.QueryEscape(token))
+	return s.mailer.Send(ctx, Mail{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Someone asked to reset the password for your NEXUS account.\n\nOpen the link below to choose a new password:\n\n%s\n\n"+
+			"The link expires in %d minutes and can only be used once. If this wasn't you, you can ignore this email.\n",
+			link, cfg.PasswordResetTTLMinutes),
+	})
+}
+
+// ResetPassword sets a new password and ends every existing session, since
+// whoever held the old password may still be logged in.
+func (s *AuthService) ResetPassword(ctx context.Context, token, newPassword string) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	var userID uint
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		id, err := consumeUserToken(tx, token, models.TokenPurposePasswordReset)
+		if err != nil {
+			return err
+		}
+		userID = id
+
+		// Receiving the reset link proves ownership of the address too.
+		return tx.Model(&models.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+			"password":          string(hashedPassword),
+			"email_verified_at": gorm.Expr("COALESCE(email_verified_at, ?)", time.Now()),
+		}).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	return NewTokenService().LogoutAll(ctx, userID)
+}
+
+// issueUserToken invalidates any outstanding token for the same purpose so
+// only the most recent email link works.
+func (s *AuthService) issueUserToken(userID uint, purpose string, ttl time.Duration) (string, error) {
+	token, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.UserToken{}).
+			Where("user_id = ? AND purpose = ? AND used_at IS NULL", userID, purpose).
+			Update("used_at", now).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.UserToken{
+			UserID:    userID,
+			Purpose:   purpose,
+			TokenHash: hashToken(token),
+			ExpiresAt: now.Add(ttl),
+		}).Error
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+func consumeUserToken(tx *gorm.DB, token, purpose string) (uint, error) {
+	var ut models.UserToken
+	if err := tx.Where("token_hash = ? AND purpose = ?", hashToken(token), purpose).First(&ut).Error; err != nil {
+		return 0, ErrInvalidUserToken
+	}
+
+	// Conditional update so two concurrent requests can't both use the token.
+	result := tx.Model(&models.UserToken{}).
+		Where("id = ? AND used_at IS NULL AND expires_at > ?", ut.ID, time.Now()).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	if result.RowsAffected != 1 {
+		return 0, ErrInvalidUserToken
+	}
+	return ut.UserID, nil
+}
@@ -1,37 +1,633 @@
 package services
 
 import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/config"
 	"github.com/jeremy/ai-autonomous-webshop/backend/internal/db"
 	"github.com/jeremy/ai-autonomous-webshop/backend/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrCartItemNotFound = errors.New("variant is not in the cart")
+	ErrTooManyCoupons   = errors.New("too many coupons in the cart")
 )
 
-type CartService struct{}
+// maxCartCoupons bounds how many codes one cart can hold.
+const maxCartCoupons = 5
+
+// Strategies for a variant that is in both the guest cart and the user's
+// cart when they are merged at login.
+const (
+	CartMergeSum       = "sum"
+	CartMergeMax       = "max"
+	CartMergeKeepUser  = "keep_user"
+	CartMergeKeepGuest = "keep_guest"
+)
+
+// CartOwner identifies a cart: a signed-in user's persistent cart, or the
+// cart of a guest identified by the ID in their signed cookie.
+type CartOwner struct {
+	UserID  uint
+	GuestID string
+}
+
+func UserCart(userID uint) CartOwner     { return CartOwner{UserID: userID} }
+func GuestCart(guestID string) CartOwner { return CartOwner{GuestID: guestID} }
+
+func (o CartOwner) IsGuest() bool { return o.UserID == 0 }
+
+// CartService manages both kinds of cart. User carts are CartItem rows;
+// guest carts live in Redis for GuestCartTTLHours after the last change and
+// are merged into the user's cart when the guest logs in or registers.
+type CartService struct {
+	guestTTL      time.Duration
+	secret        []byte
+	mergeStrategy string
+	maxPerProduct int
+	promotions    *PromotionService
+	taxes         *TaxService
+	shipping      *ShippingService
+}
+
+func NewCartService() *CartService {
+	cfg := config.LoadConfig()
+	secret := cfg.GuestCartSecret
+	if secret == "" {
+		secret = cfg.JWTSecret
+	}
+	key := sha256.Sum256([]byte("guest-cart:" + secret))
+
+	strategy := cfg.CartMergeStrategy
+	switch strategy {
+	case CartMergeSum, CartMergeMax, CartMergeKeepUser, CartMergeKeepGuest:
+	default:
+		log.Printf("Unknown CART_MERGE_STRATEGY %q, using %q", strategy, CartMergeSum)
+		strategy = CartMergeSum
+	}
+
+	return &CartService{
+		guestTTL:      time.Duration(cfg.GuestCartTTLHours) * time.Hour,
+		secret:        key[:],
+		mergeStrategy: strategy,
+		maxPerProduct: cfg.CartMaxQuantity,
+		promotions:    NewPromotionService(),
+		taxes:         NewTaxService(),
+		shipping:      NewShippingService(),
+	}
+}
+
+func (s *CartService) guestStore() guestCartStore {
+	return currentGuestCartStore(s.guestTTL)
+}
+
+func (s *CartService) GuestTTL() time.Duration {
+	return s.guestTTL
+}
+
+// NewGuestCart starts a guest cart. It returns the cart ID and the signed
+// value to put in the visitor's cookie.
+func (s *CartService) NewGuestCart() (string, string, error) {
+	b := make([]byte, 18)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	id := base64.RawURLEncoding.EncodeToString(b)
+	return id, id + "." + s.signGuestID(id), nil
+}
+
+// ParseGuestCookie checks the signature of a guest cart cookie and returns
+// the cart ID in it.
+func (s *CartService) ParseGuestCookie(value string) (string, bool) {
+	id, sig, ok := strings.Cut(value, ".")
+	if !ok || id == "" {
+		return "", false
+	}
+	if !hmac.Equal([]byte(sig), []byte(s.signGuestID(id))) {
+		return "", false
+	}
+	return id, true
+}
+
+func (s *CartService) signGuestID(id string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(id))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// CartQuantityLimitError is returned when a product's variants together
+// would go over the most of it one order may hold.
+type CartQuantityLimitError struct {
+	ProductID uint
+	VariantID uint
+	Max       int
+}
+
+func (e *CartQuantityLimitError) Error() string {
+	return fmt.Sprintf("at most %d of product %d fit in one order", e.Max, e.ProductID)
+}
+
+// maxQuantity is the product's own limit per order, or CART_MAX_QUANTITY
+// when it has none. It applies to all of the product's variants together.
+func (s *CartService) maxQuantity(product *models.Product) int {
+	return productMaxQuantity(product, s.maxPerProduct)
+}
+
+func productMaxQuantity(product *models.Product, fallback int) int {
+	if product.MaxQuantity > 0 {
+		return product.MaxQuantity
+	}
+	return fallback
+}
+
+// checkOrderQuantities checks that no product is in lines more often, over
+// all its variants, than one order may hold. Checkout runs it again, as the
+// limit may have been lowered since the cart was filled.
+func checkOrderQuantities(tx *gorm.DB, lines []models.OrderItem, fallback int) error {
+	quantities := make(map[uint]int)
+	variants := make(map[uint]uint)
+	for _, line := range lines {
+		quantities[line.ProductID] += line.Quantity
+		variants[line.ProductID] = line.VariantID
+	}
+	productIDs := make([]uint, 0, len(quantities))
+	for id := range quantities {
+		productIDs = append(productIDs, id)
+	}
+	var products []models.Product
+	if err := tx.Unscoped().Select("id", "max_quantity").Where("id IN ?", productIDs).Order("id").Find(&products).Error; err != nil {
+		return err
+	}
+	for i := range products {
+		if max := productMaxQuantity(&products[i], fallback); quantities[products[i].ID] > max {
+			return &CartQuantityLimitError{ProductID: products[i].ID, VariantID: variants[products[i].ID], Max: max}
+		}
+	}
+	return nil
+}
+
+// otherVariantsQuantity is how many units of the product's other variants
+// than variantID the cart holds.
+func (s *CartService) otherVariantsQuantity(ctx context.Context, owner CartOwner, productID, variantID uint) (int, error) {
+	if !owner.IsGuest() {
+		var total int
+		err := db.DB.WithContext(ctx).Model(&models.CartItem{}).Select("COALESCE(SUM(quantity), 0)").
+			Where("user_id = ? AND product_id = ? AND variant_id <> ?", owner.UserID, productID, variantID).Scan(&total).Error
+		return total, err
+	}
+
+	items, err := s.guestStore().Items(ctx, owner.GuestID)
+	if err != nil {
+		return 0, err
+	}
+	ids := make([]uint, 0, len(items))
+	for id := range items {
+		if id != variantID {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	if err := db.DB.WithContext(ctx).Model(&models.ProductVariant{}).
+		Where("id IN ? AND product_id = ?", ids, productID).Pluck("id", &ids).Error; err != nil {
+		return 0, err
+	}
+	total := 0
+	for _, id := range ids {
+		total += items[id].Quantity
+	}
+	return total, nil
+}
+
+// checkLine loads the variant for a line of quantity units with its product
+// and checks it can still be bought in that amount, next to what the cart
+// holds of the product's other variants.
+func (s *CartService) checkLine(ctx context.Context, owner CartOwner, variantID uint, quantity int) (*models.ProductVariant, *models.Product, error) {
+	var variant models.ProductVariant
+	if err := db.DB.WithContext(ctx).Preload("Options", orderedOptionValues).First(&variant, variantID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, ErrProductUnavailable
+		}
+		return nil, nil, err
+	}
+	var product models.Product
+	if err := db.DB.WithContext(ctx).First(&product, variant.ProductID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, ErrProductUnavailable
+		}
+		return nil, nil, err
+	}
+	others, err := s.otherVariantsQuantity(ctx, owner, product.ID, variant.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if max := s.maxQuantity(&product); others+quantity > max {
+		return nil, nil, &CartQuantityLimitError{ProductID: product.ID, VariantID: variant.ID, Max: max}
+	}
+	if quantity > variant.Stock {
+		return nil, nil, &InsufficientStockError{Items: []StockShortage{stockShortage(&product, &variant, quantity, variant.Stock)}}
+	}
+	return &variant, &product, nil
+}
+
+// lines returns the cart's lines with their variants and products. Ones
+// deleted since they were added are included so the summary can flag them.
+func (s *CartService) lines(ctx context.Context, owner CartOwner) ([]models.CartItem, error) {
+	unscoped := func(tx *gorm.DB) *gorm.DB { return tx.Unscoped() }
+	if !owner.IsGuest() {
+		var items []models.CartItem
+		if err := db.DB.WithContext(ctx).Preload("Product", unscoped).Preload("Variant", unscoped).
+			Preload("Variant.Options", orderedOptionValues).
+			Where("user_id = ?", owner.UserID).Order("id").Find(&items).Error; err != nil {
+			return nil, err
+		}
+		return items, nil
+	}
+
+	if owner.GuestID == "" {
+		return []models.CartItem{}, nil
+	}
+	guest, err := s.guestStore().Items(ctx, owner.GuestID)
+	if err != nil {
+		return nil, err
+	}
+	if len(guest) == 0 {
+		return []models.CartItem{}, nil
+	}
 
-func (s *CartService) GetCart(userID uint) ([]models.CartItem, error) {
-	var items []models.CartItem
-	if err := db.DB.Preload("Product").Where("user_id = ?", userID).Find(&items).Error; err != nil {
+	variantIDs := make([]uint, 0, len(guest))
+	for id := range guest {
+		variantIDs = append(variantIDs, id)
+	}
+	var variants []models.ProductVariant
+	if err := db.DB.WithContext(ctx).Unscoped().Preload("Options", orderedOptionValues).
+		Where("id IN ?", variantIDs).Order("id").Find(&variants).Error; err != nil {
 		return nil, err
 	}
+	productIDs := make([]uint, 0, len(variants))
+	for _, v := range variants {
+		productIDs = append(productIDs, v.ProductID)
+	}
+	var products []models.Product
+	if err := db.DB.WithContext(ctx).Unscoped().Where("id IN ?", productIDs).Find(&products).Error; err != nil {
+		return nil, err
+	}
+	byID := make(map[uint]models.Product, len(products))
+	for _, p := range products {
+		byID[p.ID] = p
+	}
+
+	// Guest lines have no row, so they carry no ID or UserID.
+	items := make([]models.CartItem, 0, len(variants))
+	for _, v := range variants {
+		product, ok := byID[v.ProductID]
+		if !ok {
+			continue
+		}
+		items = append(items, models.CartItem{
+			ProductID:  v.ProductID,
+			VariantID:  v.ID,
+			Quantity:   guest[v.ID].Quantity,
+			PriceAtAdd: guest[v.ID].Price,
+			Product:    product,
+			Variant:    v,
+		})
+	}
 	return items, nil
 }
 
-func (s *CartService) AddToCart(userID, productID uint, quantity int) error {
+// AddToCart adds quantity units of a variant, up to its stock and the
+// product's maximum per order. The variant can be left out for a product
+// that has only one.
+func (s *CartService) AddToCart(ctx context.Context, owner CartOwner, productID, variantID uint, quantity int) error {
+	if quantity < 1 {
+		return ErrInvalidQuantity
+	}
+	variantID, err := resolveVariant(db.DB.WithContext(ctx), productID, variantID)
+	if err != nil {
+		return err
+	}
+	if owner.IsGuest() {
+		store := s.guestStore()
+		items, err := store.Items(ctx, owner.GuestID)
+		if err != nil {
+			return err
+		}
+		variant, product, err := s.checkLine(ctx, owner, variantID, items[variantID].Quantity+quantity)
+		if err != nil {
+			return err
+		}
+		return store.Set(ctx, owner.GuestID, variantID, guestCartLine{Quantity: items[variantID].Quantity + quantity, Price: variant.UnitPrice(product)})
+	}
+
 	var item models.CartItem
-	err := db.DB.Where("user_id = ? AND product_id = ?", userID, productID).First(&item).Error
-	
-	if err == nil {
-		item.Quantity += quantity
-		return db.DB.Save(&item).Error
+	err = db.DB.WithContext(ctx).Where("user_id = ? AND variant_id = ?", owner.UserID, variantID).First(&item).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	variant, product, err := s.checkLine(ctx, owner, variantID, item.Quantity+quantity)
+	if err != nil {
+		return err
+	}
+	if item.ID != 0 {
+		return db.DB.WithContext(ctx).Model(&item).
+			Updates(map[string]interface{}{"quantity": item.Quantity + quantity, "price_at_add": variant.UnitPrice(product)}).Error
 	}
 
 	item = models.CartItem{
-		UserID:    userID,
-		ProductID: productID,
-		Quantity:  quantity,
+		UserID:     owner.UserID,
+		ProductID:  product.ID,
+		VariantID:  variant.ID,
+		Quantity:   quantity,
+		PriceAtAdd: variant.UnitPrice(product),
+	}
+	return db.DB.WithContext(ctx).Create(&item).Error
+}
+
+// SetQuantity changes the quantity of a variant already in the cart. Zero
+// removes it. Like AddToCart it takes the current price as the line's
+// reference price.
+func (s *CartService) SetQuantity(ctx context.Context, owner CartOwner, variantID uint, quantity int) error {
+	if quantity < 0 {
+		return ErrInvalidQuantity
+	}
+	if quantity == 0 {
+		return s.RemoveFromCart(ctx, owner, variantID)
+	}
+
+	if owner.IsGuest() {
+		store := s.guestStore()
+		items, err := store.Items(ctx, owner.GuestID)
+		if err != nil {
+			return err
+		}
+		if _, ok := items[variantID]; !ok {
+			return ErrCartItemNotFound
+		}
+		variant, product, err := s.checkLine(ctx, owner, variantID, quantity)
+		if err != nil {
+			return err
+		}
+		return store.Set(ctx, owner.GuestID, variantID, guestCartLine{Quantity: quantity, Price: variant.UnitPrice(product)})
+	}
+
+	var item models.CartItem
+	if err := db.DB.WithContext(ctx).Where("user_id = ? AND variant_id = ?", owner.UserID, variantID).First(&item).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrCartItemNotFound
+		}
+		return err
+	}
+	variant, product, err := s.checkLine(ctx, owner, variantID, quantity)
+	if err != nil {
+		return err
+	}
+	return db.DB.WithContext(ctx).Model(&item).
+		Updates(map[string]interface{}{"quantity": quantity, "price_at_add": variant.UnitPrice(product)}).Error
+}
+
+func (s *CartService) RemoveFromCart(ctx context.Context, owner CartOwner, variantID uint) error {
+	if owner.IsGuest() {
+		return s.guestStore().Remove(ctx, owner.GuestID, variantID)
 	}
-	return db.DB.Create(&item).Error
+	return db.DB.WithContext(ctx).Where("user_id = ? AND variant_id = ?", owner.UserID, variantID).Delete(&models.CartItem{}).Error
 }
 
-func (s *CartService) RemoveFromCart(userID, productID uint) error {
-	return db.DB.Where("user_id = ? AND product_id = ?", userID, productID).Delete(&models.CartItem{}).Error
+// Coupons returns the promotion codes applied to the cart.
+func (s *CartService) Coupons(ctx context.Context, owner CartOwner) ([]string, error) {
+	if owner.IsGuest() {
+		if owner.GuestID == "" {
+			return nil, nil
+		}
+		return s.guestStore().Coupons(ctx, owner.GuestID)
+	}
+	var codes []string
+	if err := db.DB.WithContext(ctx).Model(&models.CartCoupon{}).
+		Where("user_id = ?", owner.UserID).Order("id").Pluck("code", &codes).Error; err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// ApplyCoupon adds a promotion code to the cart. The code has to belong to
+// a promotion that can be used now; whether it applies to what is in the
+// cart shows in the summary.
+func (s *CartService) ApplyCoupon(ctx context.Context, owner CartOwner, code string) error {
+	code = NormalizeCouponCode(code)
+	if err := s.promotions.CheckCode(code); err != nil {
+		return err
+	}
+
+	codes, err := s.Coupons(ctx, owner)
+	if err != nil {
+		return err
+	}
+	for _, c := range codes {
+		if c == code {
+			return nil
+		}
+	}
+	if len(codes) >= maxCartCoupons {
+		return ErrTooManyCoupons
+	}
+
+	if owner.IsGuest() {
+		return s.guestStore().SetCoupons(ctx, owner.GuestID, append(codes, code))
+	}
+	return db.DB.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&models.CartCoupon{UserID: owner.UserID, Code: code}).Error
+}
+
+func (s *CartService) RemoveCoupon(ctx context.Context, owner CartOwner, code string) error {
+	code = NormalizeCouponCode(code)
+	if !owner.IsGuest() {
+		return db.DB.WithContext(ctx).Where("user_id = ? AND code = ?", owner.UserID, code).Delete(&models.CartCoupon{}).Error
+	}
+
+	codes, err := s.Coupons(ctx, owner)
+	if err != nil {
+		return err
+	}
+	kept := codes[:0]
+	for _, c := range codes {
+		if c != code {
+			kept = append(kept, c)
+		}
+	}
+	return s.guestStore().SetCoupons(ctx, owner.GuestID, kept)
+}
+
+// MergeGuestCart moves a guest cart into the user's cart and deletes it.
+// Variants already in the user's cart are resolved by the configured
+// CART_MERGE_STRATEGY; variants that no longer exist are dropped and
+// quantities are capped at each product's maximum per order, over all its
+// variants; a line the user already had is never emptied. It returns the
+// number of guest lines merged. Coupons on the guest cart move over too.
+//
+// The guest cart is taken out of the store before it is merged, so two
+// logins from the same guest cart at once can't both merge it. If the merge
+// fails, it is put back.
+func (s *CartService) MergeGuestCart(ctx context.Context, guestID string, userID uint) (int, error) {
+	store := s.guestStore()
+	guest, coupons, err := store.Take(ctx, guestID)
+	if err != nil {
+		return 0, err
+	}
+	if len(guest) == 0 && len(coupons) == 0 {
+		return 0, nil
+	}
+
+	variantIDs := make([]uint, 0, len(guest))
+	for id := range guest {
+		variantIDs = append(variantIDs, id)
+	}
+	sort.Slice(variantIDs, func(i, j int) bool { return variantIDs[i] < variantIDs[j] })
+
+	merged := 0
+	err = db.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var variants []models.ProductVariant
+		if err := tx.Where("id IN ?", variantIDs).Find(&variants).Error; err != nil {
+			return err
+		}
+		productIDs := make([]uint, 0, len(variants))
+		for _, v := range variants {
+			productIDs = append(productIDs, v.ProductID)
+		}
+		var existing []models.Product
+		if err := tx.Where("id IN ?", productIDs).Find(&existing).Error; err != nil {
+			return err
+		}
+		products := make(map[uint]*models.Product, len(existing))
+		for i := range existing {
+			products[existing[i].ID] = &existing[i]
+		}
+		available := make(map[uint]*models.Product, len(variants))
+		for _, v := range variants {
+			if p := products[v.ProductID]; p != nil {
+				available[v.ID] = p
+			}
+		}
+
+		var rows []models.CartItem
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("user_id = ?", userID).Find(&rows).Error; err != nil {
+			return err
+		}
+		current := make(map[uint]*models.CartItem, len(rows))
+		inCart := make(map[uint]int)
+		for i := range rows {
+			current[rows[i].VariantID] = &rows[i]
+			inCart[rows[i].ProductID] += rows[i].Quantity
+		}
+
+		for _, id := range variantIDs {
+			product := available[id]
+			if product == nil {
+				continue
+			}
+			qty := guest[id].Quantity
+			row, ok := current[id]
+			// The product's other variants in the cart count towards
+			// its maximum too.
+			max := s.maxQuantity(product) - inCart[product.ID]
+			if ok {
+				max += row.Quantity
+			}
+			if max < 0 {
+				max = 0
+			}
+			if !ok {
+				if qty > max {
+					qty = max
+				}
+				if qty == 0 {
+					continue
+				}
+				item := models.CartItem{UserID: userID, ProductID: product.ID, VariantID: id, Quantity: qty, PriceAtAdd: guest[id].Price}
+				if err := tx.Create(&item).Error; err != nil {
+					return err
+				}
+				inCart[product.ID] += qty
+				merged++
+				continue
+			}
+
+			switch s.mergeStrategy {
+			case CartMergeSum:
+				qty += row.Quantity
+			case CartMergeMax:
+				if row.Quantity > qty {
+					qty = row.Quantity
+				}
+			case CartMergeKeepUser:
+				qty = row.Quantity
+			}
+			if qty > max {
+				qty = max
+			}
+			if qty < 1 {
+				qty = row.Quantity
+			}
+			if qty != row.Quantity {
+				if err := tx.Model(row).Update("quantity", qty).Error; err != nil {
+					return err
+				}
+				inCart[product.ID] += qty - row.Quantity
+			}
+			merged++
+		}
+
+		var count int64
+		if err := tx.Model(&models.CartCoupon{}).Where("user_id = ?", userID).Count(&count).Error; err != nil {
+			return err
+		}
+		for _, code := range coupons {
+			if count >= maxCartCoupons {
+				break
+			}
+			result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.CartCoupon{UserID: userID, Code: code})
+			if result.Error != nil {
+				return result.Error
+			}
+			count += result.RowsAffected
+		}
+		return nil
+	})
+	if err != nil {
+		s.restoreGuestCart(store, guestID, guest, coupons)
+		return 0, err
+	}
+	return merged, nil
+}
+
+// restoreGuestCart puts back a guest cart that MergeGuestCart took but
+// couldn't merge. It doesn't use the request's context, which may be what
+// failed the merge.
+func (s *CartService) restoreGuestCart(store guestCartStore, guestID string, items map[uint]guestCartLine, coupons []string) {
+	ctx := context.Background()
+	for variantID, line := range items {
+		if err := store.Set(ctx, guestID, variantID, line); err != nil {
+			log.Printf("Failed to restore guest cart %s after a failed merge: %v", guestID, err)
+			return
+		}
+	}
+	if len(coupons) > 0 {
+		if err := store.SetCoupons(ctx, guestID, coupons); err != nil {
+			log.Printf("Failed to restore the coupons of guest cart %s after a failed merge: %v", guestID, err)
+		}
+	}
 }
@@ -0,0 +1,292 @@
+package services
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/db"
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/models"
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/money"
+)
+
+// Cart warning codes.
+const (
+	CartWarningPriceChanged      = "price_changed"
+	CartWarningUnavailable       = "unavailable"
+	CartWarningOutOfStock        = "out_of_stock"
+	CartWarningInsufficientStock = "insufficient_stock"
+	CartWarningCouponRejected    = "coupon_rejected"
+	CartWarningNoShipping        = "shipping_unavailable"
+)
+
+// CartLine is a cart item priced in the shopper's currency. UnitPrice and
+// LineTotal are net; DisplayPrice is the unit price the way the shopper's
+// country shows prices, with tax where PricesIncludeTax. Stock is the
+// variant's.
+type CartLine struct {
+	ProductID    uint                  `json:"product_id"`
+	VariantID    uint                  `json:"variant_id"`
+	SKU          string                `json:"sku"`
+	VariantName  string                `json:"variant_name,omitempty"`
+	Quantity     int                   `json:"quantity"`
+	UnitPrice    money.Money           `json:"unit_price"`
+	DisplayPrice money.Money           `json:"display_price"`
+	TaxRate      float64               `json:"tax_rate"`
+	LineTotal    money.Money           `json:"line_total"`
+	Available    bool                  `json:"available"`
+	Stock        int                   `json:"stock"`
+	MaxQty       int                   `json:"max_quantity"`
+	Product      models.Product        `json:"product"`
+	Variant      models.ProductVariant `json:"variant"`
+}
+
+// CartWarning tells the shopper about a line that changed since it was
+// added, a coupon that doesn't apply or a destination nothing ships to.
+// OldPrice and NewPrice are set for price changes, Available for stock
+// problems and Coupon and Reason for coupons.
+type CartWarning struct {
+	Code      string       `json:"code"`
+	ProductID uint         `json:"product_id,omitempty"`
+	VariantID uint         `json:"variant_id,omitempty"`
+	Coupon    string       `json:"coupon,omitempty"`
+	Reason    string       `json:"reason,omitempty"`
+	Message   string       `json:"message"`
+	OldPrice  *money.Money `json:"old_price,omitempty"`
+	NewPrice  *money.Money `json:"new_price,omitempty"`
+	Available *int         `json:"available,omitempty"`
+}
+
+// CartSummary is the cart with its totals as the server computes them.
+// Lines that can't be bought are listed but left out of the totals. Tax and
+// shipping are estimates until checkout; Shipping is the cheapest of
+// ShippingOptions.
+type CartSummary struct {
+	Items            []CartLine         `json:"items"`
+	ItemCount        int                `json:"item_count"`
+	Subtotal         money.Money        `json:"subtotal"`
+	Discount         money.Money        `json:"discount"`
+	Tax              money.Money        `json:"tax"`
+	TaxLines         []TaxBreakdown     `json:"tax_lines"`
+	PricesIncludeTax bool               `json:"prices_include_tax"`
+	ReverseCharge    bool               `json:"reverse_charge"`
+	Shipping         money.Money        `json:"shipping"`
+	ShippingOptions  []ShippingQuote    `json:"shipping_options"`
+	GrandTotal       money.Money        `json:"grand_total"`
+	Currency         string             `json:"currency"`
+	ExchangeRate     float64            `json:"exchange_rate"`
+	Coupons          []string           `json:"coupons"`
+	Promotions       []AppliedPromotion `json:"promotions"`
+	Warnings         []CartWarning      `json:"warnings"`
+}
+
+// Summary prices the cart in currency, which falls back to BaseCurrency
+// when it isn't supported, with tax and shipping for location.
+func (s *CartService) Summary(ctx context.Context, owner CartOwner, currency string, location TaxLocation) (*CartSummary, error) {
+	items, err := s.lines(ctx, owner)
+	if err != nil {
+		return nil, err
+	}
+	coupons, err := s.Coupons(ctx, owner)
+	if err != nil {
+		return nil, err
+	}
+
+	// Converted the same way an order placed now would be.
+	presentment := lockOrderCurrency(currency)
+	convert := presentment.present
+
+	summary := &CartSummary{
+		Items:           make([]CartLine, 0, len(items)),
+		Subtotal:        presentment.zero(),
+		Currency:        presentment.code,
+		ExchangeRate:    presentment.rate,
+		Coupons:         append([]string{}, coupons...),
+		Promotions:      []AppliedPromotion{},
+		TaxLines:        []TaxBreakdown{},
+		ShippingOptions: []ShippingQuote{},
+		Warnings:        []CartWarning{},
+	}
+
+	productIDs := make([]uint, 0, len(items))
+	inCart := make(map[uint]int)
+	for _, item := range items {
+		productIDs = append(productIDs, item.ProductID)
+		inCart[item.ProductID] += item.Quantity
+	}
+	categories, err := productCategoryNames(db.DB.WithContext(ctx), productIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	baseSubtotal := money.Zero(BaseCurrency)
+	var weight int
+	var promotionLines []PromotionLine
+	for _, item := range items {
+		product, variant := item.Product, item.Variant
+		price := variant.UnitPrice(&product)
+		name := product.Name
+		if title := variant.Title(); title != "" {
+			name += " (" + title + ")"
+		}
+		line := CartLine{
+			ProductID:   item.ProductID,
+			VariantID:   item.VariantID,
+			SKU:         variant.SKU,
+			VariantName: variant.Title(),
+			Quantity:    item.Quantity,
+			UnitPrice:   convert(price),
+			Stock:       variant.Stock,
+			MaxQty:      lineMaxQuantity(s.maxQuantity(&product), inCart[item.ProductID], item.Quantity),
+			Product:     product,
+			Variant:     variant,
+		}
+		line.LineTotal = line.UnitPrice.Times(int64(item.Quantity))
+
+		switch {
+		case product.DeletedAt.Valid, variant.DeletedAt.Valid, variant.ID == 0:
+			summary.Warnings = append(summary.Warnings, CartWarning{
+				Code:      CartWarningUnavailable,
+				ProductID: item.ProductID,
+				VariantID: item.VariantID,
+				Message:   name + " is no longer available",
+			})
+		case variant.Stock <= 0:
+			summary.Warnings = append(summary.Warnings, CartWarning{
+				Code:      CartWarningOutOfStock,
+				ProductID: item.ProductID,
+				VariantID: item.VariantID,
+				Message:   name + " is out of stock",
+			})
+		default:
+			line.Available = true
+			if item.Quantity > variant.Stock {
+				available := variant.Stock
+				summary.Warnings = append(summary.Warnings, CartWarning{
+					Code:      CartWarningInsufficientStock,
+					ProductID: item.ProductID,
+					VariantID: item.VariantID,
+					Message:   fmt.Sprintf("Only %d of %s left in stock", available, name),
+					Available: &available,
+				})
+			}
+		}
+
+		if line.Available && item.PriceAtAdd.IsPositive() && !item.PriceAtAdd.Equal(price) {
+			oldPrice := convert(item.PriceAtAdd)
+			newPrice := line.UnitPrice
+			summary.Warnings = append(summary.Warnings, CartWarning{
+				Code:      CartWarningPriceChanged,
+				ProductID: item.ProductID,
+				VariantID: item.VariantID,
+				Message:   "The price of " + name + " has changed since you added it",
+				OldPrice:  &oldPrice,
+				NewPrice:  &newPrice,
+			})
+		}
+
+		if line.Available {
+			summary.ItemCount += item.Quantity
+			summary.Subtotal = summary.Subtotal.Add(line.LineTotal)
+			baseSubtotal = baseSubtotal.Add(price.Times(int64(item.Quantity)))
+			weight += variant.Weight(&product) * item.Quantity
+			promotionLines = append(promotionLines, PromotionLine{
+				ProductID:  product.ID,
+				VariantID:  variant.ID,
+				Categories: categories[product.ID],
+				Price:      price,
+				Quantity:   item.Quantity,
+			})
+		}
+		summary.Items = append(summary.Items, line)
+	}
+
+	// Per-user limits are left to checkout for guests, who have no user.
+	promotions, err := s.promotions.Evaluate(db.DB.WithContext(ctx), owner.UserID, promotionLines, coupons, false)
+	if err != nil {
+		return nil, err
+	}
+	for _, applied := range promotions.Applied {
+		applied.Amount = convert(applied.Amount)
+		summary.Promotions = append(summary.Promotions, applied)
+	}
+	for _, rejected := range promotions.Rejected {
+		summary.Warnings = append(summary.Warnings, CartWarning{
+			Code:    CartWarningCouponRejected,
+			Coupon:  rejected.Code,
+			Reason:  rejected.Reason,
+			Message: "Coupon " + rejected.Code + " can't be used with this cart",
+		})
+	}
+
+	shipping := money.Zero(BaseCurrency)
+	if summary.ItemCount > 0 {
+		destination := ShippingDestination{Country: location.Country, Region: location.Region}
+		quotes, err := s.shipping.Quote(ctx, destination, ShippingParcel{WeightGrams: weight, Value: baseSubtotal.Sub(promotions.Discount)})
+		if err != nil {
+			return nil, err
+		}
+		if len(quotes) == 0 {
+			summary.Warnings = append(summary.Warnings, CartWarning{
+				Code:    CartWarningNoShipping,
+				Message: "We can't ship this cart to your location",
+			})
+		} else if !promotions.FreeShipping {
+			shipping = quotes[0].Price
+		}
+		for _, quote := range quotes {
+			if promotions.FreeShipping {
+				quote.Price = money.Zero(BaseCurrency)
+			}
+			quote.Price = convert(quote.Price)
+			summary.ShippingOptions = append(summary.ShippingOptions, quote)
+		}
+	}
+
+	taxable := make([]TaxableLine, 0, len(promotionLines))
+	for _, line := range promotionLines {
+		taxable = append(taxable, TaxableLine{
+			ProductID:  line.ProductID,
+			Categories: line.Categories,
+			Amount:     line.Price.Times(int64(line.Quantity)).Sub(promotions.LineDiscounts[line.VariantID]),
+		})
+	}
+	taxes, err := s.taxes.Calculate(db.DB.WithContext(ctx), location, taxable, shipping)
+	if err != nil {
+		return nil, err
+	}
+	rates := make(map[uint]float64, len(taxes.Lines))
+	for _, line := range taxes.Lines {
+		rates[line.ProductID] = line.Rate
+	}
+	for i := range summary.Items {
+		line := &summary.Items[i]
+		line.TaxRate = rates[line.ProductID]
+		line.DisplayPrice = line.UnitPrice
+		if taxes.PricesIncludeTax {
+			price := line.Variant.UnitPrice(&line.Product)
+			line.DisplayPrice = convert(price.Add(price.Percent(line.TaxRate)))
+		}
+	}
+	for _, b := range taxes.Breakdown {
+		b.TaxableAmount = convert(b.TaxableAmount)
+		b.Amount = convert(b.Amount)
+		summary.TaxLines = append(summary.TaxLines, b)
+	}
+
+	summary.Discount = money.Min(convert(promotions.Discount), summary.Subtotal)
+	summary.Shipping = convert(shipping)
+	summary.Tax = convert(taxes.Total)
+	summary.PricesIncludeTax = taxes.PricesIncludeTax
+	summary.ReverseCharge = taxes.ReverseCharge
+	summary.GrandTotal = money.Sum(summary.Subtotal.Sub(summary.Discount), summary.Tax, summary.Shipping)
+	return summary, nil
+}
+
+// lineMaxQuantity is the most a line can hold next to what the cart has of
+// its product's other variants.
+func lineMaxQuantity(max, inCart, quantity int) int {
+	if room := max - (inCart - quantity); room > 0 {
+		return room
+	}
+	return 0
+}
@@ -0,0 +1,864 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/db"
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/models"
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/money"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrImportJobNotFound = errors.New("import job not found")
+	ErrUnsupportedFormat = errors.New("unsupported catalog format, expected csv or ndjson")
+	ErrImportQueueFull   = errors.New("too many catalog imports waiting, try again later")
+)
+
+const (
+	// importProgressEvery is how many rows go by between progress reports.
+	importProgressEvery = 100
+	// maxImportErrors caps the row errors kept per job; Failed still
+	// counts them all.
+	maxImportErrors = 1000
+	exportBatchSize = 500
+)
+
+// catalogColumns are the columns of a CSV export, in order. Imports read
+// the same columns by header name, in any order.
+var catalogColumns = []string{
+	"sku", "product_id", "variant_id", "name", "description", "categories", "image_url",
+	"price", "variant_price", "stock", "weight_grams", "max_quantity", "options",
+}
+
+// catalogImports carries IDs of jobs waiting for the import worker.
+var catalogImports = make(chan uint, 32)
+
+// CatalogRow is one variant in a catalog file. On import only SKU is
+// required: a field left out, as an empty CSV cell or a missing JSON key,
+// keeps its current value. A SKU that isn't known yet becomes a new
+// product with a single variant, which needs Name and Price. ProductID,
+// VariantID and Options are informational and ignored on import; option
+// types are set up with VariantService.SetOptions.
+//
+// Price, Name and the other product fields apply to the variant's product;
+// VariantPrice and Stock to the variant itself. Categories are slugs or
+// names of existing categories, the primary one first, and replace the
+// product's; in CSV they are separated by semicolons.
+type CatalogRow struct {
+	SKU          string            `json:"sku"`
+	ProductID    uint              `json:"product_id,omitempty"`
+	VariantID    uint              `json:"variant_id,omitempty"`
+	Name         *string           `json:"name,omitempty"`
+	Description  *string           `json:"description,omitempty"`
+	Categories   []string          `json:"categories,omitempty"`
+	ImageURL     *string           `json:"image_url,omitempty"`
+	Price        *float64          `json:"price,omitempty"`
+	VariantPrice *float64          `json:"variant_price,omitempty"`
+	Stock        *int              `json:"stock,omitempty"`
+	WeightGrams  *int              `json:"weight_grams,omitempty"`
+	MaxQuantity  *int              `json:"max_quantity,omitempty"`
+	Options      map[string]string `json:"options,omitempty"`
+}
+
+// CatalogFilter narrows an export. Zero fields don't filter; CategoryID
+// takes in the categories below it too.
+type CatalogFilter struct {
+	CategoryID   uint
+	UpdatedSince *time.Time
+	InStockOnly  bool
+}
+
+type ImportJobListResult struct {
+	Jobs       []models.CatalogImportJob `json:"jobs"`
+	Total      int64                     `json:"total"`
+	Page       int                       `json:"page"`
+	PageSize   int                       `json:"page_size"`
+	TotalPages int                       `json:"total_pages"`
+}
+
+// rowError is why a row was rejected.
+type rowError struct {
+	Field   string
+	Message string
+}
+
+func (e *rowError) Error() string {
+	if e.Field == "" {
+		return e.Message
+	}
+	return e.Field + ": " + e.Message
+}
+
+// CatalogService imports and exports the catalog in bulk, one row per
+// variant.
+type CatalogService struct{}
+
+func NewCatalogService() *CatalogService {
+	return &CatalogService{}
+}
+
+// CatalogFormat tells the format of an upload from its name.
+func CatalogFormat(filename string) string {
+	name := strings.ToLower(filename)
+	switch {
+	case strings.HasSuffix(name, ".csv"):
+		return models.CatalogFormatCSV
+	case strings.HasSuffix(name, ".ndjson"), strings.HasSuffix(name, ".jsonl"):
+		return models.CatalogFormatNDJSON
+	}
+	return ""
+}
+
+// StartImport keeps the upload in a temporary file and queues a job to
+// apply it. Progress goes to userID over the notifier as the job runs.
+func (s *CatalogService) StartImport(userID uint, format, filename string, upload io.Reader, dryRun bool) (*models.CatalogImportJob, error) {
+	if format != models.CatalogFormatCSV && format != models.CatalogFormatNDJSON {
+		return nil, ErrUnsupportedFormat
+	}
+
+	file, err := os.CreateTemp("", "catalog-import-*."+format)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(file, upload); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(file.Name())
+		return nil, err
+	}
+
+	job := &models.CatalogImportJob{
+		UserID:   userID,
+		Format:   format,
+		Filename: filename,
+		FilePath: file.Name(),
+		DryRun:   dryRun,
+		Status:   models.ImportJobQueued,
+	}
+	if err := db.DB.Create(job).Error; err != nil {
+		os.Remove(file.Name())
+		return nil, err
+	}
+
+	select {
+	case catalogImports <- job.ID:
+	default:
+		s.fail(job, ErrImportQueueFull.Error())
+		os.Remove(file.Name())
+		return nil, ErrImportQueueFull
+	}
+	return job, nil
+}
+
+// StartImportWorker runs queued imports one at a time. Jobs left queued or
+// running by a restart are picked up again when their file is still
+// there; applying a file twice is harmless since rows are upserted by SKU.
+func (s *CatalogService) StartImportWorker() {
+	if db.DB != nil {
+		var pending []models.CatalogImportJob
+		if err := db.DB.Where("status IN ?", []string{models.ImportJobQueued, models.ImportJobRunning}).
+			Order("id").Find(&pending).Error; err != nil {
+			log.Printf("Failed to look up pending catalog imports: %v", err)
+		}
+		for i := range pending {
+			job := &pending[i]
+			if _, err := os.Stat(job.FilePath); err != nil {
+				s.fail(job, "import was interrupted by a restart, please upload the file again")
+				continue
+			}
+			select {
+			case catalogImports <- job.ID:
+			default:
+				s.fail(job, ErrImportQueueFull.Error())
+			}
+		}
+	}
+
+	go func() {
+		for id := range catalogImports {
+			s.runImport(id)
+		}
+	}()
+}
+
+func (s *CatalogService) GetImport(id uint) (*models.CatalogImportJob, error) {
+	var job models.CatalogImportJob
+	if err := db.DB.Preload("Errors", func(tx *gorm.DB) *gorm.DB { return tx.Order("row_number, id") }).
+		First(&job, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrImportJobNotFound
+		}
+		return nil, err
+	}
+	return &job, nil
+}
+
+func (s *CatalogService) ListImports(page, pageSize int) (*ImportJobListResult, error) {
+	var jobs []models.CatalogImportJob
+	var total int64
+
+	db.DB.Model(&models.CatalogImportJob{}).Count(&total)
+	if err := db.DB.Order("id desc").Scopes(db.Paginate(page, pageSize)).Find(&jobs).Error; err != nil {
+		return nil, err
+	}
+
+	pagination := db.Pagination{Page: page, PageSize: pageSize}
+	return &ImportJobListResult{
+		Jobs:       jobs,
+		Total:      total,
+		Page:       page,
+		PageSize:   pagination.GetLimit(),
+		TotalPages: pagination.GetTotalPages(total),
+	}, nil
+}
+
+func (s *CatalogService) fail(job *models.CatalogImportJob, reason string) {
+	now := time.Now()
+	job.Status = models.ImportJobFailed
+	job.Error = reason
+	job.FinishedAt = &now
+	if err := db.DB.Model(job).Select("status", "error", "finished_at").Updates(job).Error; err != nil {
+		log.Printf("Failed to mark catalog import %d failed: %v", job.ID, err)
+	}
+}
+
+func (s *CatalogService) runImport(id uint) {
+	var job models.CatalogImportJob
+	if err := db.DB.First(&job, id).Error; err != nil {
+		log.Printf("Catalog import %d not found: %v", id, err)
+		return
+	}
+	defer os.Remove(job.FilePath)
+
+	file, err := os.Open(job.FilePath)
+	if err != nil {
+		s.fail(&job, "upload could not be read")
+		s.notify(&job)
+		return
+	}
+	defer file.Close()
+
+	now := time.Now()
+	job.Status = models.ImportJobRunning
+	job.StartedAt = &now
+	job.Processed, job.Created, job.Updated, job.Failed = 0, 0, 0, 0
+	db.DB.Model(&job).Select("status", "started_at", "processed", "created", "updated", "failed").Updates(&job)
+	db.DB.Where("job_id = ?", job.ID).Delete(&models.CatalogImportError{})
+
+	var next func() (CatalogRow, error)
+	if job.Format == models.CatalogFormatCSV {
+		next, err = csvRows(file)
+	} else {
+		next = ndjsonRows(file)
+	}
+	if err != nil {
+		s.fail(&job, err.Error())
+		s.notify(&job)
+		return
+	}
+
+	// SKUs a dry run would have created, so a later row for the same SKU
+	// counts as an update just as it would for real.
+	planned := make(map[string]bool)
+	var pendingErrors []models.CatalogImportError
+	saveProgress := func() {
+		if len(pendingErrors) > 0 {
+			if err := db.DB.Create(&pendingErrors).Error; err != nil {
+				log.Printf("Failed to save errors of catalog import %d: %v", job.ID, err)
+			}
+			pendingErrors = nil
+		}
+		db.DB.Model(&job).Select("processed", "created", "updated", "failed").Updates(&job)
+		s.notify(&job)
+	}
+
+	for {
+		row, err := next()
+		if err == io.EOF {
+			break
+		}
+		var rowErr *rowError
+		if err != nil && !errors.As(err, &rowErr) {
+			saveProgress()
+			s.fail(&job, fmt.Sprintf("reading row %d: %v", job.Processed+1, err))
+			s.notify(&job)
+			return
+		}
+
+		job.Processed++
+		if err == nil {
+			var created bool
+			if job.DryRun {
+				created, err = planCatalogRow(&row, planned)
+			} else {
+				created, err = applyCatalogRow(&row)
+			}
+			if err == nil && created {
+				job.Created++
+			} else if err == nil {
+				job.Updated++
+			}
+		}
+		if err != nil {
+			job.Failed++
+			if job.Failed <= maxImportErrors {
+				importErr := models.CatalogImportError{JobID: job.ID, Row: job.Processed, SKU: row.SKU, Message: err.Error()}
+				if errors.As(err, &rowErr) {
+					importErr.Field, importErr.Message = rowErr.Field, rowErr.Message
+				}
+				pendingErrors = append(pendingErrors, importErr)
+			}
+		}
+
+		if job.Processed%importProgressEvery == 0 {
+			saveProgress()
+		}
+	}
+
+	finished := time.Now()
+	job.Status = models.ImportJobCompleted
+	job.FinishedAt = &finished
+	if len(pendingErrors) > 0 {
+		if err := db.DB.Create(&pendingErrors).Error; err != nil {
+			log.Printf("Failed to save errors of catalog import %d: %v", job.ID, err)
+		}
+	}
+	db.DB.Model(&job).Select("status", "processed", "created", "updated", "failed", "finished_at").Updates(&job)
+	s.notify(&job)
+	log.Printf("Catalog import %d finished: %d rows, %d created, %d updated, %d failed (dry run: %t)",
+		job.ID, job.Processed, job.Created, job.Updated, job.Failed, job.DryRun)
+}
+
+// notify reports a job's progress, or its outcome once it has finished, to
+// the staff member who started it.
+func (s *CatalogService) notify(job *models.CatalogImportJob) {
+	if Notifier == nil {
+		return
+	}
+	switch job.Status {
+	case models.ImportJobCompleted:
+		Notifier.NotifyUser(job.UserID, "CATALOG_IMPORT_COMPLETED",
+			fmt.Sprintf("Catalog import of %s finished: %d created, %d updated, %d failed", job.Filename, job.Created, job.Updated, job.Failed), job)
+	case models.ImportJobFailed:
+		Notifier.NotifyUser(job.UserID, "CATALOG_IMPORT_FAILED", "Catalog import of "+job.Filename+" failed: "+job.Error, job)
+	default:
+		Notifier.NotifyUser(job.UserID, "CATALOG_IMPORT_PROGRESS",
+			fmt.Sprintf("Catalog import of %s: %d rows processed", job.Filename, job.Processed), job)
+	}
+}
+
+// csvRows reads the header of a CSV catalog and returns a function giving
+// its rows one at a time.
+func csvRows(r io.Reader) (func() (CatalogRow, error), error) {
+	reader := csv.NewReader(bufio.NewReader(r))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, errors.New("file is empty")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		columns[name] = i
+	}
+	if _, ok := columns["sku"]; !ok {
+		return nil, errors.New("header has no sku column")
+	}
+
+	return func() (CatalogRow, error) {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return CatalogRow{}, io.EOF
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			return CatalogRow{}, &rowError{Message: parseErr.Err.Error()}
+		}
+		if err != nil {
+			return CatalogRow{}, err
+		}
+		cell := func(column string) *string {
+			i, ok := columns[column]
+			if !ok || i >= len(record) {
+				return nil
+			}
+			value := strings.TrimSpace(record[i])
+			if value == "" {
+				return nil
+			}
+			return &value
+		}
+
+		row := CatalogRow{
+			Name:        cell("name"),
+			Description: cell("description"),
+			ImageURL:    cell("image_url"),
+		}
+		if sku := cell("sku"); sku != nil {
+			row.SKU = *sku
+		}
+		if categories := cell("categories"); categories != nil {
+			for _, c := range strings.Split(*categories, ";") {
+				if c = strings.TrimSpace(c); c != "" {
+					row.Categories = append(row.Categories, c)
+				}
+			}
+		}
+		for _, f := range []struct {
+			column string
+			dest   **float64
+		}{{"price", &row.Price}, {"variant_price", &row.VariantPrice}} {
+			if value := cell(f.column); value != nil {
+				parsed, err := strconv.ParseFloat(*value, 64)
+				if err != nil {
+					return row, &rowError{Field: f.column, Message: "must be a number"}
+				}
+				*f.dest = &parsed
+			}
+		}
+		for _, f := range []struct {
+			column string
+			dest   **int
+		}{{"stock", &row.Stock}, {"weight_grams", &row.WeightGrams}, {"max_quantity", &row.MaxQuantity}} {
+			if value := cell(f.column); value != nil {
+				parsed, err := strconv.Atoi(*value)
+				if err != nil {
+					return row, &rowError{Field: f.column, Message: "must be a whole number"}
+				}
+				*f.dest = &parsed
+			}
+		}
+		return row, nil
+	}, nil
+}
+
+// ndjsonRows returns a function giving the rows of an NDJSON catalog, one
+// JSON object per line, one at a time. Blank lines are skipped.
+func ndjsonRows(r io.Reader) func() (CatalogRow, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	return func() (CatalogRow, error) {
+		for scanner.Scan() {
+			line := bytes.TrimSpace(scanner.Bytes())
+			if len(line) == 0 {
+				continue
+			}
+			var row CatalogRow
+			if err := json.Unmarshal(line, &row); err != nil {
+				return CatalogRow{}, &rowError{Message: "invalid JSON: " + err.Error()}
+			}
+			row.SKU = strings.TrimSpace(row.SKU)
+			return row, nil
+		}
+		if err := scanner.Err(); err != nil {
+			return CatalogRow{}, err
+		}
+		return CatalogRow{}, io.EOF
+	}
+}
+
+// validateCatalogRow checks the fields a row sets. isNew is whether the row
+// creates a product, which needs a name and a price.
+func validateCatalogRow(row *CatalogRow, isNew bool) error {
+	switch {
+	case row.SKU == "":
+		return &rowError{Field: "sku", Message: "is required"}
+	case len(row.SKU) > 64:
+		return &rowError{Field: "sku", Message: "must be at most 64 characters"}
+	case isNew && (row.Name == nil || strings.TrimSpace(*row.Name) == ""):
+		return &rowError{Field: "name", Message: "is required for a new product"}
+	case row.Name != nil && len(*row.Name) > 255:
+		return &rowError{Field: "name", Message: "must be at most 255 characters"}
+	case row.Description != nil && len(*row.Description) > 10000:
+		return &rowError{Field: "description", Message: "must be at most 10000 characters"}
+	case len(row.Categories) > 20:
+		return &rowError{Field: "categories", Message: "must be at most 20"}
+	case isNew && row.Price == nil:
+		return &rowError{Field: "price", Message: "is required for a new product"}
+	case row.Price != nil && *row.Price <= 0:
+		return &rowError{Field: "price", Message: "must be greater than 0"}
+	case row.VariantPrice != nil && *row.VariantPrice <= 0:
+		return &rowError{Field: "variant_price", Message: "must be greater than 0"}
+	case row.Stock != nil && *row.Stock < 0:
+		return &rowError{Field: "stock", Message: "must not be negative"}
+	case row.WeightGrams != nil && *row.WeightGrams < 0:
+		return &rowError{Field: "weight_grams", Message: "must not be negative"}
+	case row.MaxQuantity != nil && *row.MaxQuantity < 0:
+		return &rowError{Field: "max_quantity", Message: "must not be negative"}
+	}
+	if row.ImageURL != nil {
+		if u, err := url.ParseRequestURI(*row.ImageURL); err != nil || u.Host == "" {
+			return &rowError{Field: "image_url", Message: "must be an absolute URL"}
+		}
+	}
+	return nil
+}
+
+// planCatalogRow is the dry run of applyCatalogRow: it checks the row and
+// tells whether it would create a product, writing nothing.
+func planCatalogRow(row *CatalogRow, planned map[string]bool) (bool, error) {
+	var count int64
+	if err := db.DB.Model(&models.ProductVariant{}).Where("sku = ?", row.SKU).Count(&count).Error; err != nil {
+		return false, err
+	}
+	isNew := count == 0 && !planned[row.SKU]
+	if err := validateCatalogRow(row, isNew); err != nil {
+		return false, err
+	}
+	if _, err := catalogCategoryIDs(db.DB, row.Categories); err != nil {
+		return false, err
+	}
+	if isNew {
+		planned[row.SKU] = true
+	}
+	return isNew, nil
+}
+
+// applyCatalogRow upserts the variant with the row's SKU in a transaction
+// of its own, so a rejected row leaves the others in place. created tells
+// whether it made a new product.
+func applyCatalogRow(row *CatalogRow) (created bool, err error) {
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		var variant models.ProductVariant
+		err := tx.Where("sku = ?", row.SKU).First(&variant).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			if err := validateCatalogRow(row, true); err != nil {
+				return err
+			}
+			created = true
+			return createCatalogProduct(tx, row)
+		}
+		if err != nil {
+			return err
+		}
+		if err := validateCatalogRow(row, false); err != nil {
+			return err
+		}
+		return updateCatalogVariant(tx, &variant, row)
+	})
+	return created, err
+}
+
+func createCatalogProduct(tx *gorm.DB, row *CatalogRow) error {
+	product := models.Product{
+		Name:    strings.TrimSpace(*row.Name),
+		Price:   money.FromFloat(*row.Price, BaseCurrency),
+		Version: 1,
+	}
+	applyCatalogProductFields(&product, row)
+	variant := models.ProductVariant{SKU: row.SKU}
+	if row.VariantPrice != nil {
+		price := money.FromFloat(*row.VariantPrice, BaseCurrency)
+		variant.Price = &price
+	}
+	if row.Stock != nil {
+		product.Stock = *row.Stock
+		variant.Stock = *row.Stock
+	}
+
+	if err := setProductSlug(tx, &product); err != nil {
+		return err
+	}
+	if err := tx.Create(&product).Error; err != nil {
+		return err
+	}
+	if len(row.Categories) > 0 {
+		categoryIDs, err := catalogCategoryIDs(tx, row.Categories)
+		if err != nil {
+			return err
+		}
+		if err := setProductCategories(tx, &product, categoryIDs, false); err != nil {
+			return err
+		}
+		if err := tx.Model(&product).Select("category_id", "category").Omit(clause.Associations).Updates(&product).Error; err != nil {
+			return err
+		}
+	}
+	variant.ProductID = product.ID
+	return tx.Create(&variant).Error
+}
+
+// catalogCategoryIDs looks up the categories a row names, in order.
+func catalogCategoryIDs(tx *gorm.DB, names []string) ([]uint, error) {
+	ids := make([]uint, 0, len(names))
+	for _, name := range names {
+		category, err := categoryBySlugOrName(tx, name)
+		if errors.Is(err, ErrCategoryNotFound) {
+			return nil, &rowError{Field: "categories", Message: "unknown category " + name}
+		}
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, category.ID)
+	}
+	return ids, nil
+}
+
+func updateCatalogVariant(tx *gorm.DB, variant *models.ProductVariant, row *CatalogRow) error {
+	productIDs, err := lockVariantProducts(tx, []uint{variant.ID})
+	if err != nil {
+		return err
+	}
+
+	var product models.Product
+	if err := tx.Unscoped().First(&product, variant.ProductID).Error; err != nil {
+		return err
+	}
+	if row.Name != nil || row.Description != nil || row.Categories != nil || row.ImageURL != nil ||
+		row.Price != nil || row.WeightGrams != nil || row.MaxQuantity != nil {
+		if row.Name != nil {
+			name := product.Name
+			product.Name = strings.TrimSpace(*row.Name)
+			if product.Name != name || product.Slug == "" {
+				if err := setProductSlug(tx, &product); err != nil {
+					return err
+				}
+			}
+		}
+		if row.Price != nil {
+			product.Price = money.FromFloat(*row.Price, BaseCurrency)
+		}
+		applyCatalogProductFields(&product, row)
+		if row.Categories != nil {
+			categoryIDs, err := catalogCategoryIDs(tx, row.Categories)
+			if err != nil {
+				return err
+			}
+			if err := setProductCategories(tx, &product, categoryIDs, false); err != nil {
+				return err
+			}
+		}
+		product.Version++
+		if err := tx.Unscoped().Model(&product).Select(productColumns).Omit(clause.Associations).Updates(&product).Error; err != nil {
+			return err
+		}
+	}
+
+	if row.VariantPrice == nil && row.Stock == nil {
+		return nil
+	}
+	updates := map[string]interface{}{}
+	if row.VariantPrice != nil {
+		updates["price"] = money.FromFloat(*row.VariantPrice, BaseCurrency)
+	}
+	if row.Stock != nil {
+		updates["stock"] = *row.Stock
+	}
+	if err := tx.Model(variant).Updates(updates).Error; err != nil {
+		return err
+	}
+	return syncProductStock(tx, productIDs)
+}
+
+// applyCatalogProductFields copies the product fields a row sets other than
+// name and price, which createCatalogProduct and updateCatalogVariant
+// handle themselves.
+func applyCatalogProductFields(p *models.Product, row *CatalogRow) {
+	if row.Description != nil {
+		p.Description = *row.Description
+	}
+	if row.ImageURL != nil {
+		p.ImageURL = *row.ImageURL
+	}
+	if row.WeightGrams != nil {
+		p.WeightGrams = *row.WeightGrams
+	}
+	if row.MaxQuantity != nil {
+		p.MaxQuantity = *row.MaxQuantity
+	}
+}
+
+// Export writes every live variant matching filter to w as CSV or NDJSON,
+// a batch at a time, calling flush after each batch so the rows go out
+// while the rest are read. Only one batch is held in memory.
+func (s *CatalogService) Export(ctx context.Context, w io.Writer, format string, filter CatalogFilter, flush func()) error {
+	var writeRows func([]CatalogRow) error
+	switch format {
+	case models.CatalogFormatCSV:
+		writer := csv.NewWriter(w)
+		if err := writer.Write(catalogColumns); err != nil {
+			return err
+		}
+		writeRows = func(rows []CatalogRow) error {
+			for i := range rows {
+				if err := writer.Write(rows[i].csvRecord()); err != nil {
+					return err
+				}
+			}
+			writer.Flush()
+			return writer.Error()
+		}
+	case models.CatalogFormatNDJSON:
+		encoder := json.NewEncoder(w)
+		writeRows = func(rows []CatalogRow) error {
+			for i := range rows {
+				if err := encoder.Encode(&rows[i]); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	default:
+		return ErrUnsupportedFormat
+	}
+
+	query := db.DB.WithContext(ctx).Model(&models.ProductVariant{}).
+		Joins("JOIN products ON products.id = product_variants.product_id AND products.deleted_at IS NULL").
+		Preload("Options", orderedOptionValues)
+	if filter.CategoryID != 0 {
+		query = query.Scopes(inCategoryTree(filter.CategoryID))
+	}
+	if filter.UpdatedSince != nil {
+		query = query.Where("(products.updated_at >= ? OR product_variants.updated_at >= ?)", *filter.UpdatedSince, *filter.UpdatedSince)
+	}
+	if filter.InStockOnly {
+		query = query.Where("product_variants.stock > 0")
+	}
+
+	var batch []models.ProductVariant
+	result := query.FindInBatches(&batch, exportBatchSize, func(tx *gorm.DB, _ int) error {
+		rows, err := catalogRows(ctx, batch)
+		if err != nil {
+			return err
+		}
+		if err := writeRows(rows); err != nil {
+			return err
+		}
+		flush()
+		return nil
+	})
+	return result.Error
+}
+
+// catalogRows turns a batch of variants into export rows, loading their
+// products and option names.
+func catalogRows(ctx context.Context, variants []models.ProductVariant) ([]CatalogRow, error) {
+	productIDs := make([]uint, 0, len(variants))
+	typeIDs := make([]uint, 0)
+	for _, v := range variants {
+		productIDs = append(productIDs, v.ProductID)
+		for _, o := range v.Options {
+			typeIDs = append(typeIDs, o.OptionTypeID)
+		}
+	}
+
+	var products []models.Product
+	if err := db.DB.WithContext(ctx).Preload("Categories").Where("id IN ?", productIDs).Find(&products).Error; err != nil {
+		return nil, err
+	}
+	byID := make(map[uint]*models.Product, len(products))
+	for i := range products {
+		byID[products[i].ID] = &products[i]
+	}
+	var types []models.OptionType
+	if len(typeIDs) > 0 {
+		if err := db.DB.WithContext(ctx).Where("id IN ?", typeIDs).Find(&types).Error; err != nil {
+			return nil, err
+		}
+	}
+	typeNames := make(map[uint]string, len(types))
+	for _, t := range types {
+		typeNames[t.ID] = t.Name
+	}
+
+	rows := make([]CatalogRow, 0, len(variants))
+	for i := range variants {
+		v := &variants[i]
+		p, ok := byID[v.ProductID]
+		if !ok {
+			continue
+		}
+		price := p.Price.Float()
+		stock := v.Stock
+		row := CatalogRow{
+			SKU:         v.SKU,
+			ProductID:   p.ID,
+			VariantID:   v.ID,
+			Name:        &p.Name,
+			Description: &p.Description,
+			ImageURL:    &p.ImageURL,
+			Price:       &price,
+			Stock:       &stock,
+			WeightGrams: &p.WeightGrams,
+			MaxQuantity: &p.MaxQuantity,
+		}
+		if v.Price != nil {
+			variantPrice := v.Price.Float()
+			row.VariantPrice = &variantPrice
+		}
+		slugs := make(map[uint]string, len(p.Categories))
+		for _, c := range p.Categories {
+			slugs[c.ID] = c.Slug
+		}
+		for _, id := range productCategoryIDs(p) {
+			row.Categories = append(row.Categories, slugs[id])
+		}
+		if len(v.Options) > 0 {
+			row.Options = make(map[string]string, len(v.Options))
+			for _, o := range v.Options {
+				row.Options[typeNames[o.OptionTypeID]] = o.Value
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// csvRecord is the row in the order of catalogColumns. Options are written
+// as "Name=Value" pairs separated by semicolons.
+func (r *CatalogRow) csvRecord() []string {
+	str := func(s *string) string {
+		if s == nil {
+			return ""
+		}
+		return *s
+	}
+	num := func(n *int) string {
+		if n == nil {
+			return ""
+		}
+		return strconv.Itoa(*n)
+	}
+	amount := func(f *float64) string {
+		if f == nil {
+			return ""
+		}
+		return money.FromFloat(*f, BaseCurrency).String()
+	}
+	options := make([]string, 0, len(r.Options))
+	for name, value := range r.Options {
+		options = append(options, name+"="+value)
+	}
+	sort.Strings(options)
+
+	return []string{
+		r.SKU, strconv.FormatUint(uint64(r.ProductID), 10), strconv.FormatUint(uint64(r.VariantID), 10),
+		str(r.Name), str(r.Description), strings.Join(r.Categories, ";"), str(r.ImageURL),
+		amount(r.Price), amount(r.VariantPrice), num(r.Stock), num(r.WeightGrams), num(r.MaxQuantity),
+		strings.Join(options, ";"),
+	}
+}
@@ -0,0 +1,524 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/db"
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrCategoryNotFound  = errors.New("category not found")
+	ErrCategorySlugTaken = errors.New("category slug is already in use")
+	ErrCategoryCycle     = errors.New("a category can't be moved below itself")
+	ErrCategoryHasKids   = errors.New("category still has subcategories")
+	ErrInvalidCategory   = errors.New("invalid category")
+	ErrInvalidAttributes = errors.New("invalid product attributes")
+)
+
+const (
+	// Margin analysis factors of products without a category that sets
+	// them.
+	defaultSalesMultiplier = 1.0
+	defaultTrendScore      = 0.5
+)
+
+// CategoryInput is what staff send to create or replace a category. Slug
+// is made from Name when left empty.
+type CategoryInput struct {
+	Name            string                     `json:"name" binding:"required,max=100"`
+	Slug            string                     `json:"slug" binding:"max=120"`
+	ParentID        *uint                      `json:"parent_id"`
+	Description     string                     `json:"description"`
+	Position        int                        `json:"position"`
+	Attributes      []models.CategoryAttribute `json:"attributes" binding:"dive"`
+	SalesMultiplier *float64                   `json:"sales_multiplier" binding:"omitempty,gt=0"`
+	TrendScore      *float64                   `json:"trend_score" binding:"omitempty,min=0,max=1"`
+}
+
+// CategoryProductsResult is a category page: the category, the path to it
+// from the root and the products in it or below it.
+type CategoryProductsResult struct {
+	Category    models.Category   `json:"category"`
+	Breadcrumbs []models.Category `json:"breadcrumbs"`
+	Products    []models.Product  `json:"products"`
+	Total       int64             `json:"total"`
+	Page        int               `json:"page"`
+	PageSize    int               `json:"page_size"`
+	TotalPages  int               `json:"total_pages"`
+}
+
+type CategoryService struct{}
+
+func NewCategoryService() *CategoryService {
+	return &CategoryService{}
+}
+
+// Slugify makes a URL slug of s: lower case letters and digits, with runs
+// of anything else turned into single dashes.
+func Slugify(s string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(s) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+	return b.String()
+}
+
+// Tree returns every category nested under its parent, siblings in
+// position order.
+func (s *CategoryService) Tree() ([]models.Category, error) {
+	var all []models.Category
+	if err := db.DB.Order("position, name, id").Find(&all).Error; err != nil {
+		return nil, err
+	}
+
+	children := make(map[uint][]models.Category)
+	var roots []models.Category
+	for _, c := range all {
+		if c.ParentID == nil {
+			roots = append(roots, c)
+		} else {
+			children[*c.ParentID] = append(children[*c.ParentID], c)
+		}
+	}
+	var attach func(nodes []models.Category)
+	attach = func(nodes []models.Category) {
+		for i := range nodes {
+			nodes[i].Children = children[nodes[i].ID]
+			attach(nodes[i].Children)
+		}
+	}
+	attach(roots)
+	if roots == nil {
+		roots = []models.Category{}
+	}
+	return roots, nil
+}
+
+func (s *CategoryService) Get(id uint) (*models.Category, error) {
+	var category models.Category
+	if err := db.DB.First(&category, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrCategoryNotFound
+		}
+		return nil, err
+	}
+	return &category, nil
+}
+
+// BySlug returns the category with the given slug.
+func (s *CategoryService) BySlug(slug string) (*models.Category, error) {
+	var category models.Category
+	if err := db.DB.Where("slug = ?", slug).First(&category).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrCategoryNotFound
+		}
+		return nil, err
+	}
+	return &category, nil
+}
+
+// Products lists the products in the category with the given slug or in
+// any category below it, with the breadcrumbs leading to it.
+func (s *CategoryService) Products(slug string, page, pageSize int) (*CategoryProductsResult, error) {
+	found, err := s.BySlug(slug)
+	if err != nil {
+		return nil, err
+	}
+	category := *found
+	breadcrumbs, err := categoryAncestors(db.DB, category.ID)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.DB.Where("parent_id = ?", category.ID).Order("position, name, id").Find(&category.Children).Error; err != nil {
+		return nil, err
+	}
+
+	var products []models.Product
+	var total int64
+	query := db.DB.Model(&models.Product{}).Scopes(inCategoryTree(category.ID))
+	query.Count(&total)
+	if err := withVariants(query).Preload("Categories").Order("products.id").Scopes(db.Paginate(page, pageSize)).Find(&products).Error; err != nil {
+		return nil, err
+	}
+
+	pagination := db.Pagination{Page: page, PageSize: pageSize}
+	return &CategoryProductsResult{
+		Category:    category,
+		Breadcrumbs: breadcrumbs,
+		Products:    products,
+		Total:       total,
+		Page:        page,
+		PageSize:    pagination.GetLimit(),
+		TotalPages:  pagination.GetTotalPages(total),
+	}, nil
+}
+
+func (s *CategoryService) Create(input CategoryInput) (*models.Category, error) {
+	category := &models.Category{}
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := applyCategoryInput(tx, category, input); err != nil {
+			return err
+		}
+		return tx.Create(category).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return category, nil
+}
+
+// Update replaces a category. Moving it under another parent takes its
+// subcategories and products along. A new name is copied onto products
+// that have it as their primary category.
+func (s *CategoryService) Update(id uint, input CategoryInput) (*models.Category, error) {
+	var category models.Category
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&category, id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrCategoryNotFound
+			}
+			return err
+		}
+		if err := applyCategoryInput(tx, &category, input); err != nil {
+			return err
+		}
+		if err := tx.Save(&category).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.Product{}).Where("category_id = ?", category.ID).
+			Update("category", category.Name).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &category, nil
+}
+
+// Delete removes a category without subcategories. Its products leave it;
+// those that had it as their primary category fall back to another of
+// theirs.
+func (s *CategoryService) Delete(id uint) error {
+	return db.DB.Transaction(func(tx *gorm.DB) error {
+		var category models.Category
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&category, id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrCategoryNotFound
+			}
+			return err
+		}
+		var children int64
+		if err := tx.Model(&models.Category{}).Where("parent_id = ?", id).Count(&children).Error; err != nil {
+			return err
+		}
+		if children > 0 {
+			return ErrCategoryHasKids
+		}
+
+		if err := tx.Exec("DELETE FROM product_categories WHERE category_id = ?", id).Error; err != nil {
+			return err
+		}
+		if err := tx.Exec(`UPDATE products SET
+				category_id = (SELECT MIN(pc.category_id) FROM product_categories pc WHERE pc.product_id = products.id),
+				category = COALESCE((SELECT c.name FROM categories c WHERE c.id = (
+					SELECT MIN(pc.category_id) FROM product_categories pc WHERE pc.product_id = products.id)), '')
+			WHERE category_id = ?`, id).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&category).Error
+	})
+}
+
+func applyCategoryInput(tx *gorm.DB, c *models.Category, in CategoryInput) error {
+	slug := Slugify(in.Slug)
+	if slug == "" {
+		slug = Slugify(in.Name)
+	}
+	if slug == "" {
+		return fmt.Errorf("%w: name must contain letters or digits", ErrInvalidCategory)
+	}
+	var taken int64
+	if err := tx.Model(&models.Category{}).Where("slug = ? AND id <> ?", slug, c.ID).Count(&taken).Error; err != nil {
+		return err
+	}
+	if taken > 0 {
+		return ErrCategorySlugTaken
+	}
+
+	if in.ParentID != nil {
+		var parent models.Category
+		if err := tx.First(&parent, *in.ParentID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return fmt.Errorf("%w: parent %d", ErrCategoryNotFound, *in.ParentID)
+			}
+			return err
+		}
+		if c.ID != 0 {
+			below, err := categoryTreeIDs(tx, c.ID)
+			if err != nil {
+				return err
+			}
+			for _, id := range below {
+				if id == parent.ID {
+					return ErrCategoryCycle
+				}
+			}
+		}
+	}
+
+	seen := make(map[string]bool, len(in.Attributes))
+	for i := range in.Attributes {
+		a := &in.Attributes[i]
+		a.Name = strings.TrimSpace(a.Name)
+		key := strings.ToLower(a.Name)
+		switch {
+		case a.Name == "":
+			return fmt.Errorf("%w: attribute names can't be blank", ErrInvalidCategory)
+		case seen[key]:
+			return fmt.Errorf("%w: attribute %s is defined twice", ErrInvalidCategory, a.Name)
+		case !models.IsValidAttributeType(a.Type):
+			return fmt.Errorf("%w: attribute %s has unknown type %q", ErrInvalidCategory, a.Name, a.Type)
+		case a.Type == models.AttributeSelect && len(a.Options) == 0:
+			return fmt.Errorf("%w: select attribute %s needs options", ErrInvalidCategory, a.Name)
+		}
+		if a.Type != models.AttributeSelect {
+			a.Options = nil
+		}
+		seen[key] = true
+	}
+
+	c.Name = strings.TrimSpace(in.Name)
+	c.Slug = slug
+	c.ParentID = in.ParentID
+	c.Description = in.Description
+	c.Position = in.Position
+	c.Attributes = in.Attributes
+	c.SalesMultiplier = in.SalesMultiplier
+	c.TrendScore = in.TrendScore
+	return nil
+}
+
+// categoryTreeIDs is the category and every category below it.
+func categoryTreeIDs(tx *gorm.DB, id uint) ([]uint, error) {
+	var ids []uint
+	err := tx.Raw(`WITH RECURSIVE tree AS (
+			SELECT id FROM categories WHERE id = ? AND deleted_at IS NULL
+			UNION ALL
+			SELECT c.id FROM categories c JOIN tree ON c.parent_id = tree.id WHERE c.deleted_at IS NULL
+		) SELECT id FROM tree`, id).Scan(&ids).Error
+	return ids, err
+}
+
+// categoryAncestors is the path from the root down to the category,
+// including it.
+func categoryAncestors(tx *gorm.DB, id uint) ([]models.Category, error) {
+	var path []models.Category
+	err := tx.Raw(`WITH RECURSIVE crumbs AS (
+			SELECT categories.*, 0 AS depth FROM categories WHERE id = ? AND deleted_at IS NULL
+			UNION ALL
+			SELECT c.*, crumbs.depth + 1 FROM categories c JOIN crumbs ON c.id = crumbs.parent_id WHERE c.deleted_at IS NULL
+		) SELECT * FROM crumbs ORDER BY depth DESC`, id).Scan(&path).Error
+	return path, err
+}
+
+// inCategoryTree limits a product query to products in the category or
+// any category below it.
+func inCategoryTree(categoryID uint) func(*gorm.DB) *gorm.DB {
+	return func(tx *gorm.DB) *gorm.DB {
+		return tx.Where(`EXISTS (SELECT 1 FROM product_categories pc WHERE pc.product_id = products.id AND pc.category_id IN (
+			WITH RECURSIVE tree AS (
+				SELECT id FROM categories WHERE id = ? AND deleted_at IS NULL
+				UNION ALL
+				SELECT c.id FROM categories c JOIN tree ON c.parent_id = tree.id WHERE c.deleted_at IS NULL
+			) SELECT id FROM tree))`, categoryID)
+	}
+}
+
+// categoryBySlugOrName finds a category by slug, or else by its name, for
+// callers that were given a category as free text.
+func categoryBySlugOrName(tx *gorm.DB, value string) (*models.Category, error) {
+	var category models.Category
+	err := tx.Where("slug = ?", Slugify(value)).First(&category).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		err = tx.Where("LOWER(name) = LOWER(?)", strings.TrimSpace(value)).Order("id").First(&category).Error
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("%w: %s", ErrCategoryNotFound, value)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &category, nil
+}
+
+// setProductCategories puts a product in the given categories, the first
+// becoming its primary one, and checks its attributes against them. An
+// empty list takes it out of every category. The caller saves the
+// product's CategoryID and Category.
+func setProductCategories(tx *gorm.DB, product *models.Product, categoryIDs []uint, requireAttributes bool) error {
+	var categories []models.Category
+	if len(categoryIDs) > 0 {
+		if err := tx.Where("id IN ?", categoryIDs).Find(&categories).Error; err != nil {
+			return err
+		}
+	}
+	byID := make(map[uint]models.Category, len(categories))
+	for _, c := range categories {
+		byID[c.ID] = c
+	}
+	ordered := make([]models.Category, 0, len(categoryIDs))
+	seen := make(map[uint]bool, len(categoryIDs))
+	for _, id := range categoryIDs {
+		c, ok := byID[id]
+		if !ok {
+			return fmt.Errorf("%w: %d", ErrCategoryNotFound, id)
+		}
+		if !seen[id] {
+			seen[id] = true
+			ordered = append(ordered, c)
+		}
+	}
+
+	if err := checkProductAttributes(tx, categoryIDs, product.Attributes, requireAttributes); err != nil {
+		return err
+	}
+	if err := tx.Model(product).Association("Categories").Replace(ordered); err != nil {
+		return err
+	}
+	product.Categories = ordered
+	product.CategoryID = nil
+	product.Category = ""
+	if len(ordered) > 0 {
+		product.CategoryID = &ordered[0].ID
+		product.Category = ordered[0].Name
+	}
+	return nil
+}
+
+// checkProductAttributes checks attribute values against what the
+// categories and their ancestors define: no unknown attributes, and values
+// of the right type. With requireAll, required attributes must be set too.
+func checkProductAttributes(tx *gorm.DB, categoryIDs []uint, values map[string]string, requireAll bool) error {
+	defined := make(map[string]models.CategoryAttribute)
+	for _, id := range categoryIDs {
+		path, err := categoryAncestors(tx, id)
+		if err != nil {
+			return err
+		}
+		for _, c := range path {
+			for _, a := range c.Attributes {
+				defined[a.Name] = a
+			}
+		}
+	}
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		a, ok := defined[name]
+		if !ok {
+			return fmt.Errorf("%w: %s is not an attribute of the product's categories", ErrInvalidAttributes, name)
+		}
+		value := values[name]
+		switch a.Type {
+		case models.AttributeNumber:
+			if _, err := strconv.ParseFloat(value, 64); err != nil {
+				return fmt.Errorf("%w: %s must be a number", ErrInvalidAttributes, name)
+			}
+		case models.AttributeBoolean:
+			if _, err := strconv.ParseBool(value); err != nil {
+				return fmt.Errorf("%w: %s must be true or false", ErrInvalidAttributes, name)
+			}
+		case models.AttributeSelect:
+			allowed := false
+			for _, option := range a.Options {
+				allowed = allowed || option == value
+			}
+			if !allowed {
+				return fmt.Errorf("%w: %s must be one of %s", ErrInvalidAttributes, name, strings.Join(a.Options, ", "))
+			}
+		}
+	}
+
+	if requireAll {
+		for name, a := range defined {
+			if a.Required && strings.TrimSpace(values[name]) == "" {
+				return fmt.Errorf("%w: %s is required", ErrInvalidAttributes, name)
+			}
+		}
+	}
+	return nil
+}
+
+// productCategoryNames maps products to the names and slugs of their
+// categories and of every ancestor of those, which promotions and tax rules
+// scoped to a category match against: a rule for "Electronics" covers
+// products in "Electronics > Phones" too.
+func productCategoryNames(tx *gorm.DB, productIDs []uint) (map[uint][]string, error) {
+	names := make(map[uint][]string, len(productIDs))
+	if len(productIDs) == 0 {
+		return names, nil
+	}
+	var rows []struct {
+		ProductID uint
+		Name      string
+		Slug      string
+	}
+	err := tx.Raw(`WITH RECURSIVE up AS (
+			SELECT pc.product_id, c.id, c.parent_id, c.name, c.slug
+			FROM product_categories pc JOIN categories c ON c.id = pc.category_id AND c.deleted_at IS NULL
+			WHERE pc.product_id IN ?
+			UNION
+			SELECT up.product_id, c.id, c.parent_id, c.name, c.slug
+			FROM categories c JOIN up ON c.id = up.parent_id WHERE c.deleted_at IS NULL
+		) SELECT DISTINCT product_id, name, slug FROM up`, productIDs).Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range rows {
+		names[r.ProductID] = append(names[r.ProductID], r.Name, r.Slug)
+	}
+	return names, nil
+}
+
+// categoryFactors are the margin analysis factors of a category: the
+// nearest ones set on it or its ancestors, else the defaults.
+func categoryFactors(tx *gorm.DB, categoryID *uint) (salesMultiplier, trendScore float64, err error) {
+	salesMultiplier, trendScore = defaultSalesMultiplier, defaultTrendScore
+	if categoryID == nil {
+		return salesMultiplier, trendScore, nil
+	}
+	path, err := categoryAncestors(tx, *categoryID)
+	if err != nil {
+		return salesMultiplier, trendScore, err
+	}
+	var multiplierSet, trendSet bool
+	for i := len(path) - 1; i >= 0; i-- {
+		if c := path[i]; c.SalesMultiplier != nil && !multiplierSet {
+			salesMultiplier, multiplierSet = *c.SalesMultiplier, true
+		}
+		if c := path[i]; c.TrendScore != nil && !trendSet {
+			trendScore, trendSet = *c.TrendScore, true
+		}
+	}
+	return salesMultiplier, trendScore, nil
+}
@@ -0,0 +1,484 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/config"
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/db"
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/models"
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/money"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrCartEmpty          = errors.New("cart is empty")
+	ErrProductUnavailable = errors.New("product is no longer available")
+	ErrInvalidQuantity    = errors.New("quantity must be at least 1")
+	ErrOrderNotFound      = errors.New("order not found")
+	ErrOrderNotPending    = errors.New("order is not awaiting payment")
+)
+
+type StockShortage struct {
+	ProductID uint   `json:"product_id"`
+	VariantID uint   `json:"variant_id"`
+	SKU       string `json:"sku"`
+	Name      string `json:"name"`
+	Requested int    `json:"requested"`
+	Available int    `json:"available"`
+}
+
+// InsufficientStockError lists every line that can't be fulfilled, not just
+// the first one, so the client can fix the whole cart in one go.
+type InsufficientStockError struct {
+	Items []StockShortage
+}
+
+func (e *InsufficientStockError) Error() string {
+	return fmt.Sprintf("insufficient stock for %d item(s)", len(e.Items))
+}
+
+type CheckoutService struct {
+	payments      *PaymentService
+	promotions    *PromotionService
+	taxes         *TaxService
+	shipping      *ShippingService
+	holdTTL       time.Duration
+	maxPerProduct int
+}
+
+func NewCheckoutService() *CheckoutService {
+	return &CheckoutService{
+		payments:      NewPaymentService(),
+		promotions:    NewPromotionService(),
+		taxes:         NewTaxService(),
+		shipping:      NewShippingService(),
+		holdTTL:       stockHoldTTL(),
+		maxPerProduct: config.LoadConfig().CartMaxQuantity,
+	}
+}
+
+// CheckoutOptions are the customer's choices for an order. AddressID is an
+// entry of their address book, which also decides where the order is
+// taxed. Coupons are only read by PlaceOrder; a cart checkout uses the
+// coupons applied to the cart. Currency is what the order is presented and
+// charged in; an unsupported one falls back to BaseCurrency.
+type CheckoutOptions struct {
+	AddressID        uint
+	ShippingMethodID uint
+	VATID            string
+	Coupons          []string
+	PaymentMethodID  string
+	Currency         string
+}
+
+// CheckoutResult carries the order and, when the gateway wants the customer
+// to authenticate (3-D Secure), the intent the client has to act on. In that
+// case the order stays pending until CompletePayment is called.
+type CheckoutResult struct {
+	Order   *models.Order  `json:"order"`
+	Payment *PaymentIntent `json:"payment,omitempty"`
+}
+
+func (r *CheckoutResult) ActionRequired() bool {
+	return r.Payment != nil && r.Payment.Status == IntentRequiresAction
+}
+
+// Checkout turns the user's cart into a paid order, with the coupons
+// applied to the cart.
+func (s *CheckoutService) Checkout(ctx context.Context, userID uint, opts CheckoutOptions) (*CheckoutResult, error) {
+	opts.Coupons = nil
+	return s.placeOrder(ctx, userID, nil, opts)
+}
+
+// PlaceOrder buys the given lines directly with the coupon codes in opts,
+// leaving the cart alone. Prices sent by the client are ignored. A line
+// may leave out the variant of a product that has only one.
+func (s *CheckoutService) PlaceOrder(ctx context.Context, userID uint, items []models.OrderItem, opts CheckoutOptions) (*CheckoutResult, error) {
+	if len(items) == 0 {
+		return nil, ErrCartEmpty
+	}
+	for _, item := range items {
+		if item.Quantity < 1 {
+			return nil, ErrInvalidQuantity
+		}
+	}
+	return s.placeOrder(ctx, userID, items, opts)
+}
+
+// placeOrder runs stock reservation, promotions, shipping, tax, order
+// creation and cart clearing in one database transaction, which commits the
+// pending order with its stock on hold. Discounts, shipping, tax and the
+// exchange rate are fixed on the order at this point; a coupon or shipping
+// method that no longer applies fails the checkout rather than silently
+// changing the price. The gateway is only called after that commit, so a
+// slow gateway doesn't keep the stock and promotion rows locked for every
+// other checkout; a second short transaction then marks the order paid. If
+// the payment fails, whatever money moved is voided or refunded and the
+// order fails, giving back its stock and promotions and, for a cart
+// checkout, the cart.
+func (s *CheckoutService) placeOrder(ctx context.Context, userID uint, items []models.OrderItem, opts CheckoutOptions) (*CheckoutResult, error) {
+	var order *models.Order
+	fromCart := items == nil
+	coupons := opts.Coupons
+
+	err := db.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		address, err := findAddress(tx, userID, opts.AddressID)
+		if err != nil {
+			return err
+		}
+		location := TaxLocation{Country: address.Country, Region: address.Region, VATID: opts.VATID}
+
+		lines := items
+		for i := range lines {
+			if lines[i].VariantID, err = resolveVariant(tx, lines[i].ProductID, lines[i].VariantID); err != nil {
+				return err
+			}
+		}
+		var cartHold []models.StockReservation
+		var held map[uint]int
+		if fromCart {
+			if lines, err = lockCartLines(tx, userID); err != nil {
+				return err
+			}
+			// Stock the cart has on hold goes straight to the order.
+			if cartHold, held, err = lockCartHold(tx, userID); err != nil {
+				return err
+			}
+			if err := tx.Model(&models.CartCoupon{}).Where("user_id = ?", userID).Order("id").Pluck("code", &coupons).Error; err != nil {
+				return err
+			}
+		}
+
+		orderItems, total, err := reserveStock(tx, lines, held)
+		if err != nil {
+			return err
+		}
+		if err := checkOrderQuantities(tx, orderItems, s.maxPerProduct); err != nil {
+			return err
+		}
+		categories, err := productCategories(tx, orderItems)
+		if err != nil {
+			return err
+		}
+		promotions, err := s.promotions.applyToOrderItems(tx, userID, orderItems, categories, coupons)
+		if err != nil {
+			return err
+		}
+		weight, err := orderWeight(tx, orderItems)
+		if err != nil {
+			return err
+		}
+		destination := ShippingDestination{Country: address.Country, Region: address.Region, PostalCode: address.PostalCode}
+		quote, err := s.shipping.quoteMethod(ctx, destination, ShippingParcel{WeightGrams: weight, Value: total.Sub(promotions.Discount)}, opts.ShippingMethodID)
+		if err != nil {
+			return err
+		}
+		shipping := quote.Price
+		if promotions.FreeShipping {
+			shipping = money.Zero(BaseCurrency)
+		}
+		taxes, err := s.taxes.applyToOrderItems(tx, location, orderItems, categories, shipping)
+		if err != nil {
+			return err
+		}
+
+		order = &models.Order{
+			UserID:           userID,
+			Subtotal:         total,
+			DiscountTotal:    promotions.Discount,
+			FreeShipping:     promotions.FreeShipping,
+			ShippingAddress:  &address.PostalAddress,
+			ShippingMethodID: &quote.MethodID,
+			ShippingMethod:   quote.Name,
+			ShippingCarrier:  quote.Carrier,
+			ShippingTotal:    shipping,
+			ShippingTax:      taxes.Shipping.Amount,
+			TaxTotal:         taxes.Total,
+			TaxCountry:       strings.ToUpper(location.Country),
+			TaxRegion:        strings.ToUpper(location.Region),
+			VATID:            NormalizeVATID(location.VATID),
+			PricesIncludeTax: taxes.PricesIncludeTax,
+			ReverseCharge:    taxes.ReverseCharge,
+			Total:            money.Sum(total.Sub(promotions.Discount), shipping, taxes.Total),
+			Status:           models.OrderStatusPending,
+			Items:            orderItems,
+			TaxLines:         orderTaxLines(taxes.Breakdown),
+		}
+		lockOrderCurrency(opts.Currency).apply(order)
+		if err := tx.Create(order).Error; err != nil {
+			return err
+		}
+		if err := recordDiscounts(tx, order, promotions.Applied); err != nil {
+			return err
+		}
+		if err := recordOrderStatus(tx, order.ID, "", order.Status, CustomerActor(userID), "order placed"); err != nil {
+			return err
+		}
+
+		if fromCart {
+			if err := tx.Where("user_id = ?", userID).Delete(&models.CartItem{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("user_id = ?", userID).Delete(&models.CartCoupon{}).Error; err != nil {
+				return err
+			}
+			if err := settleReservations(tx, cartHold, models.StockReservationConsumed, &order.ID); err != nil {
+				return err
+			}
+		}
+
+		// The order holds its stock until the payment settles it: paying
+		// consumes the hold, failing releases it, and the sweeper cancels
+		// an order still pending after holdTTL, such as one whose customer
+		// never finishes 3-D Secure.
+		return holdOrderStock(tx, order, s.holdTTL)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	authorized, err := s.payments.Authorize(ctx, db.DB, order, opts.PaymentMethodID)
+	if err == nil {
+		switch authorized.Status {
+		case IntentRequiresAction:
+			// The customer finishes 3-D Secure in the browser and then
+			// calls CompletePayment.
+			return &CheckoutResult{Order: order, Payment: authorized}, nil
+		case IntentRequiresCapture:
+		default:
+			err = fmt.Errorf("%w: unexpected intent status %s", ErrPaymentDeclined, authorized.Status)
+		}
+	}
+	if err != nil {
+		s.failCheckout(order, fromCart, coupons, authorized, nil, err)
+		return nil, err
+	}
+
+	captured, err := s.payments.Capture(ctx, db.DB, order)
+	if err != nil {
+		s.failCheckout(order, fromCart, coupons, authorized, nil, err)
+		return nil, err
+	}
+
+	// The money has moved; a client that went away meanwhile must not
+	// stop the order from being marked paid.
+	var transition *OrderTransition
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(order, order.ID).Error; err != nil {
+			return err
+		}
+		// A webhook may have got there first.
+		if order.Status == models.OrderStatusPaid {
+			return nil
+		}
+		transition, err = transitionOrder(tx, order, models.OrderStatusPaid, ProviderActor(order.PaymentProvider), "payment captured")
+		return err
+	})
+	if err != nil {
+		s.failCheckout(order, fromCart, coupons, nil, captured, err)
+		return nil, err
+	}
+	notifyOrderTransition(transition)
+	return &CheckoutResult{Order: order, Payment: captured}, nil
+}
+
+// failCheckout ends a checkout whose payment went wrong after its order was
+// committed: money that moved is voided or refunded, the order fails, which
+// releases its stock hold and promotions, and a cart checkout gets its
+// lines and coupons back.
+func (s *CheckoutService) failCheckout(order *models.Order, fromCart bool, coupons []string, authorized, captured *PaymentIntent, cause error) {
+	s.compensate(order.UserID, authorized, captured, cause)
+
+	var transition *OrderTransition
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		var current models.Order
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&current, order.ID).Error; err != nil {
+			return err
+		}
+		if current.Status != models.OrderStatusPending && current.Status != models.OrderStatusAuthorized {
+			return nil
+		}
+		var err error
+		transition, err = transitionOrder(tx, &current, models.OrderStatusPaymentFailed, SystemActor(), "payment failed")
+		if err != nil {
+			return err
+		}
+		if !fromCart {
+			return nil
+		}
+		return restoreCart(tx, order, coupons)
+	})
+	if err != nil {
+		log.Printf("Failed to fail order %d after payment error: %v", order.ID, err)
+		return
+	}
+	notifyOrderTransition(transition)
+}
+
+// restoreCart puts the lines and coupons of a failed cart checkout back in
+// the user's cart, leaving alone variants added to it again meanwhile.
+func restoreCart(tx *gorm.DB, order *models.Order, coupons []string) error {
+	for _, item := range order.Items {
+		var count int64
+		if err := tx.Model(&models.CartItem{}).Where("user_id = ? AND variant_id = ?", order.UserID, item.VariantID).
+			Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			continue
+		}
+		if err := tx.Create(&models.CartItem{
+			UserID:     order.UserID,
+			ProductID:  item.ProductID,
+			VariantID:  item.VariantID,
+			Quantity:   item.Quantity,
+			PriceAtAdd: item.Price,
+		}).Error; err != nil {
+			return err
+		}
+	}
+	for _, code := range coupons {
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&models.CartCoupon{UserID: order.UserID, Code: code}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// productCategories maps the products of order lines to their categories,
+// which promotions and tax rules can be scoped by.
+func productCategories(tx *gorm.DB, items []models.OrderItem) (map[uint][]string, error) {
+	productIDs := make([]uint, 0, len(items))
+	for _, item := range items {
+		productIDs = append(productIDs, item.ProductID)
+	}
+	return productCategoryNames(tx, productIDs)
+}
+
+// CompletePayment finishes an order left pending by 3-D Secure. It looks at
+// the intent's current status at the gateway: an authorized intent is
+// captured, a failed one fails the order, which releases its stock hold.
+// As in placeOrder, the gateway is called with no transaction open, so the
+// order row isn't locked against webhooks and the sweeper meanwhile.
+func (s *CheckoutService) CompletePayment(ctx context.Context, userID, orderID uint) (*CheckoutResult, error) {
+	var order models.Order
+	if err := db.DB.WithContext(ctx).Preload("Items").
+		Where("id = ? AND user_id = ?", orderID, userID).First(&order).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrOrderNotFound
+		}
+		return nil, err
+	}
+	if !awaitingPayment(&order) {
+		return nil, ErrOrderNotPending
+	}
+
+	intent, err := s.payments.Gateway().GetIntent(ctx, order.PaymentIntentID)
+	if err != nil {
+		return nil, err
+	}
+
+	var captured *PaymentIntent
+	var capturedNow, declined bool
+	switch intent.Status {
+	case IntentRequiresAction, IntentRequiresConfirmation:
+		return nil, ErrPaymentActionRequired
+	case IntentSucceeded:
+		captured = intent
+	case IntentRequiresCapture:
+		captured, err = s.payments.Capture(ctx, db.DB, &order)
+		if err != nil {
+			return nil, err
+		}
+		capturedNow = true
+	default:
+		declined = true
+		if intent.Status != IntentCanceled {
+			if err := s.payments.Void(ctx, db.DB, &order); err != nil {
+				log.Printf("Failed to void intent %s of order %d: %v", order.PaymentIntentID, order.ID, err)
+			}
+		}
+	}
+
+	var transition *OrderTransition
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&order, order.ID).Error; err != nil {
+			return err
+		}
+		// A webhook may have settled the order while the gateway was asked.
+		if declined && order.Status == models.OrderStatusPaymentFailed ||
+			!declined && order.Status == models.OrderStatusPaid {
+			return nil
+		}
+		if !awaitingPayment(&order) {
+			return ErrOrderNotPending
+		}
+		var err error
+		if declined {
+			transition, err = transitionOrder(tx, &order, models.OrderStatusPaymentFailed, ProviderActor(order.PaymentProvider), "payment authentication failed")
+			return err
+		}
+		transition, err = transitionOrder(tx, &order, models.OrderStatusPaid, ProviderActor(order.PaymentProvider), "payment captured")
+		return err
+	})
+	if err != nil {
+		if capturedNow {
+			s.compensate(userID, nil, captured, err)
+		}
+		return nil, err
+	}
+	notifyOrderTransition(transition)
+	if declined {
+		return nil, fmt.Errorf("%w: authentication failed", ErrPaymentDeclined)
+	}
+
+	return &CheckoutResult{Order: &order, Payment: captured}, nil
+}
+
+// awaitingPayment is whether an order is still waiting for its payment to
+// settle. A webhook may already have moved it to authorized.
+func awaitingPayment(order *models.Order) bool {
+	return (order.Status == models.OrderStatusPending || order.Status == models.OrderStatusAuthorized) &&
+		order.PaymentIntentID != ""
+}
+
+// compensate undoes money movement for a checkout or payment completion
+// that couldn't be recorded. It talks to the gateway directly, as the
+// transaction that would have recorded it may have rolled back.
+func (s *CheckoutService) compensate(userID uint, authorized, captured *PaymentIntent, cause error) {
+	gateway := s.payments.Gateway()
+	ctx := context.Background()
+
+	switch {
+	case captured != nil:
+		log.Printf("Checkout for user %d failed after capturing %s: %v, refunding", userID, captured.ID, cause)
+		if _, err := gateway.Refund(ctx, captured.ID, 0); err != nil {
+			reportCompensationFailure(userID, captured.ID, captured.Amount, "refund", err)
+		}
+	case authorized != nil:
+		log.Printf("Checkout for user %d failed after creating intent %s: %v, voiding", userID, authorized.ID, cause)
+		if _, err := gateway.Void(ctx, authorized.ID); err != nil {
+			reportCompensationFailure(userID, authorized.ID, authorized.Amount, "void", err)
+		}
+	}
+}
+
+func reportCompensationFailure(userID uint, intentID string, amount int64, action string, err error) {
+	log.Printf("CRITICAL: %s of payment %s for user %d failed: %v", action, intentID, userID, err)
+	if Notifier != nil {
+		go Notifier.NotifyAdmins("PAYMENT_COMPENSATION_FAILED", fmt.Sprintf("Payment %s could not be reversed (%s)", intentID, action), map[string]any{
+			"user_id":   userID,
+			"intent_id": intentID,
+			"amount":    amount,
+			"action":    action,
+			"error":     err.Error(),
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package services
+
+import (
+	"sort"
+
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/money"
+)
+
+// BaseCurrency is the currency product prices and orders are kept in.
+const BaseCurrency = "USD"
+
+// Currency is the ISO 4217 metadata of a currency. DecimalPlaces is the
+// ISO minor unit. Rate is units of the currency per BaseCurrency unit: in
+// CurrencyMap it is the bundled rate used until a RateProvider has
+// delivered (zero if there is none), CurrencyService fills in the current
+// one.
+type Currency struct {
+	Code          string  `json:"code"`
+	Numeric       string  `json:"numeric"`
+	Symbol        string  `json:"symbol"`
+	Name          string  `json:"name"`
+	Rate          float64 `json:"rate"`
+	Locale        string  `json:"locale"`
+	DecimalPlaces int     `json:"decimal_places"`
+}
+
+// CurrencyMap has every currency CountryToCurrency can pick. A currency is
+// only offered to shoppers while there is a rate for it; see
+// IsSupportedCurrency.
+var CurrencyMap = map[string]Currency{
+	"AED": {Code: "AED", Numeric: "784", Symbol: "AED", Name: "UAE Dirham", Locale: "ar-AE", DecimalPlaces: 2},
+	"ARS": {Code: "ARS", Numeric: "032", Symbol: "AR$", Name: "Argentine Peso", Locale: "es-AR", DecimalPlaces: 2},
+	"AUD": {Code: "AUD", Numeric: "036", Symbol: "A$", Name: "Australian Dollar", Rate: 1.53, Locale: "en-AU", DecimalPlaces: 2},
+	"BRL": {Code: "BRL", Numeric: "986", Symbol: "R$", Name: "Brazilian Real", Rate: 4.97, Locale: "pt-BR", DecimalPlaces: 2},
+	"CAD": {Code: "CAD", Numeric: "124", Symbol: "C$", Name: "Canadian Dollar", Rate: 1.36, Locale: "en-CA", DecimalPlaces: 2},
+	"CHF": {Code: "CHF", Numeric: "756", Symbol: "Fr", Name: "Swiss Franc", Rate: 0.88, Locale: "de-CH", DecimalPlaces: 2},
+	"CLP": {Code: "CLP", Numeric: "152", Symbol: "CLP$", Name: "Chilean Peso", Locale: "es-CL", DecimalPlaces: 0},
+	"CNY": {Code: "CNY", Numeric: "156", Symbol: "¥", Name: "Yuan Renminbi", Rate: 7.24, Locale: "zh-CN", DecimalPlaces: 2},
+	"COP": {Code: "COP", Numeric: "170", Symbol: "COL$", Name: "Colombian Peso", Locale: "es-CO", DecimalPlaces: 2},
+	"CZK": {Code: "CZK", Numeric: "203", Symbol: "Kč", Name: "Czech Koruna", Locale: "cs-CZ", DecimalPlaces: 2},
+	"DKK": {Code: "DKK", Numeric: "208", Symbol: "kr", Name: "Danish Krone", Locale: "da-DK", DecimalPlaces: 2},
+	"EGP": {Code: "EGP", Numeric: "818", Symbol: "E£", Name: "Egyptian Pound", Locale: "ar-EG", DecimalPlaces: 2},
+	"EUR": {Code: "EUR", Numeric: "978", Symbol: "€", Name: "Euro", Rate: 0.92, Locale: "de-DE", DecimalPlaces: 2},
+	"GBP": {Code: "GBP", Numeric: "826", Symbol: "£", Name: "Pound Sterling", Rate: 0.79, Locale: "en-GB", DecimalPlaces: 2},
+	"HKD": {Code: "HKD", Numeric: "344", Symbol: "HK$", Name: "Hong Kong Dollar", Locale: "zh-HK", DecimalPlaces: 2},
+	"HUF": {Code: "HUF", Numeric: "348", Symbol: "Ft", Name: "Forint", Locale: "hu-HU", DecimalPlaces: 2},
+	"IDR": {Code: "IDR", Numeric: "360", Symbol: "Rp", Name: "Rupiah", Locale: "id-ID", DecimalPlaces: 2},
+	"ILS": {Code: "ILS", Numeric: "376", Symbol: "₪", Name: "New Israeli Sheqel", Locale: "he-IL", DecimalPlaces: 2},
+	"INR": {Code: "INR", Numeric: "356", Symbol: "₹", Name: "Indian Rupee", Rate: 83.12, Locale: "en-IN", DecimalPlaces: 2},
+	"JPY": {Code: "JPY", Numeric: "392", Symbol: "¥", Name: "Yen", Rate: 149.50, Locale: "ja-JP", DecimalPlaces: 0},
+	"KES": {Code: "KES", Numeric: "404", Symbol: "KSh", Name: "Kenyan Shilling", Locale: "en-KE", DecimalPlaces: 2},
+	"KRW": {Code: "KRW", Numeric: "410", Symbol: "₩", Name: "Won", Locale: "ko-KR", DecimalPlaces: 0},
+	"MAD": {Code: "MAD", Numeric: "504", Symbol: "DH", Name: "Moroccan Dirham", Locale: "ar-MA", DecimalPlaces: 2},
+	"MXN": {Code: "MXN", Numeric: "484", Symbol: "MX$", Name: "Mexican Peso", Locale: "es-MX", DecimalPlaces: 2},
+	"MYR": {Code: "MYR", Numeric: "458", Symbol: "RM", Name: "Malaysian Ringgit", Locale: "ms-MY", DecimalPlaces: 2},
+	"NGN": {Code: "NGN", Numeric: "566", Symbol: "₦", Name: "Naira", Locale: "en-NG", DecimalPlaces: 2},
+	"NOK": {Code: "NOK", Numeric: "578", Symbol: "kr", Name: "Norwegian Krone", Locale: "nb-NO", DecimalPlaces: 2},
+	"NZD": {Code: "NZD", Numeric: "554", Symbol: "NZ$", Name: "New Zealand Dollar", Locale: "en-NZ", DecimalPlaces: 2},
+	"PEN": {Code: "PEN", Numeric: "604", Symbol: "S/", Name: "Sol", Locale: "es-PE", DecimalPlaces: 2},
+	"PHP": {Code: "PHP", Numeric: "608", Symbol: "₱", Name: "Philippine Peso", Locale: "en-PH", DecimalPlaces: 2},
+	"PLN": {Code: "PLN", Numeric: "985", Symbol: "zł", Name: "Zloty", Locale: "pl-PL", DecimalPlaces: 2},
+	"RON": {Code: "RON", Numeric: "946", Symbol: "lei", Name: "Romanian Leu", Locale: "ro-RO", DecimalPlaces: 2},
+	"RUB": {Code: "RUB", Numeric: "643", Symbol: "₽", Name: "Russian Ruble", Locale: "ru-RU", DecimalPlaces: 2},
+	"SAR": {Code: "SAR", Numeric: "682", Symbol: "SAR", Name: "Saudi Riyal", Locale: "ar-SA", DecimalPlaces: 2},
+	"SEK": {Code: "SEK", Numeric: "752", Symbol: "kr", Name: "Swedish Krona", Locale: "sv-SE", DecimalPlaces: 2},
+	"SGD": {Code: "SGD", Numeric: "702", Symbol: "S$", Name: "Singapore Dollar", Locale: "en-SG", DecimalPlaces: 2},
+	"THB": {Code: "THB", Numeric: "764", Symbol: "฿", Name: "Baht", Locale: "th-TH", DecimalPlaces: 2},
+	"TRY": {Code: "TRY", Numeric: "949", Symbol: "₺", Name: "Turkish Lira", Locale: "tr-TR", DecimalPlaces: 2},
+	"UAH": {Code: "UAH", Numeric: "980", Symbol: "₴", Name: "Hryvnia", Locale: "uk-UA", DecimalPlaces: 2},
+	"USD": {Code: "USD", Numeric: "840", Symbol: "$", Name: "US Dollar", Rate: 1.0, Locale: "en-US", DecimalPlaces: 2},
+	"VND": {Code: "VND", Numeric: "704", Symbol: "₫", Name: "Dong", Locale: "vi-VN", DecimalPlaces: 0},
+	"ZAR": {Code: "ZAR", Numeric: "710", Symbol: "R", Name: "Rand", Locale: "en-ZA", DecimalPlaces: 2},
+}
+
+// IsSupportedCurrency reports whether prices can be shown and charged in
+// code, which takes a current rate for it.
+func IsSupportedCurrency(code string) bool {
+	_, ok := currentRates().Rates[code]
+	return ok
+}
+
+type CurrencyService struct{}
+
+func NewCurrencyService() *CurrencyService {
+	return &CurrencyService{}
+}
+
+func (s *CurrencyService) GetCurrencyForCountry(countryCode string) Currency {
+	if currency, ok := CountryToCurrency[countryCode]; ok && IsSupportedCurrency(currency) {
+		return s.GetCurrency(currency)
+	}
+	return s.GetCurrency(BaseCurrency)
+}
+
+// GetCurrency returns code with its current rate, or BaseCurrency if code
+// isn't supported.
+func (s *CurrencyService) GetCurrency(code string) Currency {
+	rates := currentRates().Rates
+	if _, ok := rates[code]; !ok {
+		code = BaseCurrency
+	}
+	curr := CurrencyMap[code]
+	curr.Rate = rates[code]
+	return curr
+}
+
+// GetSupportedCurrencies lists the currencies there is a rate for, by
+// code.
+func (s *CurrencyService) GetSupportedCurrencies() []Currency {
+	rates := currentRates().Rates
+	currencies := make([]Currency, 0, len(rates))
+	for code, rate := range rates {
+		curr := CurrencyMap[code]
+		curr.Rate = rate
+		currencies = append(currencies, curr)
+	}
+	sort.Slice(currencies, func(i, j int) bool { return currencies[i].Code < currencies[j].Code })
+	return currencies
+}
+
+// ConvertPrice converts amount into toCurrency at the current rates,
+// rounded to the minor unit of toCurrency.
+func (s *CurrencyService) ConvertPrice(amount money.Money, toCurrency string) money.Money {
+	if amount.Currency() == toCurrency {
+		return amount
+	}
+	return amount.Convert(toCurrency, s.GetExchangeRate(amount.Currency(), toCurrency))
+}
+
+// FormatPrice renders amount with its currency symbol and as many decimals
+// as the currency has.
+func (s *CurrencyService) FormatPrice(amount money.Money) string {
+	return s.GetCurrency(amount.Currency()).Symbol + amount.String()
+}
+
+// GetExchangeRate returns units of toCurrency per unit of fromCurrency at
+// the current rates. A currency without a rate counts as BaseCurrency, so
+// callers check IsSupportedCurrency first.
+func (s *CurrencyService) GetExchangeRate(fromCurrency, toCurrency string) float64 {
+	rates := currentRates().Rates
+	fromRate := rates[fromCurrency]
+	toRate := rates[toCurrency]
+
+	if fromRate == 0 {
+		fromRate = 1
+	}
+	if toRate == 0 {
+		toRate = 1
+	}
+
+	return toRate / fromRate
+}
@@ -0,0 +1,416 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/config"
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/db"
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrInvalidRateTable = errors.New("invalid exchange rate table")
+
+// RateTable is a set of rates as a provider publishes it: units of each
+// currency per unit of Base, valid on AsOf.
+type RateTable struct {
+	Base  string
+	AsOf  time.Time
+	Rates map[string]float64
+}
+
+// RateProvider fetches the latest exchange rates. The ECB provider reads
+// the European Central Bank's daily reference rates; the file provider
+// reads the same XML, or JSON, from disk for machines without internet
+// access.
+type RateProvider interface {
+	Name() string
+	FetchRates(ctx context.Context) (*RateTable, error)
+}
+
+var (
+	defaultRateProvider     RateProvider
+	defaultRateProviderOnce sync.Once
+)
+
+// DefaultRateProvider returns the provider selected by
+// EXCHANGE_RATE_PROVIDER, or nil for "static", which keeps the bundled
+// rates.
+func DefaultRateProvider() RateProvider {
+	defaultRateProviderOnce.Do(func() {
+		cfg := config.LoadConfig()
+		switch cfg.ExchangeRateProvider {
+		case "static":
+		case "file":
+			defaultRateProvider = NewFileRateProvider(cfg.ExchangeRateFile)
+		default:
+			if cfg.ExchangeRateProvider != "ecb" {
+				log.Printf("Unknown exchange rate provider %q, using ecb", cfg.ExchangeRateProvider)
+			}
+			defaultRateProvider = NewECBRateProvider(cfg.ExchangeRateURL)
+		}
+	})
+	return defaultRateProvider
+}
+
+type ECBRateProvider struct {
+	url        string
+	httpClient *http.Client
+}
+
+func NewECBRateProvider(url string) *ECBRateProvider {
+	return &ECBRateProvider{
+		url:        url,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (p *ECBRateProvider) Name() string { return "ecb" }
+
+func (p *ECBRateProvider) FetchRates(ctx context.Context) (*RateTable, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "NEXUS-Shop/1.0")
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("ECB returned status %d", resp.StatusCode)
+	}
+	return parseECBRates(io.LimitReader(resp.Body, 1<<20))
+}
+
+// ecbEnvelope is the eurofxref format: a Cube per day holding a Cube per
+// currency, with rates against the euro.
+type ecbEnvelope struct {
+	Cube struct {
+		Days []struct {
+			Time  string `xml:"time,attr"`
+			Rates []struct {
+				Currency string  `xml:"currency,attr"`
+				Rate     float64 `xml:"rate,attr"`
+			} `xml:"Cube"`
+		} `xml:"Cube"`
+	} `xml:"Cube"`
+}
+
+// parseECBRates reads the most recent day of an eurofxref document.
+func parseECBRates(r io.Reader) (*RateTable, error) {
+	var envelope ecbEnvelope
+	if err := xml.NewDecoder(r).Decode(&envelope); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRateTable, err)
+	}
+	if len(envelope.Cube.Days) == 0 {
+		return nil, fmt.Errorf("%w: no rates", ErrInvalidRateTable)
+	}
+	day := envelope.Cube.Days[0]
+	asOf, err := time.Parse("2006-01-02", day.Time)
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad date %q", ErrInvalidRateTable, day.Time)
+	}
+
+	table := &RateTable{Base: "EUR", AsOf: asOf, Rates: make(map[string]float64, len(day.Rates))}
+	for _, rate := range day.Rates {
+		table.Rates[strings.ToUpper(rate.Currency)] = rate.Rate
+	}
+	return table, nil
+}
+
+// FileRateProvider reads rates from a file, either an eurofxref XML
+// document or JSON like
+//
+//	{"base": "EUR", "as_of": "2026-10-16", "rates": {"USD": 1.0842, ...}}
+//
+// The file is read again on every refresh, so it can be replaced while the
+// server runs.
+type FileRateProvider struct {
+	path string
+}
+
+func NewFileRateProvider(path string) *FileRateProvider {
+	return &FileRateProvider{path: path}
+}
+
+func (p *FileRateProvider) Name() string { return "file" }
+
+func (p *FileRateProvider) FetchRates(ctx context.Context) (*RateTable, error) {
+	if p.path == "" {
+		return nil, errors.New("EXCHANGE_RATE_FILE is not set")
+	}
+	data, err := os.ReadFile(p.path)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("<")) {
+		return parseECBRates(bytes.NewReader(data))
+	}
+
+	var file struct {
+		Base  string             `json:"base"`
+		AsOf  string             `json:"as_of"`
+		Rates map[string]float64 `json:"rates"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRateTable, err)
+	}
+	asOf, err := time.Parse("2006-01-02", file.AsOf)
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad date %q", ErrInvalidRateTable, file.AsOf)
+	}
+	table := &RateTable{Base: strings.ToUpper(file.Base), AsOf: asOf, Rates: make(map[string]float64, len(file.Rates))}
+	for code, rate := range file.Rates {
+		table.Rates[strings.ToUpper(code)] = rate
+	}
+	return table, nil
+}
+
+// RateSnapshot is the set of rates in use: units of each currency per unit
+// of BaseCurrency. Snapshots are never changed once in use, a refresh
+// swaps in a new one.
+type RateSnapshot struct {
+	Base      string             `json:"base"`
+	Source    string             `json:"source"`
+	AsOf      time.Time          `json:"as_of"`
+	FetchedAt time.Time          `json:"fetched_at"`
+	Rates     map[string]float64 `json:"rates"`
+}
+
+// RateStatus is the snapshot in use with how fresh it is. Rates are stale
+// once they are older than EXCHANGE_RATE_MAX_AGE_HOURS, which means
+// refreshes have been failing; the bundled rates are always stale.
+type RateStatus struct {
+	RateSnapshot
+	Stale      bool       `json:"stale"`
+	StaleAfter *time.Time `json:"stale_after,omitempty"`
+}
+
+const (
+	rateSourceBundled = "bundled"
+	redisRatesKey     = "exchange_rates:last_good"
+)
+
+var (
+	exchangeRates   = bundledRates()
+	exchangeRatesMu sync.RWMutex
+	// rateMaxAge is EXCHANGE_RATE_MAX_AGE_HOURS, set by StartRefreshJob.
+	rateMaxAge = 96 * time.Hour
+)
+
+// bundledRates are the rates shipped in CurrencyMap, used until a provider
+// has delivered.
+func bundledRates() *RateSnapshot {
+	snapshot := &RateSnapshot{Base: BaseCurrency, Source: rateSourceBundled, Rates: make(map[string]float64)}
+	for code, curr := range CurrencyMap {
+		if curr.Rate > 0 {
+			snapshot.Rates[code] = curr.Rate
+		}
+	}
+	return snapshot
+}
+
+func currentRates() *RateSnapshot {
+	exchangeRatesMu.RLock()
+	defer exchangeRatesMu.RUnlock()
+	return exchangeRates
+}
+
+func setRates(snapshot *RateSnapshot) {
+	exchangeRatesMu.Lock()
+	exchangeRates = snapshot
+	exchangeRatesMu.Unlock()
+}
+
+// RateStatus reports the rates in use and whether they are stale.
+func (s *CurrencyService) RateStatus() RateStatus {
+	snapshot := currentRates()
+	status := RateStatus{RateSnapshot: *snapshot, Stale: true}
+	if snapshot.Source != rateSourceBundled {
+		staleAfter := snapshot.AsOf.Add(rateMaxAge)
+		status.StaleAfter = &staleAfter
+		status.Stale = time.Now().After(staleAfter)
+	}
+	return status
+}
+
+// RefreshRates fetches rates from the configured provider and, if they
+// pass validation, puts them in use and stores them as the last good
+// rates. On failure the rates in use are kept.
+func (s *CurrencyService) RefreshRates() error {
+	provider := DefaultRateProvider()
+	if provider == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	table, err := provider.FetchRates(ctx)
+	if err != nil {
+		return err
+	}
+	snapshot, err := normalizeRates(table, provider.Name())
+	if err != nil {
+		return err
+	}
+	if current := currentRates(); current.Source != rateSourceBundled && snapshot.AsOf.Before(current.AsOf) {
+		return fmt.Errorf("%w: rates of %s are older than the %s ones in use", ErrInvalidRateTable,
+			snapshot.AsOf.Format("2006-01-02"), current.AsOf.Format("2006-01-02"))
+	}
+
+	setRates(snapshot)
+	saveLastGoodRates(ctx, snapshot)
+	return nil
+}
+
+// normalizeRates rebases a provider's table onto BaseCurrency and keeps the
+// currencies CurrencyMap knows. The table has to have a rate for
+// BaseCurrency, and no rate may be zero or negative.
+func normalizeRates(table *RateTable, source string) (*RateSnapshot, error) {
+	rates := make(map[string]float64, len(table.Rates)+1)
+	for code, rate := range table.Rates {
+		if rate <= 0 {
+			return nil, fmt.Errorf("%w: rate %g for %s", ErrInvalidRateTable, rate, code)
+		}
+		rates[code] = rate
+	}
+	rates[table.Base] = 1
+	base, ok := rates[BaseCurrency]
+	if !ok {
+		return nil, fmt.Errorf("%w: no rate for %s", ErrInvalidRateTable, BaseCurrency)
+	}
+
+	snapshot := &RateSnapshot{
+		Base:      BaseCurrency,
+		Source:    source,
+		AsOf:      table.AsOf,
+		FetchedAt: time.Now().UTC(),
+		Rates:     make(map[string]float64, len(rates)),
+	}
+	for code, rate := range rates {
+		if _, known := CurrencyMap[code]; known {
+			snapshot.Rates[code] = rate / base
+		}
+	}
+	return snapshot, nil
+}
+
+// saveLastGoodRates stores snapshot in Redis, where the other instances
+// pick it up on start, and in Postgres, which outlives a Redis flush.
+// Failing to store only costs a restart the fresh rates, so errors are
+// logged.
+func saveLastGoodRates(ctx context.Context, snapshot *RateSnapshot) {
+	if db.Redis != nil {
+		if data, err := json.Marshal(snapshot); err == nil {
+			if err := db.Redis.Set(ctx, redisRatesKey, data, 0).Err(); err != nil {
+				log.Printf("Failed to store exchange rates in Redis: %v", err)
+			}
+		}
+	}
+	if db.DB == nil {
+		return
+	}
+	err := db.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		codes := make([]string, 0, len(snapshot.Rates))
+		rows := make([]models.ExchangeRate, 0, len(snapshot.Rates))
+		for code, rate := range snapshot.Rates {
+			codes = append(codes, code)
+			rows = append(rows, models.ExchangeRate{
+				Currency:  code,
+				Base:      snapshot.Base,
+				Rate:      rate,
+				Source:    snapshot.Source,
+				AsOf:      snapshot.AsOf,
+				FetchedAt: snapshot.FetchedAt,
+			})
+		}
+		if err := tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(&rows).Error; err != nil {
+			return err
+		}
+		return tx.Where("currency NOT IN ?", codes).Delete(&models.ExchangeRate{}).Error
+	})
+	if err != nil {
+		log.Printf("Failed to store exchange rates: %v", err)
+	}
+}
+
+// loadLastGoodRates puts the last stored rates in use, from Redis or else
+// Postgres, so a restart doesn't fall back to the bundled rates while the
+// provider is unreachable.
+func loadLastGoodRates(ctx context.Context) {
+	if db.Redis != nil {
+		if data, err := db.Redis.Get(ctx, redisRatesKey).Bytes(); err == nil {
+			var snapshot RateSnapshot
+			if err := json.Unmarshal(data, &snapshot); err == nil && snapshot.Base == BaseCurrency && len(snapshot.Rates) > 0 {
+				setRates(&snapshot)
+				return
+			}
+		}
+	}
+	if db.DB == nil {
+		return
+	}
+	var rows []models.ExchangeRate
+	if err := db.DB.WithContext(ctx).Where("base = ?", BaseCurrency).Find(&rows).Error; err != nil {
+		log.Printf("Failed to load stored exchange rates: %v", err)
+		return
+	}
+	if len(rows) == 0 {
+		return
+	}
+	snapshot := &RateSnapshot{Base: BaseCurrency, Rates: make(map[string]float64, len(rows))}
+	for _, row := range rows {
+		snapshot.Rates[row.Currency] = row.Rate
+		snapshot.Source, snapshot.AsOf, snapshot.FetchedAt = row.Source, row.AsOf, row.FetchedAt
+	}
+	setRates(snapshot)
+}
+
+// StartRefreshJob loads the last good rates and then refreshes them every
+// EXCHANGE_RATE_REFRESH_MINUTES, starting right away.
+func (s *CurrencyService) StartRefreshJob() {
+	cfg := config.LoadConfig()
+	if cfg.ExchangeRateMaxAgeHours > 0 {
+		rateMaxAge = time.Duration(cfg.ExchangeRateMaxAgeHours) * time.Hour
+	}
+	loadLastGoodRates(context.Background())
+	if DefaultRateProvider() == nil {
+		return
+	}
+
+	interval := time.Duration(cfg.ExchangeRateRefreshMins) * time.Minute
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	refresh := func() {
+		if err := s.RefreshRates(); err != nil {
+			current := currentRates()
+			log.Printf("Exchange rate refresh failed: %v, keeping %s rates as of %s", err, current.Source, current.AsOf.Format("2006-01-02"))
+		}
+	}
+
+	go func() {
+		refresh()
+		ticker := time.NewTicker(interval)
+		for range ticker.C {
+			refresh()
+		}
+	}()
+}
@@ -0,0 +1,300 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type FakeOutcome string
+
+const (
+	FakeApprove       FakeOutcome = "approve"
+	FakeDecline       FakeOutcome = "decline"
+	FakeRequireAction FakeOutcome = "requires_action"
+	FakeTimeout       FakeOutcome = "timeout"
+)
+
+// Payment method IDs the fake understands when nothing is scripted, named
+// after Stripe's test cards so the frontend works against either gateway.
+var fakePaymentMethods = map[string]FakeOutcome{
+	"pm_card_declined":               FakeDecline,
+	"pm_card_chargeDeclined":         FakeDecline,
+	"pm_card_authenticationRequired": FakeRequireAction,
+	"pm_card_threeDSecureRequired":   FakeRequireAction,
+	"pm_card_timeout":                FakeTimeout,
+}
+
+// FakeGateway is an in-process PaymentGateway for development and tests.
+// Outcomes of Confirm can be queued with Script; otherwise they follow the
+// payment method ID. A FakeTimeout authorizes the intent but reports a
+// timeout, like a response lost on the wire.
+type FakeGateway struct {
+	webhookSecret string
+
+	mu      sync.Mutex
+	seq     int
+	intents map[string]*PaymentIntent
+	byKey   map[string]string
+	refunds map[string][]PaymentRefund
+	script  []FakeOutcome
+	failOps map[string]error
+}
+
+func NewFakeGateway(webhookSecret string) *FakeGateway {
+	return &FakeGateway{
+		webhookSecret: webhookSecret,
+		intents:       make(map[string]*PaymentIntent),
+		byKey:         make(map[string]string),
+		refunds:       make(map[string][]PaymentRefund),
+		failOps:       make(map[string]error),
+	}
+}
+
+func (g *FakeGateway) Name() string { return "fake" }
+
+// Script queues outcomes for the next Confirm calls, in order.
+func (g *FakeGateway) Script(outcomes ...FakeOutcome) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.script = append(g.script, outcomes...)
+}
+
+// FailNext makes the next call of op ("create", "confirm", "capture",
+// "refund" or "void") return err without changing any state.
+func (g *FakeGateway) FailNext(op string, err error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.failOps[op] = err
+}
+
+// CompleteAction simulates the customer finishing (or failing) 3-D Secure.
+func (g *FakeGateway) CompleteAction(intentID string, approve bool) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	intent, ok := g.intents[intentID]
+	if !ok {
+		return ErrPaymentIntentNotFound
+	}
+	if intent.Status != IntentRequiresAction {
+		return fmt.Errorf("intent %s is %s, not awaiting action", intentID, intent.Status)
+	}
+	if approve {
+		intent.Status = IntentRequiresCapture
+	} else {
+		intent.Status = IntentRequiresPaymentMethod
+	}
+	intent.NextActionURL = ""
+	return nil
+}
+
+func (g *FakeGateway) CreateIntent(ctx context.Context, params CreateIntentParams) (*PaymentIntent, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if err := g.takeFailure("create"); err != nil {
+		return nil, err
+	}
+
+	if id, ok := g.byKey[params.IdempotencyKey]; ok && params.IdempotencyKey != "" {
+		copied := *g.intents[id]
+		return &copied, nil
+	}
+
+	g.seq++
+	id := fmt.Sprintf("pi_fake_%d", g.seq)
+	intent := &PaymentIntent{
+		ID:           id,
+		Status:       IntentRequiresPaymentMethod,
+		Amount:       params.Amount,
+		Currency:     strings.ToLower(params.Currency),
+		ClientSecret: id + "_secret",
+	}
+	g.intents[id] = intent
+	if params.IdempotencyKey != "" {
+		g.byKey[params.IdempotencyKey] = id
+	}
+
+	copied := *intent
+	return &copied, nil
+}
+
+func (g *FakeGateway) GetIntent(ctx context.Context, intentID string) (*PaymentIntent, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	intent, ok := g.intents[intentID]
+	if !ok {
+		return nil, ErrPaymentIntentNotFound
+	}
+	copied := *intent
+	return &copied, nil
+}
+
+func (g *FakeGateway) Confirm(ctx context.Context, intentID, paymentMethodID string) (*PaymentIntent, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if err := g.takeFailure("confirm"); err != nil {
+		return nil, err
+	}
+
+	intent, ok := g.intents[intentID]
+	if !ok {
+		return nil, ErrPaymentIntentNotFound
+	}
+	if intent.Status != IntentRequiresPaymentMethod && intent.Status != IntentRequiresConfirmation {
+		return nil, fmt.Errorf("intent %s cannot be confirmed in status %s", intentID, intent.Status)
+	}
+
+	outcome := FakeApprove
+	if len(g.script) > 0 {
+		outcome, g.script = g.script[0], g.script[1:]
+	} else if o, ok := fakePaymentMethods[paymentMethodID]; ok {
+		outcome = o
+	}
+
+	switch outcome {
+	case FakeDecline:
+		intent.Status = IntentRequiresPaymentMethod
+		return nil, fmt.Errorf("%w: card was declined", ErrPaymentDeclined)
+	case FakeRequireAction:
+		intent.Status = IntentRequiresAction
+		intent.NextActionURL = "https://fake-gateway.local/3ds/" + intentID
+	case FakeTimeout:
+		intent.Status = IntentRequiresCapture
+		return nil, ErrPaymentTimeout
+	default:
+		intent.Status = IntentRequiresCapture
+	}
+
+	copied := *intent
+	return &copied, nil
+}
+
+func (g *FakeGateway) Capture(ctx context.Context, intentID string, amount int64) (*PaymentIntent, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if err := g.takeFailure("capture"); err != nil {
+		return nil, err
+	}
+
+	intent, ok := g.intents[intentID]
+	if !ok {
+		return nil, ErrPaymentIntentNotFound
+	}
+	if intent.Status != IntentRequiresCapture {
+		return nil, fmt.Errorf("intent %s cannot be captured in status %s", intentID, intent.Status)
+	}
+	if amount <= 0 || amount > intent.Amount {
+		amount = intent.Amount
+	}
+	intent.AmountCaptured = amount
+	intent.Status = IntentSucceeded
+
+	copied := *intent
+	return &copied, nil
+}
+
+func (g *FakeGateway) Refund(ctx context.Context, intentID string, amount int64) (*PaymentRefund, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if err := g.takeFailure("refund"); err != nil {
+		return nil, err
+	}
+
+	intent, ok := g.intents[intentID]
+	if !ok {
+		return nil, ErrPaymentIntentNotFound
+	}
+	if intent.Status != IntentSucceeded {
+		return nil, fmt.Errorf("intent %s has not been captured", intentID)
+	}
+
+	var refunded int64
+	for _, r := range g.refunds[intentID] {
+		refunded += r.Amount
+	}
+	if amount <= 0 {
+		amount = intent.AmountCaptured - refunded
+	}
+	if refunded+amount > intent.AmountCaptured {
+		return nil, fmt.Errorf("refund of %d exceeds remaining captured amount %d", amount, intent.AmountCaptured-refunded)
+	}
+
+	refund := PaymentRefund{
+		ID:       fmt.Sprintf("re_fake_%s_%d", intentID, len(g.refunds[intentID])+1),
+		IntentID: intentID,
+		Amount:   amount,
+		Status:   IntentSucceeded,
+	}
+	g.refunds[intentID] = append(g.refunds[intentID], refund)
+	return &refund, nil
+}
+
+func (g *FakeGateway) Void(ctx context.Context, intentID string) (*PaymentIntent, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if err := g.takeFailure("void"); err != nil {
+		return nil, err
+	}
+
+	intent, ok := g.intents[intentID]
+	if !ok {
+		return nil, ErrPaymentIntentNotFound
+	}
+	if intent.Status == IntentSucceeded {
+		return nil, fmt.Errorf("intent %s is already captured, refund it instead", intentID)
+	}
+	intent.Status = IntentCanceled
+
+	copied := *intent
+	return &copied, nil
+}
+
+// fakeEvent is the fake's webhook body; its type is already one of the
+// normalized kinds.
+type fakeEvent struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	IntentID string `json:"intent_id"`
+	Amount   int64  `json:"amount"`
+}
+
+// SignWebhook returns the Fake-Signature header value for payload, so
+// tests and local tooling can produce valid deliveries.
+func (g *FakeGateway) SignWebhook(payload []byte, now time.Time) string {
+	ts := strconv.FormatInt(now.Unix(), 10)
+	return "t=" + ts + ",v1=" + signPayload(ts, payload, g.webhookSecret)
+}
+
+func (g *FakeGateway) ParseWebhook(payload []byte, headers http.Header) (*GatewayEvent, error) {
+	if !verifySignedPayload(headers.Get("Fake-Signature"), payload, g.webhookSecret, time.Now()) {
+		return nil, ErrInvalidWebhook
+	}
+
+	var fe fakeEvent
+	if err := json.Unmarshal(payload, &fe); err != nil || fe.ID == "" {
+		return nil, ErrInvalidWebhook
+	}
+
+	event := &GatewayEvent{ID: fe.ID, Type: fe.Type, IntentID: fe.IntentID, Amount: fe.Amount}
+	switch fe.Type {
+	case PaymentEventAuthorized, PaymentEventCaptured, PaymentEventFailed, PaymentEventRefunded, PaymentEventDisputed:
+		event.Kind = fe.Type
+	}
+	return event, nil
+}
+
+func (g *FakeGateway) takeFailure(op string) error {
+	err, ok := g.failOps[op]
+	if !ok {
+		return nil
+	}
+	delete(g.failOps, op)
+	return err
+}
@@ -0,0 +1,249 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/db"
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/money"
+	"github.com/redis/go-redis/v9"
+)
+
+// guestCartLine is one variant in a guest cart. Price is the variant's
+// price when the line was last added or changed, like CartItem.PriceAtAdd.
+type guestCartLine struct {
+	Quantity int
+	Price    money.Money
+}
+
+// guestCartStore keeps the carts of visitors who aren't signed in. Every
+// write extends the cart's TTL. Redis is used when connected; process
+// memory is the fallback.
+type guestCartStore interface {
+	Items(ctx context.Context, cartID string) (map[uint]guestCartLine, error)
+	// Set stores the quantity and price of a line, replacing what was there.
+	Set(ctx context.Context, cartID string, variantID uint, line guestCartLine) error
+	Remove(ctx context.Context, cartID string, variantID uint) error
+	Coupons(ctx context.Context, cartID string) ([]string, error)
+	SetCoupons(ctx context.Context, cartID string, codes []string) error
+	// Take deletes the cart and returns what was in it, in one step, so
+	// that a cart can only be taken once.
+	Take(ctx context.Context, cartID string) (map[uint]guestCartLine, []string, error)
+}
+
+func currentGuestCartStore(ttl time.Duration) guestCartStore {
+	if db.Redis != nil {
+		return &redisGuestCartStore{client: db.Redis, ttl: ttl}
+	}
+	return &memoryGuestCartStore{ttl: ttl, guestCartMemory: localGuestCarts}
+}
+
+// Each guest cart is a Redis hash with a "<variant id>" field for the
+// quantity and a "<variant id>:price" field for the price of each line as
+// a decimal in BaseCurrency, plus a "coupons" field with the applied codes, comma-separated.
+const (
+	redisGuestCartPrefix       = "cart:guest:"
+	redisGuestCartPriceField   = ":price"
+	redisGuestCartCouponsField = "coupons"
+)
+
+type redisGuestCartStore struct {
+	client *redis.Client
+	ttl    time.Duration
+}
+
+func (s *redisGuestCartStore) Items(ctx context.Context, cartID string) (map[uint]guestCartLine, error) {
+	values, err := s.client.HGetAll(ctx, redisGuestCartPrefix+cartID).Result()
+	if err != nil {
+		return nil, err
+	}
+	return parseRedisGuestCart(values), nil
+}
+
+func parseRedisGuestCart(values map[string]string) map[uint]guestCartLine {
+	items := make(map[uint]guestCartLine, len(values)/2)
+	for field, value := range values {
+		if strings.HasSuffix(field, redisGuestCartPriceField) {
+			continue
+		}
+		variantID, err := strconv.ParseUint(field, 10, 64)
+		if err != nil {
+			continue
+		}
+		qty, err := strconv.Atoi(value)
+		if err != nil || qty <= 0 {
+			continue
+		}
+		price, _ := money.Parse(values[field+redisGuestCartPriceField], BaseCurrency)
+		items[uint(variantID)] = guestCartLine{Quantity: qty, Price: price}
+	}
+	return items
+}
+
+func (s *redisGuestCartStore) Set(ctx context.Context, cartID string, variantID uint, line guestCartLine) error {
+	key := redisGuestCartPrefix + cartID
+	field := strconv.FormatUint(uint64(variantID), 10)
+	pipe := s.client.TxPipeline()
+	pipe.HSet(ctx, key, field, line.Quantity, field+redisGuestCartPriceField, line.Price.String())
+	pipe.Expire(ctx, key, s.ttl)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (s *redisGuestCartStore) Remove(ctx context.Context, cartID string, variantID uint) error {
+	key := redisGuestCartPrefix + cartID
+	field := strconv.FormatUint(uint64(variantID), 10)
+	pipe := s.client.TxPipeline()
+	pipe.HDel(ctx, key, field, field+redisGuestCartPriceField)
+	pipe.Expire(ctx, key, s.ttl)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (s *redisGuestCartStore) Coupons(ctx context.Context, cartID string) ([]string, error) {
+	value, err := s.client.HGet(ctx, redisGuestCartPrefix+cartID, redisGuestCartCouponsField).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil || value == "" {
+		return nil, err
+	}
+	return strings.Split(value, ","), nil
+}
+
+func (s *redisGuestCartStore) SetCoupons(ctx context.Context, cartID string, codes []string) error {
+	key := redisGuestCartPrefix + cartID
+	pipe := s.client.TxPipeline()
+	if len(codes) == 0 {
+		pipe.HDel(ctx, key, redisGuestCartCouponsField)
+	} else {
+		pipe.HSet(ctx, key, redisGuestCartCouponsField, strings.Join(codes, ","))
+	}
+	pipe.Expire(ctx, key, s.ttl)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// Take reads and deletes the hash in one MULTI/EXEC, which Redis runs
+// without anything in between.
+func (s *redisGuestCartStore) Take(ctx context.Context, cartID string) (map[uint]guestCartLine, []string, error) {
+	key := redisGuestCartPrefix + cartID
+	pipe := s.client.TxPipeline()
+	all := pipe.HGetAll(ctx, key)
+	pipe.Del(ctx, key)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, nil, err
+	}
+	values := all.Val()
+	var coupons []string
+	if value := values[redisGuestCartCouponsField]; value != "" {
+		coupons = strings.Split(value, ",")
+	}
+	return parseRedisGuestCart(values), coupons, nil
+}
+
+type memoryGuestCart struct {
+	items     map[uint]guestCartLine
+	coupons   []string
+	expiresAt time.Time
+}
+
+type guestCartMemory struct {
+	mu    sync.Mutex
+	carts map[string]*memoryGuestCart
+}
+
+// localGuestCarts is shared by every CartService so that guest carts
+// survive between requests without Redis.
+var localGuestCarts = &guestCartMemory{carts: make(map[string]*memoryGuestCart)}
+
+type memoryGuestCartStore struct {
+	ttl time.Duration
+	*guestCartMemory
+}
+
+// cart returns the live cart for cartID, dropping expired carts on the way.
+// The caller holds s.mu.
+func (s *memoryGuestCartStore) cart(cartID string, create bool) *memoryGuestCart {
+	now := time.Now()
+	for id, cart := range s.carts {
+		if cart.expiresAt.Before(now) {
+			delete(s.carts, id)
+		}
+	}
+	cart := s.carts[cartID]
+	if cart == nil && create {
+		cart = &memoryGuestCart{items: make(map[uint]guestCartLine)}
+		s.carts[cartID] = cart
+	}
+	if cart != nil && create {
+		cart.expiresAt = now.Add(s.ttl)
+	}
+	return cart
+}
+
+func (s *memoryGuestCartStore) Items(ctx context.Context, cartID string) (map[uint]guestCartLine, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	items := make(map[uint]guestCartLine)
+	if cart := s.cart(cartID, false); cart != nil {
+		for id, line := range cart.items {
+			items[id] = line
+		}
+	}
+	return items, nil
+}
+
+func (s *memoryGuestCartStore) Set(ctx context.Context, cartID string, variantID uint, line guestCartLine) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.cart(cartID, true).items[variantID] = line
+	return nil
+}
+
+func (s *memoryGuestCartStore) Remove(ctx context.Context, cartID string, variantID uint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if cart := s.cart(cartID, false); cart != nil {
+		delete(cart.items, variantID)
+		cart.expiresAt = time.Now().Add(s.ttl)
+	}
+	return nil
+}
+
+func (s *memoryGuestCartStore) Coupons(ctx context.Context, cartID string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if cart := s.cart(cartID, false); cart != nil {
+		return append([]string(nil), cart.coupons...), nil
+	}
+	return nil, nil
+}
+
+func (s *memoryGuestCartStore) SetCoupons(ctx context.Context, cartID string, codes []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.cart(cartID, true).coupons = append([]string(nil), codes...)
+	return nil
+}
+
+func (s *memoryGuestCartStore) Take(ctx context.Context, cartID string) (map[uint]guestCartLine, []string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cart := s.cart(cartID, false)
+	if cart == nil {
+		return map[uint]guestCartLine{}, nil, nil
+	}
+	delete(s.carts, cartID)
+	return cart.items, cart.coupons, nil
+}
@@ -2,6 +2,7 @@ package services
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"time"
 
@@ -38,6 +39,9 @@ func (h *HealthService) CheckHealth() HealthResponse {
 
 	response.Components["database"] = dbHealth
 	response.Components["redis"] = redisHealth
+	// Stale rates are reported but don't fail the check: prices keep
+	// working off the last good rates.
+	response.Components["exchange_rates"] = h.checkExchangeRates()
 
 	if dbHealth.Status != "healthy" || redisHealth.Status != "healthy" {
 		response.Status = "unhealthy"
@@ -82,6 +86,17 @@ func (h *HealthService) checkRedis() ComponentHealth {
 	return health
 }
 
+func (h *HealthService) checkExchangeRates() ComponentHealth {
+	status := NewCurrencyService().RateStatus()
+	if !status.Stale {
+		return ComponentHealth{Status: "healthy"}
+	}
+	return ComponentHealth{
+		Status: "stale",
+		Error:  fmt.Sprintf("using %s rates as of %s", status.Source, status.AsOf.Format("2006-01-02")),
+	}
+}
+
 func (h *HealthService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
@@ -6,6 +6,7 @@ import (
 
 	"github.com/jeremy/ai-autonomous-webshop/backend/internal/db"
 	"github.com/jeremy/ai-autonomous-webshop/backend/internal/models"
+	"gorm.io/gorm"
 )
 
 type InventoryService struct{}
@@ -13,27 +14,40 @@ type InventoryService struct{}
 func (s *InventoryService) CheckAndRestock() {
 	log.Println("AI Agent: Checking inventory levels for autonomous restock...")
 
-	var products []models.Product
-	if err := db.DB.Find(&products).Error; err != nil {
-		log.Printf("Error fetching products for inventory check: %v", err)
+	var variants []models.ProductVariant
+	if err := db.DB.Preload("Options", orderedOptionValues).
+		Joins("JOIN products ON products.id = product_variants.product_id AND products.deleted_at IS NULL").
+		Where("product_variants.stock < ?", 5).Order("product_variants.id").Find(&variants).Error; err != nil {
+		log.Printf("Error fetching variants for inventory check: %v", err)
 		return
 	}
 
-	for _, p := range products {
-		if p.Stock < 5 {
-			log.Printf("AI Agent: Low stock detected for %s (%d). Ordering 50 units from supplier...", p.Name, p.Stock)
-			
-			// Mock supplier order delay
-			time.Sleep(500 * time.Millisecond)
-
-			p.Stock += 50
-			db.DB.Save(&p)
-			
-			log.Printf("AI Agent: Restock complete for %s. New stock: %d", p.Name, p.Stock)
-			
-			// Notify Admin
-			Notifier.NotifyUser(1, "INVENTORY_RESTOCK", "Autonomous restock complete for "+p.Name, p)
+	for _, v := range variants {
+		var p models.Product
+		if err := db.DB.First(&p, v.ProductID).Error; err != nil {
+			continue
 		}
+		name := p.Name
+		if title := v.Title(); title != "" {
+			name += " (" + title + ")"
+		}
+		log.Printf("AI Agent: Low stock detected for %s [%s] (%d). Ordering 50 units from supplier...", name, v.SKU, v.Stock)
+
+		// Mock supplier order delay
+		time.Sleep(500 * time.Millisecond)
+
+		err := db.DB.Transaction(func(tx *gorm.DB) error {
+			return restock(tx, map[uint]int{v.ID: 50})
+		})
+		if err != nil {
+			log.Printf("AI Agent: Restock of %s failed: %v", name, err)
+			continue
+		}
+
+		log.Printf("AI Agent: Restock complete for %s. New stock: %d", name, v.Stock+50)
+
+		// Notify Admin
+		Notifier.NotifyUser(1, "INVENTORY_RESTOCK", "Autonomous restock complete for "+name, v)
 	}
 }
 
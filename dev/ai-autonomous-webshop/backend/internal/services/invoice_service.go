@@ -0,0 +1,530 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/config"
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/db"
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/models"
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/money"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrInvoiceNotFound  = errors.New("invoice not found")
+	ErrOrderNotInvoiced = errors.New("order has not been paid, so it has no invoice")
+)
+
+// Document number prefixes; numbers look like INV-2026-000042.
+var invoicePrefixes = map[string]string{
+	models.InvoiceTypeInvoice:    "INV",
+	models.InvoiceTypeCreditNote: "CN",
+}
+
+// InvoiceService hands out the invoices and credit notes of orders. They
+// are issued as part of the transaction that pays or refunds the order;
+// documents missing because that failed, or because the order predates
+// invoicing, are issued the first time someone asks for them.
+type InvoiceService struct{}
+
+func NewInvoiceService() *InvoiceService {
+	return &InvoiceService{}
+}
+
+// Invoice returns the invoice of an order. userID restricts it to the
+// owner's orders; zero means any order (admin).
+func (s *InvoiceService) Invoice(ctx context.Context, orderID, userID uint) (*models.Invoice, error) {
+	documents, err := s.ensureDocuments(ctx, orderID, userID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range documents {
+		if documents[i].Type == models.InvoiceTypeInvoice {
+			return &documents[i], nil
+		}
+	}
+	return nil, ErrInvoiceNotFound
+}
+
+// Documents lists the invoice and credit notes of an order, oldest first,
+// without their PDFs.
+func (s *InvoiceService) Documents(ctx context.Context, orderID, userID uint) ([]models.Invoice, error) {
+	documents, err := s.ensureDocuments(ctx, orderID, userID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range documents {
+		documents[i].PDF = nil
+	}
+	return documents, nil
+}
+
+// Document returns one invoice or credit note of an order.
+func (s *InvoiceService) Document(ctx context.Context, orderID, userID, documentID uint) (*models.Invoice, error) {
+	documents, err := s.ensureDocuments(ctx, orderID, userID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range documents {
+		if documents[i].ID == documentID {
+			return &documents[i], nil
+		}
+	}
+	return nil, ErrInvoiceNotFound
+}
+
+func (s *InvoiceService) ensureDocuments(ctx context.Context, orderID, userID uint) ([]models.Invoice, error) {
+	var documents []models.Invoice
+	err := db.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var order models.Order
+		if err := lockOrder(tx, &order, orderID, userID); err != nil {
+			return err
+		}
+		if order.PaidAt == nil {
+			return ErrOrderNotInvoiced
+		}
+		if _, err := issueInvoice(tx, &order); err != nil {
+			return err
+		}
+
+		var refunds []models.Refund
+		if err := tx.Where("order_id = ? AND id NOT IN (?)", order.ID,
+			tx.Model(&models.Invoice{}).Select("refund_id").Where("order_id = ? AND refund_id IS NOT NULL", order.ID)).
+			Order("id").Find(&refunds).Error; err != nil {
+			return err
+		}
+		for i := range refunds {
+			if _, err := issueCreditNote(tx, &order, &refunds[i]); err != nil {
+				return err
+			}
+		}
+
+		return tx.Where("order_id = ?", order.ID).Order("id").Find(&documents).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return documents, nil
+}
+
+// issueInvoice issues the invoice of a paid order, or returns the one it
+// already has.
+func issueInvoice(tx *gorm.DB, order *models.Order) (*models.Invoice, error) {
+	var existing models.Invoice
+	err := tx.Where("order_id = ? AND type = ?", order.ID, models.InvoiceTypeInvoice).First(&existing).Error
+	if err == nil {
+		return &existing, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	full, buyer, err := loadInvoiceOrder(tx, order.ID)
+	if err != nil {
+		return nil, err
+	}
+	invoice, err := newInvoice(tx, models.InvoiceTypeInvoice, full)
+	if err != nil {
+		return nil, err
+	}
+	currency := currencyOf(full)
+	invoice.Net = full.PresentmentSubtotal.Sub(full.PresentmentDiscountTotal).Add(full.PresentmentShippingTotal)
+	invoice.TaxTotal = full.PresentmentTaxTotal
+	invoice.Total = full.PresentmentTotal
+
+	view := invoiceView{
+		Title:      "Invoice",
+		Number:     invoice.Number,
+		IssuedAt:   invoice.IssuedAt,
+		References: []string{fmt.Sprintf("Order #%d", full.ID)},
+		Buyer:      buyer,
+	}
+	if full.PaidAt != nil {
+		view.References = append(view.References, "Paid "+full.PaidAt.Format("2006-01-02"))
+	}
+	for _, item := range full.Items {
+		view.Lines = append(view.Lines, invoiceViewLine{
+			Description: item.ProductName,
+			Quantity:    item.Quantity,
+			UnitPrice:   item.PresentmentPrice,
+			Discount:    item.PresentmentDiscount,
+			Tax:         item.PresentmentTaxAmount,
+			Amount:      item.PresentmentPrice.Times(int64(item.Quantity)).Sub(item.PresentmentDiscount),
+		})
+	}
+	if full.ShippingTotal.IsPositive() || full.ShippingMethod != "" {
+		view.Lines = append(view.Lines, invoiceViewLine{
+			Description: strings.TrimSpace("Shipping " + full.ShippingMethod),
+			Quantity:    1,
+			UnitPrice:   full.PresentmentShippingTotal,
+			Tax:         currency.present(full.ShippingTax),
+			Amount:      full.PresentmentShippingTotal,
+		})
+	}
+	view.Totals = append(view.Totals, invoiceViewTotal{Label: "Subtotal", Amount: full.PresentmentSubtotal})
+	if full.PresentmentDiscountTotal.IsPositive() {
+		view.Totals = append(view.Totals, invoiceViewTotal{Label: "Discount", Amount: full.PresentmentDiscountTotal.Neg()})
+	}
+	if full.ShippingTotal.IsPositive() || full.ShippingMethod != "" {
+		view.Totals = append(view.Totals, invoiceViewTotal{Label: "Shipping", Amount: full.PresentmentShippingTotal})
+	}
+	view.Totals = append(view.Totals, invoiceViewTotal{Label: "Net total", Amount: invoice.Net})
+	for _, line := range full.TaxLines {
+		view.Totals = append(view.Totals, invoiceViewTotal{Label: taxLineLabel(line.Name, line.Rate, line.PresentmentTaxableAmount), Amount: line.PresentmentAmount})
+	}
+	view.Totals = append(view.Totals, invoiceViewTotal{Label: "Total", Amount: full.PresentmentTotal, Bold: true})
+	if full.ReverseCharge {
+		view.Notes = append(view.Notes, reverseChargeNote)
+	}
+	if note := exchangeRateNote(full, full.TaxTotal, full.Total); note != "" {
+		view.Notes = append(view.Notes, note)
+	}
+
+	invoice.PDF = renderInvoicePDF(view)
+	if err := tx.Create(invoice).Error; err != nil {
+		return nil, err
+	}
+	return invoice, nil
+}
+
+// issueCreditNote issues the credit note of a refund. A refund is part of
+// the order's total, so it is split into net and tax in the same
+// proportions as the order.
+func issueCreditNote(tx *gorm.DB, order *models.Order, refund *models.Refund) (*models.Invoice, error) {
+	invoice, err := issueInvoice(tx, order)
+	if err != nil {
+		return nil, err
+	}
+	full, buyer, err := loadInvoiceOrder(tx, order.ID)
+	if err != nil {
+		return nil, err
+	}
+	note, err := newInvoice(tx, models.InvoiceTypeCreditNote, full)
+	if err != nil {
+		return nil, err
+	}
+	note.RefundID = &refund.ID
+	note.InvoiceID = &invoice.ID
+
+	share := big.NewRat(1, 1)
+	if full.PresentmentTotal.IsPositive() && refund.Amount.LessThan(full.PresentmentTotal) {
+		share = big.NewRat(refund.Amount.Minor(), full.PresentmentTotal.Minor())
+	}
+	var taxTotals []invoiceViewTotal
+	note.TaxTotal = currencyOf(full).zero()
+	baseTax := money.Zero(full.BaseCurrency)
+	for _, line := range full.TaxLines {
+		amount := line.PresentmentAmount.MulRat(share)
+		note.TaxTotal = note.TaxTotal.Add(amount)
+		baseTax = baseTax.Add(line.Amount.MulRat(share))
+		taxTotals = append(taxTotals, invoiceViewTotal{
+			Label:  taxLineLabel(line.Name, line.Rate, line.PresentmentTaxableAmount.MulRat(share)),
+			Amount: amount,
+		})
+	}
+	note.Total = refund.Amount
+	note.Net = refund.Amount.Sub(note.TaxTotal)
+
+	description := "Refund"
+	if refund.Reason != "" {
+		description += ": " + refund.Reason
+	}
+	view := invoiceView{
+		Title:    "Credit note",
+		Number:   note.Number,
+		IssuedAt: note.IssuedAt,
+		References: []string{
+			fmt.Sprintf("Order #%d", full.ID),
+			"Corrects invoice " + invoice.Number,
+		},
+		Buyer: buyer,
+		Lines: []invoiceViewLine{{
+			Description: description,
+			Quantity:    1,
+			UnitPrice:   note.Net,
+			Tax:         note.TaxTotal,
+			Amount:      note.Net,
+		}},
+	}
+	view.Totals = append(view.Totals, invoiceViewTotal{Label: "Net credited", Amount: note.Net})
+	view.Totals = append(view.Totals, taxTotals...)
+	view.Totals = append(view.Totals, invoiceViewTotal{Label: "Total credited", Amount: note.Total, Bold: true})
+	if full.ReverseCharge {
+		view.Notes = append(view.Notes, reverseChargeNote)
+	}
+	if rate := exchangeRateNote(full, baseTax, refund.BaseAmount); rate != "" {
+		view.Notes = append(view.Notes, rate)
+	}
+	view.Notes = append(view.Notes, "The amount credited is refunded to the original payment method.")
+
+	note.PDF = renderInvoicePDF(view)
+	if err := tx.Create(note).Error; err != nil {
+		return nil, err
+	}
+	return note, nil
+}
+
+// issueDocument runs issue in a savepoint so that a failure to produce a
+// document doesn't undo the payment or refund it is for. The document is
+// issued later, when it is first asked for.
+func issueDocument(tx *gorm.DB, orderID uint, issue func(tx *gorm.DB) error) {
+	if err := tx.Transaction(issue); err != nil {
+		log.Printf("Failed to issue invoice document for order %d: %v", orderID, err)
+	}
+}
+
+// newInvoice numbers a new document of type typ for order.
+func newInvoice(tx *gorm.DB, typ string, order *models.Order) (*models.Invoice, error) {
+	now := time.Now().UTC()
+	sequence, err := nextInvoiceSequence(tx, typ, now.Year())
+	if err != nil {
+		return nil, err
+	}
+	return &models.Invoice{
+		Type:     typ,
+		Year:     now.Year(),
+		Sequence: sequence,
+		Number:   fmt.Sprintf("%s-%d-%06d", invoicePrefixes[typ], now.Year(), sequence),
+		OrderID:  order.ID,
+		UserID:   order.UserID,
+		Currency: order.Currency,
+		IssuedAt: now,
+	}, nil
+}
+
+// nextInvoiceSequence takes the next number of a type and year. The
+// upsert keeps the sequence row locked until tx ends.
+func nextInvoiceSequence(tx *gorm.DB, typ string, year int) (int, error) {
+	var number int
+	err := tx.Raw(`INSERT INTO invoice_sequences (type, year, last_number) VALUES (?, ?, 1)
+		ON CONFLICT (type, year) DO UPDATE SET last_number = invoice_sequences.last_number + 1
+		RETURNING last_number`, typ, year).Scan(&number).Error
+	return number, err
+}
+
+// loadInvoiceOrder loads an order with what goes on its documents, and the
+// buyer's details as printed.
+func loadInvoiceOrder(tx *gorm.DB, orderID uint) (*models.Order, []string, error) {
+	var order models.Order
+	if err := tx.Preload("Items").Preload("TaxLines").First(&order, orderID).Error; err != nil {
+		return nil, nil, err
+	}
+	var user models.User
+	if err := tx.Unscoped().Select("id", "email").First(&user, order.UserID).Error; err != nil {
+		return nil, nil, err
+	}
+
+	var buyer []string
+	if a := order.ShippingAddress; a != nil {
+		buyer = append(buyer, a.Name)
+		if a.Company != "" {
+			buyer = append(buyer, a.Company)
+		}
+		buyer = append(buyer, a.Line1)
+		if a.Line2 != "" {
+			buyer = append(buyer, a.Line2)
+		}
+		buyer = append(buyer, strings.TrimSpace(a.PostalCode+" "+a.City))
+		buyer = append(buyer, strings.TrimSpace(a.Region+" "+a.Country))
+	}
+	buyer = append(buyer, user.Email)
+	if order.VATID != "" {
+		buyer = append(buyer, "VAT ID: "+order.VATID)
+	}
+	return &order, buyer, nil
+}
+
+// exchangeRateNote states the rate an order in a foreign currency was
+// converted at, and the tax and total of a document in the base currency,
+// which is what the seller accounts for them in.
+func exchangeRateNote(order *models.Order, tax, total money.Money) string {
+	if order.Currency == order.BaseCurrency || order.BaseCurrency == "" {
+		return ""
+	}
+	return fmt.Sprintf("Exchange rate 1 %s = %s %s. Tax %s, total %s.", order.BaseCurrency,
+		strconv.FormatFloat(order.ExchangeRate, 'f', -1, 64), order.Currency,
+		formatInvoiceAmount(tax), formatInvoiceAmount(total))
+}
+
+const reverseChargeNote = "Reverse charge: VAT is to be accounted for by the recipient (Article 196, Council Directive 2006/112/EC)."
+
+type invoiceView struct {
+	Title      string
+	Number     string
+	IssuedAt   time.Time
+	References []string
+	Buyer      []string
+	Lines      []invoiceViewLine
+	Totals     []invoiceViewTotal
+	Notes      []string
+}
+
+type invoiceViewLine struct {
+	Description string
+	Quantity    int
+	UnitPrice   money.Money
+	Discount    money.Money
+	Tax         money.Money
+	Amount      money.Money
+}
+
+type invoiceViewTotal struct {
+	Label  string
+	Amount money.Money
+	Bold   bool
+}
+
+func taxLineLabel(name string, rate float64, taxable money.Money) string {
+	return fmt.Sprintf("%s %g%% of %s", name, rate, formatInvoiceAmount(taxable))
+}
+
+func formatInvoiceAmount(amount money.Money) string {
+	currency := amount.Currency()
+	if currency == "" {
+		currency = BaseCurrency
+	}
+	return amount.String() + " " + currency
+}
+
+// Column positions of the line table, in points from the left edge.
+const (
+	invoiceLeft       = 50.0
+	invoiceRight      = 545.0
+	invoiceColQty     = 285.0
+	invoiceColPrice   = 355.0
+	invoiceColDisc    = 415.0
+	invoiceColTax     = 475.0
+	invoiceDescWidth  = 200.0
+	invoiceBottomEdge = 90.0
+)
+
+// renderInvoicePDF lays out an invoice or credit note on A4 pages: seller
+// and document details at the top, the buyer, the lines, the totals with
+// the tax breakdown and any notes.
+func renderInvoicePDF(v invoiceView) []byte {
+	cfg := config.LoadConfig()
+	doc := newPDFDocument()
+
+	y := 790.0
+	doc.text(invoiceLeft, y, 16, true, cfg.InvoiceSellerName)
+	doc.textRight(invoiceRight, y, 20, true, v.Title)
+	y -= 16
+	right := append([]string{"No. " + v.Number, "Date " + v.IssuedAt.Format("2006-01-02")}, v.References...)
+	seller := splitInvoiceLines(cfg.InvoiceSellerAddress)
+	if cfg.InvoiceSellerVATID != "" {
+		seller = append(seller, "VAT ID: "+cfg.InvoiceSellerVATID)
+	}
+	for i := 0; i < len(seller) || i < len(right); i++ {
+		if i < len(seller) {
+			doc.text(invoiceLeft, y, 9, false, seller[i])
+		}
+		if i < len(right) {
+			doc.textRight(invoiceRight, y, 9, false, right[i])
+		}
+		y -= 12
+	}
+
+	y -= 18
+	doc.text(invoiceLeft, y, 10, true, "Bill to")
+	y -= 13
+	for _, line := range v.Buyer {
+		doc.text(invoiceLeft, y, 9, false, line)
+		y -= 12
+	}
+
+	header := func() {
+		y -= 18
+		doc.text(invoiceLeft, y, 9, true, "Description")
+		doc.textRight(invoiceColQty, y, 9, true, "Qty")
+		doc.textRight(invoiceColPrice, y, 9, true, "Unit price")
+		doc.textRight(invoiceColDisc, y, 9, true, "Discount")
+		doc.textRight(invoiceColTax, y, 9, true, "Tax")
+		doc.textRight(invoiceRight, y, 9, true, "Net amount")
+		y -= 5
+		doc.line(invoiceLeft, y, invoiceRight, y)
+		y -= 12
+	}
+	newPage := func() {
+		doc.addPage()
+		y = 790
+		doc.text(invoiceLeft, y, 9, false, v.Title+" "+v.Number+" (continued)")
+	}
+	amount := formatInvoiceAmount
+
+	header()
+	for _, line := range v.Lines {
+		if y < invoiceBottomEdge {
+			newPage()
+			header()
+		}
+		doc.text(invoiceLeft, y, 9, false, fitInvoiceText(line.Description, invoiceDescWidth, 9))
+		doc.textRight(invoiceColQty, y, 9, false, strconv.Itoa(line.Quantity))
+		doc.textRight(invoiceColPrice, y, 9, false, amount(line.UnitPrice))
+		if !line.Discount.IsZero() {
+			doc.textRight(invoiceColDisc, y, 9, false, amount(line.Discount.Neg()))
+		}
+		doc.textRight(invoiceColTax, y, 9, false, amount(line.Tax))
+		doc.textRight(invoiceRight, y, 9, false, amount(line.Amount))
+		y -= 14
+	}
+
+	y += 7
+	doc.line(invoiceLeft, y, invoiceRight, y)
+	y -= 16
+	for _, total := range v.Totals {
+		if y < invoiceBottomEdge {
+			newPage()
+			y -= 24
+		}
+		size := 9.0
+		if total.Bold {
+			size = 11
+		}
+		doc.textRight(invoiceColTax, y, size, total.Bold, total.Label)
+		doc.textRight(invoiceRight, y, size, total.Bold, amount(total.Amount))
+		y -= size + 5
+	}
+
+	y -= 14
+	for _, note := range v.Notes {
+		if y < invoiceBottomEdge-40 {
+			newPage()
+			y -= 24
+		}
+		doc.text(invoiceLeft, y, 8, false, fitInvoiceText(note, invoiceRight-invoiceLeft, 8))
+		y -= 11
+	}
+	return doc.bytes()
+}
+
+// splitInvoiceLines splits a configured multi-line value, written with
+// semicolons between the lines.
+func splitInvoiceLines(value string) []string {
+	var lines []string
+	for _, line := range strings.Split(value, ";") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// fitInvoiceText shortens s with an ellipsis until it is at most width
+// points wide.
+func fitInvoiceText(s string, width, size float64) string {
+	if pdfTextWidth(s, size, false) <= width {
+		return s
+	}
+	runes := []rune(s)
+	for len(runes) > 0 && pdfTextWidth(string(runes)+"...", size, false) > width {
+		runes = runes[:len(runes)-1]
+	}
+	return string(runes) + "..."
+}
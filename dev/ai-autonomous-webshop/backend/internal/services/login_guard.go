@@ -0,0 +1,175 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/config"
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/db"
+	"github.com/redis/go-redis/v9"
+)
+
+// AccountLockedError is returned while an account is in backoff or lockout.
+type AccountLockedError struct {
+	RetryAfter time.Duration
+	LockedOut  bool
+}
+
+func (e *AccountLockedError) Error() string {
+	if e.LockedOut {
+		return fmt.Sprintf("account temporarily locked, retry in %s", e.RetryAfter.Round(time.Second))
+	}
+	return fmt.Sprintf("too many failed login attempts, retry in %s", e.RetryAfter.Round(time.Second))
+}
+
+const (
+	redisLoginAttemptsPrefix = "auth:login_attempts:"
+	loginAttemptWindow       = 24 * time.Hour
+)
+
+type loginAttempts struct {
+	failures    int
+	lockedUntil time.Time
+	expiresAt   time.Time
+}
+
+// LoginGuard tracks failed logins per account rather than per IP, so that
+// rotating addresses doesn't help an attacker. After LoginBackoffThreshold
+// failures each further failure doubles the wait (1s, 2s, 4s, ...); reaching
+// LoginMaxAttempts locks the account for LoginLockoutMinutes. State lives in
+// Redis when connected and in process memory otherwise.
+type LoginGuard struct {
+	threshold   int
+	maxAttempts int
+	lockout     time.Duration
+
+	mu    sync.Mutex
+	local map[string]*loginAttempts
+}
+
+func NewLoginGuard() *LoginGuard {
+	cfg := config.LoadConfig()
+	return &LoginGuard{
+		threshold:   cfg.LoginBackoffThreshold,
+		maxAttempts: cfg.LoginMaxAttempts,
+		lockout:     time.Duration(cfg.LoginLockoutMinutes) * time.Minute,
+		local:       make(map[string]*loginAttempts),
+	}
+}
+
+func normalizeLoginKey(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// LoginAttempt is an attempt counted by Reserve. Failures counts the
+// attempts since the last Reset, this one included; LockedOut is whether
+// it used up the last one before a lockout.
+type LoginAttempt struct {
+	Failures  int
+	LockedOut bool
+}
+
+// reserveScript is Reserve for Redis. ARGV holds the time now, the window
+// in seconds and then the delay in seconds after 1, 2, ... failures, the
+// last one standing for any higher count.
+var reserveScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local locked = tonumber(redis.call('HGET', KEYS[1], 'locked_until') or '0')
+if locked > now then
+	return {0, tonumber(redis.call('HGET', KEYS[1], 'failures') or '0'), locked}
+end
+local failures = redis.call('HINCRBY', KEYS[1], 'failures', 1)
+local delay = tonumber(ARGV[2 + math.min(failures, #ARGV - 2)])
+redis.call('HSET', KEYS[1], 'locked_until', now + delay)
+redis.call('EXPIRE', KEYS[1], ARGV[2])
+return {1, failures, 0}
+`)
+
+// Reserve counts an attempt against the account before the credentials
+// are even checked, backing off as if it failed; a successful attempt then
+// calls Reset. The check and the count happen in one step, so a burst of
+// parallel attempts can't all get in before the lock is written. While the
+// account is locked it returns an *AccountLockedError and counts nothing.
+func (g *LoginGuard) Reserve(ctx context.Context, email string) (LoginAttempt, error) {
+	key := normalizeLoginKey(email)
+	now := time.Now()
+
+	if db.Redis != nil {
+		args := []interface{}{now.Unix(), int64(loginAttemptWindow / time.Second)}
+		for failures := 1; failures <= g.maxAttempts || failures == 1; failures++ {
+			args = append(args, int64(g.delayFor(failures)/time.Second))
+		}
+		result, err := reserveScript.Run(ctx, db.Redis, []string{redisLoginAttemptsPrefix + key}, args...).Int64Slice()
+		if err != nil {
+			return LoginAttempt{}, err
+		}
+		failures := int(result[1])
+		if result[0] == 0 {
+			return LoginAttempt{Failures: failures}, &AccountLockedError{
+				RetryAfter: time.Until(time.Unix(result[2], 0)),
+				LockedOut:  failures >= g.maxAttempts,
+			}
+		}
+		return LoginAttempt{Failures: failures, LockedOut: failures == g.maxAttempts}, nil
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.purgeLocked(now)
+
+	state, ok := g.local[key]
+	if !ok {
+		state = &loginAttempts{}
+		g.local[key] = state
+	}
+	if wait := state.lockedUntil.Sub(now); wait > 0 {
+		return LoginAttempt{Failures: state.failures}, &AccountLockedError{
+			RetryAfter: wait,
+			LockedOut:  state.failures >= g.maxAttempts,
+		}
+	}
+	state.failures++
+	state.lockedUntil = now.Add(g.delayFor(state.failures))
+	state.expiresAt = now.Add(loginAttemptWindow)
+	return LoginAttempt{Failures: state.failures, LockedOut: state.failures == g.maxAttempts}, nil
+}
+
+func (g *LoginGuard) Reset(ctx context.Context, email string) error {
+	key := normalizeLoginKey(email)
+	if db.Redis != nil {
+		return db.Redis.Del(ctx, redisLoginAttemptsPrefix+key).Err()
+	}
+	g.mu.Lock()
+	delete(g.local, key)
+	g.mu.Unlock()
+	return nil
+}
+
+func (g *LoginGuard) delayFor(failures int) time.Duration {
+	if failures >= g.maxAttempts {
+		return g.lockout
+	}
+	if failures < g.threshold {
+		return 0
+	}
+	shift := failures - g.threshold
+	if shift > 20 {
+		return g.lockout
+	}
+	delay := time.Second << shift
+	if delay > g.lockout {
+		delay = g.lockout
+	}
+	return delay
+}
+
+func (g *LoginGuard) purgeLocked(now time.Time) {
+	for key, state := range g.local {
+		if now.After(state.expiresAt) {
+			delete(g.local, key)
+		}
+	}
+}
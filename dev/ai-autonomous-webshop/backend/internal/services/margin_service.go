@@ -11,6 +11,8 @@ import (
 
 	"github.com/jeremy/ai-autonomous-webshop/backend/internal/db"
 	"github.com/jeremy/ai-autonomous-webshop/backend/internal/models"
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/money"
+	"gorm.io/gorm"
 )
 
 type MarginService struct {
@@ -26,8 +28,8 @@ func NewMarginService() *MarginService {
 type ProductWithCost struct {
 	ProductID   uint
 	ProductName string
-	Price       float64
-	CostPrice   float64
+	Price       money.Money
+	CostPrice   money.Money
 	Stock       int
 	Category    string
 	SalesCount  int
@@ -44,7 +46,8 @@ func (s *MarginService) AnalyzeAllProducts(limit int, useOllama bool) ([]models.
 	}
 
 	var analyses []models.MarginAnalysis
-	var totalRevenue, projectedRevenue float64
+	totalRevenue := money.Zero(BaseCurrency)
+	projectedRevenue := money.Zero(BaseCurrency)
 	highOpps, mediumOpps, lowOpps := 0, 0, 0
 
 	rand.Seed(time.Now().UnixNano())
@@ -54,10 +57,10 @@ func (s *MarginService) AnalyzeAllProducts(limit int, useOllama bool) ([]models.
 		analyses = append(analyses, analysis)
 
 		salesCount := s.getSalesCount(p.ID)
-		unitSales := float64(maxInt(salesCount, 1))
+		unitSales := int64(maxInt(salesCount, 1))
 
-		totalRevenue += p.Price * unitSales
-		projectedRevenue += analysis.SuggestedPrice * unitSales
+		totalRevenue = totalRevenue.Add(p.Price.Times(unitSales))
+		projectedRevenue = projectedRevenue.Add(analysis.SuggestedPrice.Times(unitSales))
 
 		if analysis.PriceChange > 15 {
 			highOpps++
@@ -85,7 +88,7 @@ func (s *MarginService) AnalyzeAllProducts(limit int, useOllama bool) ([]models.
 		AvgProjectedMargin: avgProjected,
 		TotalRevenue:       totalRevenue,
 		ProjectedRevenue:   projectedRevenue,
-		PotentialGain:      projectedRevenue - totalRevenue,
+		PotentialGain:      projectedRevenue.Sub(totalRevenue),
 		HighOpportunity:    highOpps,
 		MediumOpportunity:  mediumOpps,
 		LowOpportunity:     lowOpps,
@@ -96,13 +99,17 @@ func (s *MarginService) AnalyzeAllProducts(limit int, useOllama bool) ([]models.
 
 func (s *MarginService) analyzeProduct(p models.Product, useOllama bool) models.MarginAnalysis {
 	costPrice := s.estimateCostPrice(p.Price)
-	currentMargin := (p.Price - costPrice) / p.Price * 100
+	currentMargin := p.Price.Sub(costPrice).Ratio(p.Price) * 100
 
-	salesVelocity := s.calculateSalesVelocity(s.getSalesCount(p.ID), p.Category)
-	trendScore := s.calculateTrendScore(p.Category)
+	salesMultiplier, categoryTrend, err := categoryFactors(db.DB, p.CategoryID)
+	if err != nil {
+		log.Printf("Margin analysis: category factors of %s unavailable, using defaults: %v", p.Name, err)
+	}
+	salesVelocity := s.calculateSalesVelocity(s.getSalesCount(p.ID), salesMultiplier)
+	trendScore := s.calculateTrendScore(categoryTrend)
 	demandLevel := s.determineDemandLevel(p.Stock, salesVelocity, trendScore)
 
-	var suggestedPrice float64
+	var suggestedPrice money.Money
 	var reason string
 	var confidence float64
 	var analysisSource string
@@ -115,8 +122,8 @@ func (s *MarginService) analyzeProduct(p models.Product, useOllama bool) models.
 		analysisSource = "heuristic"
 	}
 
-	projectedMargin := (suggestedPrice - costPrice) / suggestedPrice * 100
-	priceChange := ((suggestedPrice - p.Price) / p.Price) * 100
+	projectedMargin := suggestedPrice.Sub(costPrice).Ratio(suggestedPrice) * 100
+	priceChange := suggestedPrice.Sub(p.Price).Ratio(p.Price) * 100
 
 	return models.MarginAnalysis{
 		ProductID:       p.ID,
@@ -143,49 +150,21 @@ func (s *MarginService) getSalesCount(productID uint) int {
 	return int(count)
 }
 
-func (s *MarginService) estimateCostPrice(sellingPrice float64) float64 {
+func (s *MarginService) estimateCostPrice(sellingPrice money.Money) money.Money {
 	marginRatio := 0.4 + rand.Float64()*0.2
-	return sellingPrice * (1 - marginRatio)
+	return sellingPrice.Mul(1 - marginRatio)
 }
 
-func (s *MarginService) calculateSalesVelocity(salesCount int, category string) float64 {
+// calculateSalesVelocity scales sales by the category's sales multiplier,
+// configured per category.
+func (s *MarginService) calculateSalesVelocity(salesCount int, multiplier float64) float64 {
 	baseVelocity := float64(maxInt(salesCount, 1))
-
-	categoryMultipliers := map[string]float64{
-		"Electronics": 1.5,
-		"Clothing":    1.2,
-		"Home":        1.0,
-		"Books":       0.8,
-		"Toys":        1.3,
-		"Sports":      1.1,
-		"Food":        0.9,
-	}
-
-	multiplier := categoryMultipliers[category]
-	if multiplier == 0 {
-		multiplier = 1.0
-	}
-
 	return baseVelocity * multiplier * (0.8 + rand.Float64()*0.4)
 }
 
-func (s *MarginService) calculateTrendScore(category string) float64 {
-	trendingCategories := map[string]float64{
-		"Electronics": 0.85,
-		"Toys":        0.78,
-		"Sports":      0.72,
-		"Clothing":    0.65,
-		"Home":        0.58,
-		"Books":       0.45,
-		"Food":        0.40,
-	}
-
-	trendScore := trendingCategories[category]
-	if trendScore == 0 {
-		trendScore = 0.5
-	}
-
-	return trendScore + (rand.Float64()*0.2 - 0.1)
+// calculateTrendScore jitters the category's configured trend score.
+func (s *MarginService) calculateTrendScore(categoryTrend float64) float64 {
+	return categoryTrend + (rand.Float64()*0.2 - 0.1)
 }
 
 func (s *MarginService) determineDemandLevel(stock int, salesVelocity float64, trendScore float64) string {
@@ -206,61 +185,61 @@ func (s *MarginService) determineDemandLevel(stock int, salesVelocity float64, t
 	return "low"
 }
 
-func (s *MarginService) analyzeWithHeuristic(p models.Product, costPrice, currentMargin float64, demandLevel string, salesVelocity, trendScore float64) (float64, string, float64) {
-	var suggestedPrice float64
+// analyzeWithHeuristic works out a price factor and applies it to the
+// current price in one step, so the suggestion is rounded only once.
+func (s *MarginService) analyzeWithHeuristic(p models.Product, costPrice money.Money, currentMargin float64, demandLevel string, salesVelocity, trendScore float64) (money.Money, string, float64) {
+	var factor float64
 	var reason string
 	var confidence float64
 
 	switch demandLevel {
 	case "high":
 		if p.Stock < 20 {
-			suggestedPrice = p.Price * 1.20
+			factor = 1.20
 			reason = fmt.Sprintf("High demand (%s) with low stock - opportunity for 20%% price increase", demandLevel)
 			confidence = 0.92
 		} else {
-			suggestedPrice = p.Price * 1.12
+			factor = 1.12
 			reason = fmt.Sprintf("Strong demand (%s) trending on social media", demandLevel)
 			confidence = 0.85
 		}
 	case "medium":
-		suggestedPrice = p.Price * 1.06
+		factor = 1.06
 		reason = "Moderate demand with stable inventory - conservative 6% increase"
 		confidence = 0.72
 	default:
 		if p.Stock > 100 {
-			suggestedPrice = p.Price * 0.92
+			factor = 0.92
 			reason = "High stock with low demand - reduce price to clear inventory"
 			confidence = 0.78
 		} else {
-			suggestedPrice = p.Price
+			factor = 1
 			reason = "Current price optimal for low demand scenario"
 			confidence = 0.65
 		}
 	}
 
 	if trendScore > 0.7 {
-		suggestedPrice *= 1.05
+		factor *= 1.05
 		reason += " (+5% trending premium)"
 		confidence += 0.05
 	}
 
 	if currentMargin < 15 {
-		suggestedPrice *= 1.08
+		factor *= 1.08
 		reason += " (margin boost)"
 	}
 
-	suggestedPrice = math.Round(suggestedPrice*100) / 100
-
-	return suggestedPrice, reason, math.Min(confidence, 0.98)
+	return p.Price.Mul(factor), reason, math.Min(confidence, 0.98)
 }
 
-func (s *MarginService) analyzeWithOllama(p models.Product, costPrice, currentMargin float64, demandLevel string) (float64, string, float64) {
+func (s *MarginService) analyzeWithOllama(p models.Product, costPrice money.Money, currentMargin float64, demandLevel string) (money.Money, string, float64) {
 	prompt := fmt.Sprintf(`You are a pricing expert for an e-commerce store. Analyze this product and suggest an optimal price.
 
 Product: %s
 Category: %s
-Current Price: $%.2f
-Cost Price: $%.2f (estimated)
+Current Price: $%s
+Cost Price: $%s (estimated)
 Current Margin: %.1f%%
 Stock: %d units
 Demand Level: %s
@@ -329,9 +308,7 @@ Respond ONLY with a JSON object in this exact format:
 		return s.analyzeWithHeuristic(p, costPrice, currentMargin, demandLevel, 0, 0)
 	}
 
-	parsed.SuggestedPrice = math.Round(parsed.SuggestedPrice*100) / 100
-
-	return parsed.SuggestedPrice, parsed.Reason, math.Min(parsed.Confidence, 0.95)
+	return money.FromFloat(parsed.SuggestedPrice, BaseCurrency), parsed.Reason, math.Min(parsed.Confidence, 0.95)
 }
 
 func (s *MarginService) ApplyPriceChange(analysisID uint) (*models.ApplyPriceChangeResponse, error) {
@@ -357,7 +334,10 @@ func (s *MarginService) ApplyPriceChange(analysisID uint) (*models.ApplyPriceCha
 	oldMargin := analysis.CurrentMargin
 
 	product.Price = analysis.SuggestedPrice
-	if err := db.DB.Save(&product).Error; err != nil {
+	if err := db.DB.Model(&product).Updates(map[string]interface{}{
+		"price":   product.Price,
+		"version": gorm.Expr("version + 1"),
+	}).Error; err != nil {
 		return nil, fmt.Errorf("failed to update price: %w", err)
 	}
 
@@ -368,9 +348,9 @@ func (s *MarginService) ApplyPriceChange(analysisID uint) (*models.ApplyPriceCha
 		log.Printf("Failed to update analysis: %v", err)
 	}
 
-	newMargin := (product.Price - analysis.CostPrice) / product.Price * 100
+	newMargin := product.Price.Sub(analysis.CostPrice).Ratio(product.Price) * 100
 
-	log.Printf("AI Agent: Applied price change for %s: $%.2f -> $%.2f (margin: %.1f%% -> %.1f%%)",
+	log.Printf("AI Agent: Applied price change for %s: $%s -> $%s (margin: %.1f%% -> %.1f%%)",
 		product.Name, oldPrice, product.Price, oldMargin, newMargin)
 
 	return &models.ApplyPriceChangeResponse{
@@ -0,0 +1,363 @@
+package services
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/config"
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/db"
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrMFAAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	ErrMFANotEnrolled    = errors.New("two-factor authentication enrollment has not been started")
+	ErrMFANotEnabled     = errors.New("two-factor authentication is not enabled")
+	ErrInvalidMFACode    = errors.New("invalid two-factor code")
+)
+
+const recoveryCodeCount = 10
+
+type MFAEnrollment struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri"`
+	// QRPayload is the string to encode into the QR code shown to the user;
+	// rendering is left to the client.
+	QRPayload string `json:"qr_payload"`
+}
+
+type MFAService struct {
+	issuer string
+	key    []byte
+	guard  *LoginGuard
+	audit  *SecurityAuditService
+}
+
+func NewMFAService() *MFAService {
+	cfg := config.LoadConfig()
+	secret := cfg.MFAEncryptionKey
+	if secret == "" {
+		secret = cfg.JWTSecret
+	}
+	key := sha256.Sum256([]byte("mfa:" + secret))
+
+	return &MFAService{
+		issuer: cfg.MFAIssuer,
+		key:    key[:],
+		guard:  NewLoginGuard(),
+		audit:  &SecurityAuditService{},
+	}
+}
+
+// MFARequired reports whether policy forces the user to use a second factor
+// before privileged permissions are granted. With MFA_REQUIRED_FOR_ADMIN set
+// this applies to every role that can reach the admin API (staff and admin).
+func MFARequired(user *models.User) bool {
+	if !config.LoadConfig().MFARequiredForAdmin {
+		return false
+	}
+	return user.HasRole(models.RoleAdmin) || user.HasRole(models.RoleStaff)
+}
+
+// Enroll generates a new secret and stores it encrypted but inactive until
+// ConfirmEnrollment proves the authenticator app has it.
+func (s *MFAService) Enroll(userID uint) (*MFAEnrollment, error) {
+	user, err := s.loadUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.MFAEnabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := s.encrypt(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.DB.Model(user).Updates(map[string]interface{}{
+		"mfa_secret":    encrypted,
+		"mfa_last_step": 0,
+	}).Error; err != nil {
+		return nil, err
+	}
+
+	uri := totpURI(s.issuer, user.Email, secret)
+	return &MFAEnrollment{
+		Secret:     secret,
+		OTPAuthURI: uri,
+		QRPayload:  uri,
+	}, nil
+}
+
+// ConfirmEnrollment activates MFA and returns the recovery codes. They are
+// shown exactly once; only hashes are kept.
+func (s *MFAService) ConfirmEnrollment(ctx context.Context, userID uint, code string) ([]string, error) {
+	user, err := s.loadUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.MFAEnabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+	if user.MFASecret == "" {
+		return nil, ErrMFANotEnrolled
+	}
+
+	step, err := s.checkTOTP(ctx, user, code)
+	if err != nil {
+		return nil, err
+	}
+
+	var codes []string
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(user).Updates(map[string]interface{}{
+			"mfa_enabled":   true,
+			"mfa_last_step": step,
+		}).Error; err != nil {
+			return err
+		}
+		codes, err = replaceRecoveryCodes(tx, user.ID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.audit.Record(&models.SecurityEvent{
+		Type:   models.SecurityEventMFAEnabled,
+		UserID: &user.ID,
+		Email:  user.Email,
+	})
+	return codes, nil
+}
+
+func (s *MFAService) Disable(ctx context.Context, userID uint, code string) error {
+	user, err := s.loadUser(userID)
+	if err != nil {
+		return err
+	}
+	if !user.MFAEnabled {
+		return ErrMFANotEnabled
+	}
+	if err := s.Verify(ctx, user, code, ""); err != nil {
+		return err
+	}
+
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(user).Updates(map[string]interface{}{
+			"mfa_enabled":   false,
+			"mfa_secret":    "",
+			"mfa_last_step": 0,
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", user.ID).Delete(&models.MFARecoveryCode{}).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	s.audit.Record(&models.SecurityEvent{
+		Type:   models.SecurityEventMFADisabled,
+		UserID: &user.ID,
+		Email:  user.Email,
+	})
+	return nil
+}
+
+func (s *MFAService) RegenerateRecoveryCodes(ctx context.Context, userID uint, code string) ([]string, error) {
+	user, err := s.loadUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if !user.MFAEnabled {
+		return nil, ErrMFANotEnabled
+	}
+	if err := s.Verify(ctx, user, code, ""); err != nil {
+		return nil, err
+	}
+
+	var codes []string
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		codes, err = replaceRecoveryCodes(tx, user.ID)
+		return err
+	})
+	return codes, err
+}
+
+// VerifyLogin is the second step of a login: it loads the user behind an
+// mfa_pending token and checks the submitted factor.
+func (s *MFAService) VerifyLogin(ctx context.Context, userID uint, code, recoveryCode string) (*models.User, error) {
+	user, err := s.loadUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.Verify(ctx, user, code, recoveryCode); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// Verify accepts either a TOTP code or an unused recovery code. Failures
+// count against the same backoff as password attempts, keyed per user.
+func (s *MFAService) Verify(ctx context.Context, user *models.User, code, recoveryCode string) error {
+	if !user.MFAEnabled {
+		return ErrMFANotEnabled
+	}
+
+	if recoveryCode != "" {
+		guardKey := fmt.Sprintf("mfa:%d", user.ID)
+		if _, err := s.guard.Reserve(ctx, guardKey); err != nil {
+			return err
+		}
+		if !consumeRecoveryCode(user.ID, recoveryCode) {
+			return ErrInvalidMFACode
+		}
+		s.guard.Reset(ctx, guardKey)
+		s.audit.Record(&models.SecurityEvent{
+			Type:   models.SecurityEventMFARecoveryUsed,
+			UserID: &user.ID,
+			Email:  user.Email,
+		})
+		return nil
+	}
+
+	step, err := s.checkTOTP(ctx, user, code)
+	if err != nil {
+		return err
+	}
+	// Moving the last step forward only if no other request did so since
+	// the user was loaded is what keeps a code from being used twice.
+	result := db.DB.Model(&models.User{}).Where("id = ? AND mfa_last_step < ?", user.ID, step).
+		Update("mfa_last_step", step)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrInvalidMFACode
+	}
+	user.MFALastStep = step
+	return nil
+}
+
+func (s *MFAService) checkTOTP(ctx context.Context, user *models.User, code string) (int64, error) {
+	guardKey := fmt.Sprintf("mfa:%d", user.ID)
+	if _, err := s.guard.Reserve(ctx, guardKey); err != nil {
+		return 0, err
+	}
+
+	secret, err := s.decrypt(user.MFASecret)
+	if err != nil {
+		return 0, err
+	}
+
+	step, ok := validateTOTP(secret, code, time.Now(), user.MFALastStep)
+	if !ok {
+		return 0, ErrInvalidMFACode
+	}
+	s.guard.Reset(ctx, guardKey)
+	return step, nil
+}
+
+func (s *MFAService) loadUser(userID uint) (*models.User, error) {
+	var user models.User
+	if err := db.DB.Preload("Roles").First(&user, userID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (s *MFAService) encrypt(plaintext string) (string, error) {
+	block, err := aes.NewCipher(s.key)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(plaintext), nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (s *MFAService) decrypt(ciphertext string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return "", err
+	}
+	block, err := aes.NewCipher(s.key)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+	if len(data) < gcm.NonceSize() {
+		return "", errors.New("mfa secret is corrupt")
+	}
+	plaintext, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt mfa secret: %w", err)
+	}
+	return string(plaintext), nil
+}
+
+func replaceRecoveryCodes(tx *gorm.DB, userID uint) ([]string, error) {
+	if err := tx.Where("user_id = ?", userID).Delete(&models.MFARecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	records := make([]models.MFARecoveryCode, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 6)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		raw := strings.ToLower(totpEncoding.EncodeToString(b))
+		codes[i] = raw[:5] + "-" + raw[5:10]
+		records[i] = models.MFARecoveryCode{
+			UserID:   userID,
+			CodeHash: hashRecoveryCode(codes[i]),
+		}
+	}
+
+	if err := tx.Create(&records).Error; err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func consumeRecoveryCode(userID uint, code string) bool {
+	result := db.DB.Model(&models.MFARecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, hashRecoveryCode(code)).
+		Update("used_at", time.Now())
+	return result.Error == nil && result.RowsAffected == 1
+}
+
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	return hashToken(normalized)
+}
@@ -1,11 +1,12 @@
 package services
 
 import (
-	"encoding/json"
 	"log"
 	"sync"
 
 	"github.com/gorilla/websocket"
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/db"
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/models"
 )
 
 type NotificationService struct {
@@ -84,6 +85,23 @@ func (s *NotificationService) NotifyUser(userID uint, msgType, message string, d
 	}
 }
 
+// NotifyAdmins sends the message to every user holding the admin role.
+func (s *NotificationService) NotifyAdmins(msgType, message string, data any) {
+	if db.DB == nil {
+		return
+	}
+
+	var adminIDs []uint
+	if err := db.DB.Model(&models.UserRole{}).Where("role = ?", models.RoleAdmin).Pluck("user_id", &adminIDs).Error; err != nil {
+		log.Printf("Failed to look up admins for %s notification: %v", msgType, err)
+		return
+	}
+
+	for _, id := range adminIDs {
+		s.NotifyUser(id, msgType, message, data)
+	}
+}
+
 func (s *NotificationService) Register(conn *websocket.Conn, userID uint) {
 	s.register <- clientRegistration{conn: conn, userID: userID}
 }
@@ -0,0 +1,89 @@
+package services
+
+import (
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/models"
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/money"
+)
+
+// orderCurrency converts between BaseCurrency and the currency an order is
+// presented and charged in. The rate is taken once, when the order is
+// placed, and stored on the order; everything later, payment and refunds
+// included, uses that rate and never the current one.
+type orderCurrency struct {
+	code string
+	rate float64
+}
+
+// lockOrderCurrency takes today's rate for code, which falls back to
+// BaseCurrency when it isn't supported.
+func lockOrderCurrency(code string) orderCurrency {
+	if !IsSupportedCurrency(code) {
+		code = BaseCurrency
+	}
+	return orderCurrency{
+		code: code,
+		rate: NewCurrencyService().GetExchangeRate(BaseCurrency, code),
+	}
+}
+
+// currencyOf is the locked currency of a placed order.
+func currencyOf(order *models.Order) orderCurrency {
+	c := orderCurrency{code: order.Currency, rate: order.ExchangeRate}
+	if c.code == "" {
+		c.code = BaseCurrency
+	}
+	if c.rate <= 0 {
+		c.rate = 1
+	}
+	return c
+}
+
+// present converts a BaseCurrency amount into the order's currency.
+func (c orderCurrency) present(amount money.Money) money.Money {
+	return amount.Convert(c.code, c.rate)
+}
+
+// base converts an amount in the order's currency back into BaseCurrency.
+func (c orderCurrency) base(amount money.Money) money.Money {
+	return amount.Convert(BaseCurrency, 1/c.rate)
+}
+
+// amount reads a major-unit amount given in the order's currency, as staff
+// enter refunds.
+func (c orderCurrency) amount(major float64) money.Money {
+	return money.FromFloat(major, c.code)
+}
+
+func (c orderCurrency) zero() money.Money {
+	return money.Zero(c.code)
+}
+
+// apply locks c on order and fills its presentment amounts from the base
+// ones. Lines are converted per unit and totals summed from them, the same
+// way the cart summary does, so the customer pays what the cart showed.
+func (c orderCurrency) apply(order *models.Order) {
+	order.Currency = c.code
+	order.BaseCurrency = BaseCurrency
+	order.ExchangeRate = c.rate
+
+	subtotal := c.zero()
+	for i := range order.Items {
+		item := &order.Items[i]
+		item.PresentmentPrice = c.present(item.Price)
+		item.PresentmentDiscount = c.present(item.Discount)
+		item.PresentmentTaxAmount = c.present(item.TaxAmount)
+		subtotal = subtotal.Add(item.PresentmentPrice.Times(int64(item.Quantity)))
+	}
+	for i := range order.TaxLines {
+		line := &order.TaxLines[i]
+		line.PresentmentTaxableAmount = c.present(line.TaxableAmount)
+		line.PresentmentAmount = c.present(line.Amount)
+	}
+
+	order.PresentmentSubtotal = subtotal
+	order.PresentmentDiscountTotal = money.Min(c.present(order.DiscountTotal), subtotal)
+	order.PresentmentShippingTotal = c.present(order.ShippingTotal)
+	order.PresentmentTaxTotal = c.present(order.TaxTotal)
+	order.PresentmentTotal = money.Sum(order.PresentmentSubtotal.Sub(order.PresentmentDiscountTotal), order.PresentmentShippingTotal, order.PresentmentTaxTotal)
+	order.PresentmentRefundedTotal = c.zero()
+}
@@ -1,71 +1,193 @@
 package services
 
 import (
-	"gorm.io/gorm"
+	"context"
+	"errors"
+	"fmt"
+	"time"
 
 	"github.com/jeremy/ai-autonomous-webshop/backend/internal/db"
 	"github.com/jeremy/ai-autonomous-webshop/backend/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrInvalidOrderStatus = errors.New("invalid order status")
+	ErrOrderStatusChanged = errors.New("order status was changed concurrently")
 )
 
+// InvalidTransitionError is returned for a transition the state machine in
+// models.OrderTransitions doesn't allow.
+type InvalidTransitionError struct {
+	From string
+	To   string
+}
+
+func (e *InvalidTransitionError) Error() string {
+	return fmt.Sprintf("order cannot move from %s to %s", e.From, e.To)
+}
+
+const (
+	ActorCustomer = "customer"
+	ActorStaff    = "staff"
+	ActorSystem   = "system"
+)
+
+// OrderActor identifies who caused a status change.
+type OrderActor struct {
+	Type string
+	ID   *uint
+}
+
+func CustomerActor(userID uint) OrderActor { return OrderActor{Type: ActorCustomer, ID: &userID} }
+func StaffActor(userID uint) OrderActor    { return OrderActor{Type: ActorStaff, ID: &userID} }
+func SystemActor() OrderActor              { return OrderActor{Type: ActorSystem} }
+func ProviderActor(name string) OrderActor { return OrderActor{Type: "provider:" + name} }
+
+// OrderTransition describes a committed status change, for notifying.
+type OrderTransition struct {
+	OrderID uint   `json:"order_id"`
+	UserID  uint   `json:"-"`
+	From    string `json:"from"`
+	To      string `json:"to"`
+	Reason  string `json:"reason,omitempty"`
+}
+
 type OrderService struct{}
 
-func (s *OrderService) Create(userID uint, items []models.OrderItem) (*models.Order, error) {
-	tx := db.DB.Begin()
+func (s *OrderService) GetByUserID(userID uint) ([]models.Order, error) {
+	var orders []models.Order
+	if err := db.DB.Preload("Items").Preload("Transactions").Preload("Refunds").Preload("Shipments").Where("user_id = ?", userID).Find(&orders).Error; err != nil {
+		return nil, err
+	}
+	return orders, nil
+}
 
-	// Batch fetch all products at once to avoid N+1 queries
-	productIDs := make([]uint, len(items))
-	for i, item := range items {
-		productIDs[i] = item.ProductID
+// History returns the status history of an order. userID restricts it to
+// the owner's orders; zero means any order (admin).
+func (s *OrderService) History(orderID, userID uint) ([]models.OrderStatusHistory, error) {
+	query := db.DB.Model(&models.Order{}).Where("id = ?", orderID)
+	if userID != 0 {
+		query = query.Where("user_id = ?", userID)
+	}
+	var count int64
+	if err := query.Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		return nil, ErrOrderNotFound
 	}
 
-	var products []models.Product
-	if err := tx.Where("id IN ?", productIDs).Find(&products).Error; err != nil {
-		tx.Rollback()
+	var history []models.OrderStatusHistory
+	if err := db.DB.Where("order_id = ?", orderID).Order("created_at, id").Find(&history).Error; err != nil {
 		return nil, err
 	}
+	return history, nil
+}
 
-	// Create a map for quick lookup
-	productMap := make(map[uint]models.Product)
-	for _, p := range products {
-		productMap[p.ID] = p
+// Transition changes an order's status in its own transaction and notifies
+// the customer once committed.
+func (s *OrderService) Transition(ctx context.Context, orderID uint, to string, actor OrderActor, reason string) (*models.Order, error) {
+	if !models.IsValidOrderStatus(to) {
+		return nil, ErrInvalidOrderStatus
 	}
 
-	// Verify all products exist
-	for _, item := range items {
-		if _, ok := productMap[item.ProductID]; !ok {
-			tx.Rollback()
-			return nil, gorm.ErrRecordNotFound
+	var order models.Order
+	var transition *OrderTransition
+	err := db.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&order, orderID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrOrderNotFound
+			}
+			return err
 		}
+		var err error
+		transition, err = transitionOrder(tx, &order, to, actor, reason)
+		return err
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	var total float64
-	for i, item := range items {
-		product := productMap[item.ProductID]
-		// Set the price from the DB to ensure data integrity
-		items[i].Price = product.Price
-		total += product.Price * float64(item.Quantity)
+	notifyOrderTransition(transition)
+	return &order, nil
+}
+
+// transitionOrder moves order to status `to` inside tx, writes the history
+// row, settles the order's stock hold and promotion uses and invoices a
+// paid order. The update is conditional on the status the caller saw, so a
+// concurrent change makes it fail instead of being overwritten. Callers
+// pass the result to notifyOrderTransition after committing.
+func transitionOrder(tx *gorm.DB, order *models.Order, to string, actor OrderActor, reason string) (*OrderTransition, error) {
+	from := order.Status
+	if !models.CanTransitionOrder(from, to) {
+		return nil, &InvalidTransitionError{From: from, To: to}
 	}
 
-	order := &models.Order{
-		UserID: userID,
-		Total:  total,
-		Status: "pending",
-		Items:  items,
+	updates := map[string]interface{}{"status": to}
+	if to == models.OrderStatusPaid && order.PaidAt == nil {
+		now := time.Now()
+		order.PaidAt = &now
+		updates["paid_at"] = now
 	}
 
-	if err := tx.Create(order).Error; err != nil {
-		tx.Rollback()
+	result := tx.Model(&models.Order{}).Where("id = ? AND status = ?", order.ID, from).Updates(updates)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected != 1 {
+		return nil, ErrOrderStatusChanged
+	}
+	order.Status = to
+
+	if err := recordOrderStatus(tx, order.ID, from, to, actor, reason); err != nil {
 		return nil, err
 	}
 
-	tx.Commit()
-	return order, nil
+	// An order waiting for payment has its stock on a hold. Payment makes
+	// the stock the order's for good; failing or cancelling gives it back.
+	if from == models.OrderStatusPending || from == models.OrderStatusAuthorized {
+		var err error
+		switch to {
+		case models.OrderStatusPaid:
+			err = settleOrderStock(tx, order.ID, models.StockReservationConsumed)
+		case models.OrderStatusPaymentFailed, models.OrderStatusCancelled:
+			err = settleOrderStock(tx, order.ID, models.StockReservationReleased)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if to == models.OrderStatusPaid {
+		issueDocument(tx, order.ID, func(tx *gorm.DB) error {
+			_, err := issueInvoice(tx, order)
+			return err
+		})
+	}
+	// A failed or cancelled order doesn't use up its promotions.
+	if to == models.OrderStatusPaymentFailed || to == models.OrderStatusCancelled {
+		if err := releaseDiscounts(tx, order.ID); err != nil {
+			return nil, err
+		}
+	}
+	return &OrderTransition{OrderID: order.ID, UserID: order.UserID, From: from, To: to, Reason: reason}, nil
 }
 
-func (s *OrderService) GetByUserID(userID uint) ([]models.Order, error) {
-	var orders []models.Order
-	if err := db.DB.Preload("Items").Where("user_id = ?", userID).Find(&orders).Error; err != nil {
-		return nil, err
+func recordOrderStatus(tx *gorm.DB, orderID uint, from, to string, actor OrderActor, reason string) error {
+	return tx.Create(&models.OrderStatusHistory{
+		OrderID:    orderID,
+		FromStatus: from,
+		ToStatus:   to,
+		Actor:      actor.Type,
+		ActorID:    actor.ID,
+		Reason:     reason,
+	}).Error
+}
+
+func notifyOrderTransition(t *OrderTransition) {
+	if t == nil || Notifier == nil {
+		return
 	}
-	return orders, nil
+	go Notifier.NotifyUser(t.UserID, "ORDER_STATUS_CHANGED", fmt.Sprintf("Order #%d is now %s", t.OrderID, t.To), t)
 }
@@ -1,33 +1,123 @@
 package services
 
 import (
-	"errors"
+	"context"
+	"fmt"
 	"log"
-	"time"
+
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/models"
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/money"
+	"gorm.io/gorm"
 )
 
-type PaymentService struct{}
+// PaymentService drives a PaymentGateway for an order and keeps a
+// PaymentTransaction row for every gateway call it makes.
+type PaymentService struct {
+	gateway PaymentGateway
+}
 
-type PaymentResult struct {
-	TransactionID string
-	Status        string
-	Amount        float64
+func NewPaymentService() *PaymentService {
+	return &PaymentService{gateway: DefaultPaymentGateway()}
 }
 
-func (s *PaymentService) ProcessPayment(amount float64, cardNumber string) (*PaymentResult, error) {
-	log.Printf("Processing payment of $%.2f for card ending in %s", amount, cardNumber[len(cardNumber)-4:])
-	
-	// Mock processing delay
-	time.Sleep(1 * time.Second)
+func NewPaymentServiceWithGateway(gateway PaymentGateway) *PaymentService {
+	return &PaymentService{gateway: gateway}
+}
 
-	// Simple mock logic: If card ends in '0000', fail it.
-	if len(cardNumber) >= 4 && cardNumber[len(cardNumber)-4:] == "0000" {
-		return nil, errors.New("payment declined: insufficient funds")
+func (s *PaymentService) Gateway() PaymentGateway {
+	return s.gateway
+}
+
+// Authorize creates a manual-capture intent for the order and confirms it
+// with the client's payment method. On error the returned intent is still
+// set if it was created, so the caller can void it. The customer is charged
+// the order's presentment total in its presentment currency.
+func (s *PaymentService) Authorize(ctx context.Context, tx *gorm.DB, order *models.Order, paymentMethodID string) (*PaymentIntent, error) {
+	amount := order.PresentmentTotal.Minor()
+
+	intent, err := s.gateway.CreateIntent(ctx, CreateIntentParams{
+		Amount:         amount,
+		Currency:       order.Currency,
+		OrderID:        order.ID,
+		IdempotencyKey: fmt.Sprintf("order-%d", order.ID),
+	})
+	if err != nil {
+		s.record(tx, order, models.PaymentTxnAuthorize, "", "", amount, "", err)
+		return nil, err
 	}
 
-	return &PaymentResult{
-		TransactionID: "TXN-" + time.Now().Format("20060102150405"),
-		Status:        "success",
-		Amount:        amount,
-	}, nil
+	order.PaymentProvider = s.gateway.Name()
+	order.PaymentIntentID = intent.ID
+	if err := tx.Model(order).Updates(map[string]interface{}{
+		"payment_provider":  order.PaymentProvider,
+		"payment_intent_id": order.PaymentIntentID,
+	}).Error; err != nil {
+		return intent, err
+	}
+
+	confirmed, err := s.gateway.Confirm(ctx, intent.ID, paymentMethodID)
+	if err != nil {
+		s.record(tx, order, models.PaymentTxnAuthorize, intent.ID, "", amount, "", err)
+		return intent, err
+	}
+	s.record(tx, order, models.PaymentTxnAuthorize, confirmed.ID, "", amount, confirmed.Status, nil)
+	return confirmed, nil
+}
+
+func (s *PaymentService) Capture(ctx context.Context, tx *gorm.DB, order *models.Order) (*PaymentIntent, error) {
+	amount := order.PresentmentTotal.Minor()
+
+	intent, err := s.gateway.Capture(ctx, order.PaymentIntentID, amount)
+	if err != nil {
+		s.record(tx, order, models.PaymentTxnCapture, order.PaymentIntentID, "", amount, "", err)
+		return nil, err
+	}
+	s.record(tx, order, models.PaymentTxnCapture, intent.ID, "", intent.AmountCaptured, intent.Status, nil)
+	return intent, nil
+}
+
+// Refund returns amount (in the order's presentment currency) to the
+// customer; zero refunds whatever is left.
+func (s *PaymentService) Refund(ctx context.Context, tx *gorm.DB, order *models.Order, amount money.Money) (*PaymentRefund, error) {
+	minor := amount.Minor()
+
+	refund, err := s.gateway.Refund(ctx, order.PaymentIntentID, minor)
+	if err != nil {
+		s.record(tx, order, models.PaymentTxnRefund, order.PaymentIntentID, "", minor, "", err)
+		return nil, err
+	}
+	s.record(tx, order, models.PaymentTxnRefund, order.PaymentIntentID, refund.ID, refund.Amount, refund.Status, nil)
+	return refund, nil
+}
+
+func (s *PaymentService) Void(ctx context.Context, tx *gorm.DB, order *models.Order) error {
+	intent, err := s.gateway.Void(ctx, order.PaymentIntentID)
+	if err != nil {
+		s.record(tx, order, models.PaymentTxnVoid, order.PaymentIntentID, "", 0, "", err)
+		return err
+	}
+	s.record(tx, order, models.PaymentTxnVoid, intent.ID, "", 0, intent.Status, nil)
+	return nil
+}
+
+// record never fails the payment flow: losing an audit row is better than
+// losing track of money that already moved.
+func (s *PaymentService) record(tx *gorm.DB, order *models.Order, txnType, intentID, ref string, amount int64, status string, callErr error) {
+	txn := &models.PaymentTransaction{
+		OrderID:     order.ID,
+		Provider:    s.gateway.Name(),
+		Type:        txnType,
+		IntentID:    intentID,
+		ProviderRef: ref,
+		Amount:      amount,
+		Currency:    order.Currency,
+		Status:      status,
+	}
+	if callErr != nil {
+		txn.Status = "failed"
+		txn.Error = callErr.Error()
+	}
+	if err := tx.Create(txn).Error; err != nil {
+		log.Printf("Failed to record %s transaction for order %d: %v", txnType, order.ID, err)
+	}
 }
@@ -0,0 +1,362 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/config"
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/db"
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/models"
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/money"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrUnknownPaymentProvider = errors.New("unknown payment provider")
+	ErrPaymentEventNotFound   = errors.New("payment event not found")
+
+	// errOrderNotYetVisible covers the webhook racing the checkout
+	// transaction that creates the order; a retry usually finds it.
+	errOrderNotYetVisible = errors.New("no order for payment intent yet")
+)
+
+type PaymentEventListResult struct {
+	Events     []models.PaymentEvent `json:"events"`
+	Total      int64                 `json:"total"`
+	Page       int                   `json:"page"`
+	PageSize   int                   `json:"page_size"`
+	TotalPages int                   `json:"total_pages"`
+}
+
+// PaymentWebhookService stores verified provider events and applies them
+// to orders. Every event is stored before it is processed, so a processing
+// failure never loses it: failed events are retried with backoff by
+// StartRetryJob until WebhookMaxAttempts, after which they are marked dead
+// and left for an admin.
+type PaymentWebhookService struct {
+	gateway     PaymentGateway
+	maxAttempts int
+}
+
+func NewPaymentWebhookService() *PaymentWebhookService {
+	return &PaymentWebhookService{
+		gateway:     DefaultPaymentGateway(),
+		maxAttempts: config.LoadConfig().WebhookMaxAttempts,
+	}
+}
+
+// Ingest verifies and stores a webhook delivery, then tries to process it
+// right away. duplicate is true when the event was delivered before.
+func (s *PaymentWebhookService) Ingest(ctx context.Context, provider string, payload []byte, headers http.Header) (event *models.PaymentEvent, duplicate bool, err error) {
+	if provider != s.gateway.Name() {
+		return nil, false, ErrUnknownPaymentProvider
+	}
+
+	parsed, err := s.gateway.ParseWebhook(payload, headers)
+	if err != nil {
+		return nil, false, err
+	}
+
+	event = &models.PaymentEvent{
+		Provider: provider,
+		EventID:  parsed.ID,
+		Type:     parsed.Type,
+		Kind:     parsed.Kind,
+		IntentID: parsed.IntentID,
+		Amount:   parsed.Amount,
+		Payload:  string(payload),
+		Status:   models.PaymentEventReceived,
+	}
+	result := db.DB.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(event)
+	if result.Error != nil {
+		return nil, false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return event, true, nil
+	}
+
+	if err := s.Process(ctx, event.ID); err != nil {
+		// Stored and scheduled for retry; the provider doesn't need to
+		// redeliver.
+		log.Printf("Payment event %s/%s failed, will retry: %v", provider, parsed.ID, err)
+	}
+	return event, false, nil
+}
+
+// Process applies one stored event. The event row is locked for the
+// duration so the retry job and a manual retry can't apply it twice.
+func (s *PaymentWebhookService) Process(ctx context.Context, eventID uint) error {
+	var event models.PaymentEvent
+	var applyErr error
+	var transition *OrderTransition
+
+	err := db.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&event, eventID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrPaymentEventNotFound
+			}
+			return err
+		}
+		if event.Status == models.PaymentEventProcessed || event.Status == models.PaymentEventIgnored {
+			return nil
+		}
+
+		// Apply in a savepoint so a failure rolls back the order changes
+		// but still lets us record the attempt on the event.
+		var order *models.Order
+		var result string
+		applyErr = tx.Transaction(func(tx *gorm.DB) error {
+			var err error
+			order, transition, result, err = s.apply(tx, &event)
+			return err
+		})
+
+		now := time.Now()
+		event.Attempts++
+		if applyErr != nil {
+			event.LastError = applyErr.Error()
+			if event.Attempts >= s.maxAttempts {
+				event.Status = models.PaymentEventDead
+				event.NextAttemptAt = nil
+			} else {
+				event.Status = models.PaymentEventFailed
+				next := now.Add(webhookRetryDelay(event.Attempts))
+				event.NextAttemptAt = &next
+			}
+		} else {
+			event.LastError = ""
+			event.NextAttemptAt = nil
+			event.ProcessedAt = &now
+			event.Result = result
+			event.Status = models.PaymentEventProcessed
+			if order == nil {
+				event.Status = models.PaymentEventIgnored
+			} else {
+				event.OrderID = &order.ID
+			}
+		}
+		return tx.Save(&event).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	if event.Status == models.PaymentEventDead {
+		log.Printf("Payment event %s/%s gave up after %d attempts: %s", event.Provider, event.EventID, event.Attempts, event.LastError)
+		if Notifier != nil {
+			go Notifier.NotifyAdmins("PAYMENT_EVENT_FAILED", fmt.Sprintf("Payment event %s could not be processed", event.EventID), event)
+		}
+	}
+	if applyErr == nil {
+		notifyOrderTransition(transition)
+	}
+	return applyErr
+}
+
+// apply moves the order forward. It returns a nil order when the event
+// doesn't change anything, e.g. a capture for an order that is already paid.
+func (s *PaymentWebhookService) apply(tx *gorm.DB, event *models.PaymentEvent) (*models.Order, *OrderTransition, string, error) {
+	if event.Kind == "" {
+		return nil, nil, "event type not handled", nil
+	}
+	if event.IntentID == "" {
+		return nil, nil, "event has no payment intent", nil
+	}
+
+	var order models.Order
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("payment_intent_id = ?", event.IntentID).First(&order).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// A checkout that rolled back voids its intent, which comes
+			// back here as a failure for an order that never existed.
+			if event.Kind == PaymentEventFailed {
+				return nil, nil, "no order for payment intent", nil
+			}
+			return nil, nil, "", errOrderNotYetVisible
+		}
+		return nil, nil, "", err
+	}
+
+	from := order.Status
+	var to string
+
+	switch event.Kind {
+	case PaymentEventAuthorized:
+		to = models.OrderStatusAuthorized
+	case PaymentEventCaptured:
+		to = models.OrderStatusPaid
+	case PaymentEventFailed:
+		to = models.OrderStatusPaymentFailed
+	case PaymentEventRefunded:
+		if !orderWasPaid(from) {
+			return nil, nil, "order not refundable in status " + from, nil
+		}
+		// The amount is the total refunded so far, in the presentment
+		// currency. Refunds made through RefundService are already
+		// counted; anything above that was refunded at the provider
+		// directly. It is recorded as a refund of its own, with its credit
+		// note, and taken back to the base currency at the order's locked
+		// rate.
+		currency := currencyOf(&order)
+		refunded := money.New(event.Amount, currency.code)
+		if !refunded.GreaterThan(order.PresentmentRefundedTotal) {
+			return nil, nil, fmt.Sprintf("order %d: refund already recorded", order.ID), nil
+		}
+		baseRefunded := currency.base(refunded)
+		to = models.OrderStatusPartiallyRefunded
+		if !refunded.LessThan(order.PresentmentTotal) {
+			to = models.OrderStatusRefunded
+			baseRefunded = order.Total
+		}
+		actor := ProviderActor(event.Provider)
+		refund := &models.Refund{
+			OrderID:    order.ID,
+			Amount:     refunded.Sub(order.PresentmentRefundedTotal),
+			Currency:   order.Currency,
+			BaseAmount: baseRefunded.Sub(order.RefundedTotal),
+			Reason:     "refunded at the payment provider",
+			Provider:   event.Provider,
+			Status:     IntentSucceeded,
+			Actor:      actor.Type,
+			ActorID:    actor.ID,
+		}
+		if err := tx.Create(refund).Error; err != nil {
+			return nil, nil, "", err
+		}
+		if err := tx.Model(&models.Order{}).Where("id = ?", order.ID).Updates(map[string]interface{}{
+			"refunded_total":             baseRefunded,
+			"presentment_refunded_total": refunded,
+		}).Error; err != nil {
+			return nil, nil, "", err
+		}
+		order.RefundedTotal = baseRefunded
+		order.PresentmentRefundedTotal = refunded
+		issueDocument(tx, order.ID, func(tx *gorm.DB) error {
+			_, err := issueCreditNote(tx, &order, refund)
+			return err
+		})
+	case PaymentEventDisputed:
+		to = models.OrderStatusDisputed
+	}
+
+	// Providers deliver out of order and more than once: an event that the
+	// state machine doesn't allow from here is stale, not an error.
+	if !models.CanTransitionOrder(from, to) {
+		return nil, nil, fmt.Sprintf("order %d is %s, %s not applicable", order.ID, from, to), nil
+	}
+
+	transition, err := transitionOrder(tx, &order, to, ProviderActor(event.Provider), event.Type)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	if to == models.OrderStatusDisputed && Notifier != nil {
+		go Notifier.NotifyAdmins("PAYMENT_DISPUTED", fmt.Sprintf("Payment for order #%d is disputed", order.ID), event)
+	}
+	return &order, transition, fmt.Sprintf("order %d: %s -> %s", order.ID, from, to), nil
+}
+
+// orderWasPaid reports whether money was captured for an order in this
+// status at some point.
+func orderWasPaid(status string) bool {
+	switch status {
+	case models.OrderStatusPending, models.OrderStatusAuthorized, models.OrderStatusPaymentFailed, models.OrderStatusCancelled:
+		return false
+	}
+	return true
+}
+
+// webhookRetryDelay backs off 1m, 2m, 4m, ... capped at an hour.
+func webhookRetryDelay(attempts int) time.Duration {
+	if attempts > 6 {
+		return time.Hour
+	}
+	return time.Minute << (attempts - 1)
+}
+
+func (s *PaymentWebhookService) RetryDue() {
+	if db.DB == nil {
+		return
+	}
+
+	var ids []uint
+	if err := db.DB.Model(&models.PaymentEvent{}).
+		Where("status = ? AND next_attempt_at <= ?", models.PaymentEventFailed, time.Now()).
+		Order("next_attempt_at").Limit(100).Pluck("id", &ids).Error; err != nil {
+		log.Printf("Error fetching payment events to retry: %v", err)
+		return
+	}
+
+	for _, id := range ids {
+		if err := s.Process(context.Background(), id); err != nil {
+			log.Printf("Retry of payment event %d failed: %v", id, err)
+		}
+	}
+}
+
+func (s *PaymentWebhookService) StartRetryJob() {
+	ticker := time.NewTicker(time.Minute)
+	go func() {
+		for range ticker.C {
+			s.RetryDue()
+		}
+	}()
+}
+
+// Retry reprocesses an event on an admin's request, including dead ones.
+func (s *PaymentWebhookService) Retry(ctx context.Context, id uint) (*models.PaymentEvent, error) {
+	result := db.DB.WithContext(ctx).Model(&models.PaymentEvent{}).
+		Where("id = ? AND status = ?", id, models.PaymentEventDead).
+		Updates(map[string]interface{}{"status": models.PaymentEventFailed, "attempts": 0})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	processErr := s.Process(ctx, id)
+	if errors.Is(processErr, ErrPaymentEventNotFound) {
+		return nil, processErr
+	}
+
+	return s.Get(id)
+}
+
+func (s *PaymentWebhookService) Get(id uint) (*models.PaymentEvent, error) {
+	var event models.PaymentEvent
+	if err := db.DB.First(&event, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrPaymentEventNotFound
+		}
+		return nil, err
+	}
+	return &event, nil
+}
+
+func (s *PaymentWebhookService) List(status string, page, pageSize int) (*PaymentEventListResult, error) {
+	var events []models.PaymentEvent
+	var total int64
+
+	query := db.DB.Model(&models.PaymentEvent{})
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	query.Count(&total)
+
+	if err := query.Order("created_at desc").Scopes(db.Paginate(page, pageSize)).Find(&events).Error; err != nil {
+		return nil, err
+	}
+
+	pagination := db.Pagination{Page: page, PageSize: pageSize}
+	limit := pagination.GetLimit()
+
+	return &PaymentEventListResult{
+		Events:     events,
+		Total:      total,
+		Page:       page,
+		PageSize:   limit,
+		TotalPages: pagination.GetTotalPages(total),
+	}, nil
+}
@@ -2,12 +2,12 @@ package services
 
 import (
 	"log"
-	"math"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/jeremy/ai-autonomous-webshop/backend/internal/db"
 	"github.com/jeremy/ai-autonomous-webshop/backend/internal/models"
+	"gorm.io/gorm"
 )
 
 type PricingService struct{}
@@ -36,16 +36,18 @@ func (s *PricingService) AdjustPrices() {
 			inventoryFactor = 0.90
 		}
 
-		// Calculate new price
-		newPrice := oldPrice * demandFactor * inventoryFactor
+		// Calculate new price, rounded half to even to the cent
+		newPrice := oldPrice.Mul(demandFactor * inventoryFactor)
 
-		// Round to 2 decimal places
-		newPrice = math.Round(newPrice*100) / 100
-
-		if newPrice != oldPrice {
+		if !newPrice.Equal(oldPrice) {
+			// Only the price: stock moves under checkouts meanwhile. The
+			// version bump keeps staff from saving over it unawares.
 			p.Price = newPrice
-			db.DB.Save(&p)
-			log.Printf("AI Agent: Adjusted price for %s: $%.2f -> $%.2f", p.Name, oldPrice, newPrice)
+			db.DB.Model(&p).Updates(map[string]interface{}{
+				"price":   newPrice,
+				"version": gorm.Expr("version + 1"),
+			})
+			log.Printf("AI Agent: Adjusted price for %s: $%s -> $%s", p.Name, oldPrice, newPrice)
 
 			// Notify Admin of price change
 			Notifier.NotifyUser(1, "PRICE_ADJUSTMENT", "AI adjusted price for "+p.Name, gin.H{
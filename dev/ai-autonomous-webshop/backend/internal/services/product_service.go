@@ -1,11 +1,84 @@
 package services
 
 import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
 	"github.com/jeremy/ai-autonomous-webshop/backend/internal/db"
 	"github.com/jeremy/ai-autonomous-webshop/backend/internal/models"
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/money"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrProductModified   = errors.New("product was changed since it was read")
+	ErrProductNotDeleted = errors.New("product is not deleted")
 )
 
+// ProductInput is what staff send to replace a product's details. Stock
+// is not part of it: it belongs to the variants. The first of CategoryIDs
+// is the primary category; Attributes must be ones the categories define,
+// with every required one set. The slug follows the name.
+type ProductInput struct {
+	Name            string            `json:"name" binding:"required,max=255"`
+	MetaTitle       string            `json:"meta_title" binding:"max=70"`
+	MetaDescription string            `json:"meta_description" binding:"max=160"`
+	Description     string            `json:"description" binding:"max=10000"`
+	Price           float64           `json:"price" binding:"required,gt=0"`
+	ImageURL        string            `json:"image_url" binding:"omitempty,url"`
+	BlurHash        string            `json:"blur_hash" binding:"max=100"`
+	CategoryIDs     []uint            `json:"category_ids" binding:"max=20"`
+	Attributes      map[string]string `json:"attributes"`
+	MaxQuantity     int               `json:"max_quantity" binding:"min=0"`
+	WeightGrams     int               `json:"weight_grams" binding:"min=0"`
+}
+
+// ProductCreateInput is a new product. Stock is what its single variant
+// starts out with.
+type ProductCreateInput struct {
+	ProductInput
+	Stock int `json:"stock" binding:"min=0"`
+}
+
+// ProductPatch changes only the fields that are set.
+type ProductPatch struct {
+	Name            *string            `json:"name" binding:"omitempty,min=1,max=255"`
+	MetaTitle       *string            `json:"meta_title" binding:"omitempty,max=70"`
+	MetaDescription *string            `json:"meta_description" binding:"omitempty,max=160"`
+	Description     *string            `json:"description" binding:"omitempty,max=10000"`
+	Price           *float64           `json:"price" binding:"omitempty,gt=0"`
+	ImageURL        *string            `json:"image_url" binding:"omitempty,url"`
+	BlurHash        *string            `json:"blur_hash" binding:"omitempty,max=100"`
+	CategoryIDs     *[]uint            `json:"category_ids" binding:"omitempty,max=20"`
+	Attributes      *map[string]string `json:"attributes"`
+	MaxQuantity     *int               `json:"max_quantity" binding:"omitempty,min=0"`
+	WeightGrams     *int               `json:"weight_grams" binding:"omitempty,min=0"`
+}
+
+type BulkPriceUpdate struct {
+	ProductID uint    `json:"product_id" binding:"required"`
+	Price     float64 `json:"price" binding:"required,gt=0"`
+}
+
+// BulkStockUpdate sets the stock of a variant. VariantID may be left out
+// for a product with a single variant.
+type BulkStockUpdate struct {
+	ProductID uint `json:"product_id" binding:"required"`
+	VariantID uint `json:"variant_id"`
+	Stock     int  `json:"stock" binding:"min=0"`
+}
+
+// productColumns are the columns staff edits write. Stock is kept by the
+// variants and never written from a product edit.
+var productColumns = []string{
+	"name", "slug", "meta_title", "meta_description", "description", "price", "image_url",
+	"blur_hash", "category", "category_id", "attributes", "max_quantity", "weight_grams",
+	"version", "updated_at",
+}
+
 type ProductService struct{}
 
 type ProductListResult struct {
@@ -30,7 +103,7 @@ func (s *ProductService) GetPaginated(page, pageSize int) (*ProductListResult, e
 
 	db.DB.Model(&models.Product{}).Count(&total)
 
-	if err := db.DB.Scopes(db.Paginate(page, pageSize)).Find(&products).Error; err != nil {
+	if err := withVariants(db.DB).Preload("Categories").Scopes(db.Paginate(page, pageSize)).Find(&products).Error; err != nil {
 		return nil, err
 	}
 
@@ -48,14 +121,24 @@ func (s *ProductService) GetPaginated(page, pageSize int) (*ProductListResult, e
 	}, nil
 }
 
+// GetByCategory lists the products in a category, given by slug or name,
+// and in the categories below it. An unknown category lists nothing.
 func (s *ProductService) GetByCategory(category string, page, pageSize int) (*ProductListResult, error) {
 	var products []models.Product
 	var total int64
 
-	query := db.DB.Model(&models.Product{}).Where("category = ?", category)
+	query := db.DB.Model(&models.Product{})
+	found, err := categoryBySlugOrName(db.DB, category)
+	if errors.Is(err, ErrCategoryNotFound) {
+		query = query.Where("1 = 0")
+	} else if err != nil {
+		return nil, err
+	} else {
+		query = query.Scopes(inCategoryTree(found.ID))
+	}
 	query.Count(&total)
 
-	if err := query.Scopes(db.Paginate(page, pageSize)).Find(&products).Error; err != nil {
+	if err := withVariants(query).Preload("Categories").Scopes(db.Paginate(page, pageSize)).Find(&products).Error; err != nil {
 		return nil, err
 	}
 
@@ -75,7 +158,7 @@ func (s *ProductService) GetByCategory(category string, page, pageSize int) (*Pr
 
 func (s *ProductService) GetByID(id uint) (*models.Product, error) {
 	var product models.Product
-	if err := db.DB.First(&product, id).Error; err != nil {
+	if err := withVariants(db.DB).Preload("Categories").First(&product, id).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return nil, nil
 		}
@@ -84,6 +167,353 @@ func (s *ProductService) GetByID(id uint) (*models.Product, error) {
 	return &product, nil
 }
 
-func (s *ProductService) Create(product *models.Product) error {
-	return db.DB.Create(product).Error
+// GetBySlug finds a product by its slug. A slug the product had before a
+// rename finds no product but gives its current slug, to redirect to.
+func (s *ProductService) GetBySlug(slug string) (*models.Product, string, error) {
+	var product models.Product
+	err := withVariants(db.DB).Preload("Categories").Where("slug = ?", slug).First(&product).Error
+	if err == nil {
+		return &product, "", nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, "", err
+	}
+
+	var current []string
+	if err := db.DB.Model(&models.ProductSlugRedirect{}).
+		Joins("JOIN products ON products.id = product_slug_redirects.product_id AND products.deleted_at IS NULL").
+		Where("product_slug_redirects.slug = ?", slug).Pluck("products.slug", &current).Error; err != nil {
+		return nil, "", err
+	}
+	if len(current) == 0 {
+		return nil, "", nil
+	}
+	return nil, current[0], nil
+}
+
+// ListDeleted lists soft-deleted products, most recently deleted first,
+// for staff to pick ones to restore.
+func (s *ProductService) ListDeleted(page, pageSize int) (*ProductListResult, error) {
+	var products []models.Product
+	var total int64
+
+	query := db.DB.Model(&models.Product{}).Unscoped().Where("deleted_at IS NOT NULL")
+	query.Count(&total)
+
+	if err := query.Order("deleted_at desc").Scopes(db.Paginate(page, pageSize)).Find(&products).Error; err != nil {
+		return nil, err
+	}
+
+	pagination := db.Pagination{Page: page, PageSize: pageSize}
+	return &ProductListResult{
+		Products:   products,
+		Total:      total,
+		Page:       page,
+		PageSize:   pagination.GetLimit(),
+		TotalPages: pagination.GetTotalPages(total),
+	}, nil
+}
+
+// Create adds a product with a single variant holding its stock. Option
+// types, and with them more variants, are set up afterwards with
+// VariantService.SetOptions.
+func (s *ProductService) Create(input ProductCreateInput) (*models.Product, error) {
+	product := &models.Product{Stock: input.Stock, Version: 1}
+	applyProductInput(product, input.ProductInput)
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := setProductSlug(tx, product); err != nil {
+			return err
+		}
+		if err := tx.Create(product).Error; err != nil {
+			return err
+		}
+		if err := setProductCategories(tx, product, input.CategoryIDs, true); err != nil {
+			return err
+		}
+		if err := tx.Model(product).Select("category_id", "category").Updates(product).Error; err != nil {
+			return err
+		}
+		return createDefaultVariant(tx, product)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return product, nil
+}
+
+// Update replaces a product's details. version is the version the edit was
+// made against; 0 skips the check.
+func (s *ProductService) Update(id uint, version int, input ProductInput) (*models.Product, error) {
+	return s.edit(id, version, func(p *models.Product) ([]uint, bool) {
+		applyProductInput(p, input)
+		return input.CategoryIDs, true
+	})
+}
+
+// Patch changes the fields of a product that are set in patch. version is
+// as for Update.
+func (s *ProductService) Patch(id uint, version int, patch ProductPatch) (*models.Product, error) {
+	return s.edit(id, version, func(p *models.Product) ([]uint, bool) {
+		if patch.Name != nil {
+			p.Name = strings.TrimSpace(*patch.Name)
+		}
+		if patch.MetaTitle != nil {
+			p.MetaTitle = *patch.MetaTitle
+		}
+		if patch.MetaDescription != nil {
+			p.MetaDescription = *patch.MetaDescription
+		}
+		if patch.Description != nil {
+			p.Description = *patch.Description
+		}
+		if patch.Price != nil {
+			p.Price = money.FromFloat(*patch.Price, BaseCurrency)
+		}
+		if patch.ImageURL != nil {
+			p.ImageURL = *patch.ImageURL
+		}
+		if patch.BlurHash != nil {
+			p.BlurHash = *patch.BlurHash
+		}
+		if patch.MaxQuantity != nil {
+			p.MaxQuantity = *patch.MaxQuantity
+		}
+		if patch.WeightGrams != nil {
+			p.WeightGrams = *patch.WeightGrams
+		}
+		if patch.CategoryIDs == nil && patch.Attributes == nil {
+			return nil, false
+		}
+		categoryIDs := productCategoryIDs(p)
+		if patch.CategoryIDs != nil {
+			categoryIDs = *patch.CategoryIDs
+		}
+		if patch.Attributes != nil {
+			p.Attributes = *patch.Attributes
+		}
+		return categoryIDs, true
+	})
+}
+
+// edit applies change to a locked product and bumps its version, refusing
+// with ErrProductModified when the product is no longer at version. change
+// returns the product's categories, and whether they or its attributes
+// changed and need checking. A renamed product gets a new slug.
+func (s *ProductService) edit(id uint, version int, change func(*models.Product) ([]uint, bool)) (*models.Product, error) {
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		var product models.Product
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("Categories").First(&product, id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrProductNotFound
+			}
+			return err
+		}
+		if version != 0 && product.Version != version {
+			return ErrProductModified
+		}
+		name := product.Name
+		if categoryIDs, changed := change(&product); changed {
+			if err := setProductCategories(tx, &product, categoryIDs, true); err != nil {
+				return err
+			}
+		}
+		if product.Name != name || product.Slug == "" {
+			if err := setProductSlug(tx, &product); err != nil {
+				return err
+			}
+		}
+		product.Version++
+		return tx.Model(&product).Select(productColumns).Omit(clause.Associations).Updates(&product).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.GetByID(id)
+}
+
+// Delete soft-deletes a product. It drops out of the catalog and can no
+// longer be bought; carts holding it show it as unavailable, and orders
+// keep their copy of it. version is as for Update.
+func (s *ProductService) Delete(id uint, version int) error {
+	return db.DB.Transaction(func(tx *gorm.DB) error {
+		var product models.Product
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&product, id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrProductNotFound
+			}
+			return err
+		}
+		if version != 0 && product.Version != version {
+			return ErrProductModified
+		}
+		if err := tx.Model(&product).Update("version", gorm.Expr("version + 1")).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&product).Error
+	})
+}
+
+// Restore brings a soft-deleted product back into the catalog.
+func (s *ProductService) Restore(id uint) (*models.Product, error) {
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		var product models.Product
+		if err := tx.Unscoped().Clauses(clause.Locking{Strength: "UPDATE"}).First(&product, id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrProductNotFound
+			}
+			return err
+		}
+		if !product.DeletedAt.Valid {
+			return ErrProductNotDeleted
+		}
+		return tx.Unscoped().Model(&product).Updates(map[string]interface{}{
+			"deleted_at": nil,
+			"version":    gorm.Expr("version + 1"),
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.GetByID(id)
+}
+
+// BulkUpdatePrices sets the price of several products at once. Either all
+// of them change or, when one is missing, none do.
+func (s *ProductService) BulkUpdatePrices(updates []BulkPriceUpdate) (int, error) {
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		for _, u := range updates {
+			result := tx.Model(&models.Product{}).Where("id = ?", u.ProductID).Updates(map[string]interface{}{
+				"price":   money.FromFloat(u.Price, BaseCurrency),
+				"version": gorm.Expr("version + 1"),
+			})
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				return fmt.Errorf("%w: %d", ErrProductNotFound, u.ProductID)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(updates), nil
+}
+
+// BulkUpdateStock sets the stock of several variants at once, all or
+// nothing. As with UpdateVariant, stock set here is what is on the shelf
+// now; stock on hold for carts and orders comes on top of it.
+func (s *ProductService) BulkUpdateStock(updates []BulkStockUpdate) (int, error) {
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		stock := make(map[uint]int, len(updates))
+		variantIDs := make([]uint, 0, len(updates))
+		for _, u := range updates {
+			variantID, err := resolveVariant(tx, u.ProductID, u.VariantID)
+			if errors.Is(err, ErrProductUnavailable) {
+				return fmt.Errorf("%w: %d", ErrProductNotFound, u.ProductID)
+			}
+			if err != nil {
+				return err
+			}
+			var count int64
+			if err := tx.Model(&models.ProductVariant{}).
+				Where("id = ? AND product_id = ?", variantID, u.ProductID).Count(&count).Error; err != nil {
+				return err
+			}
+			if count == 0 {
+				return fmt.Errorf("%w: %d", ErrVariantNotFound, variantID)
+			}
+			if _, ok := stock[variantID]; !ok {
+				variantIDs = append(variantIDs, variantID)
+			}
+			stock[variantID] = u.Stock
+		}
+
+		productIDs, err := lockVariantProducts(tx, variantIDs)
+		if err != nil {
+			return err
+		}
+		sort.Slice(variantIDs, func(i, j int) bool { return variantIDs[i] < variantIDs[j] })
+		for _, id := range variantIDs {
+			if err := tx.Model(&models.ProductVariant{}).Where("id = ?", id).
+				Update("stock", stock[id]).Error; err != nil {
+				return err
+			}
+		}
+		return syncProductStock(tx, productIDs)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(updates), nil
+}
+
+// BulkSetCategories puts several products in the given categories at once,
+// replacing the ones they were in, all or nothing. Required attributes the
+// new categories define are left for staff to fill in.
+func (s *ProductService) BulkSetCategories(productIDs, categoryIDs []uint) (int, error) {
+	var products []models.Product
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id IN ?", productIDs).
+			Order("id").Find(&products).Error; err != nil {
+			return err
+		}
+		found := make(map[uint]bool, len(products))
+		for _, p := range products {
+			found[p.ID] = true
+		}
+		for _, id := range productIDs {
+			if !found[id] {
+				return fmt.Errorf("%w: %d", ErrProductNotFound, id)
+			}
+		}
+
+		for i := range products {
+			p := &products[i]
+			if err := setProductCategories(tx, p, categoryIDs, false); err != nil {
+				return err
+			}
+			if err := tx.Model(p).Omit(clause.Associations).Updates(map[string]interface{}{
+				"category_id": p.CategoryID,
+				"category":    p.Category,
+				"version":     gorm.Expr("version + 1"),
+			}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(products), nil
+}
+
+func applyProductInput(p *models.Product, in ProductInput) {
+	p.Name = strings.TrimSpace(in.Name)
+	p.MetaTitle = in.MetaTitle
+	p.MetaDescription = in.MetaDescription
+	p.Description = in.Description
+	p.Price = money.FromFloat(in.Price, BaseCurrency)
+	p.ImageURL = in.ImageURL
+	p.BlurHash = in.BlurHash
+	p.Attributes = in.Attributes
+	p.MaxQuantity = in.MaxQuantity
+	p.WeightGrams = in.WeightGrams
+}
+
+// productCategoryIDs lists the categories of a product loaded with them,
+// its primary category first.
+func productCategoryIDs(p *models.Product) []uint {
+	ids := make([]uint, 0, len(p.Categories))
+	if p.CategoryID != nil {
+		ids = append(ids, *p.CategoryID)
+	}
+	for _, c := range p.Categories {
+		if p.CategoryID == nil || c.ID != *p.CategoryID {
+			ids = append(ids, c.ID)
+		}
+	}
+	return ids
 }
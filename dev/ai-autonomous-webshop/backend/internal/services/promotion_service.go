@@ -0,0 +1,665 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/db"
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/models"
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/money"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrPromotionNotFound  = errors.New("promotion not found")
+	ErrPromotionCodeTaken = errors.New("promotion code is already in use")
+	ErrInvalidPromotion   = errors.New("invalid promotion")
+)
+
+// Reasons a coupon code doesn't apply.
+const (
+	CouponUnknown        = "unknown"
+	CouponNotStarted     = "not_started"
+	CouponExpired        = "expired"
+	CouponUsedUp         = "usage_limit_reached"
+	CouponUserLimit      = "per_user_limit_reached"
+	CouponMinimumNotMet  = "minimum_not_met"
+	CouponNoMatchingItem = "no_matching_items"
+	CouponNotCombinable  = "not_combinable"
+)
+
+// CouponError is returned when a coupon code can't be used.
+type CouponError struct {
+	Code   string
+	Reason string
+}
+
+func (e *CouponError) Error() string {
+	return fmt.Sprintf("coupon %s cannot be used: %s", e.Code, e.Reason)
+}
+
+// PromotionLine is a cart or order line as the promotion engine sees it.
+// Promotions are scoped by product and category; discounts are booked per
+// variant.
+type PromotionLine struct {
+	ProductID  uint
+	VariantID  uint
+	Categories []string
+	Price      money.Money
+	Quantity   int
+}
+
+// AppliedPromotion is a promotion that applies to a cart or order and what
+// it takes off.
+type AppliedPromotion struct {
+	PromotionID  uint        `json:"promotion_id"`
+	Code         string      `json:"code,omitempty"`
+	Name         string      `json:"name"`
+	Type         string      `json:"type"`
+	Amount       money.Money `json:"amount"`
+	FreeShipping bool        `json:"free_shipping,omitempty"`
+}
+
+type RejectedCoupon struct {
+	Code   string `json:"code"`
+	Reason string `json:"reason"`
+}
+
+// PromotionResult is the outcome of running the promotions against some
+// lines. LineDiscounts splits Discount over the variants it was taken off.
+type PromotionResult struct {
+	Applied       []AppliedPromotion
+	Rejected      []RejectedCoupon
+	Discount      money.Money
+	FreeShipping  bool
+	LineDiscounts map[uint]money.Money
+}
+
+// PromotionInput is what staff send to create or replace a promotion.
+type PromotionInput struct {
+	Name         string     `json:"name" binding:"required"`
+	Description  string     `json:"description"`
+	Code         string     `json:"code"`
+	Type         string     `json:"type" binding:"required"`
+	Value        float64    `json:"value" binding:"min=0"`
+	BuyQuantity  int        `json:"buy_quantity" binding:"min=0"`
+	GetQuantity  int        `json:"get_quantity" binding:"min=0"`
+	ProductIDs   []uint     `json:"product_ids"`
+	Categories   []string   `json:"categories"`
+	MinSubtotal  float64    `json:"min_subtotal" binding:"min=0"`
+	StartsAt     *time.Time `json:"starts_at"`
+	EndsAt       *time.Time `json:"ends_at"`
+	UsageLimit   int        `json:"usage_limit" binding:"min=0"`
+	PerUserLimit int        `json:"per_user_limit" binding:"min=0"`
+	Stackable    bool       `json:"stackable"`
+	Priority     int        `json:"priority"`
+	Active       *bool      `json:"active"`
+}
+
+type PromotionListResult struct {
+	Promotions []models.Promotion `json:"promotions"`
+	Total      int64              `json:"total"`
+	Page       int                `json:"page"`
+	PageSize   int                `json:"page_size"`
+	TotalPages int                `json:"total_pages"`
+}
+
+// PromotionUsage sums up the redemptions of a promotion. Released
+// redemptions, from orders that failed or were cancelled, are counted
+// separately and left out of the other figures.
+type PromotionUsage struct {
+	PromotionID   uint        `json:"promotion_id"`
+	Name          string      `json:"name"`
+	Code          string      `json:"code,omitempty"`
+	Type          string      `json:"type"`
+	Redemptions   int64       `json:"redemptions"`
+	Released      int64       `json:"released"`
+	Customers     int64       `json:"customers"`
+	DiscountTotal money.Money `json:"discount_total"`
+	LastUsedAt    *time.Time  `json:"last_used_at,omitempty"`
+}
+
+type PromotionRedemptionsResult struct {
+	Usage       PromotionUsage         `json:"usage"`
+	Redemptions []models.OrderDiscount `json:"redemptions"`
+	Total       int64                  `json:"total"`
+	Page        int                    `json:"page"`
+	PageSize    int                    `json:"page_size"`
+	TotalPages  int                    `json:"total_pages"`
+}
+
+type PromotionService struct{}
+
+func NewPromotionService() *PromotionService {
+	return &PromotionService{}
+}
+
+// NormalizeCouponCode is how codes are stored and compared.
+func NormalizeCouponCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// CheckCode tells whether a code belongs to a promotion that can be used
+// right now, before it is applied to a cart. Whether it applies to the
+// cart's contents is only known when the cart is priced.
+func (s *PromotionService) CheckCode(code string) error {
+	code = NormalizeCouponCode(code)
+	var promotion models.Promotion
+	if err := db.DB.Where("code = ? AND active = ?", code, true).First(&promotion).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return &CouponError{Code: code, Reason: CouponUnknown}
+		}
+		return err
+	}
+	if reason := promotionUnavailable(&promotion, time.Now()); reason != "" {
+		return &CouponError{Code: code, Reason: reason}
+	}
+	return nil
+}
+
+// promotionUnavailable returns why a promotion can't be used at all at
+// now, or "" if it can.
+func promotionUnavailable(p *models.Promotion, now time.Time) string {
+	switch {
+	case p.StartsAt != nil && now.Before(*p.StartsAt):
+		return CouponNotStarted
+	case p.EndsAt != nil && !now.Before(*p.EndsAt):
+		return CouponExpired
+	case p.UsageLimit > 0 && p.UsageCount >= p.UsageLimit:
+		return CouponUsedUp
+	}
+	return ""
+}
+
+// Evaluate works out which active promotions apply to lines: those without
+// a code plus those whose code is in codes. Codes that don't apply are
+// listed in Rejected with the reason. userID zero (a guest) skips the
+// per-user limits, which checkout enforces. With lock set the promotions
+// are locked for update so that usage limits hold under concurrent
+// checkouts.
+func (s *PromotionService) Evaluate(tx *gorm.DB, userID uint, lines []PromotionLine, codes []string, lock bool) (*PromotionResult, error) {
+	result := &PromotionResult{Discount: money.Zero(BaseCurrency), LineDiscounts: make(map[uint]money.Money)}
+
+	wanted := make([]string, 0, len(codes))
+	for _, code := range codes {
+		wanted = append(wanted, NormalizeCouponCode(code))
+	}
+
+	query := tx.Where("active = ?", true)
+	if len(wanted) > 0 {
+		query = query.Where("code = '' OR code IN ?", wanted)
+	} else {
+		query = query.Where("code = ''")
+	}
+	if lock {
+		query = query.Clauses(clause.Locking{Strength: "UPDATE"})
+	}
+	var promotions []models.Promotion
+	if err := query.Order("id").Find(&promotions).Error; err != nil {
+		return nil, err
+	}
+
+	found := make(map[string]bool, len(promotions))
+	for _, p := range promotions {
+		found[p.Code] = true
+	}
+	for _, code := range wanted {
+		if !found[code] {
+			result.Rejected = append(result.Rejected, RejectedCoupon{Code: code, Reason: CouponUnknown})
+		}
+	}
+	if len(promotions) == 0 {
+		return result, nil
+	}
+
+	used := make(map[uint]int)
+	if userID != 0 {
+		ids := make([]uint, 0, len(promotions))
+		for _, p := range promotions {
+			ids = append(ids, p.ID)
+		}
+		var rows []struct {
+			PromotionID uint
+			Uses        int
+		}
+		if err := tx.Model(&models.OrderDiscount{}).Select("promotion_id, COUNT(*) AS uses").
+			Where("user_id = ? AND released = ? AND promotion_id IN ?", userID, false, ids).
+			Group("promotion_id").Scan(&rows).Error; err != nil {
+			return nil, err
+		}
+		for _, r := range rows {
+			used[r.PromotionID] = r.Uses
+		}
+	}
+
+	sort.SliceStable(promotions, func(i, j int) bool {
+		return promotions[i].Priority > promotions[j].Priority
+	})
+
+	subtotal := money.Zero(BaseCurrency)
+	remaining := make(map[uint]money.Money, len(lines))
+	for _, line := range lines {
+		value := line.Price.Times(int64(line.Quantity))
+		subtotal = subtotal.Add(value)
+		remaining[line.VariantID] = remaining[line.VariantID].Add(value)
+	}
+
+	now := time.Now()
+	exclusive := false
+	for i := range promotions {
+		p := &promotions[i]
+		reason := promotionUnavailable(p, now)
+		switch {
+		case reason != "":
+		case userID != 0 && p.PerUserLimit > 0 && used[p.ID] >= p.PerUserLimit:
+			reason = CouponUserLimit
+		case subtotal.LessThan(p.MinSubtotal):
+			reason = CouponMinimumNotMet
+		case exclusive || (len(result.Applied) > 0 && !p.Stackable):
+			reason = CouponNotCombinable
+		}
+
+		var discounts map[uint]money.Money
+		if reason == "" {
+			var matched bool
+			discounts, matched = promotionDiscounts(p, lines, remaining)
+			if !matched {
+				reason = CouponNoMatchingItem
+			}
+		}
+		if reason != "" {
+			if p.Code != "" {
+				result.Rejected = append(result.Rejected, RejectedCoupon{Code: p.Code, Reason: reason})
+			}
+			continue
+		}
+
+		applied := AppliedPromotion{
+			PromotionID:  p.ID,
+			Code:         p.Code,
+			Name:         p.Name,
+			Type:         p.Type,
+			Amount:       money.Zero(BaseCurrency),
+			FreeShipping: p.Type == models.PromotionFreeShipping,
+		}
+		for variantID, amount := range discounts {
+			remaining[variantID] = remaining[variantID].Sub(amount)
+			result.LineDiscounts[variantID] = result.LineDiscounts[variantID].Add(amount)
+			applied.Amount = applied.Amount.Add(amount)
+		}
+		result.Discount = result.Discount.Add(applied.Amount)
+		result.FreeShipping = result.FreeShipping || applied.FreeShipping
+		result.Applied = append(result.Applied, applied)
+		if !p.Stackable {
+			exclusive = true
+		}
+	}
+	return result, nil
+}
+
+// promotionDiscounts works out what p takes off each variant given what is
+// left of each line after earlier promotions. matched is false when no line
+// is in the promotion's scope, or a buy-X-get-Y needs more units.
+func promotionDiscounts(p *models.Promotion, lines []PromotionLine, remaining map[uint]money.Money) (map[uint]money.Money, bool) {
+	var scoped []PromotionLine
+	scopedValue := money.Zero(BaseCurrency)
+	for _, line := range lines {
+		if p.AppliesTo(line.ProductID, line.Categories) {
+			scoped = append(scoped, line)
+			scopedValue = scopedValue.Add(remaining[line.VariantID])
+		}
+	}
+	if len(scoped) == 0 {
+		return nil, false
+	}
+
+	discounts := make(map[uint]money.Money)
+	switch p.Type {
+	case models.PromotionPercentage:
+		percent := math.Min(p.Value, 100)
+		for _, line := range scoped {
+			discounts[line.VariantID] = remaining[line.VariantID].Percent(percent)
+		}
+
+	case models.PromotionFixedAmount:
+		// Spread over the lines in proportion to their value without
+		// losing a cent to rounding.
+		if !scopedValue.IsPositive() {
+			return discounts, true
+		}
+		amount := money.Min(money.FromFloat(p.Value, BaseCurrency), scopedValue)
+		ratios := make([]int64, len(scoped))
+		for i, line := range scoped {
+			ratios[i] = remaining[line.VariantID].Minor()
+		}
+		for i, share := range amount.Allocate(ratios...) {
+			variantID := scoped[i].VariantID
+			discounts[variantID] = money.Min(share, remaining[variantID])
+		}
+
+	case models.PromotionBuyXGetY:
+		group := p.BuyQuantity + p.GetQuantity
+		if p.BuyQuantity < 1 || p.GetQuantity < 1 {
+			return nil, false
+		}
+		type unit struct {
+			variantID uint
+			price     money.Money
+		}
+		var units []unit
+		for _, line := range scoped {
+			for i := 0; i < line.Quantity; i++ {
+				units = append(units, unit{line.VariantID, line.Price})
+			}
+		}
+		free := len(units) / group * p.GetQuantity
+		if free == 0 {
+			return nil, false
+		}
+		sort.SliceStable(units, func(i, j int) bool { return units[i].price.LessThan(units[j].price) })
+		for _, u := range units[:free] {
+			discounts[u.variantID] = discounts[u.variantID].Add(u.price)
+		}
+		for variantID, amount := range discounts {
+			discounts[variantID] = money.Min(amount, remaining[variantID])
+		}
+
+	case models.PromotionFreeShipping:
+		// Nothing comes off the items; the order ships for free.
+	}
+	return discounts, true
+}
+
+// applyToOrderItems runs the promotions for a new order inside the checkout
+// transaction, with the promotions locked, and books each line's share of
+// the discount on it. Any coupon that doesn't apply fails the checkout.
+func (s *PromotionService) applyToOrderItems(tx *gorm.DB, userID uint, items []models.OrderItem, categories map[uint][]string, coupons []string) (*PromotionResult, error) {
+	lines := make([]PromotionLine, 0, len(items))
+	for _, item := range items {
+		lines = append(lines, PromotionLine{
+			ProductID:  item.ProductID,
+			VariantID:  item.VariantID,
+			Categories: categories[item.ProductID],
+			Price:      item.Price,
+			Quantity:   item.Quantity,
+		})
+	}
+
+	result, err := s.Evaluate(tx, userID, lines, coupons, true)
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Rejected) > 0 {
+		return nil, &CouponError{Code: result.Rejected[0].Code, Reason: result.Rejected[0].Reason}
+	}
+	for i := range items {
+		items[i].Discount = money.Zero(BaseCurrency).Add(result.LineDiscounts[items[i].VariantID])
+	}
+	return result, nil
+}
+
+// recordDiscounts stores the promotions applied to a new order and counts
+// them against their usage limits.
+func recordDiscounts(tx *gorm.DB, order *models.Order, applied []AppliedPromotion) error {
+	for _, a := range applied {
+		discount := models.OrderDiscount{
+			OrderID:     order.ID,
+			PromotionID: a.PromotionID,
+			UserID:      order.UserID,
+			Code:        a.Code,
+			Name:        a.Name,
+			Type:        a.Type,
+			Amount:      a.Amount,
+		}
+		if err := tx.Create(&discount).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.Promotion{}).Where("id = ?", a.PromotionID).
+			Update("usage_count", gorm.Expr("usage_count + 1")).Error; err != nil {
+			return err
+		}
+		order.Discounts = append(order.Discounts, discount)
+	}
+	return nil
+}
+
+// releaseDiscounts gives back the promotion uses of an order that failed or
+// was cancelled.
+func releaseDiscounts(tx *gorm.DB, orderID uint) error {
+	var discounts []models.OrderDiscount
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("order_id = ? AND released = ?", orderID, false).Find(&discounts).Error; err != nil {
+		return err
+	}
+	for _, d := range discounts {
+		if err := tx.Model(&d).Update("released", true).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.Promotion{}).Unscoped().Where("id = ? AND usage_count > 0", d.PromotionID).
+			Update("usage_count", gorm.Expr("usage_count - 1")).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *PromotionService) List(active *bool, page, pageSize int) (*PromotionListResult, error) {
+	var promotions []models.Promotion
+	var total int64
+
+	query := db.DB.Model(&models.Promotion{})
+	if active != nil {
+		query = query.Where("active = ?", *active)
+	}
+	query.Count(&total)
+
+	if err := query.Order("created_at desc").Scopes(db.Paginate(page, pageSize)).Find(&promotions).Error; err != nil {
+		return nil, err
+	}
+
+	pagination := db.Pagination{Page: page, PageSize: pageSize}
+	limit := pagination.GetLimit()
+
+	return &PromotionListResult{
+		Promotions: promotions,
+		Total:      total,
+		Page:       page,
+		PageSize:   limit,
+		TotalPages: pagination.GetTotalPages(total),
+	}, nil
+}
+
+func (s *PromotionService) Get(id uint) (*models.Promotion, error) {
+	var promotion models.Promotion
+	if err := db.DB.First(&promotion, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrPromotionNotFound
+		}
+		return nil, err
+	}
+	return &promotion, nil
+}
+
+func (s *PromotionService) Create(input PromotionInput, staffID uint) (*models.Promotion, error) {
+	promotion := &models.Promotion{CreatedBy: staffID, Active: true}
+	if err := applyPromotionInput(promotion, input); err != nil {
+		return nil, err
+	}
+	if err := s.checkCodeFree(promotion.Code, 0); err != nil {
+		return nil, err
+	}
+	if err := db.DB.Create(promotion).Error; err != nil {
+		return nil, err
+	}
+	return promotion, nil
+}
+
+// Update replaces a promotion's rules. Orders placed before keep the
+// discount they got.
+func (s *PromotionService) Update(id uint, input PromotionInput) (*models.Promotion, error) {
+	promotion, err := s.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := applyPromotionInput(promotion, input); err != nil {
+		return nil, err
+	}
+	if err := s.checkCodeFree(promotion.Code, id); err != nil {
+		return nil, err
+	}
+	if err := db.DB.Save(promotion).Error; err != nil {
+		return nil, err
+	}
+	return promotion, nil
+}
+
+func (s *PromotionService) Delete(id uint) error {
+	result := db.DB.Delete(&models.Promotion{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrPromotionNotFound
+	}
+	return nil
+}
+
+func (s *PromotionService) checkCodeFree(code string, exceptID uint) error {
+	if code == "" {
+		return nil
+	}
+	var count int64
+	if err := db.DB.Model(&models.Promotion{}).Where("code = ? AND id <> ?", code, exceptID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrPromotionCodeTaken
+	}
+	return nil
+}
+
+func applyPromotionInput(p *models.Promotion, in PromotionInput) error {
+	if !models.IsValidPromotionType(in.Type) {
+		return fmt.Errorf("%w: unknown type %q", ErrInvalidPromotion, in.Type)
+	}
+	switch in.Type {
+	case models.PromotionPercentage:
+		if in.Value <= 0 || in.Value > 100 {
+			return fmt.Errorf("%w: percentage must be between 0 and 100", ErrInvalidPromotion)
+		}
+	case models.PromotionFixedAmount:
+		if in.Value <= 0 {
+			return fmt.Errorf("%w: amount must be positive", ErrInvalidPromotion)
+		}
+	case models.PromotionBuyXGetY:
+		if in.BuyQuantity < 1 || in.GetQuantity < 1 {
+			return fmt.Errorf("%w: buy_quantity and get_quantity must be at least 1", ErrInvalidPromotion)
+		}
+	}
+	if in.StartsAt != nil && in.EndsAt != nil && !in.EndsAt.After(*in.StartsAt) {
+		return fmt.Errorf("%w: ends_at must be after starts_at", ErrInvalidPromotion)
+	}
+
+	p.Name = in.Name
+	p.Description = in.Description
+	p.Code = NormalizeCouponCode(in.Code)
+	p.Type = in.Type
+	p.Value = in.Value
+	p.BuyQuantity = in.BuyQuantity
+	p.GetQuantity = in.GetQuantity
+	p.ProductIDs = in.ProductIDs
+	p.Categories = in.Categories
+	p.MinSubtotal = money.FromFloat(in.MinSubtotal, BaseCurrency)
+	p.StartsAt = in.StartsAt
+	p.EndsAt = in.EndsAt
+	p.UsageLimit = in.UsageLimit
+	p.PerUserLimit = in.PerUserLimit
+	p.Stackable = in.Stackable
+	p.Priority = in.Priority
+	if in.Active != nil {
+		p.Active = *in.Active
+	}
+	if p.Type != models.PromotionBuyXGetY {
+		p.BuyQuantity, p.GetQuantity = 0, 0
+	}
+	return nil
+}
+
+// UsageReport sums up the redemptions of every promotion, deleted ones
+// included, in the optional [from, to) window.
+func (s *PromotionService) UsageReport(from, to *time.Time) ([]PromotionUsage, error) {
+	return s.usage(0, from, to)
+}
+
+// Usage sums up one promotion and lists its redemptions, newest first.
+func (s *PromotionService) Usage(id uint, page, pageSize int) (*PromotionRedemptionsResult, error) {
+	var promotion models.Promotion
+	if err := db.DB.Unscoped().First(&promotion, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrPromotionNotFound
+		}
+		return nil, err
+	}
+
+	usage := PromotionUsage{PromotionID: promotion.ID, Name: promotion.Name, Code: promotion.Code, Type: promotion.Type}
+	rows, err := s.usage(id, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) > 0 {
+		usage = rows[0]
+	}
+
+	var redemptions []models.OrderDiscount
+	var total int64
+	query := db.DB.Model(&models.OrderDiscount{}).Where("promotion_id = ?", id)
+	query.Count(&total)
+	if err := query.Order("created_at desc").Scopes(db.Paginate(page, pageSize)).Find(&redemptions).Error; err != nil {
+		return nil, err
+	}
+
+	pagination := db.Pagination{Page: page, PageSize: pageSize}
+	limit := pagination.GetLimit()
+
+	return &PromotionRedemptionsResult{
+		Usage:       usage,
+		Redemptions: redemptions,
+		Total:       total,
+		Page:        page,
+		PageSize:    limit,
+		TotalPages:  pagination.GetTotalPages(total),
+	}, nil
+}
+
+func (s *PromotionService) usage(promotionID uint, from, to *time.Time) ([]PromotionUsage, error) {
+	query := db.DB.Table("order_discounts").
+		Select(`promotions.id AS promotion_id, promotions.name, promotions.code, promotions.type,
+			COUNT(*) FILTER (WHERE NOT order_discounts.released) AS redemptions,
+			COUNT(*) FILTER (WHERE order_discounts.released) AS released,
+			COUNT(DISTINCT order_discounts.user_id) FILTER (WHERE NOT order_discounts.released) AS customers,
+			COALESCE(SUM(order_discounts.amount) FILTER (WHERE NOT order_discounts.released), 0) AS discount_total,
+			MAX(order_discounts.created_at) AS last_used_at`).
+		Joins("JOIN promotions ON promotions.id = order_discounts.promotion_id").
+		Group("promotions.id, promotions.name, promotions.code, promotions.type").
+		Order("redemptions desc, promotions.id")
+	if promotionID != 0 {
+		query = query.Where("order_discounts.promotion_id = ?", promotionID)
+	}
+	if from != nil {
+		query = query.Where("order_discounts.created_at >= ?", *from)
+	}
+	if to != nil {
+		query = query.Where("order_discounts.created_at < ?", *to)
+	}
+
+	var rows []PromotionUsage
+	if err := query.Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
@@ -0,0 +1,286 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/db"
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/models"
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/money"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrOrderNotCancellable = errors.New("order can no longer be cancelled")
+	ErrOrderNotRefundable  = errors.New("order has no payment to refund")
+	ErrInvalidRefundAmount = errors.New("refund amount must be positive")
+	ErrRefundExceedsPaid   = errors.New("refund exceeds the amount left to refund")
+	ErrRefundFailed        = errors.New("payment provider refused the refund, staff have been notified")
+)
+
+// RefundService cancels orders and gives money back through the payment
+// gateway. Refunds are made in the order's presentment currency, what the
+// customer was charged in. Every refund is stored as a models.Refund and
+// added to the order's PresentmentRefundedTotal, which is what limits
+// further refunds, and at the locked rate to its RefundedTotal.
+type RefundService struct {
+	payments *PaymentService
+}
+
+func NewRefundService() *RefundService {
+	return &RefundService{payments: NewPaymentService()}
+}
+
+// Cancel cancels an order that hasn't shipped. An unpaid order has its
+// payment voided and its stock hold released; a paid one is refunded in
+// full and its items go back into stock. userID restricts it to the
+// owner's orders; zero means any order (staff).
+func (s *RefundService) Cancel(ctx context.Context, orderID, userID uint, actor OrderActor, reason string) (*models.Order, error) {
+	var order models.Order
+	var refund *models.Refund
+	var transition *OrderTransition
+
+	err := db.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := lockOrder(tx, &order, orderID, userID); err != nil {
+			return err
+		}
+		if !models.CanTransitionOrder(order.Status, models.OrderStatusCancelled) {
+			return ErrOrderNotCancellable
+		}
+
+		switch order.Status {
+		case models.OrderStatusPending, models.OrderStatusAuthorized:
+			// Nothing is captured without the order being paid, so a failed
+			// void only leaves an authorization to lapse at the bank.
+			if order.PaymentIntentID != "" {
+				if err := s.payments.Void(ctx, tx, &order); err != nil {
+					log.Printf("Failed to void intent %s of cancelled order %d: %v", order.PaymentIntentID, order.ID, err)
+				}
+			}
+		default:
+			if remaining := order.PresentmentTotal.Sub(order.PresentmentRefundedTotal); remaining.IsPositive() {
+				var err error
+				if refund, err = s.refund(ctx, tx, &order, remaining, reason, actor, nil); err != nil {
+					return err
+				}
+			}
+			quantities := make(map[uint]int)
+			for _, item := range order.Items {
+				quantities[item.VariantID] += item.Quantity - item.ReturnedQuantity
+			}
+			if err := restock(tx, quantities); err != nil {
+				return err
+			}
+		}
+
+		var err error
+		transition, err = transitionOrder(tx, &order, models.OrderStatusCancelled, actor, reason)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	notifyOrderTransition(transition)
+	if refund != nil {
+		if err := s.settle(ctx, &order, refund); err != nil {
+			return nil, err
+		}
+	}
+	notifyRefund(&order, refund)
+	return &order, nil
+}
+
+// Refund gives back part or all of what is left of a paid order, without
+// anything being returned, e.g. as a goodwill gesture. amount is in the
+// order's presentment currency, in major units, and is rounded half to
+// even to its minor unit.
+func (s *RefundService) Refund(ctx context.Context, orderID uint, amount float64, reason string, actor OrderActor) (*models.Refund, error) {
+	var order models.Order
+	var refund *models.Refund
+	var transition *OrderTransition
+
+	err := db.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := lockOrder(tx, &order, orderID, 0); err != nil {
+			return err
+		}
+
+		var err error
+		refund, err = s.refund(ctx, tx, &order, currencyOf(&order).amount(amount), reason, actor, nil)
+		if err != nil {
+			return err
+		}
+		transition, err = transitionAfterRefund(tx, &order, actor, reason)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	notifyOrderTransition(transition)
+	if err := s.settle(ctx, &order, refund); err != nil {
+		return nil, err
+	}
+	notifyRefund(&order, refund)
+	return refund, nil
+}
+
+func (s *RefundService) ListForOrder(orderID uint) ([]models.Refund, error) {
+	var count int64
+	if err := db.DB.Model(&models.Order{}).Where("id = ?", orderID).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		return nil, ErrOrderNotFound
+	}
+
+	var refunds []models.Refund
+	if err := db.DB.Where("order_id = ?", orderID).Order("created_at, id").Find(&refunds).Error; err != nil {
+		return nil, err
+	}
+	return refunds, nil
+}
+
+// refund records a pending refund of amount, in the order's presentment
+// currency, and counts it in the order's refunded totals so that no other
+// refund can take the same money. The caller holds the order lock and,
+// once its transaction has committed, hands the refund to settle; the
+// gateway is never called with the lock held. The base amount is taken at
+// the order's locked rate; the refund that settles the order gets whatever
+// base amount is left, so rounding never leaves a remainder.
+func (s *RefundService) refund(ctx context.Context, tx *gorm.DB, order *models.Order, amount money.Money, reason string, actor OrderActor, returnID *uint) (*models.Refund, error) {
+	if !orderWasPaid(order.Status) || order.PaymentIntentID == "" {
+		return nil, ErrOrderNotRefundable
+	}
+	if !amount.IsPositive() {
+		return nil, ErrInvalidRefundAmount
+	}
+	remaining := order.PresentmentTotal.Sub(order.PresentmentRefundedTotal)
+	if amount.GreaterThan(remaining) {
+		return nil, ErrRefundExceedsPaid
+	}
+	baseAmount := currencyOf(order).base(amount)
+	if amount.Equal(remaining) {
+		baseAmount = order.Total.Sub(order.RefundedTotal)
+	}
+
+	refund := &models.Refund{
+		OrderID:         order.ID,
+		ReturnRequestID: returnID,
+		Amount:          amount,
+		Currency:        order.Currency,
+		BaseAmount:      baseAmount,
+		Reason:          reason,
+		Provider:        s.payments.Gateway().Name(),
+		Status:          models.RefundStatusPending,
+		Actor:           actor.Type,
+		ActorID:         actor.ID,
+	}
+	if err := tx.Create(refund).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Model(&models.Order{}).Where("id = ?", order.ID).Updates(map[string]interface{}{
+		"refunded_total":             gorm.Expr("refunded_total + ?", baseAmount.Minor()),
+		"presentment_refunded_total": gorm.Expr("presentment_refunded_total + ?", amount.Minor()),
+	}).Error; err != nil {
+		return nil, err
+	}
+	order.RefundedTotal = order.RefundedTotal.Add(baseAmount)
+	order.PresentmentRefundedTotal = order.PresentmentRefundedTotal.Add(amount)
+	return refund, nil
+}
+
+// settle moves a pending refund back to the customer through the gateway
+// and records the outcome in a second transaction: the provider's reference
+// and the credit note when it went through. When it didn't, the refund is
+// marked failed and taken out of the order's refunded totals again, and
+// staff are told, as whatever the refund was for has already happened. If
+// the gateway refunds but the outcome can't be recorded, the refund stays
+// pending and still counted, which is what was paid back.
+func (s *RefundService) settle(ctx context.Context, order *models.Order, refund *models.Refund) error {
+	result, refundErr := s.payments.Refund(ctx, db.DB, order, refund.Amount)
+
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		var current models.Order
+		if err := lockOrder(tx, &current, order.ID, 0); err != nil {
+			return err
+		}
+
+		if refundErr != nil {
+			refund.Status = models.RefundStatusFailed
+			if err := tx.Model(refund).Update("status", refund.Status).Error; err != nil {
+				return err
+			}
+			return tx.Model(&models.Order{}).Where("id = ?", order.ID).Updates(map[string]interface{}{
+				"refunded_total":             gorm.Expr("refunded_total - ?", refund.BaseAmount.Minor()),
+				"presentment_refunded_total": gorm.Expr("presentment_refunded_total - ?", refund.Amount.Minor()),
+			}).Error
+		}
+
+		refund.ProviderRef = result.ID
+		refund.Status = result.Status
+		if err := tx.Model(refund).Updates(map[string]interface{}{
+			"provider_ref": refund.ProviderRef,
+			"status":       refund.Status,
+		}).Error; err != nil {
+			return err
+		}
+		issueDocument(tx, current.ID, func(tx *gorm.DB) error {
+			_, err := issueCreditNote(tx, &current, refund)
+			return err
+		})
+		return nil
+	})
+
+	if refundErr != nil {
+		if err != nil {
+			log.Printf("Failed to record failed refund %d of order %d: %v", refund.ID, order.ID, err)
+		}
+		reportCompensationFailure(order.UserID, order.PaymentIntentID, refund.Amount.Minor(), "refund", refundErr)
+		return fmt.Errorf("%w: %v", ErrRefundFailed, refundErr)
+	}
+	if err != nil {
+		log.Printf("CRITICAL: refund %s of order %d went through but could not be recorded: %v", result.ID, order.ID, err)
+	}
+	return nil
+}
+
+// transitionAfterRefund moves the order to refunded once nothing is left to
+// refund, partially_refunded before that. A disputed order only follows
+// once it is fully refunded.
+func transitionAfterRefund(tx *gorm.DB, order *models.Order, actor OrderActor, reason string) (*OrderTransition, error) {
+	to := models.OrderStatusPartiallyRefunded
+	if !order.PresentmentRefundedTotal.LessThan(order.PresentmentTotal) {
+		to = models.OrderStatusRefunded
+	}
+	if !models.CanTransitionOrder(order.Status, to) {
+		return nil, nil
+	}
+	return transitionOrder(tx, order, to, actor, reason)
+}
+
+// lockOrder loads an order with its items for update. userID restricts it
+// to the owner's orders; zero means any order.
+func lockOrder(tx *gorm.DB, order *models.Order, orderID, userID uint) error {
+	query := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("Items").Where("id = ?", orderID)
+	if userID != 0 {
+		query = query.Where("user_id = ?", userID)
+	}
+	if err := query.First(order).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrOrderNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+func notifyRefund(order *models.Order, refund *models.Refund) {
+	if refund == nil || Notifier == nil {
+		return
+	}
+	go Notifier.NotifyUser(order.UserID, "REFUND_ISSUED", fmt.Sprintf("%s refunded for order #%d", formatInvoiceAmount(refund.Amount), order.ID), refund)
+}
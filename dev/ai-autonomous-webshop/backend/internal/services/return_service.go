@@ -0,0 +1,361 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/db"
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/models"
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/money"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrReturnNotFound      = errors.New("return request not found")
+	ErrReturnNotAllowed    = errors.New("order is not eligible for returns")
+	ErrReturnEmpty         = errors.New("return request has no items")
+	ErrInvalidReturnReason = errors.New("invalid return reason")
+	ErrReturnQuantity      = errors.New("return quantity exceeds what is left to return")
+	ErrReturnNotPending    = errors.New("return request has already been reviewed")
+)
+
+// ReturnLine is one order line a customer wants to send back.
+type ReturnLine struct {
+	OrderItemID uint   `json:"order_item_id" binding:"required"`
+	Quantity    int    `json:"quantity" binding:"required,min=1"`
+	Reason      string `json:"reason" binding:"required"`
+	Comment     string `json:"comment"`
+}
+
+// ReturnDecision is what staff decide when approving a return. A nil
+// RefundAmount refunds the returned lines at what was paid for them,
+// discounts and tax included; zero approves without refunding. Amounts
+// are in the order's presentment currency. Restock defaults to true.
+type ReturnDecision struct {
+	RefundAmount *float64 `json:"refund_amount"`
+	Restock      *bool    `json:"restock"`
+	Note         string   `json:"note"`
+}
+
+type ReturnListResult struct {
+	Returns    []models.ReturnRequest `json:"returns"`
+	Total      int64                  `json:"total"`
+	Page       int                    `json:"page"`
+	PageSize   int                    `json:"page_size"`
+	TotalPages int                    `json:"total_pages"`
+}
+
+// returnableStatuses are the order statuses a return can be requested in.
+// Before shipping the customer cancels instead.
+var returnableStatuses = map[string]bool{
+	models.OrderStatusShipped:           true,
+	models.OrderStatusDelivered:         true,
+	models.OrderStatusPartiallyRefunded: true,
+}
+
+type ReturnService struct {
+	refunds *RefundService
+}
+
+func NewReturnService() *ReturnService {
+	return &ReturnService{refunds: NewRefundService()}
+}
+
+// Request opens a return for some lines of the user's order. Quantities are
+// checked against what was bought minus what was returned or is waiting
+// for review already.
+func (s *ReturnService) Request(ctx context.Context, userID, orderID uint, lines []ReturnLine, note string) (*models.ReturnRequest, error) {
+	if len(lines) == 0 {
+		return nil, ErrReturnEmpty
+	}
+	for _, line := range lines {
+		if line.Quantity < 1 {
+			return nil, ErrInvalidQuantity
+		}
+		if !models.IsValidReturnReason(line.Reason) {
+			return nil, ErrInvalidReturnReason
+		}
+	}
+
+	request := &models.ReturnRequest{
+		OrderID: orderID,
+		UserID:  userID,
+		Status:  models.ReturnStatusRequested,
+		Note:    note,
+	}
+
+	err := db.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// The order lock serializes requests for the same order, so two of
+		// them can't both claim the last returnable unit.
+		var order models.Order
+		if err := lockOrder(tx, &order, orderID, userID); err != nil {
+			return err
+		}
+		if !returnableStatuses[order.Status] {
+			return ErrReturnNotAllowed
+		}
+		request.Currency = order.Currency
+
+		pending, err := pendingReturnQuantities(tx, orderID)
+		if err != nil {
+			return err
+		}
+		items := make(map[uint]models.OrderItem, len(order.Items))
+		for _, item := range order.Items {
+			items[item.ID] = item
+		}
+
+		requested := make(map[uint]int)
+		for _, line := range lines {
+			item, ok := items[line.OrderItemID]
+			if !ok {
+				return fmt.Errorf("%w: order item %d is not part of this order", ErrReturnNotAllowed, line.OrderItemID)
+			}
+			requested[item.ID] += line.Quantity
+			if requested[item.ID] > item.Quantity-item.ReturnedQuantity-pending[item.ID] {
+				return ErrReturnQuantity
+			}
+			request.Items = append(request.Items, models.ReturnItem{
+				OrderItemID: item.ID,
+				ProductID:   item.ProductID,
+				Quantity:    line.Quantity,
+				Reason:      line.Reason,
+				Comment:     line.Comment,
+			})
+		}
+		return tx.Create(request).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if Notifier != nil {
+		go Notifier.NotifyAdmins("RETURN_REQUESTED", fmt.Sprintf("Return requested for order #%d", orderID), request)
+	}
+	return request, nil
+}
+
+func pendingReturnQuantities(tx *gorm.DB, orderID uint) (map[uint]int, error) {
+	var rows []struct {
+		OrderItemID uint
+		Quantity    int
+	}
+	if err := tx.Model(&models.ReturnItem{}).
+		Select("return_items.order_item_id, SUM(return_items.quantity) AS quantity").
+		Joins("JOIN return_requests ON return_requests.id = return_items.return_request_id").
+		Where("return_requests.order_id = ? AND return_requests.status = ?", orderID, models.ReturnStatusRequested).
+		Group("return_items.order_item_id").Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	pending := make(map[uint]int, len(rows))
+	for _, r := range rows {
+		pending[r.OrderItemID] = r.Quantity
+	}
+	return pending, nil
+}
+
+// Approve accepts a return: returned quantities are booked on the order
+// lines, optionally put back into stock, and the refund goes through the
+// payment gateway.
+func (s *ReturnService) Approve(ctx context.Context, id, staffID uint, decision ReturnDecision) (*models.ReturnRequest, error) {
+	if decision.RefundAmount != nil && *decision.RefundAmount < 0 {
+		return nil, ErrInvalidRefundAmount
+	}
+
+	var request models.ReturnRequest
+	var order models.Order
+	var refund *models.Refund
+	var transition *OrderTransition
+	actor := StaffActor(staffID)
+
+	err := db.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := s.lockForReview(tx, id, &request, &order); err != nil {
+			return err
+		}
+
+		items := make(map[uint]models.OrderItem, len(order.Items))
+		for _, item := range order.Items {
+			items[item.ID] = item
+		}
+
+		currency := currencyOf(&order)
+		amount := currency.zero()
+		quantities := make(map[uint]int)
+		for _, ri := range request.Items {
+			item := items[ri.OrderItemID]
+			if err := tx.Model(&models.OrderItem{}).Where("id = ?", item.ID).
+				Update("returned_quantity", gorm.Expr("returned_quantity + ?", ri.Quantity)).Error; err != nil {
+				return err
+			}
+			quantities[item.VariantID] += ri.Quantity
+			// Discount and tax are given back in proportion, so a return
+			// refunds exactly what was paid for the units, in the currency
+			// they were paid in.
+			paid := item.PresentmentPrice.Times(int64(item.Quantity)).Sub(item.PresentmentDiscount).Add(item.PresentmentTaxAmount)
+			amount = amount.Add(paid.MulRat(big.NewRat(int64(ri.Quantity), int64(item.Quantity))))
+		}
+
+		restocked := decision.Restock == nil || *decision.Restock
+		if restocked {
+			if err := restock(tx, quantities); err != nil {
+				return err
+			}
+		}
+
+		if decision.RefundAmount != nil {
+			amount = currency.amount(*decision.RefundAmount)
+		}
+		// Earlier refunds may have used up part of what this return is
+		// worth; refund what is left rather than failing.
+		amount = money.Min(amount, order.PresentmentTotal.Sub(order.PresentmentRefundedTotal))
+		if amount.IsPositive() {
+			var err error
+			refund, err = s.refunds.refund(ctx, tx, &order, amount, fmt.Sprintf("return #%d", request.ID), actor, &request.ID)
+			if err != nil {
+				return err
+			}
+			if transition, err = transitionAfterRefund(tx, &order, actor, fmt.Sprintf("return #%d approved", request.ID)); err != nil {
+				return err
+			}
+		} else {
+			amount = currency.zero()
+		}
+
+		request.RefundAmount = amount
+		request.Currency = order.Currency
+		request.Restocked = restocked
+		return s.review(tx, &request, models.ReturnStatusApproved, staffID, decision.Note)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	notifyOrderTransition(transition)
+	notifyReturnReviewed(&request)
+	if refund != nil {
+		if err := s.refunds.settle(ctx, &order, refund); err != nil {
+			return nil, err
+		}
+	}
+	notifyRefund(&order, refund)
+	return &request, nil
+}
+
+func (s *ReturnService) Reject(ctx context.Context, id, staffID uint, note string) (*models.ReturnRequest, error) {
+	var request models.ReturnRequest
+
+	err := db.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var order models.Order
+		if err := s.lockForReview(tx, id, &request, &order); err != nil {
+			return err
+		}
+		return s.review(tx, &request, models.ReturnStatusRejected, staffID, note)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	notifyReturnReviewed(&request)
+	return &request, nil
+}
+
+// lockForReview locks the order and then the return request, the same
+// order Request takes them in, and checks it still awaits review.
+func (s *ReturnService) lockForReview(tx *gorm.DB, id uint, request *models.ReturnRequest, order *models.Order) error {
+	var orderID uint
+	if err := tx.Model(&models.ReturnRequest{}).Where("id = ?", id).Pluck("order_id", &orderID).Error; err != nil {
+		return err
+	}
+	if orderID == 0 {
+		return ErrReturnNotFound
+	}
+	if err := lockOrder(tx, order, orderID, 0); err != nil {
+		return err
+	}
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("Items").First(request, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrReturnNotFound
+		}
+		return err
+	}
+	if request.Status != models.ReturnStatusRequested {
+		return ErrReturnNotPending
+	}
+	return nil
+}
+
+// review records the decision; Approve sets RefundAmount and Restocked on
+// request beforehand.
+func (s *ReturnService) review(tx *gorm.DB, request *models.ReturnRequest, status string, staffID uint, note string) error {
+	now := time.Now()
+	request.Status = status
+	request.ResolutionNote = note
+	request.ReviewedBy = &staffID
+	request.ReviewedAt = &now
+	return tx.Model(request).
+		Select("status", "resolution_note", "reviewed_by", "reviewed_at", "refund_amount", "currency", "restocked").
+		Updates(request).Error
+}
+
+func notifyReturnReviewed(request *models.ReturnRequest) {
+	if Notifier == nil {
+		return
+	}
+	go Notifier.NotifyUser(request.UserID, "RETURN_"+strings.ToUpper(request.Status), fmt.Sprintf("Your return #%d for order #%d was %s", request.ID, request.OrderID, request.Status), request)
+}
+
+// Get returns a return request. userID restricts it to the user's own
+// returns; zero means any (staff).
+func (s *ReturnService) Get(id, userID uint) (*models.ReturnRequest, error) {
+	query := db.DB.Preload("Items").Where("id = ?", id)
+	if userID != 0 {
+		query = query.Where("user_id = ?", userID)
+	}
+	var request models.ReturnRequest
+	if err := query.First(&request).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrReturnNotFound
+		}
+		return nil, err
+	}
+	return &request, nil
+}
+
+func (s *ReturnService) GetByUserID(userID uint) ([]models.ReturnRequest, error) {
+	var requests []models.ReturnRequest
+	if err := db.DB.Preload("Items").Where("user_id = ?", userID).Order("created_at desc").Find(&requests).Error; err != nil {
+		return nil, err
+	}
+	return requests, nil
+}
+
+func (s *ReturnService) List(status string, page, pageSize int) (*ReturnListResult, error) {
+	var requests []models.ReturnRequest
+	var total int64
+
+	query := db.DB.Model(&models.ReturnRequest{})
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	query.Count(&total)
+
+	if err := query.Preload("Items").Order("created_at desc").Scopes(db.Paginate(page, pageSize)).Find(&requests).Error; err != nil {
+		return nil, err
+	}
+
+	pagination := db.Pagination{Page: page, PageSize: pageSize}
+	limit := pagination.GetLimit()
+
+	return &ReturnListResult{
+		Returns:    requests,
+		Total:      total,
+		Page:       page,
+		PageSize:   limit,
+		TotalPages: pagination.GetTotalPages(total),
+	}, nil
+}
@@ -0,0 +1,85 @@
+package services
+
+import (
+	"errors"
+
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/db"
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrInvalidRole   = errors.New("invalid role")
+	ErrUserNotFound  = errors.New("user not found")
+	ErrImplicitRole  = errors.New("customer role is implicit and cannot be changed")
+	ErrLastAdminRole = errors.New("cannot revoke the last admin")
+)
+
+type RoleService struct{}
+
+func (s *RoleService) GetUserRoles(userID uint) ([]string, error) {
+	var user models.User
+	if err := db.DB.Preload("Roles").First(&user, userID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+	return user.RoleNames(), nil
+}
+
+func (s *RoleService) Grant(userID uint, role string, grantedBy uint) error {
+	if !models.IsValidRole(role) {
+		return ErrInvalidRole
+	}
+	if role == models.RoleCustomer {
+		return ErrImplicitRole
+	}
+
+	var user models.User
+	if err := db.DB.First(&user, userID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrUserNotFound
+		}
+		return err
+	}
+
+	return db.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.UserRole{
+		UserID:    userID,
+		Role:      role,
+		GrantedBy: grantedBy,
+	}).Error
+}
+
+func (s *RoleService) Revoke(userID uint, role string) error {
+	if !models.IsValidRole(role) {
+		return ErrInvalidRole
+	}
+	if role == models.RoleCustomer {
+		return ErrImplicitRole
+	}
+
+	return db.DB.Transaction(func(tx *gorm.DB) error {
+		if role == models.RoleAdmin {
+			// Locking every admin row first makes concurrent revokes take
+			// turns, so two admins revoking each other can't both see the
+			// other one still there.
+			var admins []models.UserRole
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+				Where("role = ?", models.RoleAdmin).Order("id").Find(&admins).Error; err != nil {
+				return err
+			}
+			otherAdmins := 0
+			for _, a := range admins {
+				if a.UserID != userID {
+					otherAdmins++
+				}
+			}
+			if otherAdmins == 0 {
+				return ErrLastAdminRole
+			}
+		}
+		return tx.Where("user_id = ? AND role = ?", userID, role).Delete(&models.UserRole{}).Error
+	})
+}
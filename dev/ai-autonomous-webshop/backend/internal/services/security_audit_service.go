@@ -0,0 +1,57 @@
+package services
+
+import (
+	"log"
+
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/db"
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/models"
+)
+
+type SecurityAuditService struct{}
+
+type SecurityEventListResult struct {
+	Events     []models.SecurityEvent `json:"events"`
+	Total      int64                  `json:"total"`
+	Page       int                    `json:"page"`
+	PageSize   int                    `json:"page_size"`
+	TotalPages int                    `json:"total_pages"`
+}
+
+// Record writes the event to the security_events table. Failures are only
+// logged: auditing must never block the request that triggered it.
+func (s *SecurityAuditService) Record(event *models.SecurityEvent) {
+	log.Printf("Security: %s email=%s ip=%s %s", event.Type, event.Email, event.IP, event.Detail)
+
+	if db.DB == nil {
+		return
+	}
+	if err := db.DB.Create(event).Error; err != nil {
+		log.Printf("Failed to record security event %s: %v", event.Type, err)
+	}
+}
+
+func (s *SecurityAuditService) List(eventType string, page, pageSize int) (*SecurityEventListResult, error) {
+	var events []models.SecurityEvent
+	var total int64
+
+	query := db.DB.Model(&models.SecurityEvent{})
+	if eventType != "" {
+		query = query.Where("type = ?", eventType)
+	}
+	query.Count(&total)
+
+	if err := query.Order("created_at desc").Scopes(db.Paginate(page, pageSize)).Find(&events).Error; err != nil {
+		return nil, err
+	}
+
+	pagination := db.Pagination{Page: page, PageSize: pageSize}
+	limit := pagination.GetLimit()
+
+	return &SecurityEventListResult{
+		Events:     events,
+		Total:      total,
+		Page:       page,
+		PageSize:   limit,
+		TotalPages: pagination.GetTotalPages(total),
+	}, nil
+}
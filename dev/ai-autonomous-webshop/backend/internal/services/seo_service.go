@@ -0,0 +1,284 @@
+package services
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/config"
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/db"
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrSitemapNotFound = errors.New("sitemap not found")
+
+// sitemapMaxURLs is how many URLs the sitemap protocol allows in one file.
+// A catalog with more is split into files listed by a sitemap index.
+const sitemapMaxURLs = 50000
+
+// productSlugMaxLen leaves room in the slug column for a numeric suffix.
+const productSlugMaxLen = 150
+
+const sitemapNamespace = "http://www.sitemaps.org/schemas/sitemap/0.9"
+
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"urlset"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapIndex struct {
+	XMLName  xml.Name     `xml:"sitemapindex"`
+	Xmlns    string       `xml:"xmlns,attr"`
+	Sitemaps []sitemapURL `xml:"sitemap"`
+}
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+// SEOService builds what search engines read: the sitemap and the
+// structured data of product pages. Links point at the storefront under
+// APP_BASE_URL.
+type SEOService struct {
+	baseURL string
+}
+
+func NewSEOService() *SEOService {
+	return &SEOService{
+		baseURL: strings.TrimRight(config.LoadConfig().AppBaseURL, "/"),
+	}
+}
+
+// Sitemap is /sitemap.xml. It lists the home page, the categories and the
+// products when they fit in one file, and is an index of the files at
+// /sitemaps/ when they don't: categories.xml and products-1.xml onwards.
+func (s *SEOService) Sitemap() ([]byte, error) {
+	var products, categories int64
+	if err := db.DB.Model(&models.Product{}).Where("slug <> ''").Count(&products).Error; err != nil {
+		return nil, err
+	}
+	if err := db.DB.Model(&models.Category{}).Count(&categories).Error; err != nil {
+		return nil, err
+	}
+
+	if products+categories+1 <= sitemapMaxURLs {
+		urls, err := s.categoryURLs()
+		if err != nil {
+			return nil, err
+		}
+		productURLs, err := s.productURLs(1)
+		if err != nil {
+			return nil, err
+		}
+		return encodeSitemap(sitemapURLSet{Xmlns: sitemapNamespace, URLs: append(urls, productURLs...)})
+	}
+
+	index := sitemapIndex{Xmlns: sitemapNamespace}
+	index.Sitemaps = append(index.Sitemaps, sitemapURL{Loc: s.baseURL + "/sitemaps/categories.xml"})
+	pages := int((products + sitemapMaxURLs - 1) / sitemapMaxURLs)
+	for page := 1; page <= pages; page++ {
+		index.Sitemaps = append(index.Sitemaps, sitemapURL{Loc: fmt.Sprintf("%s/sitemaps/products-%d.xml", s.baseURL, page)})
+	}
+	return encodeSitemap(index)
+}
+
+// SitemapFile is one of the files the sitemap index lists.
+func (s *SEOService) SitemapFile(name string) ([]byte, error) {
+	if name == "categories.xml" {
+		urls, err := s.categoryURLs()
+		if err != nil {
+			return nil, err
+		}
+		return encodeSitemap(sitemapURLSet{Xmlns: sitemapNamespace, URLs: urls})
+	}
+
+	number := strings.TrimSuffix(strings.TrimPrefix(name, "products-"), ".xml")
+	page, err := strconv.Atoi(number)
+	if err != nil || page < 1 || name != "products-"+number+".xml" {
+		return nil, ErrSitemapNotFound
+	}
+	urls, err := s.productURLs(page)
+	if err != nil {
+		return nil, err
+	}
+	if len(urls) == 0 {
+		return nil, ErrSitemapNotFound
+	}
+	return encodeSitemap(sitemapURLSet{Xmlns: sitemapNamespace, URLs: urls})
+}
+
+// categoryURLs lists the home page and the category pages.
+func (s *SEOService) categoryURLs() ([]sitemapURL, error) {
+	var categories []models.Category
+	if err := db.DB.Select("slug", "updated_at").Order("id").Find(&categories).Error; err != nil {
+		return nil, err
+	}
+	urls := make([]sitemapURL, 0, len(categories)+1)
+	urls = append(urls, sitemapURL{Loc: s.baseURL + "/"})
+	for _, c := range categories {
+		urls = append(urls, sitemapURL{Loc: s.CategoryURL(c.Slug), LastMod: c.UpdatedAt.UTC().Format(time.RFC3339)})
+	}
+	return urls, nil
+}
+
+// productURLs lists one sitemap file's worth of product pages, page
+// counting from 1.
+func (s *SEOService) productURLs(page int) ([]sitemapURL, error) {
+	var products []models.Product
+	if err := db.DB.Select("id", "slug", "updated_at").Where("slug <> ''").Order("id").
+		Offset((page - 1) * sitemapMaxURLs).Limit(sitemapMaxURLs).Find(&products).Error; err != nil {
+		return nil, err
+	}
+	urls := make([]sitemapURL, 0, len(products))
+	for _, p := range products {
+		urls = append(urls, sitemapURL{Loc: s.ProductURL(p.Slug), LastMod: p.UpdatedAt.UTC().Format(time.RFC3339)})
+	}
+	return urls, nil
+}
+
+func encodeSitemap(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	if err := xml.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ProductURL is the storefront page of the product with the given slug.
+func (s *SEOService) ProductURL(slug string) string {
+	return s.baseURL + "/products/" + url.PathEscape(slug)
+}
+
+// CategoryURL is the storefront page of the category with the given slug.
+func (s *SEOService) CategoryURL(slug string) string {
+	return s.baseURL + "/categories/" + url.PathEscape(slug)
+}
+
+// ProductJSONLD is the schema.org Product description of a product page,
+// with an offer per variant.
+func (s *SEOService) ProductJSONLD(p *models.Product, variants []VariantAvailability) map[string]interface{} {
+	doc := map[string]interface{}{
+		"@context": "https://schema.org",
+		"@type":    "Product",
+		"name":     p.Name,
+		"url":      s.ProductURL(p.Slug),
+	}
+	description := p.MetaDescription
+	if description == "" {
+		description = p.Description
+	}
+	if description != "" {
+		doc["description"] = description
+	}
+	if p.ImageURL != "" {
+		doc["image"] = p.ImageURL
+	}
+	if p.Category != "" {
+		doc["category"] = p.Category
+	}
+
+	offers := make([]map[string]interface{}, 0, len(variants))
+	for _, v := range variants {
+		availability := "https://schema.org/OutOfStock"
+		if v.Available {
+			availability = "https://schema.org/InStock"
+		}
+		offer := map[string]interface{}{
+			"@type":         "Offer",
+			"sku":           v.SKU,
+			"price":         v.Price.String(),
+			"priceCurrency": v.Price.Currency(),
+			"availability":  availability,
+			"url":           s.ProductURL(p.Slug),
+		}
+		if v.Title != "" {
+			offer["name"] = v.Title
+		}
+		offers = append(offers, offer)
+	}
+
+	switch len(offers) {
+	case 0:
+	case 1:
+		doc["sku"] = offers[0]["sku"]
+		doc["offers"] = offers[0]
+	default:
+		low, high := variants[0].Price, variants[0].Price
+		for _, v := range variants[1:] {
+			if v.Price.LessThan(low) {
+				low = v.Price
+			}
+			if v.Price.GreaterThan(high) {
+				high = v.Price
+			}
+		}
+		doc["offers"] = map[string]interface{}{
+			"@type":         "AggregateOffer",
+			"lowPrice":      low.String(),
+			"highPrice":     high.String(),
+			"priceCurrency": low.Currency(),
+			"offerCount":    len(offers),
+			"offers":        offers,
+		}
+	}
+	return doc
+}
+
+// setProductSlug gives a product the slug of its name unless it has it
+// already. A slug another product has, or had before a rename, gets the
+// lowest free numeric suffix. The slug the product had is kept as a
+// redirect; the caller saves the slug column.
+func setProductSlug(tx *gorm.DB, p *models.Product) error {
+	base := Slugify(p.Name)
+	if runes := []rune(base); len(runes) > productSlugMaxLen {
+		base = strings.TrimRight(string(runes[:productSlugMaxLen]), "-")
+	}
+	if base == "" {
+		base = "product"
+	}
+
+	slug := base
+	for n := 2; ; n++ {
+		if slug == p.Slug {
+			return nil
+		}
+		var taken int64
+		if err := tx.Unscoped().Model(&models.Product{}).Where("slug = ? AND id <> ?", slug, p.ID).
+			Count(&taken).Error; err != nil {
+			return err
+		}
+		if taken == 0 {
+			if err := tx.Model(&models.ProductSlugRedirect{}).Where("slug = ? AND product_id <> ?", slug, p.ID).
+				Count(&taken).Error; err != nil {
+				return err
+			}
+		}
+		if taken == 0 {
+			break
+		}
+		slug = fmt.Sprintf("%s-%d", base, n)
+	}
+
+	if p.Slug != "" {
+		redirect := models.ProductSlugRedirect{ProductID: p.ID, Slug: p.Slug}
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&redirect).Error; err != nil {
+			return err
+		}
+	}
+	// Renamed back to an earlier name: that slug is the product's again.
+	if err := tx.Where("slug = ? AND product_id = ?", slug, p.ID).Delete(&models.ProductSlugRedirect{}).Error; err != nil {
+		return err
+	}
+	p.Slug = slug
+	return nil
+}
@@ -0,0 +1,167 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/config"
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/db"
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/models"
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/money"
+)
+
+// ShippingDestination is where a parcel is to go. Only Country is needed
+// for a quote.
+type ShippingDestination struct {
+	Country    string
+	Region     string
+	PostalCode string
+}
+
+// ShippingParcel is what is to be shipped: the total weight and the order
+// value net of discounts, in BaseCurrency.
+type ShippingParcel struct {
+	WeightGrams int
+	Value       money.Money
+}
+
+// ShippingQuote is the price of shipping a parcel with one method, in
+// BaseCurrency.
+type ShippingQuote struct {
+	MethodID uint        `json:"method_id"`
+	Name     string      `json:"name"`
+	Carrier  string      `json:"carrier"`
+	Price    money.Money `json:"price"`
+	MinDays  int         `json:"min_days"`
+	MaxDays  int         `json:"max_days"`
+}
+
+// ShippingProvider prices shipping. The table provider works off the zones,
+// methods and rate tables staff maintain; a carrier integration would ask
+// the carrier's rating API instead.
+type ShippingProvider interface {
+	Name() string
+	// Quote lists the methods that can ship parcel to destination,
+	// cheapest first. No quotes means nothing ships there.
+	Quote(ctx context.Context, destination ShippingDestination, parcel ShippingParcel) ([]ShippingQuote, error)
+	// TrackingURL links to the carrier's tracking page, or returns "" for
+	// a carrier it doesn't know.
+	TrackingURL(carrier, trackingNumber string) string
+}
+
+var (
+	defaultShippingProvider     ShippingProvider
+	defaultShippingProviderOnce sync.Once
+)
+
+// DefaultShippingProvider returns the provider selected by
+// SHIPPING_PROVIDER.
+func DefaultShippingProvider() ShippingProvider {
+	defaultShippingProviderOnce.Do(func() {
+		cfg := config.LoadConfig()
+		if cfg.ShippingProvider != "table" {
+			log.Printf("Unknown shipping provider %q, using the table provider", cfg.ShippingProvider)
+		}
+		defaultShippingProvider = NewTableShippingProvider()
+	})
+	return defaultShippingProvider
+}
+
+// trackingURLs are the tracking pages of the carriers we know, keyed by the
+// lower-cased carrier name.
+var trackingURLs = map[string]string{
+	"dhl":   "https://www.dhl.com/global-en/home/tracking.html?tracking-id=%s",
+	"fedex": "https://www.fedex.com/fedextrack/?trknbr=%s",
+	"ups":   "https://www.ups.com/track?tracknum=%s",
+	"usps":  "https://tools.usps.com/go/TrackConfirmAction?tLabels=%s",
+}
+
+// TableShippingProvider quotes from the shipping zones and rate tables in
+// the database.
+type TableShippingProvider struct{}
+
+func NewTableShippingProvider() *TableShippingProvider {
+	return &TableShippingProvider{}
+}
+
+func (p *TableShippingProvider) Name() string { return "table" }
+
+// Quote finds the zone listing the destination country, or else the rest
+// of the world zone, and prices every active method of it whose rate table
+// has a bracket for the parcel.
+func (p *TableShippingProvider) Quote(ctx context.Context, destination ShippingDestination, parcel ShippingParcel) ([]ShippingQuote, error) {
+	var zones []models.ShippingZone
+	if err := db.DB.WithContext(ctx).
+		Preload("Methods", "active = ?", true).
+		Preload("Methods.Rates").
+		Where("active = ?", true).Order("id").Find(&zones).Error; err != nil {
+		return nil, err
+	}
+
+	zone := matchShippingZone(zones, strings.ToUpper(destination.Country))
+	quotes := []ShippingQuote{}
+	if zone == nil {
+		return quotes, nil
+	}
+	for _, method := range zone.Methods {
+		value := parcel.Value.Float()
+		if method.RateBasis == models.ShippingRateByWeight {
+			value = float64(parcel.WeightGrams)
+		}
+		for _, rate := range method.Rates {
+			if !rate.Covers(value) {
+				continue
+			}
+			quotes = append(quotes, ShippingQuote{
+				MethodID: method.ID,
+				Name:     method.Name,
+				Carrier:  method.Carrier,
+				Price:    rate.Price,
+				MinDays:  method.MinDays,
+				MaxDays:  method.MaxDays,
+			})
+			break
+		}
+	}
+	sort.SliceStable(quotes, func(i, j int) bool {
+		if c := quotes[i].Price.Cmp(quotes[j].Price); c != 0 {
+			return c < 0
+		}
+		return quotes[i].MaxDays < quotes[j].MaxDays
+	})
+	return quotes, nil
+}
+
+func (p *TableShippingProvider) TrackingURL(carrier, trackingNumber string) string {
+	format, ok := trackingURLs[strings.ToLower(carrier)]
+	if !ok || trackingNumber == "" {
+		return ""
+	}
+	return fmt.Sprintf(format, url.QueryEscape(trackingNumber))
+}
+
+// matchShippingZone picks the first zone listing country, falling back to
+// the first zone without countries.
+func matchShippingZone(zones []models.ShippingZone, country string) *models.ShippingZone {
+	var rest *models.ShippingZone
+	for i := range zones {
+		zone := &zones[i]
+		if len(zone.Countries) == 0 {
+			if rest == nil {
+				rest = zone
+			}
+			continue
+		}
+		for _, c := range zone.Countries {
+			if c == country {
+				return zone
+			}
+		}
+	}
+	return rest
+}
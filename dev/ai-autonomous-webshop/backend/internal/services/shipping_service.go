@@ -0,0 +1,556 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/config"
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/db"
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/models"
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/money"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrShippingZoneNotFound   = errors.New("shipping zone not found")
+	ErrShippingMethodNotFound = errors.New("shipping method not found")
+	ErrInvalidShippingMethod  = errors.New("invalid shipping method")
+	ErrShippingUnavailable    = errors.New("shipping method is not available for this address and cart")
+	ErrShipmentNotFound       = errors.New("shipment not found")
+	ErrShipmentClosed         = errors.New("shipment has already been delivered or returned")
+	ErrInvalidShipmentStatus  = errors.New("invalid shipment status")
+	ErrOrderNotShippable      = errors.New("order cannot be shipped in its current status")
+)
+
+// ShippingZoneInput is what staff send to create or replace a zone.
+type ShippingZoneInput struct {
+	Name      string   `json:"name" binding:"required"`
+	Countries []string `json:"countries" binding:"dive,len=2"`
+	Active    *bool    `json:"active"`
+}
+
+type ShippingRateInput struct {
+	MinValue float64 `json:"min_value" binding:"min=0"`
+	MaxValue float64 `json:"max_value" binding:"min=0"`
+	Price    float64 `json:"price" binding:"min=0"`
+}
+
+// ShippingMethodInput is what staff send to create or replace a method.
+// Rates replaces the whole rate table.
+type ShippingMethodInput struct {
+	Name      string              `json:"name" binding:"required"`
+	Carrier   string              `json:"carrier" binding:"max=32"`
+	RateBasis string              `json:"rate_basis" binding:"required,oneof=weight price"`
+	MinDays   int                 `json:"min_days" binding:"min=0"`
+	MaxDays   int                 `json:"max_days" binding:"min=0"`
+	Active    *bool               `json:"active"`
+	Rates     []ShippingRateInput `json:"rates" binding:"required,min=1,dive"`
+}
+
+// ShipmentInput is what staff send when handing a parcel to a carrier.
+// TrackingURL defaults to the carrier's tracking page when the provider
+// knows it.
+type ShipmentInput struct {
+	Carrier        string `json:"carrier" binding:"required,max=32"`
+	Service        string `json:"service"`
+	TrackingNumber string `json:"tracking_number" binding:"required,max=64"`
+	TrackingURL    string `json:"tracking_url"`
+}
+
+// ShipmentEventInput is a tracking update. OccurredAt defaults to now.
+type ShipmentEventInput struct {
+	Status      string     `json:"status" binding:"required"`
+	Location    string     `json:"location"`
+	Description string     `json:"description"`
+	OccurredAt  *time.Time `json:"occurred_at"`
+}
+
+// ShipmentUpdate is pushed to the customer whenever a shipment of theirs is
+// created or its tracking changes.
+type ShipmentUpdate struct {
+	ShipmentID     uint                  `json:"shipment_id"`
+	OrderID        uint                  `json:"order_id"`
+	Status         string                `json:"status"`
+	Carrier        string                `json:"carrier"`
+	TrackingNumber string                `json:"tracking_number"`
+	TrackingURL    string                `json:"tracking_url,omitempty"`
+	Event          *models.ShipmentEvent `json:"event,omitempty"`
+}
+
+type ShippingService struct {
+	provider ShippingProvider
+}
+
+func NewShippingService() *ShippingService {
+	return &ShippingService{provider: DefaultShippingProvider()}
+}
+
+// Quote prices shipping a parcel to destination with every method that
+// can take it, cheapest first.
+func (s *ShippingService) Quote(ctx context.Context, destination ShippingDestination, parcel ShippingParcel) ([]ShippingQuote, error) {
+	return s.provider.Quote(ctx, destination, parcel)
+}
+
+// quoteMethod prices a parcel with the method the customer picked. It fails
+// with ErrShippingUnavailable if the method doesn't ship there, or not at
+// the parcel's weight or value.
+func (s *ShippingService) quoteMethod(ctx context.Context, destination ShippingDestination, parcel ShippingParcel, methodID uint) (*ShippingQuote, error) {
+	quotes, err := s.provider.Quote(ctx, destination, parcel)
+	if err != nil {
+		return nil, err
+	}
+	for i := range quotes {
+		if quotes[i].MethodID == methodID {
+			return &quotes[i], nil
+		}
+	}
+	return nil, ErrShippingUnavailable
+}
+
+// orderWeight adds up the weight of order lines in grams.
+func orderWeight(tx *gorm.DB, items []models.OrderItem) (int, error) {
+	variantIDs := make([]uint, 0, len(items))
+	productIDs := make([]uint, 0, len(items))
+	for _, item := range items {
+		variantIDs = append(variantIDs, item.VariantID)
+		productIDs = append(productIDs, item.ProductID)
+	}
+	var products []models.Product
+	if err := tx.Select("id", "weight_grams").Where("id IN ?", productIDs).Find(&products).Error; err != nil {
+		return 0, err
+	}
+	var variants []models.ProductVariant
+	if err := tx.Select("id", "product_id", "weight_grams").Where("id IN ?", variantIDs).Find(&variants).Error; err != nil {
+		return 0, err
+	}
+	byID := make(map[uint]*models.Product, len(products))
+	for i := range products {
+		byID[products[i].ID] = &products[i]
+	}
+	weights := make(map[uint]int, len(variants))
+	for _, v := range variants {
+		if p := byID[v.ProductID]; p != nil {
+			weights[v.ID] = v.Weight(p)
+		}
+	}
+	var total int
+	for _, item := range items {
+		total += weights[item.VariantID] * item.Quantity
+	}
+	return total, nil
+}
+
+func (s *ShippingService) ListZones() ([]models.ShippingZone, error) {
+	var zones []models.ShippingZone
+	if err := db.DB.Preload("Methods", func(tx *gorm.DB) *gorm.DB { return tx.Order("id") }).
+		Preload("Methods.Rates", func(tx *gorm.DB) *gorm.DB { return tx.Order("min_value") }).
+		Order("id").Find(&zones).Error; err != nil {
+		return nil, err
+	}
+	return zones, nil
+}
+
+func (s *ShippingService) CreateZone(input ShippingZoneInput) (*models.ShippingZone, error) {
+	zone := &models.ShippingZone{Active: true}
+	applyShippingZoneInput(zone, input)
+	if err := db.DB.Create(zone).Error; err != nil {
+		return nil, err
+	}
+	return zone, nil
+}
+
+func (s *ShippingService) UpdateZone(id uint, input ShippingZoneInput) (*models.ShippingZone, error) {
+	var zone models.ShippingZone
+	if err := db.DB.First(&zone, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrShippingZoneNotFound
+		}
+		return nil, err
+	}
+	applyShippingZoneInput(&zone, input)
+	if err := db.DB.Save(&zone).Error; err != nil {
+		return nil, err
+	}
+	return &zone, nil
+}
+
+// DeleteZone removes a zone with its methods. Orders keep the method name
+// and price they were placed with.
+func (s *ShippingService) DeleteZone(id uint) error {
+	return db.DB.Transaction(func(tx *gorm.DB) error {
+		var methodIDs []uint
+		if err := tx.Model(&models.ShippingMethod{}).Where("zone_id = ?", id).Pluck("id", &methodIDs).Error; err != nil {
+			return err
+		}
+		if len(methodIDs) > 0 {
+			if err := tx.Where("method_id IN ?", methodIDs).Delete(&models.ShippingRate{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("id IN ?", methodIDs).Delete(&models.ShippingMethod{}).Error; err != nil {
+				return err
+			}
+		}
+		result := tx.Delete(&models.ShippingZone{}, id)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrShippingZoneNotFound
+		}
+		return nil
+	})
+}
+
+func (s *ShippingService) CreateMethod(zoneID uint, input ShippingMethodInput) (*models.ShippingMethod, error) {
+	method := &models.ShippingMethod{ZoneID: zoneID, Active: true}
+	if err := applyShippingMethodInput(method, input); err != nil {
+		return nil, err
+	}
+
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&models.ShippingZone{}).Where("id = ?", zoneID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			return ErrShippingZoneNotFound
+		}
+		return tx.Create(method).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return method, nil
+}
+
+// UpdateMethod replaces a method and its rate table.
+func (s *ShippingService) UpdateMethod(id uint, input ShippingMethodInput) (*models.ShippingMethod, error) {
+	var method models.ShippingMethod
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&method, id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrShippingMethodNotFound
+			}
+			return err
+		}
+		if err := applyShippingMethodInput(&method, input); err != nil {
+			return err
+		}
+		if err := tx.Where("method_id = ?", id).Delete(&models.ShippingRate{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Omit("Rates").Save(&method).Error; err != nil {
+			return err
+		}
+		return tx.Create(&method.Rates).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &method, nil
+}
+
+func (s *ShippingService) DeleteMethod(id uint) error {
+	return db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("method_id = ?", id).Delete(&models.ShippingRate{}).Error; err != nil {
+			return err
+		}
+		result := tx.Delete(&models.ShippingMethod{}, id)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrShippingMethodNotFound
+		}
+		return nil
+	})
+}
+
+func applyShippingZoneInput(zone *models.ShippingZone, in ShippingZoneInput) {
+	zone.Name = in.Name
+	zone.Countries = make([]string, 0, len(in.Countries))
+	for _, c := range in.Countries {
+		zone.Countries = append(zone.Countries, strings.ToUpper(c))
+	}
+	if in.Active != nil {
+		zone.Active = *in.Active
+	}
+}
+
+func applyShippingMethodInput(method *models.ShippingMethod, in ShippingMethodInput) error {
+	if in.MaxDays < in.MinDays {
+		return fmt.Errorf("%w: max_days is below min_days", ErrInvalidShippingMethod)
+	}
+	rates := make([]models.ShippingRate, 0, len(in.Rates))
+	for _, r := range in.Rates {
+		if r.MaxValue != 0 && r.MaxValue <= r.MinValue {
+			return fmt.Errorf("%w: rate bracket %g-%g is empty", ErrInvalidShippingMethod, r.MinValue, r.MaxValue)
+		}
+		rates = append(rates, models.ShippingRate{MethodID: method.ID, MinValue: r.MinValue, MaxValue: r.MaxValue, Price: money.FromFloat(r.Price, BaseCurrency)})
+	}
+
+	method.Name = in.Name
+	method.Carrier = in.Carrier
+	method.RateBasis = in.RateBasis
+	method.MinDays = in.MinDays
+	method.MaxDays = in.MaxDays
+	method.Rates = rates
+	if in.Active != nil {
+		method.Active = *in.Active
+	}
+	return nil
+}
+
+// Shipments returns the shipments of an order with their tracking history.
+// userID restricts it to the owner's orders; zero means any order (admin).
+func (s *ShippingService) Shipments(orderID, userID uint) ([]models.Shipment, error) {
+	query := db.DB.Model(&models.Order{}).Where("id = ?", orderID)
+	if userID != 0 {
+		query = query.Where("user_id = ?", userID)
+	}
+	var count int64
+	if err := query.Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		return nil, ErrOrderNotFound
+	}
+
+	var shipments []models.Shipment
+	if err := db.DB.Preload("Events", func(tx *gorm.DB) *gorm.DB { return tx.Order("occurred_at, id") }).
+		Where("order_id = ?", orderID).Order("id").Find(&shipments).Error; err != nil {
+		return nil, err
+	}
+	return shipments, nil
+}
+
+// CreateShipment records a parcel of an order handed to a carrier and marks
+// the order shipped. A paid order goes through picking on the way. Further
+// parcels can be added to an order that has already shipped.
+func (s *ShippingService) CreateShipment(ctx context.Context, orderID, staffID uint, input ShipmentInput) (*models.Shipment, error) {
+	var order models.Order
+	var shipment *models.Shipment
+	var transitions []*OrderTransition
+
+	err := db.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := lockOrder(tx, &order, orderID, 0); err != nil {
+			return err
+		}
+
+		var steps []string
+		switch order.Status {
+		case models.OrderStatusPaid:
+			steps = []string{models.OrderStatusPicking, models.OrderStatusShipped}
+		case models.OrderStatusPicking, models.OrderStatusPartiallyRefunded:
+			steps = []string{models.OrderStatusShipped}
+		case models.OrderStatusShipped:
+		default:
+			return ErrOrderNotShippable
+		}
+
+		now := time.Now()
+		trackingURL := input.TrackingURL
+		if trackingURL == "" {
+			trackingURL = s.provider.TrackingURL(input.Carrier, input.TrackingNumber)
+		}
+		shipment = &models.Shipment{
+			OrderID:        order.ID,
+			Carrier:        input.Carrier,
+			Service:        input.Service,
+			TrackingNumber: strings.TrimSpace(input.TrackingNumber),
+			TrackingURL:    trackingURL,
+			Status:         models.ShipmentLabelCreated,
+			ShippedAt:      now,
+			CreatedBy:      staffID,
+			Events: []models.ShipmentEvent{{
+				Status:      models.ShipmentLabelCreated,
+				Description: "Shipping label created",
+				OccurredAt:  now,
+			}},
+		}
+		if err := tx.Create(shipment).Error; err != nil {
+			return err
+		}
+
+		reason := fmt.Sprintf("shipped with %s, tracking %s", shipment.Carrier, shipment.TrackingNumber)
+		for _, to := range steps {
+			transition, err := transitionOrder(tx, &order, to, StaffActor(staffID), reason)
+			if err != nil {
+				return err
+			}
+			transitions = append(transitions, transition)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, t := range transitions {
+		notifyOrderTransition(t)
+	}
+	notifyShipment(order.UserID, "SHIPMENT_CREATED", fmt.Sprintf("Order #%d has shipped with %s", order.ID, shipment.Carrier), shipment, nil)
+	return shipment, nil
+}
+
+// AddShipmentEvent records a tracking update. Updates can arrive out of
+// order; one older than the latest is kept in the history but doesn't
+// change the shipment's status. Once every shipment of a shipped order is
+// delivered, the order is marked delivered.
+func (s *ShippingService) AddShipmentEvent(ctx context.Context, shipmentID uint, actor OrderActor, input ShipmentEventInput) (*models.Shipment, error) {
+	if !models.IsValidShipmentStatus(input.Status) {
+		return nil, ErrInvalidShipmentStatus
+	}
+
+	var shipment models.Shipment
+	var order models.Order
+	var event *models.ShipmentEvent
+	var transition *OrderTransition
+
+	err := db.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&shipment, shipmentID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrShipmentNotFound
+			}
+			return err
+		}
+		if models.IsFinalShipmentStatus(shipment.Status) {
+			return ErrShipmentClosed
+		}
+		if err := lockOrder(tx, &order, shipment.OrderID, 0); err != nil {
+			return err
+		}
+
+		event = &models.ShipmentEvent{
+			ShipmentID:  shipment.ID,
+			Status:      input.Status,
+			Location:    input.Location,
+			Description: input.Description,
+			OccurredAt:  time.Now(),
+		}
+		if input.OccurredAt != nil {
+			event.OccurredAt = *input.OccurredAt
+		}
+
+		var newer int64
+		if err := tx.Model(&models.ShipmentEvent{}).Where("shipment_id = ? AND occurred_at > ?", shipment.ID, event.OccurredAt).
+			Count(&newer).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(event).Error; err != nil {
+			return err
+		}
+		if newer > 0 {
+			return nil
+		}
+
+		updates := map[string]interface{}{"status": event.Status}
+		if event.Status == models.ShipmentDelivered {
+			updates["delivered_at"] = event.OccurredAt
+			shipment.DeliveredAt = &event.OccurredAt
+		}
+		if err := tx.Model(&shipment).Updates(updates).Error; err != nil {
+			return err
+		}
+		shipment.Status = event.Status
+
+		if event.Status != models.ShipmentDelivered || order.Status != models.OrderStatusShipped {
+			return nil
+		}
+		var undelivered int64
+		if err := tx.Model(&models.Shipment{}).Where("order_id = ? AND status <> ?", order.ID, models.ShipmentDelivered).
+			Count(&undelivered).Error; err != nil {
+			return err
+		}
+		if undelivered > 0 {
+			return nil
+		}
+		var err error
+		transition, err = transitionOrder(tx, &order, models.OrderStatusDelivered, actor, "all shipments delivered")
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	notifyShipment(order.UserID, "SHIPMENT_UPDATED", fmt.Sprintf("Shipment %s of order #%d: %s", shipment.TrackingNumber, order.ID, strings.ReplaceAll(event.Status, "_", " ")), &shipment, event)
+	notifyOrderTransition(transition)
+	return &shipment, nil
+}
+
+func notifyShipment(userID uint, msgType, message string, shipment *models.Shipment, event *models.ShipmentEvent) {
+	if Notifier == nil {
+		return
+	}
+	go Notifier.NotifyUser(userID, msgType, message, ShipmentUpdate{
+		ShipmentID:     shipment.ID,
+		OrderID:        shipment.OrderID,
+		Status:         shipment.Status,
+		Carrier:        shipment.Carrier,
+		TrackingNumber: shipment.TrackingNumber,
+		TrackingURL:    shipment.TrackingURL,
+		Event:          event,
+	})
+}
+
+// defaultShippingZones are the zones the shipping tables start with: the
+// shop's own country with free standard shipping from 50, and the rest of
+// the world priced by weight.
+func defaultShippingZones(origin string) []models.ShippingZone {
+	return []models.ShippingZone{
+		{
+			Name:      "Domestic",
+			Countries: []string{origin},
+			Active:    true,
+			Methods: []models.ShippingMethod{
+				{
+					Name: "Standard", Carrier: "USPS", RateBasis: models.ShippingRateByPrice, MinDays: 3, MaxDays: 5, Active: true,
+					Rates: []models.ShippingRate{
+						{MinValue: 0, MaxValue: 50, Price: money.New(499, BaseCurrency)},
+						{MinValue: 50, Price: money.Zero(BaseCurrency)},
+					},
+				},
+				{
+					Name: "Express", Carrier: "UPS", RateBasis: models.ShippingRateByWeight, MinDays: 1, MaxDays: 2, Active: true,
+					Rates: []models.ShippingRate{
+						{MinValue: 0, MaxValue: 1000, Price: money.New(1299, BaseCurrency)},
+						{MinValue: 1000, MaxValue: 5000, Price: money.New(1999, BaseCurrency)},
+						{MinValue: 5000, Price: money.New(3499, BaseCurrency)},
+					},
+				},
+			},
+		},
+		{
+			Name:   "International",
+			Active: true,
+			Methods: []models.ShippingMethod{
+				{
+					Name: "International Standard", Carrier: "DHL", RateBasis: models.ShippingRateByWeight, MinDays: 7, MaxDays: 14, Active: true,
+					Rates: []models.ShippingRate{
+						{MinValue: 0, MaxValue: 1000, Price: money.New(1499, BaseCurrency)},
+						{MinValue: 1000, MaxValue: 5000, Price: money.New(2999, BaseCurrency)},
+						{MinValue: 5000, MaxValue: 20000, Price: money.New(5999, BaseCurrency)},
+					},
+				},
+			},
+		},
+	}
+}
+
+// SeedDefaultZones fills empty shipping tables with defaultShippingZones.
+// Staff maintain the zones and rates from then on.
+func (s *ShippingService) SeedDefaultZones() error {
+	var count int64
+	if err := db.DB.Model(&models.ShippingZone{}).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	zones := defaultShippingZones(strings.ToUpper(config.LoadConfig().TaxOriginCountry))
+	return db.DB.Create(&zones).Error
+}
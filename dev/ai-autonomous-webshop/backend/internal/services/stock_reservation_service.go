@@ -0,0 +1,478 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/config"
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/db"
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/models"
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/money"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// StockHold is the stock held for a user's cart while they are paying.
+type StockHold struct {
+	ExpiresAt time.Time                 `json:"expires_at"`
+	Items     []models.StockReservation `json:"items"`
+}
+
+// StockReservationService keeps stock aside for carts in checkout and for
+// orders waiting on 3-D Secure, so nobody else can buy it in the meantime.
+// Holds last holdTTL; StartSweeper gives back the stock of expired ones and
+// cancels orders whose payment was never completed.
+type StockReservationService struct {
+	holdTTL  time.Duration
+	payments *PaymentService
+}
+
+func NewStockReservationService() *StockReservationService {
+	return &StockReservationService{
+		holdTTL:  stockHoldTTL(),
+		payments: NewPaymentService(),
+	}
+}
+
+func stockHoldTTL() time.Duration {
+	return time.Duration(config.LoadConfig().StockHoldMinutes) * time.Minute
+}
+
+// HoldCart reserves the user's cart. Calling it again replaces the previous
+// hold with one for the current cart contents and a fresh expiry.
+func (s *StockReservationService) HoldCart(ctx context.Context, userID uint) (*StockHold, error) {
+	hold := &StockHold{ExpiresAt: time.Now().Add(s.holdTTL)}
+
+	err := db.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		lines, err := lockCartLines(tx, userID)
+		if err != nil {
+			return err
+		}
+
+		previous, held, err := lockCartHold(tx, userID)
+		if err != nil {
+			return err
+		}
+		items, _, err := reserveStock(tx, lines, held)
+		if err != nil {
+			return err
+		}
+		if err := settleReservations(tx, previous, models.StockReservationReleased, nil); err != nil {
+			return err
+		}
+
+		hold.Items = make([]models.StockReservation, len(items))
+		for i, item := range items {
+			hold.Items[i] = models.StockReservation{
+				UserID:    userID,
+				ProductID: item.ProductID,
+				VariantID: item.VariantID,
+				Quantity:  item.Quantity,
+				Status:    models.StockReservationActive,
+				ExpiresAt: hold.ExpiresAt,
+			}
+		}
+		return tx.Create(&hold.Items).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return hold, nil
+}
+
+// ReleaseCart gives back the stock held for the user's cart, e.g. when they
+// leave checkout without paying.
+func (s *StockReservationService) ReleaseCart(ctx context.Context, userID uint) error {
+	return db.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		holds, _, err := lockCartHold(tx, userID)
+		if err != nil {
+			return err
+		}
+		return releaseReservations(tx, holds)
+	})
+}
+
+// ReleaseExpired gives back the stock of cart holds past their expiry and
+// cancels orders that are still waiting for payment when their hold runs out.
+func (s *StockReservationService) ReleaseExpired() {
+	if db.DB == nil {
+		return
+	}
+	now := time.Now()
+
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		// Holds a checkout is converting right now are locked by it; skip
+		// them instead of waiting, the next run will see them settled.
+		var holds []models.StockReservation
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("order_id IS NULL AND status = ? AND expires_at <= ?", models.StockReservationActive, now).
+			Order("id").Limit(500).Find(&holds).Error; err != nil {
+			return err
+		}
+		if len(holds) > 0 {
+			log.Printf("Releasing %d expired cart stock hold(s)", len(holds))
+		}
+		return releaseReservations(tx, holds)
+	})
+	if err != nil {
+		log.Printf("Error releasing expired cart stock holds: %v", err)
+	}
+
+	var orderIDs []uint
+	if err := db.DB.Model(&models.StockReservation{}).
+		Where("order_id IS NOT NULL AND status = ? AND expires_at <= ?", models.StockReservationActive, now).
+		Distinct().Limit(100).Pluck("order_id", &orderIDs).Error; err != nil {
+		log.Printf("Error fetching orders with expired stock holds: %v", err)
+		return
+	}
+	for _, id := range orderIDs {
+		if err := s.expireOrder(context.Background(), id); err != nil {
+			log.Printf("Failed to expire order %d: %v", id, err)
+		}
+	}
+}
+
+// expireOrder cancels an order whose customer never finished paying. The
+// intent is voided first so a late 3-D Secure approval can't be captured;
+// the cancellation releases the order's hold.
+func (s *StockReservationService) expireOrder(ctx context.Context, orderID uint) error {
+	var transition *OrderTransition
+
+	err := db.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var order models.Order
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&order, orderID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return settleOrderStock(tx, orderID, models.StockReservationReleased)
+			}
+			return err
+		}
+		if order.Status != models.OrderStatusPending && order.Status != models.OrderStatusAuthorized {
+			// The order moved on without going through transitionOrder;
+			// just close the hold to match.
+			status := models.StockReservationReleased
+			if orderWasPaid(order.Status) {
+				status = models.StockReservationConsumed
+			}
+			return settleOrderStock(tx, orderID, status)
+		}
+
+		if order.PaymentIntentID != "" {
+			if err := s.payments.Void(ctx, tx, &order); err != nil {
+				log.Printf("Failed to void intent %s of expired order %d: %v", order.PaymentIntentID, order.ID, err)
+			}
+		}
+		var err error
+		transition, err = transitionOrder(tx, &order, models.OrderStatusCancelled, SystemActor(), "payment not completed in time")
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	notifyOrderTransition(transition)
+	return nil
+}
+
+func (s *StockReservationService) StartSweeper() {
+	ticker := time.NewTicker(time.Minute)
+	go func() {
+		for range ticker.C {
+			s.ReleaseExpired()
+		}
+	}()
+}
+
+// lockCartLines locks the user's cart rows and returns them as order lines.
+func lockCartLines(tx *gorm.DB, userID uint) ([]models.OrderItem, error) {
+	var cartItems []models.CartItem
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("user_id = ?", userID).Find(&cartItems).Error; err != nil {
+		return nil, err
+	}
+	if len(cartItems) == 0 {
+		return nil, ErrCartEmpty
+	}
+	lines := make([]models.OrderItem, len(cartItems))
+	for i, ci := range cartItems {
+		lines[i] = models.OrderItem{ProductID: ci.ProductID, VariantID: ci.VariantID, Quantity: ci.Quantity}
+	}
+	return lines, nil
+}
+
+// lockCartHold locks the user's active cart hold and sums it per variant,
+// in the form reserveStock takes. An expired hold the sweeper hasn't got to
+// yet still counts: its stock hasn't been given back.
+func lockCartHold(tx *gorm.DB, userID uint) ([]models.StockReservation, map[uint]int, error) {
+	var holds []models.StockReservation
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("user_id = ? AND order_id IS NULL AND status = ?", userID, models.StockReservationActive).
+		Find(&holds).Error; err != nil {
+		return nil, nil, err
+	}
+	held := make(map[uint]int)
+	for _, h := range holds {
+		held[h.VariantID] += h.Quantity
+	}
+	return holds, held, nil
+}
+
+// reserveStock locks the variants' products and then the variants in ID
+// order, so concurrent checkouts can't deadlock, checks availability and
+// takes the quantities off stock. held is stock the caller already has on
+// hold per variant (a cart hold being converted): it counts as available,
+// and whatever of it isn't needed any more goes back on the shelf. It
+// returns the lines priced from the database.
+func reserveStock(tx *gorm.DB, lines []models.OrderItem, held map[uint]int) ([]models.OrderItem, money.Money, error) {
+	quantities := make(map[uint]int)
+	for _, line := range lines {
+		quantities[line.VariantID] += line.Quantity
+	}
+
+	variantIDs := make([]uint, 0, len(quantities)+len(held))
+	for id := range quantities {
+		variantIDs = append(variantIDs, id)
+	}
+	for id := range held {
+		if _, ok := quantities[id]; !ok {
+			variantIDs = append(variantIDs, id)
+		}
+	}
+
+	// Unscoped so that held stock of a variant or product deleted since
+	// still goes back; deleted ones can't be bought though.
+	productIDs, err := lockVariantProducts(tx, variantIDs)
+	if err != nil {
+		return nil, money.Money{}, err
+	}
+	var products []models.Product
+	if err := tx.Unscoped().Where("id IN ?", productIDs).Find(&products).Error; err != nil {
+		return nil, money.Money{}, err
+	}
+	byID := make(map[uint]*models.Product, len(products))
+	for i := range products {
+		byID[products[i].ID] = &products[i]
+	}
+	var variants []models.ProductVariant
+	if err := tx.Unscoped().Clauses(clause.Locking{Strength: "UPDATE"}).Preload("Options", orderedOptionValues).
+		Where("id IN ?", variantIDs).Order("id").Find(&variants).Error; err != nil {
+		return nil, money.Money{}, err
+	}
+
+	found := make(map[uint]bool, len(variants))
+	var shortages []StockShortage
+	for _, v := range variants {
+		found[v.ID] = true
+		qty, wanted := quantities[v.ID]
+		if !wanted {
+			continue
+		}
+		p := byID[v.ProductID]
+		if p == nil || p.DeletedAt.Valid || v.DeletedAt.Valid {
+			return nil, money.Money{}, ErrProductUnavailable
+		}
+		if available := v.Stock + held[v.ID]; available < qty {
+			shortages = append(shortages, stockShortage(p, &v, qty, available))
+		}
+	}
+	for id := range quantities {
+		if !found[id] {
+			return nil, money.Money{}, ErrProductUnavailable
+		}
+	}
+	if len(shortages) > 0 {
+		return nil, money.Money{}, &InsufficientStockError{Items: shortages}
+	}
+
+	orderItems := make([]models.OrderItem, 0, len(quantities))
+	total := money.Zero(BaseCurrency)
+	for i := range variants {
+		v := &variants[i]
+		qty := quantities[v.ID]
+		if err := adjustStock(tx, v.ID, qty-held[v.ID]); err != nil {
+			if errors.Is(err, errStockChanged) {
+				return nil, money.Money{}, &InsufficientStockError{Items: []StockShortage{
+					stockShortage(byID[v.ProductID], v, qty, v.Stock+held[v.ID]),
+				}}
+			}
+			return nil, money.Money{}, err
+		}
+		if qty == 0 {
+			continue
+		}
+		p := byID[v.ProductID]
+		price := v.UnitPrice(p)
+		orderItems = append(orderItems, models.OrderItem{
+			ProductID:   p.ID,
+			ProductName: p.Name,
+			VariantID:   v.ID,
+			SKU:         v.SKU,
+			VariantName: v.Title(),
+			Quantity:    qty,
+			Price:       price,
+		})
+		total = total.Add(price.Times(int64(qty)))
+	}
+	if err := syncProductStock(tx, productIDs); err != nil {
+		return nil, money.Money{}, err
+	}
+	return orderItems, total, nil
+}
+
+func stockShortage(p *models.Product, v *models.ProductVariant, requested, available int) StockShortage {
+	name := p.Name
+	if title := v.Title(); title != "" {
+		name += " (" + title + ")"
+	}
+	return StockShortage{
+		ProductID: p.ID,
+		VariantID: v.ID,
+		SKU:       v.SKU,
+		Name:      name,
+		Requested: requested,
+		Available: available,
+	}
+}
+
+// lockVariantProducts locks the products of variants in ID order and returns
+// their IDs. Everything that changes stock locks the products first, so the
+// variant rows and the product totals are always taken in the same order.
+func lockVariantProducts(tx *gorm.DB, variantIDs []uint) ([]uint, error) {
+	var productIDs []uint
+	if len(variantIDs) == 0 {
+		return productIDs, nil
+	}
+	if err := tx.Model(&models.ProductVariant{}).Unscoped().Where("id IN ?", variantIDs).
+		Distinct().Pluck("product_id", &productIDs).Error; err != nil {
+		return nil, err
+	}
+	sort.Slice(productIDs, func(i, j int) bool { return productIDs[i] < productIDs[j] })
+	var locked []uint
+	if err := tx.Model(&models.Product{}).Unscoped().Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("id IN ?", productIDs).Order("id").Pluck("id", &locked).Error; err != nil {
+		return nil, err
+	}
+	return productIDs, nil
+}
+
+var errStockChanged = errors.New("stock changed while reserving")
+
+// adjustStock takes delta units off a variant's stock, or puts them back
+// when delta is negative. Taking is a conditional decrement, so stock can
+// never go below zero even for a caller that didn't lock the row. The
+// caller brings the product total in line with syncProductStock.
+func adjustStock(tx *gorm.DB, variantID uint, delta int) error {
+	if delta == 0 {
+		return nil
+	}
+	query := tx.Model(&models.ProductVariant{}).Unscoped().Where("id = ?", variantID)
+	if delta > 0 {
+		query = query.Where("stock >= ?", delta)
+	}
+	result := query.Update("stock", gorm.Expr("stock - ?", delta))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected != 1 {
+		return errStockChanged
+	}
+	return nil
+}
+
+// syncProductStock sets Product.Stock to the total of the product's live
+// variants.
+func syncProductStock(tx *gorm.DB, productIDs []uint) error {
+	if len(productIDs) == 0 {
+		return nil
+	}
+	return tx.Exec(`UPDATE products SET stock = (
+			SELECT COALESCE(SUM(stock), 0) FROM product_variants
+			WHERE product_variants.product_id = products.id AND product_variants.deleted_at IS NULL
+		) WHERE id IN ?`, productIDs).Error
+}
+
+// holdOrderStock puts the stock already taken for an order on a hold that
+// expires, for orders left waiting on the customer to finish paying.
+func holdOrderStock(tx *gorm.DB, order *models.Order, ttl time.Duration) error {
+	expiresAt := time.Now().Add(ttl)
+	holds := make([]models.StockReservation, len(order.Items))
+	for i, item := range order.Items {
+		holds[i] = models.StockReservation{
+			UserID:    order.UserID,
+			OrderID:   &order.ID,
+			ProductID: item.ProductID,
+			VariantID: item.VariantID,
+			Quantity:  item.Quantity,
+			Status:    models.StockReservationActive,
+			ExpiresAt: expiresAt,
+		}
+	}
+	return tx.Create(&holds).Error
+}
+
+// settleOrderStock ends an order's hold: consumed when the order was paid
+// and keeps its stock, released when the stock goes back on the shelf.
+func settleOrderStock(tx *gorm.DB, orderID uint, status string) error {
+	var holds []models.StockReservation
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("order_id = ? AND status = ?", orderID, models.StockReservationActive).
+		Find(&holds).Error; err != nil {
+		return err
+	}
+	if status == models.StockReservationReleased {
+		return releaseReservations(tx, holds)
+	}
+	return settleReservations(tx, holds, status, nil)
+}
+
+// releaseReservations puts held stock back and marks the holds released.
+func releaseReservations(tx *gorm.DB, holds []models.StockReservation) error {
+	quantities := make(map[uint]int)
+	for _, h := range holds {
+		quantities[h.VariantID] += h.Quantity
+	}
+	if err := restock(tx, quantities); err != nil {
+		return err
+	}
+	return settleReservations(tx, holds, models.StockReservationReleased, nil)
+}
+
+// restock adds quantities back to the stock of variants, locking their
+// products first like reserveStock. A variant that was hard-deleted since
+// is skipped.
+func restock(tx *gorm.DB, quantities map[uint]int) error {
+	variantIDs := make([]uint, 0, len(quantities))
+	for id := range quantities {
+		variantIDs = append(variantIDs, id)
+	}
+	sort.Slice(variantIDs, func(i, j int) bool { return variantIDs[i] < variantIDs[j] })
+
+	productIDs, err := lockVariantProducts(tx, variantIDs)
+	if err != nil {
+		return err
+	}
+	for _, id := range variantIDs {
+		if err := adjustStock(tx, id, -quantities[id]); err != nil && !errors.Is(err, errStockChanged) {
+			return err
+		}
+	}
+	return syncProductStock(tx, productIDs)
+}
+
+// settleReservations marks holds as no longer active without touching
+// stock. orderID links consumed cart holds to the order they became.
+func settleReservations(tx *gorm.DB, holds []models.StockReservation, status string, orderID *uint) error {
+	if len(holds) == 0 {
+		return nil
+	}
+	ids := make([]uint, len(holds))
+	for i, h := range holds {
+		ids[i] = h.ID
+	}
+	updates := map[string]interface{}{"status": status, "settled_at": time.Now()}
+	if orderID != nil {
+		updates["order_id"] = *orderID
+	}
+	return tx.Model(&models.StockReservation{}).Where("id IN ?", ids).Updates(updates).Error
+}
@@ -0,0 +1,259 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StripeGateway talks to the Stripe REST API (or anything compatible with
+// it) directly over HTTP. The browser collects the card with Stripe.js and
+// sends us only the pm_... payment method ID.
+type StripeGateway struct {
+	secretKey     string
+	baseURL       string
+	webhookSecret string
+	client        *http.Client
+}
+
+func NewStripeGateway(secretKey, baseURL, webhookSecret string, timeout time.Duration) *StripeGateway {
+	return &StripeGateway{
+		secretKey:     secretKey,
+		baseURL:       strings.TrimRight(baseURL, "/"),
+		webhookSecret: webhookSecret,
+		client:        &http.Client{Timeout: timeout},
+	}
+}
+
+func (g *StripeGateway) Name() string { return "stripe" }
+
+type stripeIntent struct {
+	ID             string `json:"id"`
+	Status         string `json:"status"`
+	Amount         int64  `json:"amount"`
+	AmountReceived int64  `json:"amount_received"`
+	Currency       string `json:"currency"`
+	ClientSecret   string `json:"client_secret"`
+	NextAction     *struct {
+		RedirectToURL *struct {
+			URL string `json:"url"`
+		} `json:"redirect_to_url"`
+	} `json:"next_action"`
+}
+
+type stripeRefund struct {
+	ID            string `json:"id"`
+	PaymentIntent string `json:"payment_intent"`
+	Amount        int64  `json:"amount"`
+	Status        string `json:"status"`
+}
+
+type stripeError struct {
+	Error struct {
+		Type        string `json:"type"`
+		Code        string `json:"code"`
+		DeclineCode string `json:"decline_code"`
+		Message     string `json:"message"`
+	} `json:"error"`
+}
+
+func (g *StripeGateway) CreateIntent(ctx context.Context, params CreateIntentParams) (*PaymentIntent, error) {
+	form := url.Values{}
+	form.Set("amount", strconv.FormatInt(params.Amount, 10))
+	form.Set("currency", strings.ToLower(params.Currency))
+	form.Set("capture_method", "manual")
+	form.Set("metadata[order_id]", strconv.FormatUint(uint64(params.OrderID), 10))
+
+	var intent stripeIntent
+	if err := g.post(ctx, "/v1/payment_intents", form, params.IdempotencyKey, &intent); err != nil {
+		return nil, err
+	}
+	return intent.toPaymentIntent(), nil
+}
+
+func (g *StripeGateway) GetIntent(ctx context.Context, intentID string) (*PaymentIntent, error) {
+	var intent stripeIntent
+	if err := g.do(ctx, http.MethodGet, "/v1/payment_intents/"+url.PathEscape(intentID), nil, "", &intent); err != nil {
+		return nil, err
+	}
+	return intent.toPaymentIntent(), nil
+}
+
+func (g *StripeGateway) Confirm(ctx context.Context, intentID, paymentMethodID string) (*PaymentIntent, error) {
+	form := url.Values{}
+	form.Set("payment_method", paymentMethodID)
+
+	var intent stripeIntent
+	if err := g.post(ctx, "/v1/payment_intents/"+url.PathEscape(intentID)+"/confirm", form, "", &intent); err != nil {
+		return nil, err
+	}
+	return intent.toPaymentIntent(), nil
+}
+
+func (g *StripeGateway) Capture(ctx context.Context, intentID string, amount int64) (*PaymentIntent, error) {
+	form := url.Values{}
+	if amount > 0 {
+		form.Set("amount_to_capture", strconv.FormatInt(amount, 10))
+	}
+
+	var intent stripeIntent
+	if err := g.post(ctx, "/v1/payment_intents/"+url.PathEscape(intentID)+"/capture", form, "capture-"+intentID, &intent); err != nil {
+		return nil, err
+	}
+	return intent.toPaymentIntent(), nil
+}
+
+func (g *StripeGateway) Refund(ctx context.Context, intentID string, amount int64) (*PaymentRefund, error) {
+	form := url.Values{}
+	form.Set("payment_intent", intentID)
+	if amount > 0 {
+		form.Set("amount", strconv.FormatInt(amount, 10))
+	}
+
+	var refund stripeRefund
+	if err := g.post(ctx, "/v1/refunds", form, "", &refund); err != nil {
+		return nil, err
+	}
+	return &PaymentRefund{
+		ID:       refund.ID,
+		IntentID: refund.PaymentIntent,
+		Amount:   refund.Amount,
+		Status:   refund.Status,
+	}, nil
+}
+
+func (g *StripeGateway) Void(ctx context.Context, intentID string) (*PaymentIntent, error) {
+	var intent stripeIntent
+	if err := g.post(ctx, "/v1/payment_intents/"+url.PathEscape(intentID)+"/cancel", url.Values{}, "", &intent); err != nil {
+		return nil, err
+	}
+	return intent.toPaymentIntent(), nil
+}
+
+type stripeEvent struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+	Data struct {
+		Object struct {
+			ID             string `json:"id"`
+			Object         string `json:"object"`
+			Amount         int64  `json:"amount"`
+			AmountReceived int64  `json:"amount_received"`
+			AmountRefunded int64  `json:"amount_refunded"`
+			PaymentIntent  string `json:"payment_intent"`
+		} `json:"object"`
+	} `json:"data"`
+}
+
+func (g *StripeGateway) ParseWebhook(payload []byte, headers http.Header) (*GatewayEvent, error) {
+	if !verifySignedPayload(headers.Get("Stripe-Signature"), payload, g.webhookSecret, time.Now()) {
+		return nil, ErrInvalidWebhook
+	}
+
+	var se stripeEvent
+	if err := json.Unmarshal(payload, &se); err != nil || se.ID == "" {
+		return nil, ErrInvalidWebhook
+	}
+
+	obj := se.Data.Object
+	event := &GatewayEvent{ID: se.ID, Type: se.Type, IntentID: obj.PaymentIntent}
+	if obj.Object == "payment_intent" {
+		event.IntentID = obj.ID
+	}
+
+	switch se.Type {
+	case "payment_intent.amount_capturable_updated":
+		event.Kind = PaymentEventAuthorized
+		event.Amount = obj.Amount
+	case "payment_intent.succeeded":
+		event.Kind = PaymentEventCaptured
+		event.Amount = obj.AmountReceived
+	case "payment_intent.payment_failed", "payment_intent.canceled":
+		event.Kind = PaymentEventFailed
+	case "charge.refunded":
+		event.Kind = PaymentEventRefunded
+		event.Amount = obj.AmountRefunded
+	case "charge.dispute.created":
+		event.Kind = PaymentEventDisputed
+		event.Amount = obj.Amount
+	}
+	return event, nil
+}
+
+func (g *StripeGateway) post(ctx context.Context, path string, form url.Values, idempotencyKey string, out interface{}) error {
+	return g.do(ctx, http.MethodPost, path, form, idempotencyKey, out)
+}
+
+func (g *StripeGateway) do(ctx context.Context, method, path string, form url.Values, idempotencyKey string, out interface{}) error {
+	if g.secretKey == "" {
+		return errors.New("stripe gateway is not configured: STRIPE_SECRET_KEY is empty")
+	}
+
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+	req, err := http.NewRequestWithContext(ctx, method, g.baseURL+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+g.secretKey)
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	if idempotencyKey != "" {
+		req.Header.Set("Idempotency-Key", idempotencyKey)
+	}
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		var netErr interface{ Timeout() bool }
+		if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+			return ErrPaymentTimeout
+		}
+		return fmt.Errorf("stripe request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read stripe response: %w", err)
+	}
+
+	if resp.StatusCode >= 400 {
+		var se stripeError
+		_ = json.Unmarshal(data, &se)
+		switch {
+		case se.Error.Type == "card_error":
+			return fmt.Errorf("%w: %s", ErrPaymentDeclined, se.Error.Message)
+		case resp.StatusCode == http.StatusNotFound:
+			return ErrPaymentIntentNotFound
+		default:
+			return fmt.Errorf("stripe error (%d): %s", resp.StatusCode, se.Error.Message)
+		}
+	}
+
+	return json.Unmarshal(data, out)
+}
+
+func (i *stripeIntent) toPaymentIntent() *PaymentIntent {
+	intent := &PaymentIntent{
+		ID:             i.ID,
+		Status:         i.Status,
+		Amount:         i.Amount,
+		AmountCaptured: i.AmountReceived,
+		Currency:       i.Currency,
+		ClientSecret:   i.ClientSecret,
+	}
+	if i.NextAction != nil && i.NextAction.RedirectToURL != nil {
+		intent.NextActionURL = i.NextAction.RedirectToURL.URL
+	}
+	return intent
+}
@@ -0,0 +1,374 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/config"
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/db"
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/models"
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/money"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrInvalidVATID    = errors.New("invalid VAT ID")
+	ErrTaxRuleNotFound = errors.New("tax rule not found")
+)
+
+// TaxLocation is where the customer is taxed: the country and region of
+// the shipping address, or the geo-detected ones before there is one.
+// VATID is the customer's business VAT ID, if any.
+type TaxLocation struct {
+	Country string
+	Region  string
+	VATID   string
+}
+
+// TaxableLine is an amount to tax, net of discounts, in BaseCurrency.
+// Categories are the names and slugs of the product's categories and their
+// ancestors.
+type TaxableLine struct {
+	ProductID  uint
+	Categories []string
+	Amount     money.Money
+}
+
+// LineTax is the tax on one TaxableLine.
+type LineTax struct {
+	ProductID uint        `json:"product_id"`
+	Name      string      `json:"name"`
+	Rate      float64     `json:"rate"`
+	Amount    money.Money `json:"amount"`
+}
+
+// TaxBreakdown sums up the tax at one rate.
+type TaxBreakdown struct {
+	Name          string      `json:"name"`
+	Country       string      `json:"country"`
+	Region        string      `json:"region,omitempty"`
+	Rate          float64     `json:"rate"`
+	TaxableAmount money.Money `json:"taxable_amount"`
+	Amount        money.Money `json:"amount"`
+}
+
+// TaxResult is the tax for a set of lines. Lines is in the order of the
+// input lines.
+type TaxResult struct {
+	Lines            []LineTax      `json:"lines"`
+	Shipping         LineTax        `json:"shipping"`
+	Breakdown        []TaxBreakdown `json:"breakdown"`
+	Total            money.Money    `json:"total"`
+	PricesIncludeTax bool           `json:"prices_include_tax"`
+	ReverseCharge    bool           `json:"reverse_charge"`
+}
+
+// vatIDPattern is the shape every EU-style VAT ID has: a two-letter country
+// prefix and 2 to 12 characters. It doesn't prove the ID is registered.
+var vatIDPattern = regexp.MustCompile(`^[A-Z]{2}[0-9A-Z+*]{2,12}$`)
+
+// vatPrefixes lists the VAT ID prefixes that differ from the ISO country
+// code.
+var vatPrefixes = map[string]string{"GR": "EL"}
+
+type TaxService struct {
+	originCountry string
+}
+
+func NewTaxService() *TaxService {
+	return &TaxService{originCountry: strings.ToUpper(config.LoadConfig().TaxOriginCountry)}
+}
+
+// NormalizeVATID strips the spaces, dots and dashes people type into VAT
+// IDs.
+func NormalizeVATID(id string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '.', '-':
+			return -1
+		}
+		return r
+	}, strings.ToUpper(strings.TrimSpace(id)))
+}
+
+// Calculate works out the tax on lines and on shipping for a customer at
+// location. Each amount is taxed at the most specific matching rule and
+// rounded half to even to the cent; shipping is taxed at the country's standard rate.
+// A business customer with a valid VAT ID of a reverse-charge country other
+// than the shop's own pays no tax.
+func (s *TaxService) Calculate(tx *gorm.DB, location TaxLocation, lines []TaxableLine, shipping money.Money) (*TaxResult, error) {
+	country := strings.ToUpper(location.Country)
+	region := strings.ToUpper(location.Region)
+	vatID := NormalizeVATID(location.VATID)
+	if vatID != "" && !vatIDPattern.MatchString(vatID) {
+		return nil, ErrInvalidVATID
+	}
+
+	var rules []models.TaxRule
+	if country != "" {
+		if err := tx.Where("active = ? AND country = ? AND (region = '' OR region = ?)", true, country, region).
+			Find(&rules).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	zero := money.Zero(BaseCurrency)
+	result := &TaxResult{Lines: make([]LineTax, 0, len(lines)), Shipping: LineTax{Amount: zero}, Breakdown: []TaxBreakdown{}, Total: zero}
+	standard := matchTaxRule(rules, region, nil)
+	if standard != nil {
+		result.PricesIncludeTax = standard.PricesIncludeTax
+		result.ReverseCharge = standard.ReverseCharge && vatID != "" &&
+			vatID[:2] == vatPrefix(country) && country != s.originCountry
+	}
+
+	breakdown := make(map[string]*TaxBreakdown)
+	var keys []string
+	add := func(rule *models.TaxRule, productID uint, amount money.Money) LineTax {
+		line := LineTax{ProductID: productID, Amount: zero}
+		if rule == nil {
+			return line
+		}
+		line.Name = rule.Name
+		if result.ReverseCharge {
+			line.Name = rule.Name + " reverse charge"
+		} else {
+			line.Rate = rule.Rate
+			line.Amount = amount.Percent(rule.Rate)
+		}
+
+		key := fmt.Sprintf("%s|%s|%g", line.Name, rule.Region, line.Rate)
+		b, ok := breakdown[key]
+		if !ok {
+			b = &TaxBreakdown{Name: line.Name, Country: rule.Country, Region: rule.Region, Rate: line.Rate, TaxableAmount: zero, Amount: zero}
+			breakdown[key] = b
+			keys = append(keys, key)
+		}
+		b.TaxableAmount = b.TaxableAmount.Add(amount)
+		b.Amount = b.Amount.Add(line.Amount)
+		result.Total = result.Total.Add(line.Amount)
+		return line
+	}
+
+	for _, l := range lines {
+		result.Lines = append(result.Lines, add(matchTaxRule(rules, region, l.Categories), l.ProductID, l.Amount))
+	}
+	if shipping.IsPositive() {
+		result.Shipping = add(standard, 0, shipping)
+	}
+
+	sort.Strings(keys)
+	for _, key := range keys {
+		result.Breakdown = append(result.Breakdown, *breakdown[key])
+	}
+	return result, nil
+}
+
+// applyToOrderItems taxes the lines of a new order, net of their discounts,
+// and its shipping, and books the rate and tax on each line.
+func (s *TaxService) applyToOrderItems(tx *gorm.DB, location TaxLocation, items []models.OrderItem, categories map[uint][]string, shipping money.Money) (*TaxResult, error) {
+	lines := make([]TaxableLine, 0, len(items))
+	for _, item := range items {
+		lines = append(lines, TaxableLine{
+			ProductID:  item.ProductID,
+			Categories: categories[item.ProductID],
+			Amount:     item.Price.Times(int64(item.Quantity)).Sub(item.Discount),
+		})
+	}
+	result, err := s.Calculate(tx, location, lines, shipping)
+	if err != nil {
+		return nil, err
+	}
+	for i := range items {
+		items[i].TaxRate = result.Lines[i].Rate
+		items[i].TaxAmount = result.Lines[i].Amount
+	}
+	return result, nil
+}
+
+// orderTaxLines turns a breakdown into the tax lines stored on an order.
+func orderTaxLines(breakdown []TaxBreakdown) []models.OrderTaxLine {
+	lines := make([]models.OrderTaxLine, 0, len(breakdown))
+	for _, b := range breakdown {
+		lines = append(lines, models.OrderTaxLine{
+			Name:          b.Name,
+			Country:       b.Country,
+			Region:        b.Region,
+			Rate:          b.Rate,
+			TaxableAmount: b.TaxableAmount,
+			Amount:        b.Amount,
+		})
+	}
+	return lines
+}
+
+// matchTaxRule picks the most specific rule for a region and a product in
+// categories.
+func matchTaxRule(rules []models.TaxRule, region string, categories []string) *models.TaxRule {
+	var best *models.TaxRule
+	bestScore := -1
+	for i := range rules {
+		r := &rules[i]
+		if r.Category != "" && !inCategories(r.Category, categories) {
+			continue
+		}
+		score := 0
+		if r.Region != "" {
+			if r.Region != region {
+				continue
+			}
+			score += 2
+		}
+		if r.Category != "" {
+			score++
+		}
+		if score > bestScore {
+			best, bestScore = r, score
+		}
+	}
+	return best
+}
+
+func inCategories(category string, categories []string) bool {
+	for _, c := range categories {
+		if strings.EqualFold(c, category) {
+			return true
+		}
+	}
+	return false
+}
+
+func vatPrefix(country string) string {
+	if prefix, ok := vatPrefixes[country]; ok {
+		return prefix
+	}
+	return country
+}
+
+// TaxRuleInput is what staff send to create or replace a tax rule.
+type TaxRuleInput struct {
+	Country          string  `json:"country" binding:"required,len=2"`
+	Region           string  `json:"region" binding:"max=10"`
+	Category         string  `json:"category"`
+	Name             string  `json:"name" binding:"required"`
+	Rate             float64 `json:"rate" binding:"min=0,max=100"`
+	PricesIncludeTax bool    `json:"prices_include_tax"`
+	ReverseCharge    bool    `json:"reverse_charge"`
+	Active           *bool   `json:"active"`
+}
+
+func (s *TaxService) ListRules(country string) ([]models.TaxRule, error) {
+	query := db.DB.Order("country, region, category")
+	if country != "" {
+		query = query.Where("country = ?", strings.ToUpper(country))
+	}
+	var rules []models.TaxRule
+	if err := query.Find(&rules).Error; err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+func (s *TaxService) CreateRule(input TaxRuleInput) (*models.TaxRule, error) {
+	rule := &models.TaxRule{Active: true}
+	applyTaxRuleInput(rule, input)
+	if err := db.DB.Create(rule).Error; err != nil {
+		return nil, err
+	}
+	return rule, nil
+}
+
+// UpdateRule replaces a rule. Orders placed before keep the tax they were
+// charged; it is stored on them.
+func (s *TaxService) UpdateRule(id uint, input TaxRuleInput) (*models.TaxRule, error) {
+	var rule models.TaxRule
+	if err := db.DB.First(&rule, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrTaxRuleNotFound
+		}
+		return nil, err
+	}
+	applyTaxRuleInput(&rule, input)
+	if err := db.DB.Save(&rule).Error; err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+func (s *TaxService) DeleteRule(id uint) error {
+	result := db.DB.Delete(&models.TaxRule{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrTaxRuleNotFound
+	}
+	return nil
+}
+
+func applyTaxRuleInput(rule *models.TaxRule, in TaxRuleInput) {
+	rule.Country = strings.ToUpper(in.Country)
+	rule.Region = strings.ToUpper(in.Region)
+	rule.Category = in.Category
+	rule.Name = in.Name
+	rule.Rate = in.Rate
+	rule.PricesIncludeTax = in.PricesIncludeTax
+	rule.ReverseCharge = in.ReverseCharge
+	if in.Active != nil {
+		rule.Active = *in.Active
+	}
+}
+
+// defaultTaxRules are the standard rates the rules table starts with.
+var defaultTaxRules = []models.TaxRule{
+	{Country: "DE", Name: "VAT", Rate: 19, PricesIncludeTax: true, ReverseCharge: true},
+	{Country: "FR", Name: "VAT", Rate: 20, PricesIncludeTax: true, ReverseCharge: true},
+	{Country: "NL", Name: "VAT", Rate: 21, PricesIncludeTax: true, ReverseCharge: true},
+	{Country: "BE", Name: "VAT", Rate: 21, PricesIncludeTax: true, ReverseCharge: true},
+	{Country: "ES", Name: "VAT", Rate: 21, PricesIncludeTax: true, ReverseCharge: true},
+	{Country: "IT", Name: "VAT", Rate: 22, PricesIncludeTax: true, ReverseCharge: true},
+	{Country: "AT", Name: "VAT", Rate: 20, PricesIncludeTax: true, ReverseCharge: true},
+	{Country: "IE", Name: "VAT", Rate: 23, PricesIncludeTax: true, ReverseCharge: true},
+	{Country: "PL", Name: "VAT", Rate: 23, PricesIncludeTax: true, ReverseCharge: true},
+	{Country: "SE", Name: "VAT", Rate: 25, PricesIncludeTax: true, ReverseCharge: true},
+	{Country: "GR", Name: "VAT", Rate: 24, PricesIncludeTax: true, ReverseCharge: true},
+	{Country: "GB", Name: "VAT", Rate: 20, PricesIncludeTax: true},
+	{Country: "CH", Name: "VAT", Rate: 8.1, PricesIncludeTax: true},
+	{Country: "AU", Name: "GST", Rate: 10, PricesIncludeTax: true},
+	{Country: "NZ", Name: "GST", Rate: 15, PricesIncludeTax: true},
+	{Country: "JP", Name: "Consumption tax", Rate: 10, PricesIncludeTax: true},
+	{Country: "IN", Name: "GST", Rate: 18, PricesIncludeTax: true},
+	{Country: "CA", Name: "GST", Rate: 5},
+	{Country: "CA", Region: "ON", Name: "HST", Rate: 13},
+	{Country: "CA", Region: "NS", Name: "HST", Rate: 15},
+	{Country: "US", Region: "CA", Name: "Sales tax", Rate: 7.25},
+	{Country: "US", Region: "NY", Name: "Sales tax", Rate: 4},
+	{Country: "US", Region: "TX", Name: "Sales tax", Rate: 6.25},
+	{Country: "US", Region: "WA", Name: "Sales tax", Rate: 6.5},
+	{Country: "US", Region: "FL", Name: "Sales tax", Rate: 6},
+	{Country: "US", Region: "IL", Name: "Sales tax", Rate: 6.25},
+	{Country: "US", Region: "PA", Name: "Sales tax", Rate: 6},
+	{Country: "US", Region: "MA", Name: "Sales tax", Rate: 6.25},
+	{Country: "US", Region: "NJ", Name: "Sales tax", Rate: 6.625},
+	{Country: "US", Region: "GA", Name: "Sales tax", Rate: 4},
+}
+
+// SeedDefaultRules fills an empty rules table with defaultTaxRules. Staff
+// maintain the rates from then on.
+func (s *TaxService) SeedDefaultRules() error {
+	var count int64
+	if err := db.DB.Model(&models.TaxRule{}).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	rules := make([]models.TaxRule, len(defaultTaxRules))
+	copy(rules, defaultTaxRules)
+	for i := range rules {
+		rules[i].Active = true
+	}
+	return db.DB.Create(&rules).Error
+}
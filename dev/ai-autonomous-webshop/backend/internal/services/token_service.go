@@ -0,0 +1,340 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/config"
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/db"
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/models"
+)
+
+var (
+	ErrInvalidToken        = errors.New("invalid or expired token")
+	ErrTokenRevoked        = errors.New("token has been revoked")
+	ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token reuse detected")
+)
+
+const (
+	// TokenScopeMFAPending marks the short-lived token handed out between the
+	// password step and the second factor. It is not an access token.
+	TokenScopeMFAPending = "mfa_pending"
+
+	mfaPendingTTL = 5 * time.Minute
+)
+
+type AccessClaims struct {
+	UserID      uint     `json:"user_id"`
+	Roles       []string `json:"roles"`
+	Permissions []string `json:"permissions"`
+	MFA         bool     `json:"mfa,omitempty"`
+	Scope       string   `json:"scope,omitempty"`
+	jwt.RegisteredClaims
+}
+
+type TokenPair struct {
+	AccessToken      string    `json:"access_token"`
+	RefreshToken     string    `json:"refresh_token"`
+	TokenType        string    `json:"token_type"`
+	ExpiresIn        int64     `json:"expires_in"`
+	RefreshExpiresAt time.Time `json:"refresh_expires_at"`
+}
+
+// SessionMeta describes the client a refresh token was issued to and whether
+// the login passed a second factor.
+type SessionMeta struct {
+	UserAgent   string
+	IP          string
+	MFAVerified bool
+}
+
+type TokenService struct {
+	secret     []byte
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+}
+
+func NewTokenService() *TokenService {
+	cfg := config.LoadConfig()
+	return &TokenService{
+		secret:     []byte(cfg.JWTSecret),
+		accessTTL:  time.Duration(cfg.AccessTokenTTLMinutes) * time.Minute,
+		refreshTTL: time.Duration(cfg.RefreshTokenTTLHours) * time.Hour,
+	}
+}
+
+func (s *TokenService) store() tokenStore {
+	return currentTokenStore(s.refreshTTL)
+}
+
+// IssueTokenPair starts a new refresh token family, i.e. a new session.
+func (s *TokenService) IssueTokenPair(ctx context.Context, user *models.User, meta SessionMeta) (*TokenPair, error) {
+	familyID, err := randomToken(16)
+	if err != nil {
+		return nil, err
+	}
+	return s.issue(ctx, user, familyID, meta)
+}
+
+func (s *TokenService) issue(ctx context.Context, user *models.User, familyID string, meta SessionMeta) (*TokenPair, error) {
+	accessToken, err := s.signAccessToken(user, meta.MFAVerified)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := randomToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	rt := &models.RefreshToken{
+		UserID:      user.ID,
+		FamilyID:    familyID,
+		TokenHash:   hashToken(refreshToken),
+		UserAgent:   meta.UserAgent,
+		IP:          meta.IP,
+		MFAVerified: meta.MFAVerified,
+		ExpiresAt:   time.Now().Add(s.refreshTTL),
+		CreatedAt:   time.Now(),
+	}
+	if err := s.store().SaveRefreshToken(ctx, rt); err != nil {
+		return nil, fmt.Errorf("failed to store refresh token: %w", err)
+	}
+
+	return &TokenPair{
+		AccessToken:      accessToken,
+		RefreshToken:     refreshToken,
+		TokenType:        "Bearer",
+		ExpiresIn:        int64(s.accessTTL.Seconds()),
+		RefreshExpiresAt: rt.ExpiresAt,
+	}, nil
+}
+
+// signAccessToken embeds roles and permissions as claims. When policy
+// demands MFA for the user's roles and this session hasn't passed it, the
+// token is downgraded to customer permissions until the user enrolls.
+func (s *TokenService) signAccessToken(user *models.User, mfaVerified bool) (string, error) {
+	roles := user.RoleNames()
+	if MFARequired(user) && !mfaVerified {
+		roles = []string{models.RoleCustomer}
+	}
+
+	claims := AccessClaims{
+		UserID:      user.ID,
+		Roles:       roles,
+		Permissions: models.PermissionsForRoles(roles),
+		MFA:         mfaVerified,
+	}
+	return s.sign(user, claims, s.accessTTL)
+}
+
+func (s *TokenService) sign(user *models.User, claims AccessClaims, ttl time.Duration) (string, error) {
+	jti, err := randomToken(16)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	claims.RegisteredClaims = jwt.RegisteredClaims{
+		ID:        jti,
+		Subject:   fmt.Sprintf("%d", user.ID),
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+	}
+
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(s.secret)
+}
+
+// IssueMFAPendingToken returns a token that only proves the password step
+// succeeded. It can be exchanged once, via VerifyMFA, for a real token pair.
+func (s *TokenService) IssueMFAPendingToken(user *models.User) (string, int64, error) {
+	token, err := s.sign(user, AccessClaims{
+		UserID: user.ID,
+		Scope:  TokenScopeMFAPending,
+	}, mfaPendingTTL)
+	return token, int64(mfaPendingTTL.Seconds()), err
+}
+
+func (s *TokenService) ParseMFAPendingToken(ctx context.Context, tokenString string) (*AccessClaims, error) {
+	claims, err := s.parse(ctx, tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Scope != TokenScopeMFAPending {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+// ConsumeMFAPendingToken makes sure the pending token can't be exchanged twice.
+func (s *TokenService) ConsumeMFAPendingToken(ctx context.Context, claims *AccessClaims) error {
+	if claims.ExpiresAt == nil {
+		return nil
+	}
+	return s.store().RevokeAccessToken(ctx, claims.ID, claims.UserID, claims.ExpiresAt.Time)
+}
+
+// ParseAccessToken verifies the signature and expiry and checks the token
+// against the revocation list. Scoped tokens such as mfa_pending are refused.
+func (s *TokenService) ParseAccessToken(ctx context.Context, tokenString string) (*AccessClaims, error) {
+	claims, err := s.parse(ctx, tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Scope != "" {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+func (s *TokenService) parse(ctx context.Context, tokenString string) (*AccessClaims, error) {
+	claims := &AccessClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return s.secret, nil
+	})
+	if err != nil || !token.Valid {
+		return nil, ErrInvalidToken
+	}
+
+	var issuedAt time.Time
+	if claims.IssuedAt != nil {
+		issuedAt = claims.IssuedAt.Time
+	}
+
+	revoked, err := s.store().IsAccessTokenRevoked(ctx, claims.ID, claims.UserID, issuedAt)
+	if err != nil {
+		// Fail closed: a deny-list we can't read is not a deny-list.
+		return nil, fmt.Errorf("failed to check token revocation: %w", err)
+	}
+	if revoked {
+		return nil, ErrTokenRevoked
+	}
+
+	return claims, nil
+}
+
+// Refresh rotates a refresh token. Presenting a token that was already
+// rotated means it leaked, so the whole family is revoked.
+func (s *TokenService) Refresh(ctx context.Context, refreshToken string, meta SessionMeta) (*TokenPair, error) {
+	store := s.store()
+
+	rt, err := store.GetRefreshToken(ctx, hashToken(refreshToken))
+	if err != nil {
+		return nil, err
+	}
+	if rt == nil || rt.RevokedAt != nil || time.Now().After(rt.ExpiresAt) {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	now := time.Now()
+	if rt.RotatedAt != nil {
+		return nil, s.revokeReusedFamily(ctx, rt, now)
+	}
+
+	rotated, err := store.MarkRotated(ctx, rt, now)
+	if err != nil {
+		return nil, err
+	}
+	if !rotated {
+		return nil, s.revokeReusedFamily(ctx, rt, now)
+	}
+
+	var user models.User
+	if err := db.DB.WithContext(ctx).Preload("Roles").First(&user, rt.UserID).Error; err != nil {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	meta.MFAVerified = rt.MFAVerified
+	return s.issue(ctx, &user, rt.FamilyID, meta)
+}
+
+func (s *TokenService) revokeReusedFamily(ctx context.Context, rt *models.RefreshToken, now time.Time) error {
+	log.Printf("Security: refresh token reuse detected for user %d (family %s), revoking family", rt.UserID, rt.FamilyID)
+	if err := s.store().RevokeFamily(ctx, rt.FamilyID, now); err != nil {
+		return err
+	}
+	return ErrRefreshTokenReused
+}
+
+// Logout revokes the presented access token and, when given, the session the
+// refresh token belongs to.
+func (s *TokenService) Logout(ctx context.Context, claims *AccessClaims, refreshToken string) error {
+	store := s.store()
+
+	if refreshToken != "" {
+		rt, err := store.GetRefreshToken(ctx, hashToken(refreshToken))
+		if err != nil {
+			return err
+		}
+		if rt != nil && rt.UserID == claims.UserID {
+			if err := store.RevokeFamily(ctx, rt.FamilyID, time.Now()); err != nil {
+				return err
+			}
+		}
+	}
+
+	if claims.ExpiresAt == nil {
+		return nil
+	}
+	return store.RevokeAccessToken(ctx, claims.ID, claims.UserID, claims.ExpiresAt.Time)
+}
+
+// LogoutAll revokes every session of the user and every access token issued
+// to them before the current second; see RevokeUserAccessTokens.
+func (s *TokenService) LogoutAll(ctx context.Context, userID uint) error {
+	store := s.store()
+	now := time.Now()
+
+	if err := store.RevokeUserFamilies(ctx, userID, now); err != nil {
+		return err
+	}
+	return store.RevokeUserAccessTokens(ctx, userID, now, now.Add(s.accessTTL))
+}
+
+func (s *TokenService) PurgeExpired() {
+	if db.Redis != nil || db.DB == nil {
+		return
+	}
+	purged, err := (&gormTokenStore{}).PurgeExpired(context.Background())
+	if err != nil {
+		log.Printf("Error purging expired tokens: %v", err)
+		return
+	}
+	if purged > 0 {
+		log.Printf("Purged %d expired token records", purged)
+	}
+}
+
+func (s *TokenService) StartCleanupJob() {
+	ticker := time.NewTicker(time.Hour)
+	go func() {
+		for range ticker.C {
+			s.PurgeExpired()
+		}
+	}()
+}
+
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
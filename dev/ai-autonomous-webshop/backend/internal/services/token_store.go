@@ -0,0 +1,232 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/db"
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/models"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+// tokenStore persists refresh tokens and the access-token deny-list. Redis is
+// used when connected; Postgres is the fallback.
+type tokenStore interface {
+	SaveRefreshToken(ctx context.Context, rt *models.RefreshToken) error
+	// GetRefreshToken returns nil, nil when the hash is unknown or expired.
+	GetRefreshToken(ctx context.Context, hash string) (*models.RefreshToken, error)
+	// MarkRotated reports false if the token had already been rotated, which
+	// means two requests raced with the same refresh token.
+	MarkRotated(ctx context.Context, rt *models.RefreshToken, at time.Time) (bool, error)
+	RevokeFamily(ctx context.Context, familyID string, at time.Time) error
+	RevokeUserFamilies(ctx context.Context, userID uint, at time.Time) error
+	RevokeAccessToken(ctx context.Context, jti string, userID uint, expiresAt time.Time) error
+	// RevokeUserAccessTokens denies the user's access tokens issued strictly
+	// before the whole second before. Tokens only carry their issue time to
+	// the second, so one issued in that same second stays valid: that
+	// keeps a login right after a password reset working.
+	RevokeUserAccessTokens(ctx context.Context, userID uint, before, expiresAt time.Time) error
+	IsAccessTokenRevoked(ctx context.Context, jti string, userID uint, issuedAt time.Time) (bool, error)
+}
+
+func currentTokenStore(refreshTTL time.Duration) tokenStore {
+	if db.Redis != nil {
+		return &redisTokenStore{client: db.Redis, familyTTL: refreshTTL}
+	}
+	return &gormTokenStore{}
+}
+
+type redisTokenStore struct {
+	client    *redis.Client
+	familyTTL time.Duration
+}
+
+const (
+	redisRefreshPrefix       = "auth:refresh:"
+	redisRotatedPrefix       = "auth:refresh_rotated:"
+	redisFamilyRevokedPrefix = "auth:family_revoked:"
+	redisUserFamiliesPrefix  = "auth:user_families:"
+	redisRevokedJTIPrefix    = "auth:revoked_jti:"
+	redisRevokedBeforePrefix = "auth:revoked_before:"
+)
+
+func (s *redisTokenStore) SaveRefreshToken(ctx context.Context, rt *models.RefreshToken) error {
+	data, err := json.Marshal(rt)
+	if err != nil {
+		return err
+	}
+	ttl := time.Until(rt.ExpiresAt)
+	userKey := redisUserFamiliesPrefix + strconv.FormatUint(uint64(rt.UserID), 10)
+
+	pipe := s.client.TxPipeline()
+	pipe.Set(ctx, redisRefreshPrefix+rt.TokenHash, data, ttl)
+	pipe.SAdd(ctx, userKey, rt.FamilyID)
+	pipe.Expire(ctx, userKey, ttl)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+func (s *redisTokenStore) GetRefreshToken(ctx context.Context, hash string) (*models.RefreshToken, error) {
+	data, err := s.client.Get(ctx, redisRefreshPrefix+hash).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var rt models.RefreshToken
+	if err := json.Unmarshal(data, &rt); err != nil {
+		return nil, err
+	}
+	rt.TokenHash = hash
+
+	if at, err := s.client.Get(ctx, redisRotatedPrefix+hash).Int64(); err == nil {
+		t := time.Unix(at, 0)
+		rt.RotatedAt = &t
+	}
+	if at, err := s.client.Get(ctx, redisFamilyRevokedPrefix+rt.FamilyID).Int64(); err == nil {
+		t := time.Unix(at, 0)
+		rt.RevokedAt = &t
+	}
+	return &rt, nil
+}
+
+func (s *redisTokenStore) MarkRotated(ctx context.Context, rt *models.RefreshToken, at time.Time) (bool, error) {
+	return s.client.SetNX(ctx, redisRotatedPrefix+rt.TokenHash, at.Unix(), time.Until(rt.ExpiresAt)).Result()
+}
+
+func (s *redisTokenStore) RevokeFamily(ctx context.Context, familyID string, at time.Time) error {
+	return s.client.Set(ctx, redisFamilyRevokedPrefix+familyID, at.Unix(), s.familyTTL).Err()
+}
+
+func (s *redisTokenStore) RevokeUserFamilies(ctx context.Context, userID uint, at time.Time) error {
+	userKey := redisUserFamiliesPrefix + strconv.FormatUint(uint64(userID), 10)
+	families, err := s.client.SMembers(ctx, userKey).Result()
+	if err != nil {
+		return err
+	}
+	for _, familyID := range families {
+		if err := s.RevokeFamily(ctx, familyID, at); err != nil {
+			return err
+		}
+	}
+	return s.client.Del(ctx, userKey).Err()
+}
+
+func (s *redisTokenStore) RevokeAccessToken(ctx context.Context, jti string, userID uint, expiresAt time.Time) error {
+	ttl := time.Until(expiresAt)
+	if ttl <= 0 {
+		return nil
+	}
+	return s.client.Set(ctx, redisRevokedJTIPrefix+jti, userID, ttl).Err()
+}
+
+func (s *redisTokenStore) RevokeUserAccessTokens(ctx context.Context, userID uint, before, expiresAt time.Time) error {
+	key := redisRevokedBeforePrefix + strconv.FormatUint(uint64(userID), 10)
+	return s.client.Set(ctx, key, before.Unix(), time.Until(expiresAt)).Err()
+}
+
+func (s *redisTokenStore) IsAccessTokenRevoked(ctx context.Context, jti string, userID uint, issuedAt time.Time) (bool, error) {
+	if jti != "" {
+		n, err := s.client.Exists(ctx, redisRevokedJTIPrefix+jti).Result()
+		if err != nil {
+			return false, err
+		}
+		if n > 0 {
+			return true, nil
+		}
+	}
+
+	key := redisRevokedBeforePrefix + strconv.FormatUint(uint64(userID), 10)
+	before, err := s.client.Get(ctx, key).Int64()
+	if errors.Is(err, redis.Nil) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return issuedAt.Unix() < before, nil
+}
+
+type gormTokenStore struct{}
+
+func (s *gormTokenStore) SaveRefreshToken(ctx context.Context, rt *models.RefreshToken) error {
+	return db.DB.WithContext(ctx).Create(rt).Error
+}
+
+func (s *gormTokenStore) GetRefreshToken(ctx context.Context, hash string) (*models.RefreshToken, error) {
+	var rt models.RefreshToken
+	err := db.DB.WithContext(ctx).
+		Where("token_hash = ? AND expires_at > ?", hash, time.Now()).
+		First(&rt).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rt, nil
+}
+
+func (s *gormTokenStore) MarkRotated(ctx context.Context, rt *models.RefreshToken, at time.Time) (bool, error) {
+	result := db.DB.WithContext(ctx).Model(&models.RefreshToken{}).
+		Where("id = ? AND rotated_at IS NULL", rt.ID).
+		Update("rotated_at", at)
+	return result.RowsAffected == 1, result.Error
+}
+
+func (s *gormTokenStore) RevokeFamily(ctx context.Context, familyID string, at time.Time) error {
+	return db.DB.WithContext(ctx).Model(&models.RefreshToken{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyID).
+		Update("revoked_at", at).Error
+}
+
+func (s *gormTokenStore) RevokeUserFamilies(ctx context.Context, userID uint, at time.Time) error {
+	return db.DB.WithContext(ctx).Model(&models.RefreshToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", at).Error
+}
+
+func (s *gormTokenStore) RevokeAccessToken(ctx context.Context, jti string, userID uint, expiresAt time.Time) error {
+	return db.DB.WithContext(ctx).Create(&models.RevokedToken{
+		JTI:       jti,
+		UserID:    userID,
+		ExpiresAt: expiresAt,
+	}).Error
+}
+
+func (s *gormTokenStore) RevokeUserAccessTokens(ctx context.Context, userID uint, before, expiresAt time.Time) error {
+	before = before.Truncate(time.Second)
+	return db.DB.WithContext(ctx).Create(&models.RevokedToken{
+		UserID:        userID,
+		RevokedBefore: &before,
+		ExpiresAt:     expiresAt,
+	}).Error
+}
+
+func (s *gormTokenStore) IsAccessTokenRevoked(ctx context.Context, jti string, userID uint, issuedAt time.Time) (bool, error) {
+	var count int64
+	err := db.DB.WithContext(ctx).Model(&models.RevokedToken{}).
+		Where("expires_at > ?", time.Now()).
+		Where(db.DB.Where("jti = ? AND jti <> ''", jti).
+			Or("user_id = ? AND revoked_before > ?", userID, issuedAt.Truncate(time.Second))).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// PurgeExpired deletes refresh tokens and deny-list rows that can no longer
+// match anything. Redis expires its keys on its own.
+func (s *gormTokenStore) PurgeExpired(ctx context.Context) (int64, error) {
+	now := time.Now()
+	tokens := db.DB.WithContext(ctx).Where("expires_at < ?", now).Delete(&models.RefreshToken{})
+	if tokens.Error != nil {
+		return 0, tokens.Error
+	}
+	revoked := db.DB.WithContext(ctx).Where("expires_at < ?", now).Delete(&models.RevokedToken{})
+	return tokens.RowsAffected + revoked.RowsAffected, revoked.Error
+}
@@ -20,7 +20,7 @@ type TrendingProduct struct {
 
 func (s *TrendService) GetTrendingSuggestions() []TrendingProduct {
 	log.Println("AI Agent: Scraping trending products from TikTok and Amazon...")
-	
+
 	// Mock AI logic
 	suggestions := []TrendingProduct{
 		{
@@ -0,0 +1,455 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/db"
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/models"
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/money"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrProductNotFound = errors.New("product not found")
+	ErrVariantNotFound = errors.New("variant not found")
+	ErrVariantRequired = errors.New("product comes in several variants, one has to be chosen")
+	ErrInvalidOptions  = errors.New("invalid product options")
+	ErrSKUTaken        = errors.New("SKU is already in use")
+)
+
+// ProductOptionInput is one option type of a product with the values it
+// comes in, in display order.
+type ProductOptionInput struct {
+	Name   string   `json:"name" binding:"required,max=50"`
+	Values []string `json:"values" binding:"required,min=1,dive,required,max=50"`
+}
+
+// VariantInput is what staff send to replace a variant. A nil Price or
+// WeightGrams makes the variant use the product's.
+type VariantInput struct {
+	SKU         string   `json:"sku" binding:"required,max=64"`
+	Price       *float64 `json:"price" binding:"omitempty,min=0"`
+	Stock       int      `json:"stock" binding:"min=0"`
+	WeightGrams *int     `json:"weight_grams" binding:"omitempty,min=0"`
+	Images      []string `json:"images" binding:"dive,required"`
+}
+
+// VariantAvailability is a variant as shoppers see it: its option values
+// by option name and whether it can be bought.
+type VariantAvailability struct {
+	ID          uint                  `json:"id"`
+	SKU         string                `json:"sku"`
+	Title       string                `json:"title"`
+	Options     map[string]string     `json:"options"`
+	Price       money.Money           `json:"price"`
+	Stock       int                   `json:"stock"`
+	Available   bool                  `json:"available"`
+	WeightGrams int                   `json:"weight_grams"`
+	Images      []models.VariantImage `json:"images"`
+}
+
+// VariantService manages the option types of products and the variant
+// matrix they make up.
+type VariantService struct{}
+
+func NewVariantService() *VariantService {
+	return &VariantService{}
+}
+
+// withVariants preloads a product's options and variants in display order.
+func withVariants(tx *gorm.DB) *gorm.DB {
+	return tx.Preload("Options", func(tx *gorm.DB) *gorm.DB { return tx.Order("position, id") }).
+		Preload("Options.Values", func(tx *gorm.DB) *gorm.DB { return tx.Order("position, id") }).
+		Preload("Variants", func(tx *gorm.DB) *gorm.DB { return tx.Order("id") }).
+		Preload("Variants.Options", orderedOptionValues).
+		Preload("Variants.Images", func(tx *gorm.DB) *gorm.DB { return tx.Order("position, id") })
+}
+
+// orderedOptionValues sorts a variant's option values by the position of
+// their option type, so titles read the same way for every variant.
+func orderedOptionValues(tx *gorm.DB) *gorm.DB {
+	return tx.Order("(SELECT position FROM option_types WHERE option_types.id = option_values.option_type_id), option_type_id")
+}
+
+// Matrix lists the variants of a product loaded with its variants, in
+// BaseCurrency.
+func (s *VariantService) Matrix(product *models.Product) []VariantAvailability {
+	matrix := make([]VariantAvailability, 0, len(product.Variants))
+	for i := range product.Variants {
+		v := &product.Variants[i]
+		options := make(map[string]string, len(v.Options))
+		for _, value := range v.Options {
+			for _, t := range product.Options {
+				if t.ID == value.OptionTypeID {
+					options[t.Name] = value.Value
+				}
+			}
+		}
+		images := v.Images
+		if images == nil {
+			images = []models.VariantImage{}
+		}
+		matrix = append(matrix, VariantAvailability{
+			ID:          v.ID,
+			SKU:         v.SKU,
+			Title:       v.Title(),
+			Options:     options,
+			Price:       v.UnitPrice(product),
+			Stock:       v.Stock,
+			Available:   v.Stock > 0,
+			WeightGrams: v.Weight(product),
+			Images:      images,
+		})
+	}
+	return matrix
+}
+
+// GetProduct loads a product with its options and variants.
+func (s *VariantService) GetProduct(productID uint) (*models.Product, error) {
+	var product models.Product
+	if err := withVariants(db.DB).First(&product, productID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrProductNotFound
+		}
+		return nil, err
+	}
+	return &product, nil
+}
+
+// SetOptions replaces the option types of a product and brings its
+// variants in line: every combination of values gets a variant, with a SKU
+// made from the product ID and the values and no stock, and variants that
+// no longer make up a combination are deleted. Variants that stay keep
+// their SKU, price, stock and images. No options leaves the product with a
+// single variant.
+func (s *VariantService) SetOptions(productID uint, inputs []ProductOptionInput) (*models.Product, error) {
+	if err := checkOptionInputs(inputs); err != nil {
+		return nil, err
+	}
+
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		var product models.Product
+		if err := withVariants(tx.Clauses(clause.Locking{Strength: "UPDATE"})).First(&product, productID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrProductNotFound
+			}
+			return err
+		}
+
+		types, removed, removedTypes, err := saveOptionTypes(tx, &product, inputs)
+		if err != nil {
+			return err
+		}
+
+		existing := make(map[string]*models.ProductVariant)
+		var stale []uint
+		for i := range product.Variants {
+			v := &product.Variants[i]
+			ids := make([]uint, len(v.Options))
+			for j, o := range v.Options {
+				ids[j] = o.ID
+			}
+			key := variantKey(ids)
+			if _, taken := existing[key]; taken || !coversOptions(v.Options, types, removed) {
+				stale = append(stale, v.ID)
+				continue
+			}
+			existing[key] = v
+		}
+
+		for _, combo := range optionCombinations(types) {
+			ids := make([]uint, len(combo))
+			values := make([]string, len(combo))
+			for i, o := range combo {
+				ids[i] = o.ID
+				values[i] = o.Value
+			}
+			if _, ok := existing[variantKey(ids)]; ok {
+				continue
+			}
+			variant := models.ProductVariant{
+				ProductID: product.ID,
+				SKU:       variantSKU(product.ID, values),
+				Options:   combo,
+			}
+			if err := tx.Omit("Options.*").Create(&variant).Error; err != nil {
+				return err
+			}
+		}
+
+		if len(stale) > 0 {
+			if err := tx.Where("id IN ?", stale).Delete(&models.ProductVariant{}).Error; err != nil {
+				return err
+			}
+		}
+		if len(removed) > 0 {
+			if err := tx.Exec("DELETE FROM variant_option_values WHERE option_value_id IN ?", removed).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("id IN ?", removed).Delete(&models.OptionValue{}).Error; err != nil {
+				return err
+			}
+		}
+		if len(removedTypes) > 0 {
+			if err := tx.Where("id IN ?", removedTypes).Delete(&models.OptionType{}).Error; err != nil {
+				return err
+			}
+		}
+		return syncProductStock(tx, []uint{product.ID})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.GetProduct(productID)
+}
+
+func checkOptionInputs(inputs []ProductOptionInput) error {
+	names := make(map[string]bool)
+	for _, in := range inputs {
+		name := strings.ToLower(strings.TrimSpace(in.Name))
+		if name == "" || names[name] {
+			return fmt.Errorf("%w: option names must be unique", ErrInvalidOptions)
+		}
+		names[name] = true
+		values := make(map[string]bool)
+		for _, v := range in.Values {
+			value := strings.ToLower(strings.TrimSpace(v))
+			if value == "" || values[value] {
+				return fmt.Errorf("%w: values of %s must be unique", ErrInvalidOptions, in.Name)
+			}
+			values[value] = true
+		}
+	}
+	return nil
+}
+
+// saveOptionTypes makes the product's option types and values match inputs,
+// reusing the rows of names and values it already has. It returns the
+// types in order and the IDs of the values and types that are gone, which
+// the caller deletes after the variants that use them.
+func saveOptionTypes(tx *gorm.DB, product *models.Product, inputs []ProductOptionInput) ([]models.OptionType, []uint, []uint, error) {
+	current := make(map[string]*models.OptionType, len(product.Options))
+	for i := range product.Options {
+		current[strings.ToLower(product.Options[i].Name)] = &product.Options[i]
+	}
+
+	var removed []uint
+	types := make([]models.OptionType, 0, len(inputs))
+	for position, in := range inputs {
+		name := strings.TrimSpace(in.Name)
+		t, ok := current[strings.ToLower(name)]
+		if ok {
+			delete(current, strings.ToLower(name))
+		} else {
+			t = &models.OptionType{ProductID: product.ID}
+		}
+		t.Name = name
+		t.Position = position
+
+		values := make(map[string]models.OptionValue, len(t.Values))
+		for _, v := range t.Values {
+			values[strings.ToLower(v.Value)] = v
+		}
+		kept := make([]models.OptionValue, 0, len(in.Values))
+		for i, raw := range in.Values {
+			value := strings.TrimSpace(raw)
+			v, ok := values[strings.ToLower(value)]
+			if ok {
+				delete(values, strings.ToLower(value))
+			}
+			v.Value = value
+			v.Position = i
+			kept = append(kept, v)
+		}
+		for _, v := range values {
+			removed = append(removed, v.ID)
+		}
+
+		t.Values = nil
+		if err := tx.Save(t).Error; err != nil {
+			return nil, nil, nil, err
+		}
+		for i := range kept {
+			kept[i].OptionTypeID = t.ID
+			if err := tx.Save(&kept[i]).Error; err != nil {
+				return nil, nil, nil, err
+			}
+		}
+		t.Values = kept
+		types = append(types, *t)
+	}
+
+	var removedTypes []uint
+	for _, t := range current {
+		removedTypes = append(removedTypes, t.ID)
+		for _, v := range t.Values {
+			removed = append(removed, v.ID)
+		}
+	}
+	return types, removed, removedTypes, nil
+}
+
+// coversOptions reports whether a variant has exactly one value of each
+// option type, none of them removed.
+func coversOptions(values []models.OptionValue, types []models.OptionType, removed []uint) bool {
+	if len(values) != len(types) {
+		return false
+	}
+	gone := make(map[uint]bool, len(removed))
+	for _, id := range removed {
+		gone[id] = true
+	}
+	seen := make(map[uint]bool, len(types))
+	for _, v := range values {
+		if gone[v.ID] || seen[v.OptionTypeID] {
+			return false
+		}
+		seen[v.OptionTypeID] = true
+	}
+	for _, t := range types {
+		if !seen[t.ID] {
+			return false
+		}
+	}
+	return true
+}
+
+// optionCombinations lists every way of picking one value of each type, in
+// display order. No types make a single empty combination.
+func optionCombinations(types []models.OptionType) [][]models.OptionValue {
+	combos := [][]models.OptionValue{{}}
+	for _, t := range types {
+		next := make([][]models.OptionValue, 0, len(combos)*len(t.Values))
+		for _, combo := range combos {
+			for _, v := range t.Values {
+				c := append(append([]models.OptionValue{}, combo...), v)
+				next = append(next, c)
+			}
+		}
+		combos = next
+	}
+	return combos
+}
+
+func variantKey(valueIDs []uint) string {
+	ids := append([]uint{}, valueIDs...)
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = strconv.FormatUint(uint64(id), 10)
+	}
+	return strings.Join(parts, ",")
+}
+
+// variantSKU is the SKU a new variant gets, like "42-M-RED". The single
+// variant of a product without options is just the product ID.
+func variantSKU(productID uint, values []string) string {
+	parts := []string{strconv.FormatUint(uint64(productID), 10)}
+	for _, v := range values {
+		parts = append(parts, strings.ToUpper(strings.Join(strings.Fields(v), "_")))
+	}
+	return strings.Join(parts, "-")
+}
+
+// UpdateVariant replaces the SKU, price, stock, weight and images of a
+// variant. Stock set here is what is on the shelf now; stock on hold for
+// carts and orders comes on top of it.
+func (s *VariantService) UpdateVariant(productID, variantID uint, input VariantInput) (*models.ProductVariant, error) {
+	var variant models.ProductVariant
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		var product models.Product
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&product, productID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrProductNotFound
+			}
+			return err
+		}
+		if err := tx.Where("product_id = ?", productID).First(&variant, variantID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrVariantNotFound
+			}
+			return err
+		}
+
+		variant.SKU = strings.TrimSpace(input.SKU)
+		var taken int64
+		if err := tx.Model(&models.ProductVariant{}).Where("sku = ? AND id <> ?", variant.SKU, variant.ID).Count(&taken).Error; err != nil {
+			return err
+		}
+		if taken > 0 {
+			return ErrSKUTaken
+		}
+		variant.Price = nil
+		if input.Price != nil {
+			price := money.FromFloat(*input.Price, BaseCurrency)
+			variant.Price = &price
+		}
+		variant.Stock = input.Stock
+		variant.WeightGrams = input.WeightGrams
+		if err := tx.Omit(clause.Associations).Save(&variant).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Where("variant_id = ?", variant.ID).Delete(&models.VariantImage{}).Error; err != nil {
+			return err
+		}
+		variant.Images = make([]models.VariantImage, len(input.Images))
+		for i, url := range input.Images {
+			variant.Images[i] = models.VariantImage{VariantID: variant.ID, URL: url, Position: i}
+		}
+		if len(variant.Images) > 0 {
+			if err := tx.Create(&variant.Images).Error; err != nil {
+				return err
+			}
+		}
+		return syncProductStock(tx, []uint{productID})
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := db.DB.Preload("Options", orderedOptionValues).First(&variant, variant.ID).Error; err != nil {
+		return nil, err
+	}
+	return &variant, nil
+}
+
+// createDefaultVariant gives a new product its single variant, holding the
+// stock the product was created with.
+func createDefaultVariant(tx *gorm.DB, product *models.Product) error {
+	variant := models.ProductVariant{
+		ProductID: product.ID,
+		SKU:       variantSKU(product.ID, nil),
+		Stock:     product.Stock,
+	}
+	if err := tx.Create(&variant).Error; err != nil {
+		return err
+	}
+	product.Variants = []models.ProductVariant{variant}
+	return nil
+}
+
+// resolveVariant picks the variant a line is for. A line may name just the
+// product when the product has a single variant.
+func resolveVariant(tx *gorm.DB, productID, variantID uint) (uint, error) {
+	if variantID != 0 {
+		return variantID, nil
+	}
+	var ids []uint
+	if err := tx.Model(&models.ProductVariant{}).Where("product_id = ?", productID).
+		Limit(2).Pluck("id", &ids).Error; err != nil {
+		return 0, err
+	}
+	switch len(ids) {
+	case 0:
+		return 0, ErrProductUnavailable
+	case 1:
+		return ids[0], nil
+	default:
+		return 0, ErrVariantRequired
+	}
+}
@@ -10,16 +10,68 @@ import (
 func RunMigrations() error {
 	log.Println("Running database migrations...")
 
+	if err := ConvertMoneyColumns(); err != nil {
+		return err
+	}
+
 	if err := db.DB.AutoMigrate(
 		&models.User{},
+		&models.UserRole{},
+		&models.RefreshToken{},
+		&models.RevokedToken{},
+		&models.UserToken{},
+		&models.SecurityEvent{},
+		&models.MFARecoveryCode{},
+		&models.PaymentTransaction{},
+		&models.PaymentEvent{},
+		&models.Category{},
 		&models.Product{},
+		&models.ProductSlugRedirect{},
+		&models.OptionType{},
+		&models.OptionValue{},
+		&models.ProductVariant{},
+		&models.VariantImage{},
 		&models.Order{},
 		&models.OrderItem{},
+		&models.OrderStatusHistory{},
+		&models.Promotion{},
+		&models.OrderDiscount{},
+		&models.OrderTaxLine{},
+		&models.Invoice{},
+		&models.InvoiceSequence{},
+		&models.ExchangeRate{},
+		&models.TaxRule{},
+		&models.Address{},
+		&models.ShippingZone{},
+		&models.ShippingMethod{},
+		&models.ShippingRate{},
+		&models.Shipment{},
+		&models.ShipmentEvent{},
+		&models.Refund{},
+		&models.ReturnRequest{},
+		&models.ReturnItem{},
 		&models.CartItem{},
+		&models.CartCoupon{},
+		&models.StockReservation{},
+		&models.CatalogImportJob{},
+		&models.CatalogImportError{},
 	); err != nil {
 		return err
 	}
 
+	if err := BackfillOrderCurrencies(); err != nil {
+		return err
+	}
+	if err := BackfillProductVariants(); err != nil {
+		return err
+	}
+	if err := BackfillCategories(); err != nil {
+		return err
+	}
+	if err := BackfillProductSlugs(); err != nil {
+		return err
+	}
+
 	log.Println("Migrations completed successfully")
 	return nil
 }
@@ -0,0 +1,47 @@
+package migrations
+
+import (
+	"log"
+
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/db"
+)
+
+// BackfillOrderCurrencies fills the presentment amounts of orders placed
+// before orders had them. Those orders were all placed and charged in the
+// base currency, so the presentment amounts equal the base ones at a rate
+// of 1. Rows that already have presentment amounts are left alone, so this
+// can run on every start. Return requests get the currency of their order,
+// which their refund amount is in.
+func BackfillOrderCurrencies() error {
+	statements := []string{
+		`UPDATE orders SET base_currency = 'USD', exchange_rate = 1,
+			presentment_subtotal = subtotal,
+			presentment_discount_total = discount_total,
+			presentment_shipping_total = shipping_total,
+			presentment_tax_total = tax_total,
+			presentment_total = total,
+			presentment_refunded_total = refunded_total
+			WHERE presentment_total = 0 AND presentment_subtotal = 0 AND total <> 0`,
+		`UPDATE order_items SET presentment_price = price,
+			presentment_discount = discount,
+			presentment_tax_amount = tax_amount
+			WHERE presentment_price = 0 AND price <> 0`,
+		`UPDATE order_tax_lines SET presentment_taxable_amount = taxable_amount,
+			presentment_amount = amount
+			WHERE presentment_taxable_amount = 0 AND taxable_amount <> 0`,
+		`UPDATE refunds SET base_amount = amount WHERE base_amount = 0 AND amount <> 0`,
+		`UPDATE return_requests SET currency = orders.currency FROM orders
+			WHERE orders.id = return_requests.order_id AND (return_requests.currency IS NULL OR return_requests.currency = '')`,
+	}
+
+	for _, statement := range statements {
+		result := db.DB.Exec(statement)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected > 0 {
+			log.Printf("Backfilled currency amounts of %d rows", result.RowsAffected)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package migrations
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/db"
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/money"
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/services"
+	"gorm.io/gorm"
+)
+
+// moneyColumn is a column that held a float64 major-unit amount and now
+// holds integer minor units. Columns in the base currency have no
+// currency; presentment columns name the SQL expression of their currency
+// and, if it lives on another table, the join that reaches it.
+type moneyColumn struct {
+	table    string
+	column   string
+	currency string
+	join     string
+}
+
+const orderJoin = "FROM orders WHERE orders.id = %s.order_id AND"
+
+var moneyColumns = []moneyColumn{
+	{table: "products", column: "price"},
+	{table: "cart_items", column: "price_at_add"},
+	{table: "orders", column: "subtotal"},
+	{table: "orders", column: "discount_total"},
+	{table: "orders", column: "shipping_total"},
+	{table: "orders", column: "shipping_tax"},
+	{table: "orders", column: "tax_total"},
+	{table: "orders", column: "total"},
+	{table: "orders", column: "refunded_total"},
+	{table: "orders", column: "presentment_subtotal", currency: "orders.currency"},
+	{table: "orders", column: "presentment_discount_total", currency: "orders.currency"},
+	{table: "orders", column: "presentment_shipping_total", currency: "orders.currency"},
+	{table: "orders", column: "presentment_tax_total", currency: "orders.currency"},
+	{table: "orders", column: "presentment_total", currency: "orders.currency"},
+	{table: "orders", column: "presentment_refunded_total", currency: "orders.currency"},
+	{table: "order_items", column: "price"},
+	{table: "order_items", column: "discount"},
+	{table: "order_items", column: "tax_amount"},
+	{table: "order_items", column: "presentment_price", currency: "orders.currency", join: orderJoin},
+	{table: "order_items", column: "presentment_discount", currency: "orders.currency", join: orderJoin},
+	{table: "order_items", column: "presentment_tax_amount", currency: "orders.currency", join: orderJoin},
+	{table: "order_tax_lines", column: "taxable_amount"},
+	{table: "order_tax_lines", column: "amount"},
+	{table: "order_tax_lines", column: "presentment_taxable_amount", currency: "orders.currency", join: orderJoin},
+	{table: "order_tax_lines", column: "presentment_amount", currency: "orders.currency", join: orderJoin},
+	{table: "order_discounts", column: "amount"},
+	{table: "promotions", column: "min_subtotal"},
+	{table: "shipping_rates", column: "price"},
+	{table: "margin_analyses", column: "current_price"},
+	{table: "margin_analyses", column: "cost_price"},
+	{table: "margin_analyses", column: "suggested_price"},
+	{table: "refunds", column: "amount", currency: "refunds.currency"},
+	{table: "refunds", column: "base_amount"},
+	{table: "invoices", column: "net", currency: "invoices.currency"},
+	{table: "invoices", column: "tax_total", currency: "invoices.currency"},
+	{table: "invoices", column: "total", currency: "invoices.currency"},
+	{table: "return_requests", column: "refund_amount", currency: "orders.currency", join: orderJoin},
+}
+
+// ConvertMoneyColumns turns the float amount columns into bigint minor
+// units. It runs before AutoMigrate, which can't change a column's type,
+// and skips columns that are missing or already converted, so it can run
+// on every start.
+func ConvertMoneyColumns() error {
+	return db.DB.Transaction(func(tx *gorm.DB) error {
+		for _, c := range moneyColumns {
+			var dataType string
+			if err := tx.Raw(`SELECT data_type FROM information_schema.columns
+				WHERE table_schema = current_schema() AND table_name = ? AND column_name = ?`,
+				c.table, c.column).Scan(&dataType).Error; err != nil {
+				return err
+			}
+			if dataType == "" || dataType == "bigint" {
+				continue
+			}
+
+			alter := fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s TYPE bigint USING round(%s::numeric * 100)",
+				c.table, c.column, c.column)
+			if err := tx.Exec(alter).Error; err != nil {
+				return err
+			}
+			if c.currency != "" {
+				if err := tx.Exec(rescale(c)).Error; err != nil {
+					return err
+				}
+			}
+			log.Printf("Converted %s.%s to minor units", c.table, c.column)
+		}
+		return nil
+	})
+}
+
+// rescale fixes the amounts of a presentment column that aren't in a
+// currency with cents, which the ALTER multiplied by 100 all the same.
+func rescale(c moneyColumn) string {
+	var codes []string
+	for code := range services.CurrencyMap {
+		if money.Exponent(code) != 2 {
+			codes = append(codes, code)
+		}
+	}
+	sort.Strings(codes)
+
+	factor := "CASE " + c.currency
+	quoted := make([]string, len(codes))
+	for i, code := range codes {
+		factor += fmt.Sprintf(" WHEN '%s' THEN %g", code, math.Pow10(money.Exponent(code)-2))
+		quoted[i] = "'" + code + "'"
+	}
+	factor += " END"
+
+	join := "WHERE"
+	if c.join != "" {
+		join = fmt.Sprintf(c.join, c.table)
+	}
+	return fmt.Sprintf("UPDATE %s SET %s = round(%s.%s * %s) %s %s IN (%s)",
+		c.table, c.column, c.table, c.column, factor, join, c.currency, strings.Join(quoted, ", "))
+}
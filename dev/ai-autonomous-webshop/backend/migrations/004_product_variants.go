@@ -0,0 +1,46 @@
+package migrations
+
+import (
+	"log"
+
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/db"
+)
+
+// BackfillProductVariants gives every product from before variants its
+// single default variant, holding the product's stock, and points existing
+// cart items, order items and stock holds at it. Where the ID is free the
+// default variant gets the ID of its product, so guest carts and cart URLs
+// that still carry product IDs keep working. Products that already have a
+// variant and rows that already name one are left alone, so this can run
+// on every start.
+func BackfillProductVariants() error {
+	statements := []string{
+		`INSERT INTO product_variants (id, product_id, sku, stock, created_at, updated_at)
+			SELECT p.id, p.id, p.id::text, p.stock, now(), now() FROM products p
+			WHERE NOT EXISTS (SELECT 1 FROM product_variants v WHERE v.product_id = p.id)
+			ON CONFLICT DO NOTHING`,
+		`INSERT INTO product_variants (product_id, sku, stock, created_at, updated_at)
+			SELECT p.id, p.id::text || '-default', p.stock, now(), now() FROM products p
+			WHERE NOT EXISTS (SELECT 1 FROM product_variants v WHERE v.product_id = p.id)`,
+		`SELECT setval(pg_get_serial_sequence('product_variants', 'id'),
+			(SELECT COALESCE(MAX(id), 1) FROM product_variants))`,
+		`UPDATE cart_items SET variant_id = (SELECT MIN(v.id) FROM product_variants v
+			WHERE v.product_id = cart_items.product_id) WHERE variant_id = 0`,
+		`UPDATE order_items SET variant_id = v.id, sku = v.sku FROM product_variants v
+			WHERE order_items.variant_id = 0 AND v.id = (SELECT MIN(id) FROM product_variants
+			WHERE product_id = order_items.product_id)`,
+		`UPDATE stock_reservations SET variant_id = (SELECT MIN(v.id) FROM product_variants v
+			WHERE v.product_id = stock_reservations.product_id) WHERE variant_id = 0`,
+	}
+
+	for _, statement := range statements {
+		result := db.DB.Exec(statement)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected > 0 {
+			log.Printf("Backfilled product variants of %d rows", result.RowsAffected)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package migrations
+
+import (
+	"log"
+
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/db"
+)
+
+// BackfillCategories turns the category names products carried before the
+// category tree into top-level categories and assigns every product to its
+// own. The sales multipliers and trend scores the margin analysis used to
+// hard-code per category name become the starting values of those
+// categories. Existing categories and assignments are left alone, so this
+// can run on every start.
+func BackfillCategories() error {
+	statements := []string{
+		`INSERT INTO categories (name, slug, position, attributes, sales_multiplier, trend_score, created_at, updated_at)
+			SELECT n.name, n.slug, 0, '[]', f.sales_multiplier, f.trend_score, now(), now()
+			FROM (SELECT DISTINCT category AS name,
+				trim(both '-' from lower(regexp_replace(category, '[^a-zA-Z0-9]+', '-', 'g'))) AS slug
+				FROM products WHERE category <> '') n
+			LEFT JOIN (VALUES
+				('Electronics', 1.5, 0.85),
+				('Clothing', 1.2, 0.65),
+				('Home', 1.0, 0.58),
+				('Books', 0.8, 0.45),
+				('Toys', 1.3, 0.78),
+				('Sports', 1.1, 0.72),
+				('Food', 0.9, 0.40)
+			) AS f(name, sales_multiplier, trend_score) ON f.name = n.name
+			WHERE n.slug <> '' AND NOT EXISTS (SELECT 1 FROM categories c
+				WHERE c.deleted_at IS NULL AND (c.slug = n.slug OR c.name = n.name))
+			ON CONFLICT DO NOTHING`,
+		`UPDATE products SET category_id = c.id FROM categories c
+			WHERE products.category_id IS NULL AND products.category <> '' AND c.deleted_at IS NULL
+			AND c.id = (SELECT MIN(id) FROM categories WHERE deleted_at IS NULL AND (name = products.category
+				OR slug = trim(both '-' from lower(regexp_replace(products.category, '[^a-zA-Z0-9]+', '-', 'g')))))`,
+		`INSERT INTO product_categories (product_id, category_id)
+			SELECT id, category_id FROM products WHERE category_id IS NOT NULL
+			ON CONFLICT DO NOTHING`,
+	}
+
+	for _, statement := range statements {
+		result := db.DB.Exec(statement)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected > 0 {
+			log.Printf("Backfilled categories of %d rows", result.RowsAffected)
+		}
+	}
+	return nil
+}
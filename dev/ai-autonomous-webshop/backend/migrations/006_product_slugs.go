@@ -0,0 +1,32 @@
+package migrations
+
+import (
+	"log"
+
+	"github.com/jeremy/ai-autonomous-webshop/backend/internal/db"
+)
+
+// BackfillProductSlugs gives products from before slugs one made from
+// their name. Where several share a name, or the name's slug is taken,
+// all but the first get their ID appended. Products that have a slug are
+// left alone, so this can run on every start.
+func BackfillProductSlugs() error {
+	result := db.DB.Exec(`UPDATE products SET slug = s.slug FROM (
+			SELECT id, CASE
+				WHEN base = '' THEN 'product-' || id
+				WHEN row_number() OVER (PARTITION BY base ORDER BY id) > 1
+					OR EXISTS (SELECT 1 FROM products o WHERE o.slug = base)
+					OR EXISTS (SELECT 1 FROM product_slug_redirects r WHERE r.slug = base)
+				THEN base || '-' || id
+				ELSE base END AS slug
+			FROM (SELECT id, trim(both '-' from left(lower(regexp_replace(name, '[^a-zA-Z0-9]+', '-', 'g')), 150)) AS base
+				FROM products WHERE slug = '') b
+		) s WHERE products.id = s.id`)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 {
+		log.Printf("Backfilled slugs of %d products", result.RowsAffected)
+	}
+	return nil
+}
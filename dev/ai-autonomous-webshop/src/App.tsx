@@ -49,7 +49,7 @@ function App() {
     if (cartSession) {
       const sharedItems = sessionService.loadSharedCart(cartSession);
       if (sharedItems) {
-        sharedItems.forEach((item: any) => addItem(item.id, item.q));
+        sharedItems.forEach((item) => addItem(item.id, item.q, item.v));
         alert('Shared cart items added!');
         window.history.replaceState({}, document.title, "/");
       }
@@ -12,10 +12,14 @@ interface CartDrawerProps {
 }
 
 export const CartDrawer: React.FC<CartDrawerProps> = ({ isOpen, onClose }) => {
-  const { items, removeItem } = useCartStore();
+  const { items, totals, removeItem } = useCartStore();
   const shouldReduceMotion = useReducedMotion();
 
-  const total = items.reduce((sum, item) => sum + item.product.price * item.quantity, 0);
+  const total = totals?.subtotal ?? 0;
+
+  // Cart amounts come already converted into the cart's currency.
+  const formatAmount = (amount: number) =>
+    new Intl.NumberFormat(undefined, { style: 'currency', currency: totals?.currency ?? 'USD' }).format(amount);
 
   const handleShare = () => {
     const link = sessionService.generateShareLink(items);
@@ -91,7 +95,7 @@ export const CartDrawer: React.FC<CartDrawerProps> = ({ isOpen, onClose }) => {
                 <AnimatePresence mode="popLayout">
                   {items.map((item, index) => (
                     <motion.div
-                      key={item.id}
+                      key={item.variant_id}
                       variants={shouldReduceMotion ? reducedMotionVariants : cartItemVariants}
                       initial="hidden"
                       animate="visible"
@@ -109,11 +113,12 @@ export const CartDrawer: React.FC<CartDrawerProps> = ({ isOpen, onClose }) => {
                       </div>
                       <div className="flex-1">
                         <h4 className="font-medium">{item.product.name}</h4>
+                        {item.variant_name && <p className="text-xs text-gray-500">{item.variant_name}</p>}
                         <p className="text-sm text-gray-500">Qty: {item.quantity}</p>
                         <div className="flex items-center justify-between mt-2">
-                          <span className="text-blue-400 font-bold">${item.product.price}</span>
+                          <span className="text-blue-400 font-bold">{formatAmount(item.display_price)}</span>
                           <button 
-                            onClick={() => removeItem(item.product_id)}
+                            onClick={() => removeItem(item.variant_id)}
                             className="text-gray-600 hover:text-red-500 transition-colors"
                           >
                             <Trash2 size={16} />
@@ -135,7 +140,7 @@ export const CartDrawer: React.FC<CartDrawerProps> = ({ isOpen, onClose }) => {
                 className="flex items-center justify-between mb-6"
               >
                 <span className="text-gray-400">Subtotal</span>
-                <span className="text-2xl font-bold">${total.toFixed(2)}</span>
+                <span className="text-2xl font-bold">{formatAmount(total)}</span>
               </motion.div>
               <div className="flex gap-2 mb-4">
                 <Button 
@@ -9,44 +9,102 @@ interface CartItemProduct {
 }
 
 interface CartItem {
-  id: number;
   product: CartItemProduct;
   product_id: number;
+  variant_id: number;
+  sku: string;
+  variant_name?: string;
   quantity: number;
+  unit_price: number;
+  display_price: number;
+  tax_rate: number;
+  line_total: number;
+  available: boolean;
+  max_quantity: number;
+}
+
+interface CartWarning {
+  code: 'price_changed' | 'unavailable' | 'out_of_stock' | 'insufficient_stock' | 'coupon_rejected' | 'shipping_unavailable';
+  product_id?: number;
+  variant_id?: number;
+  message: string;
+  old_price?: number;
+  new_price?: number;
+  available?: number;
+}
+
+interface ShippingOption {
+  method_id: number;
+  name: string;
+  carrier: string;
+  price: number;
+  min_days: number;
+  max_days: number;
+}
+
+interface CartTotals {
+  subtotal: number;
+  discount: number;
+  tax: number;
+  prices_include_tax: boolean;
+  reverse_charge: boolean;
+  shipping: number;
+  shipping_options: ShippingOption[];
+  grand_total: number;
+  currency: string;
+}
+
+interface CartSummary extends CartTotals {
+  items: CartItem[];
+  warnings: CartWarning[];
 }
 
 interface CartState {
   items: CartItem[];
+  totals: CartTotals | null;
+  warnings: CartWarning[];
   isLoading: boolean;
   fetchCart: () => Promise<void>;
-  addItem: (productId: number, quantity: number) => Promise<void>;
-  removeItem: (productId: number) => Promise<void>;
+  addItem: (productId: number, quantity: number, variantId?: number) => Promise<void>;
+  updateQuantity: (variantId: number, quantity: number) => Promise<void>;
+  removeItem: (variantId: number) => Promise<void>;
 }
 
 export const useCartStore = create<CartState>((set, get) => ({
   items: [],
+  totals: null,
+  warnings: [],
   isLoading: false,
   fetchCart: async () => {
     set({ isLoading: true });
     try {
-      const items = await cartService.getCart();
-      set({ items: items as CartItem[], isLoading: false });
+      const { items, warnings, ...totals } = (await cartService.getCart()) as CartSummary;
+      set({ items, warnings, totals, isLoading: false });
     } catch {
       set({ isLoading: false });
     }
   },
-  addItem: async (productId, quantity) => {
+  addItem: async (productId, quantity, variantId) => {
     try {
-      await cartService.addToCart(productId, quantity);
+      await cartService.addToCart(productId, quantity, variantId);
       await get().fetchCart();
     } catch (error) {
       console.error('Failed to add item', error);
       throw error;
     }
   },
-  removeItem: async (productId) => {
+  updateQuantity: async (variantId, quantity) => {
+    try {
+      await cartService.updateQuantity(variantId, quantity);
+      await get().fetchCart();
+    } catch (error) {
+      console.error('Failed to update item', error);
+      throw error;
+    }
+  },
+  removeItem: async (variantId) => {
     try {
-      await cartService.removeFromCart(productId);
+      await cartService.removeFromCart(variantId);
       await get().fetchCart();
     } catch (error) {
       console.error('Failed to remove item', error);
@@ -5,12 +5,24 @@ export const cartService = {
     const response = await api.get('/cart');
     return response.data;
   },
-  addToCart: async (productId: number, quantity: number) => {
-    const response = await api.post('/cart', { product_id: productId, quantity });
+  addToCart: async (productId: number, quantity: number, variantId?: number) => {
+    const response = await api.post('/cart', { product_id: productId, variant_id: variantId, quantity });
     return response.data;
   },
-  removeFromCart: async (productId: number) => {
-    const response = await api.delete(`/cart/${productId}`);
+  updateQuantity: async (variantId: number, quantity: number) => {
+    const response = await api.patch(`/cart/${variantId}`, { quantity });
+    return response.data;
+  },
+  applyCoupon: async (code: string) => {
+    const response = await api.post('/cart/coupons', { code });
+    return response.data;
+  },
+  removeCoupon: async (code: string) => {
+    const response = await api.delete(`/cart/coupons/${encodeURIComponent(code)}`);
+    return response.data;
+  },
+  removeFromCart: async (variantId: number) => {
+    const response = await api.delete(`/cart/${variantId}`);
     return response.data;
   },
 };
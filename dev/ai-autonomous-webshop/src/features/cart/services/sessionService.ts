@@ -1,14 +1,32 @@
+export interface SharedCartItem {
+  id: number;
+  v?: number;
+  q: number;
+}
+
+const isSharedCartItem = (item: unknown): item is SharedCartItem => {
+  const i = item as SharedCartItem;
+  return typeof i === 'object' && i !== null &&
+    typeof i.id === 'number' && typeof i.q === 'number' &&
+    (i.v === undefined || typeof i.v === 'number');
+};
+
 export const sessionService = {
-  generateShareLink: (cartItems: any[]) => {
+  generateShareLink: (cartItems: { product_id: number; variant_id: number; quantity: number }[]) => {
     // In a real app, we'd save this to the DB and return a short ID.
     // For this 2026 mock, we'll encode the cart in the URL.
-    const data = btoa(JSON.stringify(cartItems.map(i => ({ id: i.product_id, q: i.quantity }))));
+    const items: SharedCartItem[] = cartItems.map(i => ({ id: i.product_id, v: i.variant_id, q: i.quantity }));
+    const data = btoa(JSON.stringify(items));
     return `${window.location.origin}?cart_session=${data}`;
   },
-  loadSharedCart: (encodedData: string) => {
+  loadSharedCart: (encodedData: string): SharedCartItem[] | null => {
     try {
-      const decoded = JSON.parse(atob(encodedData));
-      return decoded; // Array of {id, q}
+      const decoded: unknown = JSON.parse(atob(encodedData));
+      if (!Array.isArray(decoded) || !decoded.every(isSharedCartItem)) {
+        console.error('Shared cart has an unexpected shape');
+        return null;
+      }
+      return decoded;
     } catch (e) {
       console.error('Failed to decode shared cart', e);
       return null;